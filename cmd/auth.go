@@ -3,6 +3,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/canaria-computer/m3bridge/internal/auth"
 	"github.com/canaria-computer/m3bridge/internal/config"
@@ -17,13 +22,29 @@ var authCmd = &cobra.Command{
 	RunE:  runAuth,
 }
 
+// defaultLoginTimeout ブラウザやデバイスコードでの認証を待つ時間のデフォルト値
+const defaultLoginTimeout = 5 * time.Minute
+
 var (
-	testAuth bool
+	testAuth       bool
+	authDeviceCode bool
+	noBrowser      bool
+	callbackAddr   string
+	account        string
+	loginTimeout   time.Duration
+	authority      string
+	tenant         string
 )
 
 func init() {
 	rootCmd.AddCommand(authCmd)
+	authCmd.Flags().StringVar(&account, "account", "", "認証するアカウント（例: user@contoso.com）")
+	authCmd.Flags().StringVar(&callbackAddr, "callback-addr", "", "認証コールバックの待ち受けアドレス（例: localhost:5225、ポート0で自動割り当て）")
 	authCmd.Flags().BoolVar(&testAuth, "test", false, "認証後にユーザー情報を取得してテスト")
+	authCmd.Flags().BoolVar(&authDeviceCode, "device-code", false, "デバイスコードフローで認証（ブラウザのない環境向け）")
+	authCmd.Flags().BoolVar(&noBrowser, "no-browser", false, "認証URLをブラウザで自動的に開かない")
+	authCmd.Flags().DurationVar(&loginTimeout, "timeout", defaultLoginTimeout, "認証を待つ最大時間")
+	addAuthorityFlags(authCmd)
 }
 
 func runAuth(cmd *cobra.Command, args []string) error {
@@ -31,42 +52,129 @@ func runAuth(cmd *cobra.Command, args []string) error {
 	logger.Info("認証を開始します")
 
 	// 設定を読み込む
-	cfg, err := config.NewManager(logger)
+	cfg, err := loadConfig(logger, true)
 	if err != nil {
-		return fmt.Errorf("設定読み込みエラー: %w", err)
+		return err
 	}
 
-	graphConfig := cfg.GetGraphConfig()
+	graphConfig := cfg.GetGraphConfig(profile)
+	if callbackAddr != "" {
+		graphConfig.CallbackAddr = callbackAddr
+	}
+	if err := applyAuthorityFlags(&graphConfig); err != nil {
+		return err
+	}
 
 	// 認証マネージャーを作成
-	authenticator := auth.NewAuthenticator(
-		graphConfig.ClientID,
-		graphConfig.RedirectURI,
-		graphConfig.AuthorityURL,
-		graphConfig.TokenCache,
-		logger,
-	)
-
-	// アクセストークンを取得
-	accessToken, err := authenticator.GetAccessToken()
+	authConfig := newAuthConfig(graphConfig)
+	authConfig.DeviceCode = authDeviceCode
+	authenticator := auth.NewAuthenticator(authConfig, logger)
+
+	// アクセストークンを取得（Ctrl-Cまたはタイムアウトで中断）
+	ctx, stop := loginContext()
+	defer stop()
+
+	accessToken, err := authenticator.GetAccessToken(ctx)
 	if err != nil {
 		return fmt.Errorf("トークン取得エラー: %w", err)
 	}
 
 	logger.Info("認証成功")
+	if identity, err := authenticator.Identity(); err == nil {
+		logger.Info("サインインアカウント",
+			"name", identity.Name,
+			"preferred_username", identity.PreferredUsername)
+	} else {
+		logger.Debug("id_tokenがないため、サインインアカウントを表示できません")
+	}
 
 	// テストが有効な場合、ユーザー情報を取得
 	if testAuth {
 		logger.Info("ユーザー情報を取得します")
-		graphClient, err := graph.NewClient(accessToken, logger)
+		graphClient, err := graph.NewClientWithHTTPConfig(accessToken, graph.HTTPConfig{Proxy: authConfig.Proxy}, logger)
 		if err != nil {
 			return fmt.Errorf("Graphクライアント作成エラー: %w", err)
 		}
+		graphClient.SetEndpoint(graphConfig.Cloud().GraphEndpoint)
 
-		if err := graphClient.GetUserInfo(context.Background()); err != nil {
+		userInfo, err := graphClient.GetUserInfo(ctx)
+		if err != nil {
 			return fmt.Errorf("ユーザー情報取得エラー: %w", err)
 		}
+
+		fmt.Println("\n=== ユーザー情報 ===")
+		fmt.Printf("ID: %s\n", userInfo.ID)
+		fmt.Printf("表示名: %s\n", userInfo.DisplayName)
+		fmt.Printf("UPN: %s\n", userInfo.UserPrincipalName)
+		fmt.Printf("メール: %s\n", userInfo.Mail)
+		fmt.Print("==================\n\n")
+	}
+
+	return nil
+}
+
+// addAuthorityFlags 認証機関を指定するフラグを追加
+func addAuthorityFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&authority, "authority", "", "認証機関のURL（例: https://login.microsoftonline.com/<tenant-id>）。設定ファイルの authority_url より優先")
+	cmd.Flags().StringVar(&tenant, "tenant", "", "テナント（テナントID、ドメイン名、common、organizations、consumers）")
+	cmd.MarkFlagsMutuallyExclusive("authority", "tenant")
+}
+
+// applyAuthorityFlags --authority/--tenant の指定でAuthorityURLを上書きする
+func applyAuthorityFlags(graphConfig *config.GraphConfig) error {
+	switch {
+	case authority != "":
+		if err := auth.ValidateAuthority(authority); err != nil {
+			return fmt.Errorf("--authority の指定が不正です: %w", err)
+		}
+		graphConfig.AuthorityURL = strings.TrimRight(authority, "/")
+	case tenant != "":
+		authorityURL, err := auth.TenantAuthority(graphConfig.Cloud().LoginHost, tenant)
+		if err != nil {
+			return fmt.Errorf("--tenant の指定が不正です: %w", err)
+		}
+		graphConfig.AuthorityURL = authorityURL
 	}
 
+	// 認証機関とGraphのエンドポイントが同じクラウドのものか確認
+	if err := graphConfig.ValidateCloud(); err != nil {
+		return fmt.Errorf("クラウド設定エラー: %w", err)
+	}
 	return nil
 }
+
+// loginContext 認証の待機用のコンテキストを作成
+// SIGINT/SIGTERMまたは --timeout の経過でキャンセルされる
+func loginContext() (context.Context, context.CancelFunc) {
+	ctx, stopSignal := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	return ctx, func() {
+		cancel()
+		stopSignal()
+	}
+}
+
+// newAuthConfig Graph設定から認証設定を作成
+func newAuthConfig(graphConfig config.GraphConfig) auth.Config {
+	// 設定の読み込み時に検証済み
+	proxy, _ := graphConfig.Proxy()
+
+	return auth.Config{
+		ClientID:        graphConfig.ClientID,
+		ClientSecret:    graphConfig.ClientSecret,
+		CertificateFile: graphConfig.ClientCertificateFile,
+		RedirectURI:     graphConfig.RedirectURI,
+		AuthorityURL:    graphConfig.AuthorityURL,
+		TokenCachePath:  graphConfig.TokenCache,
+		Scopes:          graphConfig.Scopes,
+		Account:         account,
+		TokenStore:      graphConfig.TokenStore,
+		CallbackAddr:    graphConfig.CallbackAddr,
+		SuccessPage:     graphConfig.SuccessPage,
+		ErrorPage:       graphConfig.ErrorPage,
+		ExpiryBuffer:    time.Duration(graphConfig.ExpiryBufferSecs) * time.Second,
+		NoBrowser:       noBrowser,
+		GraphEndpoint:   graphConfig.Cloud().GraphEndpoint,
+		Proxy:           proxy,
+	}
+}
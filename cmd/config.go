@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/canaria-computer/m3bridge/internal/auth"
+	"github.com/canaria-computer/m3bridge/internal/config"
+	"github.com/canaria-computer/m3bridge/internal/smtp"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "設定の確認",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "設定を検証",
+	Long: `設定ファイルを読み込み、認証やSMTPサーバの起動の前に設定の誤りがないか確認します。
+項目ごとに結果を表示し、1つでも失敗した場合は終了コード1で終了します。`,
+	RunE:          runConfigValidate,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "設定を変更",
+	Long: `設定ファイルの値を変更して保存します。変更できる項目は smtp.username と smtp.password です。
+値に - を指定すると標準入力から読み込みます（パスワードをシェルの履歴に残さない場合に使います）。`,
+	Args:         cobra.ExactArgs(2),
+	RunE:         runConfigSet,
+	SilenceUsage: true,
+}
+
+var configRotatePasswordCmd = &cobra.Command{
+	Use:   "rotate-password",
+	Short: "SMTP認証のパスワードを再生成",
+	Long: `SMTP認証のパスワードをランダムに生成し直して保存し、新しいパスワードを表示します。
+メールクライアントの設定も新しいパスワードに変更してください。`,
+	Args:         cobra.NoArgs,
+	RunE:         runConfigRotatePassword,
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configRotatePasswordCmd)
+}
+
+func runConfigSet(cmd *cobra.Command, args []string) error {
+	key, value := args[0], args[1]
+	if value == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("標準入力の読み込みエラー: %w", err)
+		}
+		value = strings.TrimRight(line, "\r\n")
+	}
+	if value == "" {
+		return fmt.Errorf("%s に空の値は設定できません", key)
+	}
+
+	cfg, err := loadConfig(GetLogger(), false)
+	if err != nil {
+		return err
+	}
+
+	switch key {
+	case "smtp.username":
+		if err := cfg.UpdateSMTPUsername(profile, value); err != nil {
+			return fmt.Errorf("設定保存エラー: %w", err)
+		}
+		fmt.Printf("smtp.username を %s に変更しました\n", value)
+		warnEnvOverride("SMTP_USERNAME")
+	case "smtp.password":
+		if err := cfg.UpdateSMTPPassword(profile, value); err != nil {
+			return fmt.Errorf("設定保存エラー: %w", err)
+		}
+		fmt.Printf("smtp.password を変更しました（%s）\n", maskSecret(value))
+		warnEnvOverride("SMTP_PASSWORD")
+	default:
+		return fmt.Errorf("変更できない設定です: %s（smtp.username、smtp.password のいずれかを指定してください）", key)
+	}
+	return nil
+}
+
+func runConfigRotatePassword(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(GetLogger(), false)
+	if err != nil {
+		return err
+	}
+
+	password, err := cfg.RotateSMTPPassword(profile)
+	if err != nil {
+		return fmt.Errorf("パスワード更新エラー: %w", err)
+	}
+	fmt.Println("SMTP認証のパスワードを再生成しました")
+	fmt.Printf("新しいパスワード: %s\n", password)
+	warnEnvOverride("SMTP_PASSWORD")
+	return nil
+}
+
+// warnEnvOverride 変更した設定が環境変数で上書きされている場合に警告する
+func warnEnvOverride(name string) {
+	if config.OverriddenByEnv(name) {
+		GetLogger().Warn("環境変数で上書きされているため、変更した値は使われません", "variable", config.EnvPrefix+name)
+	}
+}
+
+// maskSecret シークレットを伏せて長さのみ表示する
+func maskSecret(secret string) string {
+	return fmt.Sprintf("********、%d文字", len([]rune(secret)))
+}
+
+// clientIDPattern アプリケーション（クライアント）IDの形式（GUID）
+var clientIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// configCheck 設定の検証項目
+type configCheck struct {
+	name  string
+	check func() error
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	logger := GetLogger()
+
+	cfg, err := loadConfig(logger, false)
+	if err != nil {
+		fmt.Printf("[NG] 設定ファイルの読み込み: %v\n", err)
+		return fmt.Errorf("設定の検証に失敗しました")
+	}
+	fmt.Printf("[OK] 設定ファイルの読み込み: %s（プロファイル: %s）\n", cfg.GetConfigPath(), profile)
+
+	smtpConfig := cfg.GetSMTPConfig(profile)
+	graphConfig := cfg.GetGraphConfig(profile)
+
+	checks := []configCheck{
+		{"client_id", func() error {
+			if !clientIDPattern.MatchString(graphConfig.ClientID) {
+				return fmt.Errorf("アプリケーション（クライアント）IDの形式ではありません: %q", graphConfig.ClientID)
+			}
+			return nil
+		}},
+		{"redirect_uri", func() error {
+			return auth.ValidateRedirectURI(graphConfig.RedirectURI, graphConfig.CallbackAddr)
+		}},
+		{"authority_url", func() error {
+			if err := auth.ValidateAuthority(graphConfig.AuthorityURL); err != nil {
+				return err
+			}
+			return graphConfig.ValidateCloud()
+		}},
+		{"smtp.allowed_networks", func() error {
+			_, err := smtp.ParseNetworks(smtpConfig.AllowedNetworks)
+			return err
+		}},
+		{"passthrough_headers", func() error {
+			return smtp.ValidatePassthroughHeaders(graphConfig.PassthroughHeaders)
+		}},
+		{"text_alternative", func() error {
+			_, err := smtp.ParseTextAlternativeMode(graphConfig.TextAlternative)
+			return err
+		}},
+		{"archive_bcc", func() error {
+			if graphConfig.ArchiveBcc == "" {
+				return nil
+			}
+			if _, err := mail.ParseAddress(graphConfig.ArchiveBcc); err != nil {
+				return fmt.Errorf("メールアドレスの形式ではありません: %q", graphConfig.ArchiveBcc)
+			}
+			return nil
+		}},
+		{"footer", func() error {
+			_, err := smtp.ParseFooter(graphConfig.FooterHTML, graphConfig.FooterText)
+			return err
+		}},
+	}
+	switch {
+	case smtpConfig.SocketOnly:
+		checks = append(checks, configCheck{"smtp.socket", func() error {
+			_, err := smtpListeners(smtpConfig)
+			return err
+		}})
+	case len(smtpConfig.Listen) > 0:
+		for _, value := range smtpConfig.Listen {
+			checks = append(checks, configCheck{fmt.Sprintf("smtp.listen（%s）", value), func() error {
+				l, err := smtp.ParseListener(value)
+				if err != nil {
+					return err
+				}
+				return checkListenAddr(l.Addr)
+			}})
+		}
+	default:
+		checks = append(checks, configCheck{"smtp.port", func() error {
+			return checkListenPort(smtpConfig.Host, smtpConfig.Port)
+		}})
+		if smtpConfig.TLSPort != 0 {
+			checks = append(checks, configCheck{"smtp.tls_port", func() error {
+				return checkListenPort(smtpConfig.Host, smtpConfig.TLSPort)
+			}})
+		}
+	}
+	checks = append(checks, configCheck{"token_cache", func() error {
+		if graphConfig.TokenStore == auth.TokenStoreKeyring {
+			return nil
+		}
+		return checkWritableDir(filepath.Dir(graphConfig.TokenCache))
+	}})
+
+	failed := 0
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			failed++
+			fmt.Printf("[NG] %s: %v\n", c.name, err)
+			continue
+		}
+		fmt.Printf("[OK] %s\n", c.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("設定の検証に失敗しました（%d件）", failed)
+	}
+	fmt.Println("設定に問題はありません")
+	return nil
+}
+
+// checkListenPort ポート番号が範囲内で、待ち受けに使用できるか確認
+func checkListenPort(host string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("ポート番号は1〜65535で指定してください: %d", port)
+	}
+	return checkListenAddr(net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// checkListenAddr アドレスで待ち受けできるか、一時的に待ち受けて確認
+func checkListenAddr(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("待ち受けできません（他のプロセスが使用中の可能性があります）: %w", err)
+	}
+	return ln.Close()
+}
+
+// checkWritableDir ディレクトリに書き込めるか、一時ファイルを作成して確認
+func checkWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".m3bridge-validate-*")
+	if err != nil {
+		return fmt.Errorf("トークンキャッシュのディレクトリに書き込めません: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/auth"
+	"github.com/canaria-computer/m3bridge/internal/config"
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "動作環境を診断",
+	Long: `設定ファイル、トークンキャッシュ、認証機関とMicrosoft Graphへの接続、SMTPポートを確認し、結果をまとめて表示します。
+パスワードやシークレットは伏せて表示するため、問題を報告する際にそのまま貼り付けられます。
+トークンの更新や新しいログインは行いません。1つでも失敗した項目がある場合は終了コード1で終了します。`,
+	Args:          cobra.NoArgs,
+	RunE:          runDoctor,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+// doctorTimeout 接続確認1件あたりのタイムアウト
+const doctorTimeout = 10 * time.Second
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVar(&account, "account", "", "確認するアカウント")
+}
+
+// doctorReport 診断結果
+type doctorReport struct {
+	failed int
+	warned int
+}
+
+func (r *doctorReport) ok(name, detail string) {
+	fmt.Printf("[OK] %s: %s\n", name, detail)
+}
+
+func (r *doctorReport) warn(name, detail string) {
+	r.warned++
+	fmt.Printf("[警告] %s: %s\n", name, detail)
+}
+
+func (r *doctorReport) fail(name string, err error) {
+	r.failed++
+	fmt.Printf("[NG] %s: %v\n", name, err)
+}
+
+func (r *doctorReport) skip(name, reason string) {
+	fmt.Printf("[--] %s: %s\n", name, reason)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	logger := GetLogger()
+	report := &doctorReport{}
+
+	fmt.Println("=== 環境 ===")
+	fmt.Printf("OS/アーキテクチャ: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("Go: %s\n", runtime.Version())
+	fmt.Printf("プロファイル: %s\n", profile)
+	for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY"} {
+		if value := os.Getenv(name); value != "" {
+			fmt.Printf("%s: %s\n", name, redactURL(value))
+		}
+	}
+
+	fmt.Println("\n=== 設定 ===")
+	cfg, err := loadConfig(logger, false)
+	if err != nil {
+		report.fail("設定ファイル", err)
+		return report.finish()
+	}
+	report.ok("設定ファイル", cfg.GetConfigPath())
+
+	smtpConfig := cfg.GetSMTPConfig(profile)
+	graphConfig := cfg.GetGraphConfig(profile)
+
+	files := []string{cfg.GetConfigPath()}
+	if graphConfig.TokenStore != auth.TokenStoreKeyring {
+		files = append(files, graphConfig.TokenCache)
+	}
+	switch issues, err := config.CheckPermissions(filepath.Dir(cfg.GetConfigPath()), files...); {
+	case err != nil:
+		report.fail("パーミッション", err)
+	case len(issues) > 0:
+		for _, issue := range issues {
+			report.warn("パーミッション", issue.String())
+		}
+	default:
+		report.ok("パーミッション", "問題なし")
+	}
+
+	fmt.Printf("client_id: %s\n", graphConfig.ClientID)
+	fmt.Printf("authority_url: %s\n", graphConfig.AuthorityURL)
+	fmt.Printf("graph_endpoint: %s\n", graphConfig.Cloud().GraphEndpoint)
+	if graphConfig.ClientSecret != "" {
+		fmt.Printf("client_secret: %s\n", maskSecret(graphConfig.ClientSecret))
+	}
+	if graphConfig.ClientCertificateFile != "" {
+		fmt.Printf("client_certificate_file: %s\n", graphConfig.ClientCertificateFile)
+	}
+	if graphConfig.ProxyURL != "" {
+		fmt.Printf("proxy_url: %s\n", redactURL(graphConfig.ProxyURL))
+	}
+	fmt.Printf("smtp: %s:%d（ユーザー名: %s、パスワード: %s）\n", smtpConfig.Host, smtpConfig.Port, smtpConfig.Username, maskSecret(smtpConfig.Password))
+	for _, name := range config.OverriddenEnvNames() {
+		fmt.Printf("環境変数で上書き: %s\n", name)
+	}
+
+	fmt.Println("\n=== トークン ===")
+	token := checkDoctorToken(report, graphConfig, smtpConfig)
+
+	fmt.Println("\n=== 接続 ===")
+	// 設定の読み込み時に検証済み
+	proxy, _ := graphConfig.Proxy()
+	client := &http.Client{
+		Timeout:   doctorTimeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+	}
+	if proxy != nil {
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxy)}
+	}
+	for _, endpoint := range []struct{ name, url string }{
+		{"認証機関", strings.TrimRight(graphConfig.AuthorityURL, "/") + "/v2.0/.well-known/openid-configuration"},
+		{"Microsoft Graph", strings.TrimRight(graphConfig.Cloud().GraphEndpoint, "/") + "/v1.0/"},
+	} {
+		if err := checkReachable(client, endpoint.url); err != nil {
+			report.fail(endpoint.name, err)
+			continue
+		}
+		report.ok(endpoint.name, endpoint.url)
+	}
+
+	checkDoctorListeners(report, smtpConfig)
+
+	switch {
+	case token == nil:
+		report.skip("/me", "認証されていないため確認しません")
+	case token.IsExpired():
+		report.skip("/me", "アクセストークンが期限切れのため確認しません（serve や send の実行時に更新されます）")
+	default:
+		checkDoctorMe(report, token, graphConfig, proxy)
+	}
+
+	return report.finish()
+}
+
+// checkDoctorToken キャッシュされたトークンの状態を確認
+func checkDoctorToken(report *doctorReport, graphConfig config.GraphConfig, smtpConfig config.SMTPConfig) *auth.TokenResponse {
+	if account == "" {
+		account = smtpConfig.Account
+	}
+	fmt.Printf("保存先: %s\n", tokenStoreDescription(graphConfig))
+
+	store := auth.NewTokenStore(graphConfig.TokenStore, graphConfig.TokenCache, GetLogger())
+	token, err := store.Load(account)
+	if errors.Is(err, os.ErrNotExist) {
+		report.fail("トークン", fmt.Errorf("認証されていません（m3bridge auth で認証してください）"))
+		return nil
+	}
+	if err != nil {
+		report.fail("トークン", err)
+		return nil
+	}
+
+	fmt.Printf("アカウント: %s\n", token.Account)
+	fmt.Printf("スコープ: %s\n", token.Scope)
+	switch {
+	case !token.IsExpired():
+		report.ok("アクセストークン", fmt.Sprintf("有効（残り %s）", token.RemainingValidity().Round(time.Second)))
+	case token.RefreshToken != "":
+		report.warn("アクセストークン", "期限切れ（リフレッシュトークンで更新できます）")
+	default:
+		report.fail("アクセストークン", fmt.Errorf("期限切れで、リフレッシュトークンがありません（m3bridge auth で再認証してください）"))
+	}
+	if token.RefreshToken != "" {
+		report.ok("リフレッシュトークン", "あり")
+	} else {
+		report.warn("リフレッシュトークン", "なし（offline_access スコープがない可能性があります）")
+	}
+	return token
+}
+
+// checkDoctorListeners SMTPサーバの待ち受けアドレスを使用できるか確認
+func checkDoctorListeners(report *doctorReport, smtpConfig config.SMTPConfig) {
+	listeners, err := smtpListeners(smtpConfig)
+	if err != nil {
+		report.fail("SMTPポート", err)
+		return
+	}
+	for _, l := range listeners {
+		if l.Unix {
+			report.skip("SMTPソケット", fmt.Sprintf("%s は起動時に作成します", l.Addr))
+			continue
+		}
+		if err := checkListenAddr(l.Addr); err != nil {
+			report.fail("SMTPポート", err)
+			continue
+		}
+		report.ok("SMTPポート", fmt.Sprintf("%s で待ち受けできます", l.Addr))
+	}
+}
+
+// checkDoctorMe キャッシュのアクセストークンで /me を呼び出せるか確認
+func checkDoctorMe(report *doctorReport, token *auth.TokenResponse, graphConfig config.GraphConfig, proxy *url.URL) {
+	graphClient, err := graph.NewClientWithHTTPConfig(token.AccessToken, graph.HTTPConfig{Proxy: proxy}, GetLogger())
+	if err != nil {
+		report.fail("/me", err)
+		return
+	}
+	graphClient.SetEndpoint(graphConfig.Cloud().GraphEndpoint)
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	userInfo, err := graphClient.GetUserInfo(ctx)
+	if err != nil {
+		report.fail("/me", err)
+		return
+	}
+	report.ok("/me", fmt.Sprintf("%s（%s）", userInfo.DisplayName, userInfo.Address()))
+}
+
+// checkReachable URLにHTTPで接続できるか確認（ステータスコードは問わない）
+func checkReachable(client *http.Client, target string) error {
+	resp, err := client.Get(target)
+	if err != nil {
+		return fmt.Errorf("接続できません: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// redactURL URLに含まれるパスワードを伏せる
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "（解析できないURL）"
+	}
+	return u.Redacted()
+}
+
+// tokenStoreDescription トークンの保存先の説明
+func tokenStoreDescription(graphConfig config.GraphConfig) string {
+	if graphConfig.TokenStore == auth.TokenStoreKeyring {
+		return "OSのキーチェーン"
+	}
+	return graphConfig.TokenCache
+}
+
+// finish 診断結果のまとめを表示
+func (r *doctorReport) finish() error {
+	fmt.Printf("\n失敗: %d件、警告: %d件\n", r.failed, r.warned)
+	if r.failed > 0 {
+		return fmt.Errorf("診断で問題が見つかりました（%d件）", r.failed)
+	}
+	return nil
+}
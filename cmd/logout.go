@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/canaria-computer/m3bridge/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "サインアウトしてトークンキャッシュを削除",
+	Long: `キャッシュされたトークンを削除してサインアウトします。
+リフレッシュトークンがある場合は、Microsoft Graphにサインインセッションの失効をリクエストします。`,
+	RunE: runLogout,
+}
+
+var (
+	keepSessions bool
+)
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+	logoutCmd.Flags().StringVar(&account, "account", "", "サインアウトするアカウント")
+	logoutCmd.Flags().BoolVar(&keepSessions, "keep-sessions", false, "サインインセッションの失効を行わず、ローカルのキャッシュのみ削除")
+}
+
+func runLogout(cmd *cobra.Command, args []string) error {
+	logger := GetLogger()
+
+	// 設定を読み込む
+	cfg, err := loadConfig(logger, false)
+	if err != nil {
+		return err
+	}
+
+	graphConfig := cfg.GetGraphConfig(profile)
+
+	authenticator := auth.NewAuthenticator(newAuthConfig(graphConfig), logger)
+
+	token, err := authenticator.Logout(!keepSessions)
+	if err != nil {
+		return fmt.Errorf("サインアウトエラー: %w", err)
+	}
+
+	if token == nil {
+		fmt.Println("サインインしていません（トークンキャッシュはありません）")
+		return nil
+	}
+
+	name := token.PreferredUsername
+	if name == "" {
+		name = token.Account
+	}
+	fmt.Printf("サインアウトしました: %s\n", name)
+	if token.Scope != "" {
+		fmt.Printf("スコープ: %s\n", token.Scope)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/config"
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/canaria-computer/m3bridge/internal/queue"
+	"github.com/canaria-computer/m3bridge/internal/smtp"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "再送キューの操作",
+	Long: `一時的なエラーで送信できなかったメッセージの再送キューを確認・再送します。
+再送キューは設定ファイルの queue.enabled で有効にします。`,
+}
+
+var queueListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "再送待ちのメッセージを表示",
+	Args:         cobra.NoArgs,
+	RunE:         runQueueList,
+	SilenceUsage: true,
+}
+
+var queueFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "再送待ちのメッセージをすぐに再送",
+	Long: `再送の時刻を待たずに、再送待ちの全てのメッセージを送信します。
+キャッシュされたトークンを使用します。送信できなかったメッセージがある場合は終了コード1で終了します。`,
+	Args:         cobra.NoArgs,
+	RunE:         runQueueFlush,
+	SilenceUsage: true,
+}
+
+var queueListDead bool
+
+func init() {
+	rootCmd.AddCommand(queueCmd)
+	queueCmd.AddCommand(queueListCmd)
+	queueCmd.AddCommand(queueFlushCmd)
+	queueListCmd.Flags().BoolVar(&queueListDead, "dead", false, "再送を諦めたメッセージを表示")
+	queueFlushCmd.Flags().StringVar(&account, "account", "", "送信に使用するアカウント")
+	queueFlushCmd.Flags().DurationVar(&loginTimeout, "timeout", defaultLoginTimeout, "認証と再送を待つ最大時間")
+}
+
+func runQueueList(cmd *cobra.Command, args []string) error {
+	logger := GetLogger()
+
+	cfg, err := loadConfig(logger, false)
+	if err != nil {
+		return err
+	}
+	retryQueue, err := openQueue(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	entries, err := retryQueue.List()
+	if queueListDead {
+		entries, err = retryQueue.Dead()
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("メッセージはありません")
+		return nil
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s  受信: %s  試行: %d回  受信者: %d件  件名: %s\n",
+			entry.ID,
+			entry.CreatedAt.Local().Format(time.DateTime),
+			entry.Attempts,
+			len(entry.Message.To)+len(entry.Message.Cc)+len(entry.Message.Bcc),
+			entry.Message.Subject)
+		if !queueListDead {
+			fmt.Printf("  次回の再送: %s\n", entry.NextAttempt.Local().Format(time.DateTime))
+		}
+		if entry.LastError != "" {
+			fmt.Printf("  エラー: %s\n", entry.LastError)
+		}
+	}
+	return nil
+}
+
+func runQueueFlush(cmd *cobra.Command, args []string) error {
+	logger := GetLogger()
+
+	cfg, err := loadConfig(logger, false)
+	if err != nil {
+		return err
+	}
+	retryQueue, err := openQueue(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := loginContext()
+	defer stop()
+
+	graphClient, err := cachedGraphClient(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	sent, failed, err := retryQueue.Flush(ctx, queueSender(graphClient), smtp.IsTemporary)
+	if errors.Is(err, queue.ErrBusy) {
+		return fmt.Errorf("%w。serve が再送中の場合は、しばらくしてから再度実行してください", err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("送信: %d件、失敗: %d件\n", sent, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d件のメッセージを送信できませんでした（m3bridge queue list で確認できます）", failed)
+	}
+	return nil
+}
+
+// openQueue 設定の再送キューを開く（無効な場合も、残っているメッセージを確認できるよう開く）
+func openQueue(cfg *config.Manager, logger *log.Logger) (*queue.Queue, error) {
+	queueConfig := cfg.GetQueueConfig(profile)
+	return queue.New(queue.Config{
+		Dir:            queueConfig.Dir,
+		MaxAttempts:    queueConfig.MaxAttempts,
+		InitialBackoff: time.Duration(queueConfig.InitialBackoffSecs) * time.Second,
+		MaxBackoff:     time.Duration(queueConfig.MaxBackoffSecs) * time.Second,
+	}, logger)
+}
+
+// newQueue 設定で有効な場合に再送キューを作成（無効な場合はnil）
+func newQueue(cfg *config.Manager, logger *log.Logger) (*queue.Queue, error) {
+	if !cfg.GetQueueConfig(profile).Enabled {
+		return nil, nil
+	}
+	return openQueue(cfg, logger)
+}
+
+// queueSender 再送キューのメッセージをGraphで送信する関数
+func queueSender(graphClient *graph.Client) queue.SendFunc {
+	return func(ctx context.Context, entry *queue.Entry) error {
+		return graphClient.SendMessageFrom(ctx, entry.Mailbox, &entry.Message)
+	}
+}
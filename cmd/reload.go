@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/canaria-computer/m3bridge/internal/config"
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/canaria-computer/m3bridge/internal/smtp"
+	"github.com/charmbracelet/log"
+)
+
+// reloadSMTPServer 設定ファイルを読み込み直し、変更があればSMTPサーバを新しい設定で作り直す
+// 認証済みのGraphクライアントはそのまま使う。新しいサーバは待ち受けを開始した状態で返し、
+// 呼び出し側で起動して古いサーバを停止する（変わらないアドレスのソケットは新しいサーバに引き継ぐ）。
+// 変更がない場合は現在のサーバを返す。新しい設定に誤りがある場合や待ち受けを開始できない場合は、
+// 現在のサーバをそのまま動かしてエラーを返す
+func reloadSMTPServer(cfg *config.Manager, current config.SMTPConfig, currentServer smtp.Config, server *smtp.Server, graphClient *graph.Client, logger *log.Logger) (*smtp.Server, config.SMTPConfig, smtp.Config, error) {
+	if err := cfg.Reload(); err != nil {
+		return nil, current, currentServer, err
+	}
+	if !cfg.HasProfile(profile) {
+		return nil, current, currentServer, fmt.Errorf("プロファイル %s がありません", profile)
+	}
+
+	smtpConfig := cfg.GetSMTPConfig(profile)
+	graphConfig := cfg.GetGraphConfig(profile)
+	relayConfig := cfg.GetRelayConfig(profile)
+	applySMTPFlags(&smtpConfig)
+
+	serverConfig, err := newSMTPServerConfig(smtpConfig, graphConfig, relayConfig)
+	if err != nil {
+		return nil, current, currentServer, err
+	}
+	serverConfig.Recent = currentServer.Recent
+	serverConfig.Queue = currentServer.Queue
+
+	changed := changedFields(current, smtpConfig)
+	if !reflect.DeepEqual(currentServer.AllowedMailboxes, serverConfig.AllowedMailboxes) {
+		changed = append(changed, "graph.allowed_mailboxes")
+	}
+	if currentServer.SendAs != serverConfig.SendAs {
+		changed = append(changed, "graph.send_as")
+	}
+	if !reflect.DeepEqual(currentServer.PassthroughHeaders, serverConfig.PassthroughHeaders) {
+		changed = append(changed, "graph.passthrough_headers")
+	}
+	if currentServer.TextAlternative != serverConfig.TextAlternative {
+		changed = append(changed, "graph.text_alternative")
+	}
+	if !currentServer.Footer.Equal(serverConfig.Footer) {
+		changed = append(changed, "graph.footer")
+	}
+	if currentServer.Relay != serverConfig.Relay {
+		changed = append(changed, "relay")
+	}
+	if len(changed) == 0 {
+		logger.Info("SMTPサーバの設定に変更はありません")
+		return server, current, currentServer, nil
+	}
+	logger.Info("設定の変更を反映するため、SMTPサーバを再起動します", "changed", strings.Join(changed, ", "))
+
+	// 新しいサーバの待ち受けを開始してから切り替える
+	next := smtp.NewServer(serverConfig, smtp.NewGraphSender(graphClient), logger)
+	if err := next.Listen(server); err != nil {
+		return nil, current, currentServer, err
+	}
+
+	if smtpConfig.DisableAuth && !current.DisableAuth {
+		logger.Warn("SMTP認証が無効です。接続できる全てのクライアントがメールを送信できます")
+	}
+	return next, smtpConfig, serverConfig, nil
+}
+
+// changedFields 変更されたSMTP設定の項目名（JSONのキー）を返す
+// パスワードなどの値はログに出さないよう、項目名のみを返す
+func changedFields(before, after config.SMTPConfig) []string {
+	var changed []string
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	for i := range b.NumField() {
+		if reflect.DeepEqual(b.Field(i).Interface(), a.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(b.Type().Field(i).Tag.Get("json"), ",")
+		changed = append(changed, fmt.Sprintf("smtp.%s", name))
+	}
+	return changed
+}
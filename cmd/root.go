@@ -3,7 +3,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
+	"github.com/canaria-computer/m3bridge/internal/auth"
+	"github.com/canaria-computer/m3bridge/internal/config"
+	"github.com/canaria-computer/m3bridge/internal/logfile"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -11,7 +15,11 @@ import (
 
 var (
 	cfgFile string
+	profile string
 	logger  *log.Logger
+
+	strictPerms bool
+	fixPerms    bool
 )
 
 var rootCmd = &cobra.Command{
@@ -32,9 +40,21 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "設定ファイルパス (デフォルト: $HOME/.m3bridge.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", config.DefaultProfile, "使用するプロファイル（アカウントごとの設定）")
+	rootCmd.PersistentFlags().BoolVar(&strictPerms, "strict-perms", false, "設定ファイルやトークンキャッシュのパーミッションが広すぎる場合に起動しない")
+	rootCmd.PersistentFlags().BoolVar(&fixPerms, "fix-perms", false, "設定ファイルやトークンキャッシュのパーミッションが広すぎる場合に修正する")
 	rootCmd.PersistentFlags().String("log-level", "info", "ログレベル (debug, info, warn, error)")
 
+	rootCmd.PersistentFlags().String("log-format", "text", "ログの出力形式 (text, json, logfmt)")
+	rootCmd.PersistentFlags().String("log-file", "", "ログの出力先ファイル（デフォルト: 標準エラー出力）")
+	rootCmd.PersistentFlags().Int("log-max-size", 0, "ログファイルをローテーションするサイズ（MB、0の場合はローテーションしない）")
+	rootCmd.PersistentFlags().Int("log-max-backups", 3, "ローテーションで残す古いログファイルの数")
+
 	viper.BindPFlag("log-level", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("log-format", rootCmd.PersistentFlags().Lookup("log-format"))
+	viper.BindPFlag("log-file", rootCmd.PersistentFlags().Lookup("log-file"))
+	viper.BindPFlag("log-max-size", rootCmd.PersistentFlags().Lookup("log-max-size"))
+	viper.BindPFlag("log-max-backups", rootCmd.PersistentFlags().Lookup("log-max-backups"))
 
 	// ロガーの初期化
 	logger = log.New(os.Stderr)
@@ -74,6 +94,91 @@ func initConfig() {
 	case "error":
 		logger.SetLevel(log.ErrorLevel)
 	}
+
+	// ログの出力形式の設定（ログ収集基盤向けにキーを保ったまま出力する）
+	logFormat := viper.GetString("log-format")
+	switch logFormat {
+	case "text":
+		logger.SetFormatter(log.TextFormatter)
+	case "json":
+		logger.SetFormatter(log.JSONFormatter)
+		logger.SetReportTimestamp(true)
+	case "logfmt":
+		logger.SetFormatter(log.LogfmtFormatter)
+		logger.SetReportTimestamp(true)
+	default:
+		fmt.Printf("不明なログの出力形式です: %s（text, json, logfmt のいずれかを指定してください）\n", logFormat)
+		os.Exit(1)
+	}
+
+	// ログの出力先の設定（指定がなければ標準エラー出力のまま）
+	if logPath := viper.GetString("log-file"); logPath != "" {
+		writer, err := logfile.Open(logfile.Config{
+			Path:       os.ExpandEnv(logPath),
+			MaxSize:    int64(viper.GetInt("log-max-size")) * 1024 * 1024,
+			MaxBackups: viper.GetInt("log-max-backups"),
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		logger.SetOutput(writer)
+	}
+}
+
+// loadConfig 設定を読み込み、--profile のプロファイルがあるか確認
+// createがtrueの場合は、プロファイルがなければ初期値で作成する
+func loadConfig(logger *log.Logger, create bool) (*config.Manager, error) {
+	cfg, err := config.NewManager(logger)
+	if err != nil {
+		return nil, fmt.Errorf("設定読み込みエラー: %w", err)
+	}
+	if !cfg.HasProfile(profile) {
+		if !create {
+			return nil, fmt.Errorf("プロファイル %s がありません（m3bridge auth --profile %s で作成できます）", profile, profile)
+		}
+		if err := cfg.AddProfile(profile); err != nil {
+			return nil, fmt.Errorf("プロファイル作成エラー: %w", err)
+		}
+	}
+	if err := checkPermissions(cfg, logger); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// checkPermissions 設定ファイルとトークンキャッシュのパーミッションを確認
+// 広すぎる場合は警告し、--fix-perms で修正、--strict-perms でエラーにする
+func checkPermissions(cfg *config.Manager, logger *log.Logger) error {
+	files := []string{cfg.GetConfigPath()}
+	if graphConfig := cfg.GetGraphConfig(profile); graphConfig.TokenStore != auth.TokenStoreKeyring {
+		files = append(files, graphConfig.TokenCache)
+	}
+
+	issues, err := config.CheckPermissions(filepath.Dir(cfg.GetConfigPath()), files...)
+	if err != nil {
+		return err
+	}
+
+	var unresolved []config.PermissionIssue
+	for _, issue := range issues {
+		if fixPerms {
+			if err := issue.Fix(); err != nil {
+				return fmt.Errorf("パーミッション修正エラー: %w", err)
+			}
+			logger.Info("パーミッションを修正しました", "path", issue.Path, "mode", fmt.Sprintf("%#o", issue.Want))
+			continue
+		}
+		logger.Warn("シークレットを含むファイルを他のユーザーが読める可能性があります（--fix-perms で修正できます）",
+			"path", issue.Path,
+			"mode", fmt.Sprintf("%#o", issue.Mode),
+			"want", fmt.Sprintf("%#o", issue.Want))
+		unresolved = append(unresolved, issue)
+	}
+	if strictPerms && len(unresolved) > 0 {
+		return fmt.Errorf("パーミッションが広すぎます: %s", unresolved[0])
+	}
+	return nil
 }
 
 func GetLogger() *log.Logger {
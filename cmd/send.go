@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/canaria-computer/m3bridge/internal/auth"
+	"github.com/canaria-computer/m3bridge/internal/config"
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/canaria-computer/m3bridge/internal/markdown"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send",
+	Short: "メールを1通送信",
+	Long: `SMTPサーバを起動せずに、Microsoft Graphでメールを1通送信します。
+キャッシュされたトークンを使用するため、事前に m3bridge auth で認証してください。
+cronやCIなどのスクリプトからの利用を想定しており、送信に失敗した場合は終了コード1で終了します。`,
+	RunE:         runSend,
+	SilenceUsage: true,
+}
+
+var (
+	sendTo       []string
+	sendCc       []string
+	sendSubject  string
+	sendBody     string
+	sendBodyFile string
+	sendHTML     bool
+	sendMarkdown bool
+	sendAttach   []string
+
+	sendAutoSubmitted bool
+)
+
+func init() {
+	rootCmd.AddCommand(sendCmd)
+	sendCmd.Flags().StringSliceVar(&sendTo, "to", nil, "宛先（複数指定またはカンマ区切り）")
+	sendCmd.Flags().StringSliceVar(&sendCc, "cc", nil, "CC（複数指定またはカンマ区切り）")
+	sendCmd.Flags().StringVar(&sendSubject, "subject", "", "件名")
+	sendCmd.Flags().StringVar(&sendBody, "body", "", "本文")
+	sendCmd.Flags().StringVar(&sendBodyFile, "body-file", "", "本文を読み込むファイル（- で標準入力）")
+	sendCmd.Flags().BoolVar(&sendHTML, "html", false, "本文をHTMLとして送信")
+	sendCmd.Flags().BoolVar(&sendMarkdown, "markdown", false, "本文をMarkdownとしてHTMLに変換して送信")
+	sendCmd.Flags().StringArrayVar(&sendAttach, "attach", nil, "添付ファイルのパス（複数指定可）")
+	sendCmd.Flags().BoolVar(&sendAutoSubmitted, "auto-submitted", false, "自動送信のメールとして X-Auto-Submitted: auto-generated を付け、自動応答を抑止する")
+	sendCmd.Flags().StringVar(&account, "account", "", "送信に使用するアカウント")
+	sendCmd.Flags().DurationVar(&loginTimeout, "timeout", defaultLoginTimeout, "認証と送信を待つ最大時間")
+	sendCmd.MarkFlagRequired("to")
+	sendCmd.MarkFlagsMutuallyExclusive("body", "body-file")
+	sendCmd.MarkFlagsMutuallyExclusive("html", "markdown")
+}
+
+func runSend(cmd *cobra.Command, args []string) error {
+	logger := GetLogger()
+
+	body, err := readSendBody()
+	if err != nil {
+		return err
+	}
+	isHTML := sendHTML
+	if sendMarkdown {
+		body = markdown.ToHTML(body)
+		isHTML = true
+	}
+	attachments, err := readAttachments(sendAttach)
+	if err != nil {
+		return err
+	}
+
+	// 設定を読み込む
+	cfg, err := loadConfig(logger, false)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := loginContext()
+	defer stop()
+
+	graphClient, err := cachedGraphClient(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	err = graphClient.SendMessage(ctx, &graph.Message{
+		From:        cfg.GetGraphConfig(profile).SendAs,
+		To:          sendTo,
+		Cc:          sendCc,
+		Subject:     sendSubject,
+		Body:        body,
+		IsHTML:      isHTML,
+		Attachments: attachments,
+		Headers:     sendHeaders(),
+	})
+	if err != nil {
+		return fmt.Errorf("メール送信エラー: %w", err)
+	}
+
+	logger.Info("メールを送信しました",
+		"subject", sendSubject,
+		"to_count", len(sendTo),
+		"cc_count", len(sendCc),
+		"attachments", len(attachments))
+	return nil
+}
+
+// sendHeaders 送信するメールに追加するヘッダー
+// --auto-submitted の場合は、受信者の自動応答がブリッジに送り返されてループにならないようにする。
+// GraphはX-で始まるヘッダーしか設定できないため、Auto-Submitted（RFC 3834）は X-Auto-Submitted として送る
+func sendHeaders() []graph.Header {
+	if !sendAutoSubmitted {
+		return nil
+	}
+	return []graph.Header{
+		{Name: "X-Auto-Submitted", Value: "auto-generated"},
+		{Name: "X-Auto-Response-Suppress", Value: "All"},
+	}
+}
+
+// cachedGraphClient キャッシュされたトークンでGraphクライアントを作成
+// スクリプトから実行されるため、キャッシュにトークンがなくてもブラウザでの認証は始めない
+func cachedGraphClient(ctx context.Context, cfg *config.Manager, logger *log.Logger) (*graph.Client, error) {
+	graphConfig := cfg.GetGraphConfig(profile)
+	if account == "" {
+		account = cfg.GetSMTPConfig(profile).Account
+	}
+
+	// クライアントの資格情報と送信ユーザーが設定されている場合はアプリのみの認証を使用
+	hasCredential := graphConfig.ClientSecret != "" || graphConfig.ClientCertificateFile != ""
+	appOnly := hasCredential && graphConfig.SenderUserID != ""
+
+	authConfig := newAuthConfig(graphConfig)
+	authConfig.ClientCredentials = appOnly
+
+	if !appOnly {
+		store := auth.NewTokenStore(graphConfig.TokenStore, graphConfig.TokenCache, logger)
+		if _, err := store.Load(account); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("認証されていません。m3bridge auth で認証してください")
+			}
+			return nil, fmt.Errorf("トークンキャッシュ読み込みエラー: %w", err)
+		}
+	}
+	authenticator := auth.NewAuthenticator(authConfig, logger)
+
+	accessToken, err := authenticator.GetAccessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("トークン取得エラー: %w", err)
+	}
+
+	graphClient, err := newGraphClient(graphConfig, authConfig, accessToken, logger)
+	if err != nil {
+		return nil, err
+	}
+	graphClient.SetTokenRefresher(authenticator.ForceRefresh)
+	return graphClient, nil
+}
+
+// readSendBody --body または --body-file から本文を読み込む
+func readSendBody() (string, error) {
+	switch sendBodyFile {
+	case "":
+		return sendBody, nil
+	case "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("標準入力の読み込みエラー: %w", err)
+		}
+		return string(data), nil
+	default:
+		data, err := os.ReadFile(sendBodyFile)
+		if err != nil {
+			return "", fmt.Errorf("本文ファイル読み込みエラー: %w", err)
+		}
+		return string(data), nil
+	}
+}
+
+// readAttachments 添付ファイルを読み込む
+// Content-Typeは拡張子から決定する
+func readAttachments(paths []string) ([]graph.Attachment, error) {
+	attachments := make([]graph.Attachment, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("添付ファイル読み込みエラー: %w", err)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		attachments = append(attachments, graph.Attachment{
+			Name:        filepath.Base(path),
+			ContentType: contentType,
+			Content:     content,
+		})
+	}
+	return attachments, nil
+}
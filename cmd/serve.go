@@ -2,18 +2,32 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/canaria-computer/m3bridge/internal/auth"
 	"github.com/canaria-computer/m3bridge/internal/config"
 	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/canaria-computer/m3bridge/internal/metrics"
 	"github.com/canaria-computer/m3bridge/internal/smtp"
+	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 )
 
+// tokenRefreshMargin 有効期限のどれだけ前にトークンを更新するか
+const tokenRefreshMargin = 10 * time.Minute
+
+// defaultShutdownTimeout 停止時に処理中のセッションの終了を待つ時間のデフォルト値
+const defaultShutdownTimeout = 30 * time.Second
+
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "SMTPサーバを起動",
@@ -22,12 +36,51 @@ var serveCmd = &cobra.Command{
 }
 
 var (
-	port int
+	port            int
+	tlsPort         int
+	relayFallback   bool
+	dryRun          bool
+	serveDeviceCode bool
+	debugAddr       string
+	debugBufferSize int
+	metricsAddr     string
+	healthAddr      string
+	shutdownTimeout time.Duration
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	maxMessageBytes int64
+	maxRecipients   int
+	maxConnections  int
+	socketPath      string
+	socketOnly      bool
+	listenAddrs     []string
 )
 
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.Flags().IntVarP(&port, "port", "p", 2525, "SMTPサーバのポート番号")
+	serveCmd.Flags().IntVar(&tlsPort, "tls-port", 0, "暗黙的TLS（SMTPS）で待ち受けるポート番号（例: 465）。設定ファイルの smtp.tls_port より優先")
+	serveCmd.Flags().StringArrayVar(&listenAddrs, "listen", nil, "待ち受けるアドレス（host:port、末尾に :tls で暗黙的TLS）。複数回指定可。設定ファイルの smtp.listen と smtp.host/smtp.port/smtp.tls_port より優先")
+	serveCmd.Flags().StringVar(&socketPath, "socket", "", "TCPに加えて待ち受けるUnixドメインソケットのパス（パーミッションは0600）。設定ファイルの smtp.socket より優先")
+	serveCmd.Flags().BoolVar(&socketOnly, "socket-only", false, "TCPで待ち受けず、Unixドメインソケットのみを使う")
+	serveCmd.Flags().StringVar(&account, "account", "", "送信に使用するアカウント（未指定の場合は smtp.account またはキャッシュ上の唯一のアカウント）")
+	serveCmd.Flags().StringVar(&callbackAddr, "callback-addr", "", "認証コールバックの待ち受けアドレス（例: localhost:5225、ポート0で自動割り当て）")
+	serveCmd.Flags().BoolVar(&relayFallback, "relay-fallback", false, "Graph送信失敗時に設定済みの上流SMTPリレーへ転送")
+	serveCmd.Flags().BoolVar(&dryRun, "dry-run", false, "メッセージを解析してログに記録するが、送信しない（SMTPクライアントの動作確認用）")
+	serveCmd.Flags().StringVar(&debugAddr, "debug-addr", "", "直近の受信メッセージを確認するデバッグエンドポイントのアドレス（ループバックのみ）")
+	serveCmd.Flags().IntVar(&debugBufferSize, "debug-buffer", 20, "デバッグエンドポイントで保持するメッセージ数")
+	serveCmd.Flags().StringVar(&healthAddr, "health-addr", "", "ヘルスチェック（/healthz、/readyz）のアドレス（例: :8080）")
+	serveCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Prometheus形式のメトリクスを公開するアドレス（例: localhost:9125）")
+	serveCmd.Flags().BoolVar(&serveDeviceCode, "device-code", false, "デバイスコードフローで認証（ブラウザのない環境向け）")
+	serveCmd.Flags().BoolVar(&noBrowser, "no-browser", false, "認証URLをブラウザで自動的に開かない")
+	serveCmd.Flags().DurationVar(&loginTimeout, "timeout", defaultLoginTimeout, "起動時の認証を待つ最大時間")
+	serveCmd.Flags().DurationVar(&readTimeout, "read-timeout", 0, "クライアントからの読み込みのタイムアウト（デフォルト: 60s）。設定ファイルの smtp.read_timeout_secs より優先")
+	serveCmd.Flags().DurationVar(&writeTimeout, "write-timeout", 0, "クライアントへの書き込みのタイムアウト（デフォルト: 60s）。設定ファイルの smtp.write_timeout_secs より優先")
+	serveCmd.Flags().Int64Var(&maxMessageBytes, "max-message-bytes", 0, "1通のメッセージの最大サイズ（デフォルト: 10MB）。設定ファイルの smtp.max_message_bytes より優先")
+	serveCmd.Flags().IntVar(&maxRecipients, "max-recipients", 0, "1通のメッセージの最大受信者数（デフォルト: 50）。設定ファイルの smtp.max_recipients より優先")
+	serveCmd.Flags().IntVar(&maxConnections, "max-connections", 0, "同時に処理するSMTPセッションの最大数（デフォルト: 無制限）。設定ファイルの smtp.max_connections より優先")
+	serveCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "停止時に処理中のメッセージの送信完了を待つ最大時間")
+	addAuthorityFlags(serveCmd)
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
@@ -35,22 +88,44 @@ func runServe(cmd *cobra.Command, args []string) error {
 	logger.Info("SMTPサーバを起動します")
 
 	// 設定を読み込む
-	cfg, err := config.NewManager(logger)
+	cfg, err := loadConfig(logger, false)
 	if err != nil {
-		return fmt.Errorf("設定読み込みエラー: %w", err)
+		return err
+	}
+
+	smtpConfig := cfg.GetSMTPConfig(profile)
+	graphConfig := cfg.GetGraphConfig(profile)
+	if callbackAddr != "" {
+		graphConfig.CallbackAddr = callbackAddr
+	}
+	if err := applyAuthorityFlags(&graphConfig); err != nil {
+		return err
 	}
+	relayConfig := cfg.GetRelayConfig(profile)
 
-	smtpConfig := cfg.GetSMTPConfig()
-	graphConfig := cfg.GetGraphConfig()
+	// SMTP認証情報に紐付けられたアカウントを使用
+	if account == "" {
+		account = smtpConfig.Account
+	}
 
 	// ポートが指定された場合は更新
 	if port != 2525 {
 		smtpConfig.Port = port
-		if err := cfg.UpdateSMTPPort(port); err != nil {
+		if err := cfg.UpdateSMTPPort(profile, port); err != nil {
 			logger.Warn("ポート設定更新失敗", "error", err)
 		}
 	}
 
+	applySMTPFlags(&smtpConfig)
+	serverConfig, err := newSMTPServerConfig(smtpConfig, graphConfig, relayConfig)
+	if err != nil {
+		return err
+	}
+
+	if smtpConfig.DisableAuth {
+		logger.Warn("SMTP認証が無効です。接続できる全てのクライアントがメールを送信できます")
+	}
+
 	// SMTP接続情報を表示
 	logger.Info("SMTP設定情報",
 		"host", smtpConfig.Host,
@@ -58,26 +133,22 @@ func runServe(cmd *cobra.Command, args []string) error {
 		"username", smtpConfig.Username,
 		"password", smtpConfig.Password)
 
-	fmt.Println("\n=== SMTP接続情報 ===")
-	fmt.Printf("サーバ: %s:%d\n", smtpConfig.Host, smtpConfig.Port)
-	fmt.Printf("ユーザー名: %s\n", smtpConfig.Username)
-	fmt.Printf("パスワード: %s\n", smtpConfig.Password)
-	fmt.Printf("セキュリティ: なし（平文）\n")
-	fmt.Printf("設定ファイル: %s\n", cfg.GetConfigPath())
-	fmt.Println("=====================\n")
+	// クライアントの資格情報と送信ユーザーが設定されている場合はアプリのみの認証を使用
+	hasCredential := graphConfig.ClientSecret != "" || graphConfig.ClientCertificateFile != ""
+	appOnly := hasCredential && graphConfig.SenderUserID != ""
 
 	// 認証マネージャーを作成
-	authenticator := auth.NewAuthenticator(
-		graphConfig.ClientID,
-		graphConfig.RedirectURI,
-		graphConfig.AuthorityURL,
-		graphConfig.TokenCache,
-		logger,
-	)
-
-	// アクセストークンを取得
+	authConfig := newAuthConfig(graphConfig)
+	authConfig.DeviceCode = serveDeviceCode
+	authConfig.ClientCredentials = appOnly
+	authenticator := auth.NewAuthenticator(authConfig, logger)
+
+	// アクセストークンを取得（Ctrl-Cまたはタイムアウトで中断）
 	logger.Info("Microsoft Graphで認証します")
-	accessToken, err := authenticator.GetAccessToken()
+	loginCtx, stopLogin := loginContext()
+	defer stopLogin()
+
+	accessToken, err := authenticator.GetAccessToken(loginCtx)
 	if err != nil {
 		return fmt.Errorf("トークン取得エラー: %w", err)
 	}
@@ -85,47 +156,478 @@ func runServe(cmd *cobra.Command, args []string) error {
 	logger.Info("認証成功")
 
 	// Graphクライアントを作成
-	graphClient, err := graph.NewClient(accessToken, logger)
+	// 全てのSMTPセッションで同じクライアント（HTTP接続）を使い回す
+	graphClient, err := newGraphClient(graphConfig, authConfig, accessToken, logger)
 	if err != nil {
-		return fmt.Errorf("Graphクライアント作成エラー: %w", err)
+		return err
+	}
+
+	// ユーザー情報を確認（id_tokenがあればGraphの呼び出しを省略）
+	var sender string
+	if identity, err := authenticator.Identity(); err == nil && !appOnly {
+		logger.Info("サインインアカウント",
+			"name", identity.Name,
+			"preferred_username", identity.PreferredUsername)
+		sender = identity.PreferredUsername
+	} else {
+		userInfo, err := graphClient.GetUserInfo(loginCtx)
+		if err != nil {
+			return fmt.Errorf("ユーザー情報取得エラー: %w", err)
+		}
+		logger.Info("送信ユーザー",
+			"displayName", userInfo.DisplayName,
+			"mail", userInfo.Mail)
+		sender = userInfo.Address()
+	}
+	stopLogin()
+	if graphConfig.SendAs != "" {
+		sender = graphConfig.SendAs
 	}
 
-	// ユーザー情報を取得して確認
-	if err := graphClient.GetUserInfo(context.Background()); err != nil {
-		return fmt.Errorf("ユーザー情報取得エラー: %w", err)
+	fmt.Println("\n=== SMTP接続情報 ===")
+	for _, l := range serverConfig.Listeners {
+		switch {
+		case l.Unix:
+			fmt.Printf("ソケット: %s\n", l.Addr)
+		case l.TLS:
+			fmt.Printf("SMTPS（暗黙的TLS）: %s\n", l.Addr)
+		default:
+			fmt.Printf("サーバ: %s\n", l.Addr)
+		}
+	}
+	fmt.Printf("ユーザー名: %s\n", smtpConfig.Username)
+	fmt.Printf("パスワード: %s\n", smtpConfig.Password)
+	fmt.Printf("セキュリティ: %s\n", securityDescription(serverConfig.TLS != nil, smtpConfig.RequireTLS))
+	fmt.Printf("送信元: %s\n", sender)
+	fmt.Printf("設定ファイル: %s（プロファイル: %s）\n", cfg.GetConfigPath(), profile)
+	if dryRun {
+		fmt.Println("ドライラン: 有効（メッセージは送信されません）")
+	}
+	fmt.Print("=====================\n\n")
+
+	// デバッグエンドポイントを起動
+	var recent *smtp.RecentMessages
+	if debugAddr != "" {
+		if err := requireLoopback(debugAddr); err != nil {
+			return fmt.Errorf("デバッグエンドポイント設定エラー: %w", err)
+		}
+		recent = smtp.NewRecentMessages(debugBufferSize)
+		if err := startDebugServer(debugAddr, recent, logger); err != nil {
+			return err
+		}
+	}
+
+	// ヘルスチェックエンドポイントを起動
+	if healthAddr != "" {
+		if err := startHealthServer(healthAddr, authenticator, logger); err != nil {
+			return err
+		}
+	}
+
+	// メトリクスエンドポイントを起動
+	if metricsAddr != "" {
+		if err := startMetricsServer(metricsAddr, logger); err != nil {
+			return err
+		}
+	}
+
+	// 再送キューを作成
+	retryQueue, err := newQueue(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	if dryRun && retryQueue != nil {
+		// ドライランでは再送キューに残っているメッセージも送信しない
+		logger.Info("ドライランのため再送キューを使用しません", "dir", retryQueue.Dir())
+		retryQueue = nil
 	}
 
 	// SMTPサーバを作成
-	server := smtp.NewServer(smtp.Config{
-		Host:     smtpConfig.Host,
-		Port:     smtpConfig.Port,
-		Username: smtpConfig.Username,
-		Password: smtpConfig.Password,
-	}, graphClient, logger)
+	serverConfig.Recent = recent
+	serverConfig.Queue = retryQueue
+	server := smtp.NewServer(serverConfig, smtp.NewGraphSender(graphClient), logger)
 
-	// シグナルハンドリング
+	// トークンを有効期限の前にバックグラウンドで更新
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	if retryQueue != nil {
+		logger.Info("再送キューを使用します", "dir", retryQueue.Dir())
+		go retryQueue.Run(refreshCtx, queueSender(graphClient), smtp.IsTemporary)
+	}
+	go authenticator.AutoRefresh(refreshCtx, tokenRefreshMargin, graphClient.SetAccessToken)
+	// バックグラウンド更新の前にトークンが拒否された場合は、送信時に取得し直す
+	graphClient.SetTokenRefresher(authenticator.ForceRefresh)
+
+	// シグナルハンドリング（SIGHUPで設定を再読み込み）
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
 
-	errChan := make(chan error, 1)
+	// 再読み込みで停止した古いサーバの結果は無視する
+	errChan := make(chan serverResult, 1)
+	start := func(s *smtp.Server) {
+		go func() {
+			errChan <- serverResult{server: s, err: s.Start()}
+		}()
+	}
+
+	// 再読み込みで置き換えた古いサーバは、処理中の送信が終わるのを別のゴルーチンで待って停止する
+	// 停止するときは待機中の古いサーバの停止も待つ（再度シグナルを受けた場合は直ちに停止）
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	var draining sync.WaitGroup
+	drain := func(s *smtp.Server) {
+		draining.Go(func() {
+			ctx, cancel := context.WithTimeout(drainCtx, shutdownTimeout)
+			defer cancel()
+			if err := s.Shutdown(ctx); err != nil {
+				logger.Warn("再読み込み前のSMTPサーバ停止エラー", "error", err)
+			}
+		})
+	}
 
 	// サーバをゴルーチンで起動
-	go func() {
-		errChan <- server.Start()
-	}()
+	start(server)
 
 	// シグナルまたはエラーを待機
-	select {
-	case sig := <-sigChan:
-		logger.Info("シグナル受信、サーバを停止します", "signal", sig)
-		if err := server.Stop(); err != nil {
-			logger.Error("サーバ停止エラー", "error", err)
+	for {
+		select {
+		case <-reloadChan:
+			logger.Info("SIGHUPを受信、設定を再読み込みします")
+			next, nextSMTPConfig, nextServerConfig, err := reloadSMTPServer(cfg, smtpConfig, serverConfig, server, graphClient, logger)
+			if err != nil {
+				logger.Error("設定の再読み込みに失敗しました。現在の設定で動作を続けます", "error", err)
+				continue
+			}
+			if next == server {
+				continue
+			}
+			previous := server
+			server, smtpConfig, serverConfig = next, nextSMTPConfig, nextServerConfig
+			start(server)
+			drain(previous)
+		case sig := <-sigChan:
+			logger.Info("シグナル受信、サーバを停止します", "signal", sig, "timeout", shutdownTimeout)
+			// 送信中のメッセージが完了するまで待つ（待機中に再度シグナルを受けた場合は直ちに停止）
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			go func() {
+				select {
+				case <-sigChan:
+					logger.Warn("再度シグナルを受信したため、直ちに停止します")
+					cancel()
+					cancelDrain()
+				case <-ctx.Done():
+				}
+			}()
+			if err := server.Shutdown(ctx); err != nil {
+				logger.Error("サーバ停止エラー", "error", err)
+			}
+			draining.Wait()
+			return nil
+		case result := <-errChan:
+			if result.server != server {
+				continue
+			}
+			if result.err != nil {
+				return fmt.Errorf("サーバエラー: %w", result.err)
+			}
+			return nil
 		}
-		return nil
-	case err := <-errChan:
-		if err != nil {
-			return fmt.Errorf("サーバエラー: %w", err)
+	}
+}
+
+// serverResult SMTPサーバの終了結果
+type serverResult struct {
+	server *smtp.Server
+	err    error
+}
+
+// newGraphClient Graph設定からGraphクライアントを作成
+// アプリのみの認証では/meが使えないため、送信ユーザーを固定する
+func newGraphClient(graphConfig config.GraphConfig, authConfig auth.Config, accessToken string, logger *log.Logger) (*graph.Client, error) {
+	graphClient, err := graph.NewClientWithHTTPConfig(accessToken, graph.HTTPConfig{
+		MaxIdleConns:        graphConfig.HTTP.MaxIdleConns,
+		MaxIdleConnsPerHost: graphConfig.HTTP.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Duration(graphConfig.HTTP.IdleConnTimeoutSecs) * time.Second,
+		KeepAlive:           time.Duration(graphConfig.HTTP.KeepAliveSecs) * time.Second,
+		Proxy:               authConfig.Proxy,
+	}, logger)
+	if err != nil {
+		return nil, fmt.Errorf("Graphクライアント作成エラー: %w", err)
+	}
+
+	graphClient.SetEndpoint(graphConfig.Cloud().GraphEndpoint)
+	graphClient.SetSaveToSentItems(graphConfig.SaveToSent())
+	graphClient.SetArchiveBcc(graphConfig.ArchiveBcc)
+	if graphConfig.SendMaxAttempts > 0 {
+		graphClient.SetMaxSendAttempts(graphConfig.SendMaxAttempts)
+	}
+
+	if authConfig.ClientCredentials {
+		logger.Info("アプリのみの認証で送信します", "sender", graphConfig.SenderUserID)
+		graphClient = graphClient.ForMailbox(graphConfig.SenderUserID)
+	}
+	return graphClient, nil
+}
+
+// applySMTPFlags コマンドラインのフラグでSMTP設定を上書きする
+func applySMTPFlags(smtpConfig *config.SMTPConfig) {
+	if port != 2525 {
+		smtpConfig.Port = port
+	}
+	if tlsPort != 0 {
+		smtpConfig.TLSPort = tlsPort
+	}
+	if readTimeout != 0 {
+		smtpConfig.ReadTimeoutSecs = int(readTimeout.Seconds())
+	}
+	if writeTimeout != 0 {
+		smtpConfig.WriteTimeoutSecs = int(writeTimeout.Seconds())
+	}
+	if maxMessageBytes != 0 {
+		smtpConfig.MaxMessageBytes = maxMessageBytes
+	}
+	if maxRecipients != 0 {
+		smtpConfig.MaxRecipients = maxRecipients
+	}
+	if maxConnections != 0 {
+		smtpConfig.MaxConnections = maxConnections
+	}
+	if len(listenAddrs) > 0 {
+		smtpConfig.Listen = listenAddrs
+	}
+	if socketPath != "" {
+		smtpConfig.Socket = socketPath
+	}
+	if socketOnly {
+		smtpConfig.SocketOnly = true
+	}
+}
+
+// newSMTPServerConfig 設定ファイルの内容からSMTPサーバの設定を作成
+func newSMTPServerConfig(smtpConfig config.SMTPConfig, graphConfig config.GraphConfig, relayConfig config.RelayConfig) (smtp.Config, error) {
+	if err := smtpConfig.ValidateLimits(); err != nil {
+		return smtp.Config{}, fmt.Errorf("SMTP設定エラー: %w", err)
+	}
+	listeners, err := smtpListeners(smtpConfig)
+	if err != nil {
+		return smtp.Config{}, fmt.Errorf("SMTP設定エラー: %w", err)
+	}
+	if relayFallback && relayConfig.Host == "" {
+		return smtp.Config{}, fmt.Errorf("--relay-fallback には設定ファイルの relay.host が必要です")
+	}
+
+	xoauth2Mode, err := smtp.ParseXOAuth2Mode(smtpConfig.XOAuth2)
+	if err != nil {
+		return smtp.Config{}, fmt.Errorf("SMTP設定エラー: %w", err)
+	}
+
+	allowedNetworks, err := smtp.ParseNetworks(smtpConfig.AllowedNetworks)
+	if err != nil {
+		return smtp.Config{}, fmt.Errorf("SMTP設定エラー: smtp.allowed_networks: %w", err)
+	}
+
+	if err := smtp.ValidatePassthroughHeaders(graphConfig.PassthroughHeaders); err != nil {
+		return smtp.Config{}, fmt.Errorf("Graph設定エラー: passthrough_headers: %w", err)
+	}
+	textAlternative, err := smtp.ParseTextAlternativeMode(graphConfig.TextAlternative)
+	if err != nil {
+		return smtp.Config{}, fmt.Errorf("Graph設定エラー: %w", err)
+	}
+	footer, err := smtp.ParseFooter(graphConfig.FooterHTML, graphConfig.FooterText)
+	if err != nil {
+		return smtp.Config{}, fmt.Errorf("Graph設定エラー: %w", err)
+	}
+
+	// STARTTLSとSMTPSの設定
+	tlsConfig, err := smtpTLSConfig(smtpConfig, listeners)
+	if err != nil {
+		return smtp.Config{}, err
+	}
+
+	return smtp.Config{
+		Listeners: listeners,
+
+		Username: smtpConfig.Username,
+		Password: smtpConfig.Password,
+
+		AuthDisabled: smtpConfig.DisableAuth,
+		XOAuth2:      xoauth2Mode,
+
+		MaxLineLength:    smtpConfig.MaxLineLength,
+		MaxMessageBytes:  smtpConfig.MaxMessageBytes,
+		MaxBufferedBytes: smtpConfig.MaxBufferedBytes,
+		MaxRecipients:    smtpConfig.MaxRecipients,
+		MaxConnections:   smtpConfig.MaxConnections,
+		MaxReceived:      smtpConfig.MaxReceivedHeaders,
+		AllowedNetworks:  allowedNetworks,
+		ReadTimeout:      time.Duration(smtpConfig.ReadTimeoutSecs) * time.Second,
+		WriteTimeout:     time.Duration(smtpConfig.WriteTimeoutSecs) * time.Second,
+		TLS:              tlsConfig,
+		RequireTLS:       smtpConfig.RequireTLS,
+
+		Recipients: smtp.RecipientPolicy{
+			AllowedDomains: smtpConfig.AllowedRecipientDomains,
+			Denied:         smtpConfig.DeniedRecipients,
+		},
+		RejectAutoSubmitted: smtpConfig.RejectAutoSubmitted,
+
+		AllowedMailboxes: graphConfig.AllowedMailboxes,
+		SendAs:           graphConfig.SendAs,
+
+		PassthroughHeaders: graphConfig.PassthroughHeaders,
+		TextAlternative:    textAlternative,
+		Footer:             footer,
+
+		DryRun:        dryRun,
+		RelayFallback: relayFallback,
+		Relay: smtp.RelayConfig{
+			Host:     relayConfig.Host,
+			Port:     relayConfig.Port,
+			Username: relayConfig.Username,
+			Password: relayConfig.Password,
+		},
+	}, nil
+}
+
+// smtpListeners SMTP設定から待ち受けるアドレスの一覧を作成
+// smtp.listen を指定した場合は smtp.host/smtp.port/smtp.tls_port の代わりに使い、smtp.socket は常に追加する
+func smtpListeners(smtpConfig config.SMTPConfig) ([]smtp.Listener, error) {
+	var listeners []smtp.Listener
+	switch {
+	case smtpConfig.SocketOnly:
+		if smtpConfig.Socket == "" {
+			return nil, fmt.Errorf("smtp.socket_only（--socket-only）には smtp.socket（--socket）が必要です")
+		}
+		if len(smtpConfig.Listen) > 0 {
+			return nil, fmt.Errorf("smtp.socket_only（--socket-only）と smtp.listen（--listen）は同時に指定できません")
+		}
+	case len(smtpConfig.Listen) > 0:
+		for _, value := range smtpConfig.Listen {
+			l, err := smtp.ParseListener(value)
+			if err != nil {
+				return nil, fmt.Errorf("smtp.listen: %w", err)
+			}
+			listeners = append(listeners, l)
 		}
+	default:
+		listeners = append(listeners, smtp.Listener{Addr: net.JoinHostPort(smtpConfig.Host, strconv.Itoa(smtpConfig.Port))})
+		if smtpConfig.TLSPort > 0 {
+			listeners = append(listeners, smtp.Listener{Addr: net.JoinHostPort(smtpConfig.Host, strconv.Itoa(smtpConfig.TLSPort)), TLS: true})
+		}
+	}
+	if smtpConfig.Socket != "" {
+		listeners = append(listeners, smtp.Listener{Addr: os.ExpandEnv(smtpConfig.Socket), Unix: true})
+	}
+	return listeners, nil
+}
+
+// hasImplicitTLS 暗黙的TLS（SMTPS）で待ち受けるアドレスがあるか判定
+func hasImplicitTLS(listeners []smtp.Listener) bool {
+	for _, l := range listeners {
+		if l.TLS {
+			return true
+		}
+	}
+	return false
+}
+
+// smtpTLSConfig SMTP設定からSTARTTLSと暗黙的TLSの設定を作成（無効の場合はnil）
+func smtpTLSConfig(smtpConfig config.SMTPConfig, listeners []smtp.Listener) (*tls.Config, error) {
+	switch {
+	case smtpConfig.TLSCertFile != "" || smtpConfig.TLSKeyFile != "":
+		if smtpConfig.TLSCertFile == "" || smtpConfig.TLSKeyFile == "" {
+			return nil, fmt.Errorf("smtp.tls_cert_file と smtp.tls_key_file の両方を指定してください")
+		}
+		return smtp.LoadTLSConfig(smtpConfig.TLSCertFile, smtpConfig.TLSKeyFile)
+	case smtpConfig.TLSSelfSigned:
+		return smtp.SelfSignedTLSConfig()
+	case smtpConfig.RequireTLS:
+		return nil, fmt.Errorf("smtp.require_tls には証明書（smtp.tls_cert_file/smtp.tls_key_file）または smtp.tls_self_signed が必要です")
+	case hasImplicitTLS(listeners):
+		return nil, fmt.Errorf("SMTPS（smtp.tls_port または smtp.listen の :tls）には証明書（smtp.tls_cert_file/smtp.tls_key_file）または smtp.tls_self_signed が必要です")
+	}
+	return nil, nil
+}
+
+// securityDescription 接続情報に表示するセキュリティの説明
+func securityDescription(starttls, requireTLS bool) string {
+	switch {
+	case starttls && requireTLS:
+		return "STARTTLS（必須）"
+	case starttls:
+		return "STARTTLS（任意、平文でも接続可能）"
+	}
+	return "なし（平文）"
+}
+
+// requireLoopback アドレスがループバックであることを確認
+func requireLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
 		return nil
 	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("ループバックアドレスのみ指定できます: %s", addr)
+	}
+	return nil
+}
+
+// startHealthServer 死活監視（/healthz）と準備完了（/readyz）のエンドポイントを起動
+// /readyz はアクセストークンが有効期限内の場合のみ200を返し、それ以外は503を返す
+func startHealthServer(addr string, authenticator *auth.Authenticator, logger *log.Logger) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !authenticator.TokenValid() {
+			http.Error(w, "access token expired", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	return startHTTPServer(addr, mux, "ヘルスチェックエンドポイント", logger)
+}
+
+// startMetricsServer Prometheus形式のメトリクスを /metrics で公開する
+// 認証コールバックやデバッグエンドポイントとは別のアドレスで待ち受ける
+func startMetricsServer(addr string, logger *log.Logger) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	return startHTTPServer(addr, mux, "メトリクスエンドポイント", logger)
+}
+
+// startDebugServer デバッグ用HTTPサーバを起動
+func startDebugServer(addr string, recent *smtp.RecentMessages, logger *log.Logger) error {
+	mux := http.NewServeMux()
+	mux.Handle("/messages", recent)
+
+	return startHTTPServer(addr, mux, "デバッグエンドポイント", logger)
+}
+
+// startHTTPServer アドレスで待ち受けてからバックグラウンドでHTTPサーバを起動
+// ポートの使用中などで待ち受けられない場合は、起動したように見えないよう呼び出し側にエラーを返す
+func startHTTPServer(addr string, handler http.Handler, name string, logger *log.Logger) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%sの待ち受けエラー: %w", name, err)
+	}
+
+	logger.Info(name+"起動", "addr", ln.Addr().String())
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			logger.Error(name+"エラー", "error", err)
+		}
+	}()
+	return nil
 }
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/canaria-computer/m3bridge/internal/auth"
+	"github.com/charmbracelet/log"
+)
+
+func TestStartHealthServer(t *testing.T) {
+	logger := log.New(io.Discard)
+	authenticator := auth.NewAuthenticator(auth.Config{TokenCachePath: filepath.Join(t.TempDir(), "token.json")}, logger)
+
+	// 使用中のアドレスでは起動に失敗する
+	used, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer used.Close()
+	if err := startHealthServer(used.Addr().String(), authenticator, logger); err == nil {
+		t.Fatal("startHealthServer() on used address error = nil")
+	}
+
+	// 空いているアドレスを確保してから起動する
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+	if err := startHealthServer(addr, authenticator, logger); err != nil {
+		t.Fatalf("startHealthServer() error = %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/healthz", want: http.StatusOK},
+		// トークンを取得していないため準備完了ではない
+		{path: "/readyz", want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get("http://" + addr + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "トークンの状態を表示",
+	Long: `キャッシュされたトークンの残り有効期限、スコープ、リフレッシュトークンの有無を表示します。
+新しいログインは行いません。認証されていない場合は終了コード1で終了します。`,
+	RunE:          runStatus,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVar(&account, "account", "", "表示するアカウント")
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	logger := GetLogger()
+
+	// 設定を読み込む
+	cfg, err := loadConfig(logger, false)
+	if err != nil {
+		return err
+	}
+
+	graphConfig := cfg.GetGraphConfig(profile)
+	store := auth.NewTokenStore(graphConfig.TokenStore, graphConfig.TokenCache, logger)
+
+	token, err := store.Load(account)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("認証されていません")
+		}
+		return fmt.Errorf("トークンキャッシュ読み込みエラー: %w", err)
+	}
+
+	fmt.Println("=== トークンの状態 ===")
+	fmt.Printf("アカウント: %s\n", token.Account)
+	if token.PreferredUsername != "" {
+		fmt.Printf("サインインユーザー: %s (%s)\n", token.Name, token.PreferredUsername)
+	}
+	if token.IsExpired() {
+		fmt.Println("アクセストークン: 期限切れ")
+	} else {
+		fmt.Printf("アクセストークン: 有効（残り %s）\n", token.RemainingValidity().Round(time.Second))
+	}
+	fmt.Printf("スコープ: %s\n", token.Scope)
+	if token.RefreshToken != "" {
+		fmt.Println("リフレッシュトークン: あり")
+	} else {
+		fmt.Println("リフレッシュトークン: なし")
+	}
+
+	// 期限切れでリフレッシュもできない場合は再認証が必要
+	if token.IsExpired() && token.RefreshToken == "" {
+		return fmt.Errorf("トークンが期限切れです。再認証してください")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// defaultAccountKey アカウントを特定できないトークンのキー
+const defaultAccountKey = "default"
+
+// ErrAccountRequired キャッシュに複数のアカウントがあり、アカウントの指定が必要
+var ErrAccountRequired = errors.New("キャッシュに複数のアカウントがあります。--account でアカウントを指定してください")
+
+// tokenCacheFile アカウントごとのトークンを保持するキャッシュファイル
+type tokenCacheFile struct {
+	Accounts map[string]*TokenResponse `json:"accounts"`
+}
+
+// find アカウントのキーを検索（大文字小文字を区別しない）
+func (c *tokenCacheFile) find(account string) (string, bool) {
+	for key := range c.Accounts {
+		if strings.EqualFold(key, account) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// lookup アカウントのトークンを取得
+// accountが空の場合、アカウントが1つだけならそれを返す
+func (c *tokenCacheFile) lookup(account string) (*TokenResponse, error) {
+	if account != "" {
+		key, ok := c.find(account)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return c.Accounts[key], nil
+	}
+
+	switch len(c.Accounts) {
+	case 0:
+		return nil, os.ErrNotExist
+	case 1:
+		for _, token := range c.Accounts {
+			return token, nil
+		}
+	}
+	return nil, ErrAccountRequired
+}
+
+// Accounts キャッシュに保存されているアカウントの一覧
+func (tcm *TokenCacheManager) Accounts() ([]string, error) {
+	tcm.mu.RLock()
+	defer tcm.mu.RUnlock()
+
+	cache, _, err := tcm.readCacheShared()
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]string, 0, len(cache.Accounts))
+	for key := range cache.Accounts {
+		accounts = append(accounts, key)
+	}
+	return accounts, nil
+}
+
+// resolveAccountKey トークンを保存するアカウントのキーを決定
+// 明示的な指定、読み込み元のアカウント、id_tokenのユーザー名の順に使用する
+func resolveAccountKey(account string, token *TokenResponse) string {
+	if account != "" {
+		return account
+	}
+	if token.Account != "" {
+		return token.Account
+	}
+	if token.PreferredUsername != "" {
+		return strings.ToLower(token.PreferredUsername)
+	}
+	if claims, err := token.Claims(); err == nil && claims.PreferredUsername != "" {
+		return strings.ToLower(claims.PreferredUsername)
+	}
+	return defaultAccountKey
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	// clientAssertionType 証明書で署名したJWTによるクライアント認証
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// assertionLifetime クライアントアサーションの有効期間
+	assertionLifetime = 10 * time.Minute
+)
+
+// clientCertificate クライアント認証に使用する証明書と秘密鍵
+type clientCertificate struct {
+	key        *rsa.PrivateKey
+	thumbprint string // x5tヘッダー（証明書のSHA-1ハッシュ）
+}
+
+// loadClientCertificate PEMファイルから証明書と秘密鍵を読み込む
+// ファイルには CERTIFICATE と秘密鍵（PKCS#1またはPKCS#8のRSA鍵）の両方を含める
+func loadClientCertificate(path string) (*clientCertificate, error) {
+	data, err := os.ReadFile(os.ExpandEnv(path))
+	if err != nil {
+		return nil, fmt.Errorf("証明書ファイル読み込みエラー: %w", err)
+	}
+
+	var cert *x509.Certificate
+	var key *rsa.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert != nil {
+				continue
+			}
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("証明書の解析に失敗しました: %w", err)
+			}
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("秘密鍵の解析に失敗しました: %w", err)
+			}
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("秘密鍵の解析に失敗しました: %w", err)
+			}
+			rsaKey, ok := parsed.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("RSA以外の秘密鍵には対応していません")
+			}
+			key = rsaKey
+		}
+	}
+
+	if cert == nil {
+		return nil, fmt.Errorf("証明書ファイルに CERTIFICATE が含まれていません: %s", path)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("証明書ファイルに秘密鍵が含まれていません: %s", path)
+	}
+
+	sum := sha1.Sum(cert.Raw)
+	return &clientCertificate{
+		key:        key,
+		thumbprint: base64.RawURLEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// clientAssertion トークンエンドポイント向けのクライアントアサーション（RS256で署名したJWT）を作成
+func (c *clientCertificate) clientAssertion(clientID, audience string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := timeNow()
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": c.thumbprint,
+	}
+	claims := map[string]interface{}{
+		"aud": audience,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("クライアントアサーションの署名に失敗しました: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// hasClientCredential クライアントシークレットまたは証明書が設定されているか
+func (a *Authenticator) hasClientCredential() bool {
+	return a.clientSecret != "" || a.certificateFile != ""
+}
+
+// setClientCredential トークンリクエストにクライアントの資格情報を設定
+// 証明書が設定されている場合はクライアントアサーションを、そうでなければシークレットを使用する
+func (a *Authenticator) setClientCredential(data url.Values) error {
+	if a.certificateFile == "" {
+		if a.clientSecret != "" {
+			data.Set("client_secret", a.clientSecret)
+		}
+		return nil
+	}
+
+	if a.certificateErr != nil {
+		return a.certificateErr
+	}
+
+	tokenURL := fmt.Sprintf("%s/oauth2/v2.0/token", a.authorityURL)
+	assertion, err := a.certificate.clientAssertion(a.clientID, tokenURL)
+	if err != nil {
+		return err
+	}
+	data.Set("client_assertion_type", clientAssertionType)
+	data.Set("client_assertion", assertion)
+	return nil
+}
@@ -4,101 +4,427 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/canaria-computer/m3bridge/internal/metrics"
 	"github.com/charmbracelet/log"
 	abstractions "github.com/microsoft/kiota-abstractions-go"
 )
 
+const (
+	// defaultGraphEndpoint Microsoft Graphのエンドポイントのデフォルト値（パブリッククラウド）
+	defaultGraphEndpoint = "https://graph.microsoft.com"
+	// requiredScope メール送信に必須のスコープ
+	requiredScope = "Mail.Send"
+	// callbackPath 認証コールバックのパス
+	callbackPath = "/callback"
+	// defaultCallbackAddr コールバックサーバーのデフォルト待ち受けアドレス
+	defaultCallbackAddr = "localhost:5225"
+)
+
+// DefaultScopes 設定で指定がない場合に要求するOAuthスコープ
+var DefaultScopes = []string{"User.Read", "Mail.Send", "Mail.ReadWrite", "offline_access"}
+
+// identityScopes id_tokenを取得するために常に要求するスコープ
+var identityScopes = []string{"openid", "profile"}
+
+// Config 認証設定
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	// CertificateFile クライアント認証に使用する証明書と秘密鍵のPEMファイル（シークレットより優先）
+	CertificateFile string
+	RedirectURI     string
+	AuthorityURL    string
+	TokenCachePath  string
+	Scopes          []string
+	// Account キャッシュ上のアカウント（空の場合は既定のアカウント）
+	Account string
+
+	// TokenStore トークンの保存先（"file" または "keyring"）
+	TokenStore string
+	// CallbackAddr コールバックサーバーの待ち受けアドレス（空の場合はRedirectURIから決定）
+	CallbackAddr string
+
+	// DeviceCode ブラウザの代わりにデバイスコードフローで認証する
+	DeviceCode bool
+	// ClientCredentials サービスプリンシパルとしてアプリのみの認証を行う
+	ClientCredentials bool
+	// NoBrowser 認証URLをブラウザで自動的に開かない（URLの表示のみ行う）
+	NoBrowser bool
+
+	// SuccessPage, ErrorPage 認証後にブラウザに表示するHTMLテンプレートのパス（空の場合は組み込みのページ）
+	SuccessPage string
+	ErrorPage   string
+
+	// ExpiryBuffer 有効期限のどれだけ前から期限切れとみなすか（0の場合は5分）
+	ExpiryBuffer time.Duration
+
+	// GraphEndpoint Microsoft Graphのエンドポイント（空の場合はパブリッククラウド）
+	GraphEndpoint string
+
+	// Proxy トークンエンドポイントなどへのリクエストに使うプロキシ（nilの場合は環境変数に従う）
+	Proxy *url.URL
+}
+
 // Authenticator OAuth認証を管理
 type Authenticator struct {
 	clientID     string
+	clientSecret string
 	redirectURI  string
+
+	certificateFile string
+	certificate     *clientCertificate
+	certificateErr  error
+
+	callbackAddr string
 	authorityURL string
-	tokenCache   *TokenCacheManager
+	graphURL     string
+	scopes       string
+	tokenStore   TokenStore
+	account      string
+	deviceCode   bool
+	appOnly      bool
+	noBrowser    bool
+	expiryBuffer time.Duration
+	httpClient   *http.Client
 	logger       *log.Logger
 
+	current *TokenResponse // 直近に取得したトークン
+	mu      sync.RWMutex
+
+	flight   *tokenCall // 実行中のトークン取得
+	flightMu sync.Mutex
+
 	codeVerifier  string
 	codeChallenge string
+	state         string
 	authCode      chan string
+	pages         callbackPages
 	server        *http.Server
+	handlers      sync.WaitGroup // 実行中のコールバックハンドラ
+	handlersMu    sync.Mutex     // handlers.Add と停止中の判定を排他する
+	stopping      bool           // コールバックサーバーの停止中（新しいハンドラを受け付けない）
 }
 
 // NewAuthenticator 新しい認証マネージャーを作成
-func NewAuthenticator(clientID, redirectURI, authorityURL, tokenCachePath string, logger *log.Logger) *Authenticator {
-	return &Authenticator{
-		clientID:     clientID,
-		redirectURI:  redirectURI,
-		authorityURL: authorityURL,
-		tokenCache:   NewTokenCacheManager(tokenCachePath, logger),
+func NewAuthenticator(config Config, logger *log.Logger) *Authenticator {
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	scopes = withIdentityScopes(scopes)
+
+	expiryBuffer := config.ExpiryBuffer
+	if expiryBuffer <= 0 {
+		expiryBuffer = tokenBufferSecs * time.Second
+	}
+
+	a := &Authenticator{
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		redirectURI:  config.RedirectURI,
+		callbackAddr: resolveCallbackAddr(config.CallbackAddr, config.RedirectURI),
+		authorityURL: config.AuthorityURL,
+		graphURL:     strings.TrimRight(config.GraphEndpoint, "/"),
+		scopes:       strings.Join(scopes, " "),
+		tokenStore:   NewTokenStore(config.TokenStore, config.TokenCachePath, logger),
+		account:      config.Account,
+		deviceCode:   config.DeviceCode,
+		appOnly:      config.ClientCredentials,
+		noBrowser:    config.NoBrowser,
+		expiryBuffer: expiryBuffer,
+		httpClient:   newHTTPClient(config.Proxy),
 		logger:       logger,
-		authCode:     make(chan string),
+		authCode:     make(chan string, 1),
+	}
+	if a.graphURL == "" {
+		a.graphURL = defaultGraphEndpoint
 	}
+	a.pages = a.loadCallbackPages(config.SuccessPage, config.ErrorPage)
+
+	if config.CertificateFile != "" {
+		a.certificateFile = config.CertificateFile
+		a.certificate, a.certificateErr = loadClientCertificate(config.CertificateFile)
+		if a.certificateErr != nil {
+			a.logger.Error("クライアント証明書を読み込めません", "path", config.CertificateFile, "error", a.certificateErr)
+		}
+	}
+	return a
 }
 
-// GetAccessToken アクセストークンを取得（キャッシュまたは新規取得）
-func (a *Authenticator) GetAccessToken() (string, error) {
+// withIdentityScopes id_token取得用のスコープを追加
+func withIdentityScopes(scopes []string) []string {
+	result := append([]string(nil), scopes...)
+	for _, want := range identityScopes {
+		found := false
+		for _, s := range scopes {
+			if strings.EqualFold(s, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, want)
+		}
+	}
+	return result
+}
+
+// resolveCallbackAddr コールバックサーバーの待ち受けアドレスを決定
+func resolveCallbackAddr(callbackAddr, redirectURI string) string {
+	if callbackAddr != "" {
+		return callbackAddr
+	}
+	if u, err := url.Parse(redirectURI); err == nil && u.Host != "" {
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), "80")
+		}
+		return u.Host
+	}
+	return defaultCallbackAddr
+}
+
+// isExpired 設定した余裕を考慮してトークンが期限切れか判定
+func (a *Authenticator) isExpired(token *TokenResponse) bool {
+	return token.ExpiresWithin(a.expiryBuffer)
+}
+
+// tokenCall 実行中のトークン取得処理
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// GetAccessToken アクセストークンを取得（キャッシュ、リフレッシュ、または新規取得）
+// 同時に呼び出された場合、取得処理は1回だけ実行され、全員が同じ結果を受け取る
+// ctxがキャンセルされると、ブラウザでの認証待ちなどを中断して戻る
+func (a *Authenticator) GetAccessToken(ctx context.Context) (string, error) {
+	// 有効なトークンを保持していればそのまま返す
+	if current := a.currentToken(); current != nil && !a.isExpired(current) {
+		return current.AccessToken, nil
+	}
+
+	a.flightMu.Lock()
+	if call := a.flight; call != nil {
+		a.flightMu.Unlock()
+		a.logger.Debug("実行中のトークン取得を待機します")
+		select {
+		case <-call.done:
+			return call.token, call.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	a.flight = call
+	a.flightMu.Unlock()
+
+	call.token, call.err = a.getAccessToken(ctx)
+
+	a.flightMu.Lock()
+	a.flight = nil
+	a.flightMu.Unlock()
+	close(call.done)
+
+	return call.token, call.err
+}
+
+// getAccessToken トークン取得の本体
+func (a *Authenticator) getAccessToken(ctx context.Context) (string, error) {
 	// キャッシュからトークンを読み込む
-	cachedToken, err := a.tokenCache.LoadToken()
-	if err == nil && cachedToken != nil && !cachedToken.IsExpired() {
-		a.logger.Info("キャッシュからトークンを読み込みました", "remaining", cachedToken.RemainingValidity())
-		return cachedToken.AccessToken, nil
+	cachedToken, err := a.tokenStore.Load(a.account)
+	if errors.Is(err, ErrAccountRequired) {
+		return "", err
+	}
+	// 別のテナントで取得したトークンはリフレッシュもできないため再認証する
+	if err == nil && cachedToken != nil && !a.tenantMatches(cachedToken) {
+		a.logger.Info("キャッシュのトークンは別のテナントのものです。再認証します",
+			"cached_tenant", cachedToken.TenantID(),
+			"authority", a.authorityURL)
+		cachedToken = nil
+	}
+	if err == nil && cachedToken != nil {
+		if !a.isExpired(cachedToken) {
+			a.logger.Info("キャッシュからトークンを読み込みました", "remaining", cachedToken.RemainingValidity())
+			a.setCurrentToken(cachedToken)
+			return cachedToken.AccessToken, nil
+		}
+
+		// 期限切れの場合はリフレッシュトークンでサイレント更新を試みる
+		if cachedToken.RefreshToken != "" {
+			token, err := a.refreshToken(ctx, cachedToken)
+			if err == nil {
+				a.setCurrentToken(token)
+				return token.AccessToken, nil
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			var authErr *AuthError
+			if !errors.As(err, &authErr) || authErr.StatusCode >= http.StatusInternalServerError {
+				// 通信エラーやサーバーエラーは再認証しても解決しないため、そのまま返す
+				return "", fmt.Errorf("トークン更新エラー: %w", err)
+			}
+			if authErr.InteractionRequired() {
+				a.logger.Info("リフレッシュトークンが使用できないため、再認証します", "error", authErr.Code)
+			} else {
+				a.logger.Warn("トークン更新失敗、再認証します", "error", err)
+			}
+		}
 	}
 
 	a.logger.Debug("新しいトークンを取得します")
 
 	// 新しいトークンを取得
-	token, err := a.acquireNewToken()
+	var token *TokenResponse
+	switch {
+	case a.appOnly:
+		token, err = a.clientCredentialsToken(ctx)
+	case a.deviceCode:
+		token, err = a.DeviceCodeFlow(ctx)
+	default:
+		token, err = a.acquireNewToken(ctx)
+	}
 	if err != nil {
 		return "", fmt.Errorf("トークン取得エラー: %w", err)
 	}
 
 	// キャッシュに保存
-	if err := a.tokenCache.SaveToken(token); err != nil {
+	if err := a.tokenStore.Save(a.account, token); err != nil {
 		a.logger.Warn("トークンキャッシュ保存失敗", "error", err)
 	}
 
+	a.setCurrentToken(token)
 	return token.AccessToken, nil
 }
 
+// Identity 直近に取得したトークンのid_tokenからユーザー情報を取得
+// Graphの/meを呼ばずにサインインアカウントを確認できる
+func (a *Authenticator) Identity() (*IDTokenClaims, error) {
+	current := a.currentToken()
+	if current == nil {
+		return nil, fmt.Errorf("トークンを取得していません")
+	}
+	return current.Claims()
+}
+
+// refreshToken リフレッシュトークンで新しいトークンを取得
+func (a *Authenticator) refreshToken(ctx context.Context, cached *TokenResponse) (*TokenResponse, error) {
+	rt := cached.RefreshToken
+	a.logger.Debug("リフレッシュトークンでトークンを更新します")
+
+	data := url.Values{}
+	data.Set("client_id", a.clientID)
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", rt)
+	data.Set("scope", a.scopes)
+	if err := a.setClientCredential(data); err != nil {
+		return nil, err
+	}
+
+	token, err := a.requestToken(ctx, data)
+	if err != nil {
+		metrics.TokenRefreshes.Inc("failure")
+		return nil, err
+	}
+	metrics.TokenRefreshes.Inc("success")
+
+	// Azure ADはリフレッシュトークンをローテーションするため新しい値で上書きする
+	// 新しい値が返されなかった場合のみ既存の値を引き継ぐ
+	if token.RefreshToken == "" {
+		token.RefreshToken = rt
+	}
+	// リフレッシュ時にid_tokenが返されない場合も既存の値を引き継ぐ
+	if token.IDToken == "" {
+		token.IDToken = cached.IDToken
+		token.Name = cached.Name
+		token.PreferredUsername = cached.PreferredUsername
+	}
+	token.Account = cached.Account
+
+	if err := a.tokenStore.Save(a.account, token); err != nil {
+		a.logger.Warn("トークンキャッシュ保存失敗", "error", err)
+	}
+
+	a.logger.Info("トークン更新成功")
+	return token, nil
+}
+
+// clientCredentialsToken クライアント資格情報フローでアプリのみのトークンを取得
+func (a *Authenticator) clientCredentialsToken(ctx context.Context) (*TokenResponse, error) {
+	if !a.hasClientCredential() {
+		return nil, fmt.Errorf("クライアント資格情報フローにはクライアントシークレットまたは証明書が必要です")
+	}
+
+	a.logger.Debug("クライアント資格情報でトークンを取得します")
+
+	data := url.Values{}
+	data.Set("client_id", a.clientID)
+	data.Set("grant_type", "client_credentials")
+	// アプリのみの認証ではアプリに付与された権限をまとめて要求する
+	data.Set("scope", a.graphURL+"/.default")
+	if err := a.setClientCredential(data); err != nil {
+		return nil, err
+	}
+
+	return a.requestToken(ctx, data)
+}
+
 // acquireNewToken 新しいトークンを取得
-func (a *Authenticator) acquireNewToken() (*TokenResponse, error) {
+// 認証の待機時間はctxで制御する（タイムアウトやCtrl-Cで中断できる）
+func (a *Authenticator) acquireNewToken(ctx context.Context) (*TokenResponse, error) {
 	a.generatePKCE()
 
+	// コールバックサーバーを起動（redirect_uriは実際の待ち受けアドレスから決まる）
+	if err := a.startCallbackServer(); err != nil {
+		return nil, fmt.Errorf("コールバックサーバー起動エラー: %w", err)
+	}
+	defer a.stopCallbackServer(ctx)
+
 	authURL, err := a.buildAuthorizationURL()
 	if err != nil {
 		return nil, fmt.Errorf("認証URL生成エラー: %w", err)
 	}
 
+	// 待ち受けを開始してからブラウザを開く（サーバー起動前にリダイレクトされないように）
+	// 自動で開けない場合に備えてURLは常に表示する
 	a.logger.Info("ブラウザで以下のURLを開いてください")
 	fmt.Println(authURL)
-
-	// コールバックサーバーを起動
-	if err := a.startCallbackServer(); err != nil {
-		return nil, fmt.Errorf("コールバックサーバー起動エラー: %w", err)
+	if !a.noBrowser {
+		if err := openBrowser(authURL); err != nil {
+			a.logger.Debug("ブラウザを自動で開けませんでした", "error", err)
+		}
 	}
-	defer a.stopCallbackServer()
 
-	// 認証コードを待機（タイムアウト5分）
+	// 認証コードを待機
 	select {
 	case code := <-a.authCode:
 		a.logger.Debug("認証コード受け取り", "code_length", len(code))
-		token, err := a.exchangeCodeForToken(code)
+		token, err := a.exchangeCodeForToken(ctx, code)
 		if err != nil {
 			return nil, fmt.Errorf("トークン交換エラー: %w", err)
 		}
 		a.logger.Info("アクセストークン取得成功")
 		return token, nil
-	case <-time.After(5 * time.Minute):
-		return nil, fmt.Errorf("認証タイムアウト")
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("認証タイムアウト: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("認証を中断しました: %w", ctx.Err())
 	}
 }
 
@@ -112,6 +438,11 @@ func (a *Authenticator) generatePKCE() {
 	h.Write([]byte(a.codeVerifier))
 	a.codeChallenge = base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 
+	// CSRF対策のstateパラメータ
+	s := make([]byte, 16)
+	rand.Read(s)
+	a.state = base64.RawURLEncoding.EncodeToString(s)
+
 	a.logger.Debug("PKCE生成完了")
 }
 
@@ -128,11 +459,18 @@ func (a *Authenticator) buildAuthorizationURL() (string, error) {
 	q.Set("client_id", a.clientID)
 	q.Set("response_type", "code")
 	q.Set("redirect_uri", a.redirectURI)
-	q.Set("scope", "User.Read Mail.Send Mail.ReadWrite offline_access")
+	q.Set("scope", a.scopes)
 	q.Set("code_challenge", a.codeChallenge)
 	q.Set("code_challenge_method", "S256")
+	q.Set("state", a.state)
 	q.Set("response_mode", "query")
-	q.Set("prompt", "select_account")
+	// アカウントが指定されている場合はそのアカウントでサインインし、それ以外はアカウントを選択させる
+	if a.account != "" && a.account != defaultAccountKey {
+		q.Set("login_hint", a.account)
+		q.Set("prompt", "login")
+	} else {
+		q.Set("prompt", "select_account")
+	}
 
 	u.RawQuery = q.Encode()
 	return u.String(), nil
@@ -141,51 +479,144 @@ func (a *Authenticator) buildAuthorizationURL() (string, error) {
 // startCallbackServer コールバックサーバーを起動
 func (a *Authenticator) startCallbackServer() error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/callback", a.callbackHandler)
+	mux.HandleFunc(callbackPath, a.callbackHandler)
+
+	ln, err := net.Listen("tcp", a.callbackAddr)
+	if err != nil {
+		return fmt.Errorf("%s で待ち受けできません（ポートが使用中の可能性があります）: %w", a.callbackAddr, err)
+	}
+
+	// Listenが戻った時点で接続は受け付けられるため、この後にブラウザを開けば取りこぼさない
+	// ポート0の場合は実際に割り当てられたポートを使う
+	a.redirectURI = a.callbackRedirectURI(ln.Addr())
+
+	a.handlersMu.Lock()
+	a.stopping = false
+	a.handlersMu.Unlock()
 
 	a.server = &http.Server{
-		Addr:    "localhost:5225",
+		Addr:    ln.Addr().String(),
 		Handler: mux,
 	}
 
 	go func() {
-		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := a.server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			a.logger.Error("コールバックサーバーエラー", "error", err)
 		}
 	}()
 
-	a.logger.Debug("コールバックサーバー起動", "addr", a.server.Addr)
+	a.logger.Debug("コールバックサーバー起動", "addr", a.server.Addr, "redirect_uri", a.redirectURI)
 	return nil
 }
 
+// callbackRedirectURI 待ち受けアドレスからredirect_uriを組み立てる
+// ホスト名は設定値（localhostなど）を維持し、ポートのみ実際の値を使う
+func (a *Authenticator) callbackRedirectURI(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(a.callbackAddr)
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return a.redirectURI
+	}
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, port), callbackPath)
+}
+
 // stopCallbackServer コールバックサーバーを停止
-func (a *Authenticator) stopCallbackServer() {
-	if a.server != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		a.server.Shutdown(ctx)
-		a.logger.Debug("コールバックサーバー停止")
+// ブラウザへの応答が途中で切れないよう、実行中のハンドラの完了を待ってから停止する
+// ctxがキャンセルされている場合は待機せずに即座に停止する
+func (a *Authenticator) stopCallbackServer(ctx context.Context) {
+	if a.server == nil {
+		return
+	}
+
+	if ctx.Err() != nil {
+		a.server.Close()
+		a.logger.Debug("コールバックサーバー停止（中断）")
+		return
 	}
+
+	// 停止を始めた後はハンドラを増やさないため、Waitと同時にAddが呼ばれることはない
+	a.handlersMu.Lock()
+	a.stopping = true
+	a.handlersMu.Unlock()
+	a.handlers.Wait()
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	a.server.Shutdown(shutdownCtx)
+	a.logger.Debug("コールバックサーバー停止")
 }
 
 // callbackHandler 認証コールバックハンドラ
 func (a *Authenticator) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.beginHandler() {
+		http.Error(w, "コールバックサーバーを停止しています", http.StatusServiceUnavailable)
+		return
+	}
+	defer a.handlers.Done()
+
+	// stateが一致しない場合は外部から注入された認証コードの可能性がある
+	state := r.URL.Query().Get("state")
+	if a.state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(a.state)) != 1 {
+		a.logger.Error("stateパラメータが一致しません。リクエストを拒否します")
+		a.renderError(w, callbackPageData{Error: "invalid_state", Description: "stateパラメータが一致しません"})
+		return
+	}
+
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		errMsg := r.URL.Query().Get("error")
 		errDesc := r.URL.Query().Get("error_description")
 		a.logger.Error("認証エラー", "error", errMsg, "description", errDesc)
-		http.Error(w, fmt.Sprintf("認証エラー: %s - %s", errMsg, errDesc), http.StatusBadRequest)
+		a.renderError(w, callbackPageData{Error: errMsg, Description: errDesc})
 		return
 	}
 
 	a.logger.Debug("認証コード取得", "code_length", len(code))
-	a.authCode <- code
-	fmt.Fprintf(w, "認証が完了しました。このウィンドウを閉じてください。")
+
+	// 応答を書き終えてからコードを渡す（交換開始後にサーバーが停止しても応答が切れないように）
+	if err := renderCallbackPage(w, a.pages.success, http.StatusOK, callbackPageData{}); err != nil {
+		a.logger.Error("認証完了ページの描画に失敗しました", "error", err)
+		http.Error(w, "認証完了ページの描画に失敗しました", http.StatusInternalServerError)
+		return
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	select {
+	case a.authCode <- code:
+	default:
+		a.logger.Warn("認証コードを既に受信済みのため無視します")
+	}
+}
+
+// beginHandler 実行中のハンドラとして登録する（停止中の場合はfalse）
+func (a *Authenticator) beginHandler() bool {
+	a.handlersMu.Lock()
+	defer a.handlersMu.Unlock()
+	if a.stopping {
+		return false
+	}
+	a.handlers.Add(1)
+	return true
+}
+
+// renderError 認証エラーページを表示
+func (a *Authenticator) renderError(w http.ResponseWriter, data callbackPageData) {
+	if data.Error == "" {
+		data.Error = "unknown_error"
+	}
+	if err := renderCallbackPage(w, a.pages.error, http.StatusBadRequest, data); err != nil {
+		a.logger.Error("エラーページの描画に失敗しました", "error", err)
+		http.Error(w, fmt.Sprintf("認証エラー: %s - %s", data.Error, data.Description), http.StatusBadRequest)
+	}
 }
 
 // exchangeCodeForToken 認証コードをトークンに交換
-func (a *Authenticator) exchangeCodeForToken(code string) (*TokenResponse, error) {
+func (a *Authenticator) exchangeCodeForToken(ctx context.Context, code string) (*TokenResponse, error) {
 	tokenURL := fmt.Sprintf("%s/oauth2/v2.0/token", a.authorityURL)
 	a.logger.Debug("トークン交換開始", "url", tokenURL)
 
@@ -195,18 +626,29 @@ func (a *Authenticator) exchangeCodeForToken(code string) (*TokenResponse, error
 	data.Set("code", code)
 	data.Set("redirect_uri", a.redirectURI)
 	data.Set("code_verifier", a.codeVerifier)
+	data.Set("scope", a.scopes)
+	if err := a.setClientCredential(data); err != nil {
+		return nil, err
+	}
+
+	return a.requestToken(ctx, data)
+}
+
+// requestToken トークンエンドポイントにリクエストを送信
+func (a *Authenticator) requestToken(ctx context.Context, data url.Values) (*TokenResponse, error) {
+	tokenURL := fmt.Sprintf("%s/oauth2/v2.0/token", a.authorityURL)
 
-	resp, err := http.PostForm(tokenURL, data)
+	status, body, err := a.postTokenForm(ctx, tokenURL, data)
 	if err != nil {
+		a.logger.Error("トークン取得失敗", "error", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		a.logger.Error("トークン取得失敗", "status", resp.StatusCode, "response", string(body))
-		return nil, fmt.Errorf("トークン取得失敗 (status: %d): %s", resp.StatusCode, string(body))
+	if status != http.StatusOK {
+		// 4xxはユーザーの操作（再認証や同意）が必要なエラー
+		authErr := newAuthError(status, body)
+		a.logger.Error("トークン取得失敗", "status", status, "error", authErr.Code, "description", authErr.Description)
+		return nil, fmt.Errorf("トークン取得失敗: %w", authErr)
 	}
 
 	var tokenResp TokenResponse
@@ -214,13 +656,40 @@ func (a *Authenticator) exchangeCodeForToken(code string) (*TokenResponse, error
 		return nil, fmt.Errorf("JSONパースエラー: %w", err)
 	}
 
-	a.logger.Info("トークン取得成功", "scope", tokenResp.Scope)
+	tokenResp.applyIDTokenClaims()
+	a.logger.Info("トークン取得成功", "scope", tokenResp.Scope, "account", tokenResp.PreferredUsername)
+	a.checkGrantedScope(tokenResp.Scope)
 	return &tokenResp, nil
 }
 
+// checkGrantedScope 付与されたスコープにメール送信権限が含まれるか確認
+// 含まれない場合、送信失敗の原因が分かりにくいため明示的に警告する
+func (a *Authenticator) checkGrantedScope(scope string) {
+	if a.appOnly {
+		return
+	}
+	if !hasScope(scope, requiredScope) {
+		a.logger.Warn("付与されたスコープに Mail.Send が含まれていません。メール送信は失敗します",
+			"granted", scope,
+			"requested", a.scopes)
+	}
+}
+
+// hasScope スペース区切りのスコープ文字列に指定スコープが含まれるか判定
+// "https://graph.microsoft.com/Mail.Send" のようなリソース付きの形式も考慮する
+func hasScope(scopes, want string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if strings.EqualFold(s, want) || strings.HasSuffix(strings.ToLower(s), "/"+strings.ToLower(want)) {
+			return true
+		}
+	}
+	return false
+}
+
 // BearerTokenAuthenticationProvider Bearer トークン認証プロバイダー
 type BearerTokenAuthenticationProvider struct {
 	accessToken string
+	mu          sync.RWMutex
 	logger      *log.Logger
 }
 
@@ -238,10 +707,28 @@ func (p *BearerTokenAuthenticationProvider) AuthenticateRequest(ctx context.Cont
 		return fmt.Errorf("request cannot be nil")
 	}
 
-	request.Headers.Add("Authorization", "Bearer "+p.accessToken)
+	p.mu.RLock()
+	accessToken := p.accessToken
+	p.mu.RUnlock()
+
+	request.Headers.Add("Authorization", "Bearer "+accessToken)
 	return nil
 }
 
+// AccessToken 現在のアクセストークンを取得
+func (p *BearerTokenAuthenticationProvider) AccessToken() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.accessToken
+}
+
+// SetAccessToken アクセストークンを差し替える（バックグラウンド更新用）
+func (p *BearerTokenAuthenticationProvider) SetAccessToken(accessToken string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.accessToken = accessToken
+}
+
 // StaticTokenCredential 静的トークン認証情報
 type StaticTokenCredential struct {
 	token     string
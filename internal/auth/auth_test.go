@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestBuildAuthorizationURLPrompt(t *testing.T) {
+	tests := []struct {
+		name          string
+		account       string
+		wantPrompt    string
+		wantLoginHint string
+	}{
+		{name: "アカウントの指定なし", wantPrompt: "select_account"},
+		{name: "既定のアカウント", account: defaultAccountKey, wantPrompt: "select_account"},
+		{name: "アカウントを指定", account: "alice@example.com", wantPrompt: "login", wantLoginHint: "alice@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Authenticator{
+				clientID:     "00000000-0000-0000-0000-000000000000",
+				authorityURL: "https://login.microsoftonline.com/common",
+				redirectURI:  "http://localhost:5225/callback",
+				account:      tt.account,
+			}
+			rawURL, err := a.buildAuthorizationURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := u.Query()
+			if got := q["prompt"]; len(got) != 1 || got[0] != tt.wantPrompt {
+				t.Errorf("prompt = %q, want %q", got, tt.wantPrompt)
+			}
+			if got := q.Get("login_hint"); got != tt.wantLoginHint {
+				t.Errorf("login_hint = %q, want %q", got, tt.wantLoginHint)
+			}
+		})
+	}
+}
+
+func TestAcquireNewTokenDelayedExchange(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration
+	}{
+		{name: "すぐに交換"},
+		{name: "交換が遅い", delay: 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				json.NewEncoder(w).Encode(TokenResponse{AccessToken: "access", ExpiresIn: 3600})
+			}))
+			defer tokenServer.Close()
+
+			// ブラウザの代わりに認証後のリダイレクト（コールバック）を呼び出す
+			type page struct {
+				status int
+				body   string
+				err    error
+			}
+			pages := make(chan page, 1)
+			openBrowser = func(authURL string) error {
+				u, err := url.Parse(authURL)
+				if err != nil {
+					return err
+				}
+				callback := u.Query().Get("redirect_uri") + "?" + url.Values{"code": {"code"}, "state": {u.Query().Get("state")}}.Encode()
+				go func() {
+					resp, err := http.Get(callback)
+					if err != nil {
+						pages <- page{err: err}
+						return
+					}
+					defer resp.Body.Close()
+					body, err := io.ReadAll(resp.Body)
+					pages <- page{status: resp.StatusCode, body: string(body), err: err}
+				}()
+				return nil
+			}
+			t.Cleanup(func() { openBrowser = startBrowser })
+
+			a := NewAuthenticator(Config{
+				ClientID:       "00000000-0000-0000-0000-000000000000",
+				AuthorityURL:   tokenServer.URL,
+				CallbackAddr:   "127.0.0.1:0",
+				TokenCachePath: filepath.Join(t.TempDir(), "token.json"),
+			}, log.New(io.Discard))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			token, err := a.acquireNewToken(ctx)
+			if err != nil {
+				t.Fatalf("acquireNewToken() error = %v", err)
+			}
+			if token.AccessToken != "access" {
+				t.Errorf("AccessToken = %q, want access", token.AccessToken)
+			}
+
+			// 交換の後にサーバーを停止しても、ブラウザには認証完了ページが最後まで届く
+			got := <-pages
+			if got.err != nil {
+				t.Fatalf("callback error = %v", got.err)
+			}
+			if got.status != http.StatusOK || !strings.Contains(got.body, "</html>") {
+				t.Errorf("callback = %d %q, want complete success page", got.status, got.body)
+			}
+		})
+	}
+}
+
+func TestCallbackHandlerWhileStopping(t *testing.T) {
+	a := &Authenticator{state: "state", stopping: true, authCode: make(chan string, 1), logger: log.New(io.Discard)}
+	w := httptest.NewRecorder()
+	a.callbackHandler(w, httptest.NewRequest(http.MethodGet, callbackPath+"?code=code&state=state", nil))
+
+	// 停止を始めた後のコールバックは処理せず、認証コードも渡さない
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if len(a.authCode) != 0 {
+		t.Error("authorization code was delivered while stopping")
+	}
+}
+
+func TestGetAccessTokenConcurrent(t *testing.T) {
+	tests := []struct {
+		name         string
+		appOnly      bool
+		refreshToken string
+		wantGrant    string
+	}{
+		{name: "リフレッシュトークン", refreshToken: "refresh", wantGrant: "refresh_token"},
+		{name: "アプリのみの認証", appOnly: true, wantGrant: "client_credentials"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				grants []string
+			)
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				mu.Lock()
+				grants = append(grants, r.PostForm.Get("grant_type"))
+				n := len(grants)
+				mu.Unlock()
+				// 他の呼び出しが取得を待つ間に応答する
+				time.Sleep(200 * time.Millisecond)
+				json.NewEncoder(w).Encode(TokenResponse{AccessToken: fmt.Sprintf("renewed-%d", n), RefreshToken: tt.refreshToken, ExpiresIn: 3600})
+			}))
+			defer tokenServer.Close()
+
+			a := NewAuthenticator(Config{
+				ClientID:          "00000000-0000-0000-0000-000000000000",
+				ClientSecret:      "secret",
+				AuthorityURL:      tokenServer.URL,
+				TokenCachePath:    filepath.Join(t.TempDir(), "token.json"),
+				ClientCredentials: tt.appOnly,
+			}, log.New(io.Discard))
+			// 2時間前に取得した期限切れのトークンをキャッシュに保存しておく
+			timeNow = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+			t.Cleanup(func() { timeNow = time.Now })
+			expired := &TokenResponse{AccessToken: "expired", RefreshToken: tt.refreshToken, ExpiresIn: 3600}
+			if err := a.tokenStore.Save(a.account, expired); err != nil {
+				t.Fatal(err)
+			}
+			timeNow = time.Now
+			a.setCurrentToken(expired)
+
+			const callers = 20
+			tokens := make([]string, callers)
+			errs := make([]error, callers)
+			var wg sync.WaitGroup
+			for i := range callers {
+				wg.Go(func() {
+					tokens[i], errs[i] = a.GetAccessToken(context.Background())
+				})
+			}
+			wg.Wait()
+
+			for i := range callers {
+				if errs[i] != nil {
+					t.Fatalf("GetAccessToken() error = %v", errs[i])
+				}
+				if tokens[i] != "renewed-1" {
+					t.Errorf("GetAccessToken() = %q, want renewed-1", tokens[i])
+				}
+			}
+			if len(grants) != 1 || grants[0] != tt.wantGrant {
+				t.Errorf("grant_type = %q, want [%s] once", grants, tt.wantGrant)
+			}
+		})
+	}
+}
+
+func TestTokenRequestProxy(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	// プロキシとして転送先のURLを記録し、トークンエンドポイントの代わりに応答する
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.String())
+		mu.Unlock()
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "proxied", ExpiresIn: 3600})
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAuthenticator(Config{
+		ClientID:     "00000000-0000-0000-0000-000000000000",
+		ClientSecret: "secret",
+		AuthorityURL: "http://login.example.test/tenant",
+		Proxy:        proxyURL,
+	}, log.New(io.Discard))
+	token, err := a.clientCredentialsToken(context.Background())
+	if err != nil {
+		t.Fatalf("clientCredentialsToken() error = %v", err)
+	}
+	if token.AccessToken != "proxied" {
+		t.Errorf("AccessToken = %q, want proxied", token.AccessToken)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "POST http://login.example.test/tenant/oauth2/v2.0/token"
+	if len(requests) != 1 || requests[0] != want {
+		t.Errorf("proxy requests = %q, want [%s]", requests, want)
+	}
+}
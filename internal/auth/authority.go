@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultLoginHost ログインエンドポイントのホストのデフォルト値（パブリッククラウド）
+	defaultLoginHost = "login.microsoftonline.com"
+	// consumersTenantID 個人用Microsoftアカウントのテナントに割り当てられているID
+	consumersTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+)
+
+// loginHosts 認証機関として受け付けるログインエンドポイント（各国のクラウドを含む）
+var loginHosts = []string{
+	"login.microsoftonline.com",
+	"login.microsoftonline.us",
+	"login.partner.microsoftonline.cn",
+	"login.chinacloudapi.cn",
+}
+
+// tenantIDPattern テナントID（GUID）の形式
+var tenantIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// tenantPattern テナントとして指定できる値（GUID、ドメイン名、common/organizations/consumers）
+var tenantPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z.-]*$`)
+
+// TenantAuthority ログインエンドポイントのホストとテナントからAuthorityURLを組み立てる
+// hostが空の場合はパブリッククラウドのホストを使用する
+func TenantAuthority(host, tenant string) (string, error) {
+	if !tenantPattern.MatchString(tenant) {
+		return "", fmt.Errorf("テナントの形式が不正です: %s", tenant)
+	}
+	if host == "" {
+		host = defaultLoginHost
+	}
+	return fmt.Sprintf("https://%s/%s", host, tenant), nil
+}
+
+// ValidateAuthority AuthorityURLがMicrosoftのログインエンドポイントとして正しい形式か検証
+// 例: https://login.microsoftonline.com/{tenant}
+func ValidateAuthority(authority string) error {
+	u, err := url.Parse(authority)
+	if err != nil {
+		return fmt.Errorf("AuthorityURLを解析できません: %w", err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("AuthorityURLはhttpsで指定してください: %s", authority)
+	}
+	if !isLoginHost(u.Host) {
+		return fmt.Errorf("AuthorityURLのホストがログインエンドポイントではありません: %s", u.Host)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("AuthorityURLにクエリやフラグメントは指定できません: %s", authority)
+	}
+
+	tenant := strings.Trim(u.Path, "/")
+	if tenant == "" || strings.Contains(tenant, "/") || !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("AuthorityURLには1つのテナントを指定してください（例: https://%s/common）: %s", defaultLoginHost, authority)
+	}
+	return nil
+}
+
+// isLoginHost ログインエンドポイントのホストか判定
+func isLoginHost(host string) bool {
+	for _, h := range loginHosts {
+		if strings.EqualFold(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorityTenantID AuthorityURLで指定されたテナントのIDを取得
+// common/organizationsやドメイン名の場合はIDが決まらないため空を返す
+func authorityTenantID(authority string) string {
+	u, err := url.Parse(authority)
+	if err != nil {
+		return ""
+	}
+
+	tenant := strings.Trim(u.Path, "/")
+	switch {
+	case strings.EqualFold(tenant, "consumers"):
+		return consumersTenantID
+	case tenantIDPattern.MatchString(tenant):
+		return strings.ToLower(tenant)
+	default:
+		return ""
+	}
+}
+
+// tenantMatches キャッシュされたトークンが要求されたテナントのものか判定
+// テナントを特定できない場合は一致しているとみなす
+func (a *Authenticator) tenantMatches(token *TokenResponse) bool {
+	want := authorityTenantID(a.authorityURL)
+	if want == "" {
+		return true
+	}
+
+	got := token.TenantID()
+	if got == "" {
+		return true
+	}
+	return strings.EqualFold(got, want)
+}
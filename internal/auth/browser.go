@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// openBrowser ブラウザでURLを開く（テストではブラウザの代わりにコールバックを呼び出すために差し替える）
+var openBrowser = startBrowser
+
+// startBrowser 既定のブラウザでURLを開く
+func startBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("xdg-open", url)
+	default:
+		return fmt.Errorf("このOSではブラウザを自動で開けません: %s", runtime.GOOS)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// ブラウザの終了は待たない（プロセスの後始末のみ行う）
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+)
+
+// defaultSuccessPage 認証成功時に表示するページ
+const defaultSuccessPage = `<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>m3bridge - 認証完了</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #f4f6f8; color: #222; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+.card { background: #fff; border-radius: 8px; box-shadow: 0 2px 8px rgba(0,0,0,.1); padding: 2em 3em; text-align: center; }
+h1 { color: #107c10; font-size: 1.4em; }
+</style>
+</head>
+<body>
+<div class="card">
+<h1>認証が完了しました</h1>
+<p>m3bridge にサインインしました。このウィンドウは閉じて構いません。</p>
+</div>
+<script>setTimeout(function () { window.close(); }, 3000);</script>
+</body>
+</html>
+`
+
+// defaultErrorPage 認証失敗時に表示するページ
+const defaultErrorPage = `<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>m3bridge - 認証エラー</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #f4f6f8; color: #222; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+.card { background: #fff; border-radius: 8px; box-shadow: 0 2px 8px rgba(0,0,0,.1); padding: 2em 3em; max-width: 40em; }
+h1 { color: #a4262c; font-size: 1.4em; }
+code { background: #f4f6f8; padding: .1em .3em; }
+</style>
+</head>
+<body>
+<div class="card">
+<h1>認証に失敗しました</h1>
+<p><code>{{.Error}}</code></p>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<p>ターミナルに戻り、もう一度 <code>m3bridge auth</code> を実行してください。</p>
+</div>
+</body>
+</html>
+`
+
+// callbackPageData コールバックページのテンプレートに渡す値
+type callbackPageData struct {
+	Error       string
+	Description string
+}
+
+// callbackPages コールバックで表示するページのテンプレート
+type callbackPages struct {
+	success *template.Template
+	error   *template.Template
+}
+
+// loadCallbackPages コールバックページのテンプレートを読み込む
+// パスが空、または読み込みに失敗した場合は組み込みのページを使用する
+func (a *Authenticator) loadCallbackPages(successPath, errorPath string) callbackPages {
+	return callbackPages{
+		success: a.loadCallbackPage("success", successPath, defaultSuccessPage),
+		error:   a.loadCallbackPage("error", errorPath, defaultErrorPage),
+	}
+}
+
+// loadCallbackPage テンプレートファイルを読み込む
+func (a *Authenticator) loadCallbackPage(name, path, fallback string) *template.Template {
+	if path != "" {
+		data, err := os.ReadFile(os.ExpandEnv(path))
+		if err == nil {
+			tmpl, err := template.New(name).Parse(string(data))
+			if err == nil {
+				return tmpl
+			}
+			a.logger.Warn("コールバックページのテンプレートが不正です。既定のページを使用します", "path", path, "error", err)
+		} else {
+			a.logger.Warn("コールバックページのテンプレートを読み込めません。既定のページを使用します", "path", path, "error", err)
+		}
+	}
+	return template.Must(template.New(name).Parse(fallback))
+}
+
+// renderCallbackPage テンプレートを描画して応答を書き込む
+// 描画に失敗した場合は何も書き込まずにエラーを返す
+func renderCallbackPage(w http.ResponseWriter, tmpl *template.Template, status int, data callbackPageData) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
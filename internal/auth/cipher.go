@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	// CacheKeyEnv トークンキャッシュの暗号化キーを指定する環境変数
+	CacheKeyEnv = "M3BRIDGE_CACHE_KEY"
+	// CacheKeyKeyring CacheKeyEnv にこの値を指定すると、キーチェーンに保存したランダムなキーで暗号化する
+	CacheKeyKeyring = "keyring"
+
+	// encryptedPrefix 暗号化されたキャッシュファイルの先頭に付与する識別子
+	// v2 はソルト、ノンス、暗号文の順に連結してBase64で符号化する
+	encryptedPrefix = "m3bridge-enc:v2:"
+	// legacyEncryptedPrefix ソルトなしのSHA-256でキーを導出していた形式（読み込みのみ対応）
+	legacyEncryptedPrefix = "m3bridge-enc:v1:"
+
+	// cacheKeySaltSize, cacheKeyIterations PBKDF2（HMAC-SHA256）のソルトの長さと繰り返し回数
+	cacheKeySaltSize   = 16
+	cacheKeyIterations = 600000
+
+	// keyringCacheKeyEntry ランダムな暗号化キーを保存するキーチェーンのエントリ
+	keyringCacheKeyEntry = ".cache-key"
+)
+
+// cacheKey トークンキャッシュの暗号化キー
+// キーの導出には時間がかかるため、導出したキーをソルトごとに保持し、書き込みには読み込んだファイルのソルトを使い回す
+type cacheKey struct {
+	secret string
+	err    error // キーチェーンからキーを取得できなかった場合のエラー
+
+	mu      sync.Mutex
+	derived map[string][]byte
+	salt    []byte // 書き込みに使うソルト
+}
+
+// newCacheKey 環境変数の値から暗号化キーを作成（空の場合はnilを返し、平文で保存する）
+func newCacheKey(value string) *cacheKey {
+	if value == "" {
+		return nil
+	}
+	k := &cacheKey{secret: value, derived: make(map[string][]byte)}
+	if value == CacheKeyKeyring {
+		k.secret, k.err = keyringCacheSecret()
+	}
+	return k
+}
+
+// keyringCacheSecret キーチェーンに保存したランダムなキーを取得（ない場合は作成して保存する）
+func keyringCacheSecret() (string, error) {
+	secret, err := keyring.Get(keyringService, keyringCacheKeyEntry)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.Is(err, keyring.ErrNotFound) {
+		return "", fmt.Errorf("キーチェーンから暗号化キーを取得できません: %w", err)
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	secret = base64.StdEncoding.EncodeToString(random)
+	if err := keyring.Set(keyringService, keyringCacheKeyEntry, secret); err != nil {
+		return "", fmt.Errorf("キーチェーンに暗号化キーを保存できません: %w", err)
+	}
+	return secret, nil
+}
+
+// derive ソルトからAES-256のキーを導出
+func (k *cacheKey) derive(salt []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.derived[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := pbkdf2.Key(sha256.New, k.secret, salt, cacheKeyIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("暗号化キーの導出エラー: %w", err)
+	}
+	k.derived[string(salt)] = key
+	return key, nil
+}
+
+// writeSalt 書き込みに使うソルト（まだない場合は生成する）
+func (k *cacheKey) writeSalt() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.salt == nil {
+		salt := make([]byte, cacheKeySaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		k.salt = salt
+	}
+	return k.salt, nil
+}
+
+// useSalt 読み込んだファイルのソルトを書き込みにも使う
+func (k *cacheKey) useSalt(salt []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.salt = salt
+}
+
+// legacyCacheKey v1形式のキー（環境変数の値のSHA-256）
+func legacyCacheKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// isEncrypted キャッシュデータが暗号化形式か判定
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedPrefix)) || bytes.HasPrefix(data, []byte(legacyEncryptedPrefix))
+}
+
+// isCurrentFormat キャッシュデータが現在の形式で暗号化されているか判定
+func isCurrentFormat(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedPrefix))
+}
+
+// encryptCache AES-GCMでデータを暗号化
+func encryptCache(k *cacheKey, plaintext []byte) ([]byte, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	salt, err := k.writeSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.derive(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(append(bytes.Clone(salt), nonce...), nonce, plaintext, nil)
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+	return []byte(encryptedPrefix + encoded), nil
+}
+
+// decryptCache AES-GCMで暗号化されたデータを復号
+func decryptCache(k *cacheKey, data []byte) ([]byte, error) {
+	if k == nil {
+		return nil, fmt.Errorf("キャッシュは暗号化されていますが、%s が設定されていません", CacheKeyEnv)
+	}
+	if k.err != nil {
+		return nil, k.err
+	}
+
+	current := isCurrentFormat(data)
+	encoded := bytes.TrimPrefix(bytes.TrimPrefix(data, []byte(encryptedPrefix)), []byte(legacyEncryptedPrefix))
+	sealed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("暗号化キャッシュのデコードエラー: %w", err)
+	}
+
+	key := legacyCacheKey(k.secret)
+	var salt []byte
+	if current {
+		if len(sealed) < cacheKeySaltSize {
+			return nil, fmt.Errorf("暗号化キャッシュが破損しています")
+		}
+		salt, sealed = sealed[:cacheKeySaltSize], sealed[cacheKeySaltSize:]
+		if key, err = k.derive(salt); err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("暗号化キャッシュが破損しています")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("キャッシュの復号に失敗しました（キーが異なる可能性があります）: %w", err)
+	}
+	if salt != nil {
+		k.useSalt(salt)
+	}
+	return plaintext, nil
+}
+
+// newGCM AES-GCM暗号器を作成
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
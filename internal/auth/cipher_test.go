@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/zalando/go-keyring"
+)
+
+// legacyEncrypt v1形式（ソルトなしのSHA-256）で暗号化したキャッシュを作成
+func legacyEncrypt(t *testing.T, secret string, plaintext []byte) []byte {
+	t.Helper()
+	gcm, err := newGCM(legacyCacheKey(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return []byte(legacyEncryptedPrefix + base64.StdEncoding.EncodeToString(sealed))
+}
+
+// cacheSalt v2形式のキャッシュファイルに記録されたソルト
+func cacheSalt(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isCurrentFormat(data) {
+		t.Fatalf("cache file is not in the current format: %.20q", data)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimPrefix(data, []byte(encryptedPrefix))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed[:cacheKeySaltSize]
+}
+
+func TestLegacyEncryptedCacheMigration(t *testing.T) {
+	t.Setenv(CacheKeyEnv, "legacy-secret")
+	path := filepath.Join(t.TempDir(), "token.json")
+	legacy := legacyEncrypt(t, "legacy-secret", []byte(`{"accounts":{"alice@example.com":{"access_token":"old"}}}`))
+	if err := os.WriteFile(path, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tcm := NewTokenCacheManager(path, log.New(io.Discard))
+	token, err := tcm.LoadToken("alice@example.com")
+	if err != nil {
+		t.Fatalf("LoadToken() error = %v", err)
+	}
+	if token.AccessToken != "old" {
+		t.Errorf("AccessToken = %q, want old", token.AccessToken)
+	}
+
+	// 読み込み時にソルト付きの形式に移行し、別のマネージャーからも読み込める
+	cacheSalt(t, path)
+	token, err = NewTokenCacheManager(path, log.New(io.Discard)).LoadToken("alice@example.com")
+	if err != nil {
+		t.Fatalf("LoadToken() after migration error = %v", err)
+	}
+	if token.AccessToken != "old" {
+		t.Errorf("AccessToken after migration = %q, want old", token.AccessToken)
+	}
+}
+
+func TestCacheKeySalt(t *testing.T) {
+	t.Setenv(CacheKeyEnv, "same-secret")
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.json"), filepath.Join(dir, "second.json")
+	for _, path := range []string{first, second} {
+		tcm := NewTokenCacheManager(path, log.New(io.Discard))
+		if err := tcm.SaveToken("alice@example.com", &TokenResponse{AccessToken: "token"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 同じキーでもファイルごとにソルトが異なる
+	if bytes.Equal(cacheSalt(t, first), cacheSalt(t, second)) {
+		t.Error("two caches encrypted with the same secret share a salt")
+	}
+
+	// 別のキーでは復号できない
+	t.Setenv(CacheKeyEnv, "other-secret")
+	if _, err := NewTokenCacheManager(first, log.New(io.Discard)).LoadToken("alice@example.com"); err == nil {
+		t.Error("LoadToken() with a different key error = nil, want a decryption error")
+	}
+}
+
+func TestCacheKeyKeyring(t *testing.T) {
+	keyring.MockInit()
+	t.Setenv(CacheKeyEnv, CacheKeyKeyring)
+	path := filepath.Join(t.TempDir(), "token.json")
+
+	if err := NewTokenCacheManager(path, log.New(io.Discard)).SaveToken("alice@example.com", &TokenResponse{AccessToken: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := keyring.Get(keyringService, keyringCacheKeyEntry)
+	if err != nil {
+		t.Fatalf("keyring entry: %v", err)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(secret); err != nil || len(decoded) != 32 {
+		t.Errorf("keyring key = %q, want 32 random bytes", secret)
+	}
+	cacheSalt(t, path)
+
+	// 次に起動したときもキーチェーンの同じキーで復号できる
+	token, err := NewTokenCacheManager(path, log.New(io.Discard)).LoadToken("alice@example.com")
+	if err != nil {
+		t.Fatalf("LoadToken() error = %v", err)
+	}
+	if token.AccessToken != "token" {
+		t.Errorf("AccessToken = %q, want token", token.AccessToken)
+	}
+}
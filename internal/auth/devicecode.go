@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultPollInterval = 5 // 秒
+)
+
+// DeviceCodeResponse デバイスコードレスポンス
+type DeviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	Message         string `json:"message"`
+}
+
+// DeviceCodeFlow デバイスコードフローでトークンを取得（ブラウザのない環境向け）
+// ctxがキャンセルされるとポーリングを中断する
+func (a *Authenticator) DeviceCodeFlow(ctx context.Context) (*TokenResponse, error) {
+	dc, err := a.requestDeviceCode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("デバイスコード取得エラー: %w", err)
+	}
+
+	a.logger.Info("別の端末のブラウザで以下のURLを開き、コードを入力してください")
+	fmt.Printf("URL: %s\n", dc.VerificationURI)
+	fmt.Printf("コード: %s\n", dc.UserCode)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, fmt.Errorf("認証を中断しました: %w", err)
+		}
+
+		token, tokenErr, err := a.pollDeviceCode(ctx, dc.DeviceCode)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			a.logger.Info("アクセストークン取得成功")
+			return token, nil
+		}
+
+		switch tokenErr.Code {
+		case "authorization_pending":
+			a.logger.Debug("ユーザーの認証を待機中")
+		case "slow_down":
+			interval += defaultPollInterval * time.Second
+			a.logger.Debug("ポーリング間隔を延長", "interval", interval)
+		default:
+			return nil, fmt.Errorf("デバイスコード認証エラー: %w", tokenErr)
+		}
+	}
+
+	return nil, fmt.Errorf("デバイスコードの有効期限が切れました")
+}
+
+// requestDeviceCode デバイスコードを要求
+func (a *Authenticator) requestDeviceCode(ctx context.Context) (*DeviceCodeResponse, error) {
+	deviceCodeURL := fmt.Sprintf("%s/oauth2/v2.0/devicecode", a.authorityURL)
+	a.logger.Debug("デバイスコード要求", "url", deviceCodeURL)
+
+	data := url.Values{}
+	data.Set("client_id", a.clientID)
+	data.Set("scope", a.scopes)
+
+	status, body, err := a.postTokenForm(ctx, deviceCodeURL, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		authErr := newAuthError(status, body)
+		a.logger.Error("デバイスコード取得失敗", "status", status, "error", authErr.Code, "description", authErr.Description)
+		return nil, fmt.Errorf("デバイスコード取得失敗: %w", authErr)
+	}
+
+	var dc DeviceCodeResponse
+	if err := json.Unmarshal(body, &dc); err != nil {
+		return nil, fmt.Errorf("JSONパースエラー: %w", err)
+	}
+
+	return &dc, nil
+}
+
+// pollDeviceCode トークンエンドポイントをポーリング
+// 認証待ちの場合はトークンの代わりにエラーレスポンスを返す
+func (a *Authenticator) pollDeviceCode(ctx context.Context, deviceCode string) (*TokenResponse, *AuthError, error) {
+	tokenURL := fmt.Sprintf("%s/oauth2/v2.0/token", a.authorityURL)
+
+	data := url.Values{}
+	data.Set("client_id", a.clientID)
+	data.Set("grant_type", deviceCodeGrantType)
+	data.Set("device_code", deviceCode)
+
+	status, body, err := a.postTokenForm(ctx, tokenURL, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if status != http.StatusOK {
+		tokenErr := newAuthError(status, body)
+		if tokenErr.Code == "" {
+			return nil, nil, fmt.Errorf("トークン取得失敗: %w", tokenErr)
+		}
+		return nil, tokenErr, nil
+	}
+
+	var tokenResp TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, nil, fmt.Errorf("JSONパースエラー: %w", err)
+	}
+
+	tokenResp.applyIDTokenClaims()
+	a.logger.Info("トークン取得成功", "scope", tokenResp.Scope, "account", tokenResp.PreferredUsername)
+	a.checkGrantedScope(tokenResp.Scope)
+	return &tokenResp, nil, nil
+}
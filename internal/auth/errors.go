@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AuthError トークンエンドポイントが返したAzure ADのエラー
+// errors.Asで取り出し、Codeで分岐できる
+type AuthError struct {
+	// StatusCode HTTPステータスコード
+	StatusCode int `json:"-"`
+
+	Code          string `json:"error"`
+	Description   string `json:"error_description"`
+	ErrorCodes    []int  `json:"error_codes"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// newAuthError エラーレスポンスのボディからAuthErrorを作成
+// JSONとして解析できない場合はボディをそのままDescriptionにする
+func newAuthError(status int, body []byte) *AuthError {
+	authErr := &AuthError{StatusCode: status}
+	if err := json.Unmarshal(body, authErr); err != nil || authErr.Code == "" {
+		authErr.Code = ""
+		authErr.Description = strings.TrimSpace(string(body))
+	}
+	authErr.StatusCode = status
+	return authErr
+}
+
+// Error エラーメッセージ
+func (e *AuthError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "認証エラー (status: %d)", e.StatusCode)
+	if e.Code != "" {
+		fmt.Fprintf(&b, ": %s", e.Code)
+	}
+	if len(e.ErrorCodes) > 0 {
+		fmt.Fprintf(&b, " (codes: %v)", e.ErrorCodes)
+	}
+	if e.Description != "" {
+		fmt.Fprintf(&b, ": %s", e.Description)
+	}
+	if e.CorrelationID != "" {
+		fmt.Fprintf(&b, " [correlation_id: %s]", e.CorrelationID)
+	}
+	return b.String()
+}
+
+// InteractionRequired ユーザーの操作（サインインや同意）をやり直す必要があるか
+// リフレッシュトークンが失効・取り消された場合もこれに該当する
+func (e *AuthError) InteractionRequired() bool {
+	switch e.Code {
+	case "interaction_required", "consent_required", "login_required", "invalid_grant":
+		return true
+	}
+	return false
+}
+
+// IsInteractionRequired エラーがユーザーの操作を必要とするAuthErrorか判定
+func IsInteractionRequired(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr) && authErr.InteractionRequired()
+}
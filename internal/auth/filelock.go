@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"os"
+
+	"github.com/canaria-computer/m3bridge/internal/fsutil"
+)
+
+// lockSuffix キャッシュファイルのロック用ファイルの拡張子
+const lockSuffix = ".lock"
+
+// withFileLock キャッシュファイルのロックを取得してfnを実行
+// authとserveなど複数のプロセスが同じキャッシュを読み書きしても内容が混ざらないようにする
+func (tcm *TokenCacheManager) withFileLock(exclusive bool, fn func() error) error {
+	f, err := os.OpenFile(tcm.filePath+lockSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		// ロックファイルを作成できない場合（読み取り専用のディレクトリなど）はロックなしで続行する
+		tcm.logger.Debug("ロックファイルを作成できません", "error", err)
+		return fn()
+	}
+	defer f.Close()
+
+	if err := fsutil.Lock(f, exclusive); err != nil {
+		tcm.logger.Debug("キャッシュファイルのロックに失敗しました", "error", err)
+		return fn()
+	}
+	defer fsutil.Unlock(f)
+
+	return fn()
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IDTokenClaims id_tokenに含まれるユーザー情報
+type IDTokenClaims struct {
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	ObjectID          string `json:"oid"`
+	TenantID          string `json:"tid"`
+}
+
+// Claims id_tokenのペイロードをデコード
+// 表示用途のため署名は検証しない
+func (tr *TokenResponse) Claims() (*IDTokenClaims, error) {
+	if tr.IDToken == "" {
+		return nil, fmt.Errorf("id_tokenがありません")
+	}
+
+	var claims IDTokenClaims
+	if err := decodeJWTPayload(tr.IDToken, &claims); err != nil {
+		return nil, fmt.Errorf("id_token: %w", err)
+	}
+
+	return &claims, nil
+}
+
+// TenantID トークンを発行したテナントのIDを取得
+// id_tokenがない場合（アプリのみのトークンなど）はアクセストークンのtidクレームを使用する
+func (tr *TokenResponse) TenantID() string {
+	if claims, err := tr.Claims(); err == nil && claims.TenantID != "" {
+		return claims.TenantID
+	}
+
+	var claims struct {
+		TenantID string `json:"tid"`
+	}
+	// 個人用アカウントのアクセストークンはJWTではないことがあるため、失敗は無視する
+	if err := decodeJWTPayload(tr.AccessToken, &claims); err != nil {
+		return ""
+	}
+	return claims.TenantID
+}
+
+// decodeJWTPayload JWTのペイロードをデコード（署名は検証しない）
+func decodeJWTPayload(token string, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("形式が不正です")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("デコードエラー: %w", err)
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("JSON解析エラー: %w", err)
+	}
+	return nil
+}
+
+// applyIDTokenClaims id_tokenのクレームからアカウント情報を設定
+// id_tokenがない、または解析できない場合は何もしない
+func (tr *TokenResponse) applyIDTokenClaims() {
+	claims, err := tr.Claims()
+	if err != nil {
+		return
+	}
+	tr.Name = claims.Name
+	tr.PreferredUsername = claims.PreferredUsername
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// revokeSessionsPath サインインセッション（リフレッシュトークン）を無効化するGraphのパス
+// Microsoft IDプラットフォームにはRFC 7009形式の失効エンドポイントがないため、こちらを使用する
+const revokeSessionsPath = "/v1.0/me/revokeSignInSessions"
+
+// Logout キャッシュされたトークンを失効させて削除
+// revokeがtrueでリフレッシュトークンがある場合、ベストエフォートで失効リクエストを送信する
+// 戻り値はサインアウトしたトークン（キャッシュがなかった場合はnil）
+func (a *Authenticator) Logout(revoke bool) (*TokenResponse, error) {
+	token, err := a.tokenStore.Load(a.account)
+	if errors.Is(err, ErrAccountRequired) {
+		return nil, err
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		a.logger.Warn("キャッシュ読み込み失敗、削除のみ行います", "error", err)
+	}
+
+	if revoke && token != nil && token.RefreshToken != "" {
+		if err := a.revokeSessions(token); err != nil {
+			a.logger.Warn("トークン失効リクエスト失敗", "error", err)
+		}
+	}
+
+	account := a.account
+	if account == "" && token != nil {
+		account = token.Account
+	}
+	if err := a.tokenStore.Clear(account); err != nil {
+		return token, fmt.Errorf("キャッシュ削除エラー: %w", err)
+	}
+
+	return token, nil
+}
+
+// revokeSessions サインインセッションを失効させる
+func (a *Authenticator) revokeSessions(token *TokenResponse) error {
+	if token.IsExpired() {
+		return fmt.Errorf("アクセストークンが期限切れのため失効リクエストを送信できません")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.graphURL+revokeSessionsPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("失効リクエスト失敗 (status: %d): %s", resp.StatusCode, string(body))
+	}
+
+	a.logger.Debug("サインインセッションを失効させました")
+	return nil
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ValidateRedirectURI redirect_uriが認証コールバックの待ち受けアドレスと対応しているか検証
+// callbackAddrが空の場合はredirect_uriから待ち受けアドレスを決めるため、URLとして正しいかのみ確認する
+func ValidateRedirectURI(redirectURI, callbackAddr string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return fmt.Errorf("redirect_uriを解析できません: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("redirect_uriはhttpまたはhttpsで指定してください: %s", redirectURI)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("redirect_uriにホストがありません: %s", redirectURI)
+	}
+	if u.Path != callbackPath {
+		return fmt.Errorf("redirect_uriのパスは %s にしてください: %s", callbackPath, redirectURI)
+	}
+
+	addr := resolveCallbackAddr(callbackAddr, redirectURI)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("callback_addrが不正です: %w", err)
+	}
+	// ポート0の場合はredirect_uriを割り当てられたポートで組み立て直す
+	if port == "0" {
+		return nil
+	}
+
+	redirectPort := u.Port()
+	if redirectPort == "" {
+		redirectPort = "80"
+		if u.Scheme == "https" {
+			redirectPort = "443"
+		}
+	}
+	if redirectPort != port {
+		return fmt.Errorf("redirect_uriのポート（%s）がコールバックの待ち受けポート（%s）と一致しません", redirectPort, port)
+	}
+	if host != "" && !strings.EqualFold(host, u.Hostname()) && !(isLoopbackHost(host) && isLoopbackHost(u.Hostname())) {
+		return fmt.Errorf("redirect_uriのホスト（%s）がコールバックの待ち受けホスト（%s）と一致しません", u.Hostname(), host)
+	}
+	return nil
+}
+
+// isLoopbackHost localhostまたはループバックアドレスか判定
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
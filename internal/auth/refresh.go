@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// refreshRetryInterval バックグラウンド更新に失敗した場合の再試行間隔
+const refreshRetryInterval = time.Minute
+
+// RefreshAccessToken 現在のトークンをリフレッシュトークンで更新
+func (a *Authenticator) RefreshAccessToken(ctx context.Context) (string, error) {
+	current := a.currentToken()
+	if current == nil || current.RefreshToken == "" {
+		return "", fmt.Errorf("リフレッシュトークンがありません")
+	}
+
+	token, err := a.refreshToken(ctx, current)
+	if err != nil {
+		return "", err
+	}
+
+	a.setCurrentToken(token)
+	return token.AccessToken, nil
+}
+
+// ForceRefresh 有効期限に関係なくトークンを取得し直す
+// Graphがトークンを拒否した場合（401）に使う。アプリのみの認証ではクライアント資格情報で取得し直す
+func (a *Authenticator) ForceRefresh(ctx context.Context) (string, error) {
+	if !a.appOnly {
+		return a.RefreshAccessToken(ctx)
+	}
+
+	token, err := a.clientCredentialsToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := a.tokenStore.Save(a.account, token); err != nil {
+		a.logger.Warn("トークンキャッシュ保存失敗", "error", err)
+	}
+	a.setCurrentToken(token)
+	return token.AccessToken, nil
+}
+
+// AutoRefresh 有効期限の margin 前にトークンを更新し続ける
+// 更新のたびに onRefresh に新しいアクセストークンを渡す。ctxがキャンセルされると終了する
+// アプリのみの認証ではリフレッシュトークンがないため、クライアント資格情報で取得し直す
+func (a *Authenticator) AutoRefresh(ctx context.Context, margin time.Duration, onRefresh func(accessToken string)) {
+	current := a.currentToken()
+	if current == nil || (!a.appOnly && current.RefreshToken == "") {
+		a.logger.Debug("リフレッシュトークンがないため、バックグラウンド更新を行いません")
+		return
+	}
+
+	wait := current.RemainingValidity() - margin
+	for {
+		if wait < 0 {
+			wait = 0
+		}
+		a.logger.Debug("次回のトークン更新まで待機", "wait", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.logger.Debug("バックグラウンドのトークン更新を停止")
+			return
+		case <-timer.C:
+		}
+
+		accessToken, err := a.ForceRefresh(ctx)
+		if ctx.Err() != nil {
+			a.logger.Debug("バックグラウンドのトークン更新を停止")
+			return
+		}
+		if !a.appOnly && IsInteractionRequired(err) {
+			// リフレッシュトークンが失効しているため、再試行しても成功しない
+			a.logger.Error("リフレッシュトークンが使用できません。m3bridge auth で再認証してください", "error", err)
+			return
+		}
+		if err != nil {
+			a.logger.Warn("バックグラウンドのトークン更新に失敗しました", "error", err, "retry_in", refreshRetryInterval)
+			wait = refreshRetryInterval
+			continue
+		}
+
+		onRefresh(accessToken)
+		remaining := a.currentToken().RemainingValidity()
+		a.logger.Debug("バックグラウンドでトークンを更新しました", "remaining", remaining)
+		wait = remaining - margin
+	}
+}
+
+// TokenValid 直近に取得したトークンが有効期限内か
+// リフレッシュトークンが失効してバックグラウンド更新が止まるか、アプリのみの認証で取得し直せない状態が続くと、有効期限の経過後にfalseになる
+func (a *Authenticator) TokenValid() bool {
+	current := a.currentToken()
+	return current != nil && !current.IsExpired()
+}
+
+// currentToken 直近に取得したトークンを取得
+func (a *Authenticator) currentToken() *TokenResponse {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.current
+}
+
+// setCurrentToken 直近に取得したトークンを更新
+func (a *Authenticator) setCurrentToken(token *TokenResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.current = token
+}
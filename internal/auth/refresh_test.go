@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestAutoRefresh(t *testing.T) {
+	tests := []struct {
+		name         string
+		appOnly      bool
+		refreshToken string
+		wantGrant    string
+	}{
+		{name: "リフレッシュトークン", refreshToken: "refresh", wantGrant: "refresh_token"},
+		{name: "アプリのみの認証", appOnly: true, wantGrant: "client_credentials"},
+		{name: "リフレッシュトークンがない"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				grants []string
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				mu.Lock()
+				grants = append(grants, r.PostForm.Get("grant_type"))
+				mu.Unlock()
+				json.NewEncoder(w).Encode(TokenResponse{AccessToken: "renewed", RefreshToken: tt.refreshToken, ExpiresIn: 3600})
+			}))
+			defer server.Close()
+
+			a := NewAuthenticator(Config{
+				ClientID:          "00000000-0000-0000-0000-000000000000",
+				ClientSecret:      "secret",
+				AuthorityURL:      server.URL,
+				TokenCachePath:    filepath.Join(t.TempDir(), "token.json"),
+				ClientCredentials: tt.appOnly,
+			}, log.New(io.Discard))
+			a.setCurrentToken(&TokenResponse{AccessToken: "initial", RefreshToken: tt.refreshToken, ExpiresIn: 3600, CachedAt: time.Now()})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var refreshed []string
+			// 残り時間より長いmarginを指定し、すぐに更新させる
+			a.AutoRefresh(ctx, 2*time.Hour, func(accessToken string) {
+				refreshed = append(refreshed, accessToken)
+				cancel()
+			})
+
+			if tt.wantGrant == "" {
+				if len(refreshed) != 0 || len(grants) != 0 {
+					t.Errorf("AutoRefresh() refreshed = %q, grants = %q, want none", refreshed, grants)
+				}
+				return
+			}
+			if len(refreshed) != 1 || refreshed[0] != "renewed" {
+				t.Fatalf("AutoRefresh() refreshed = %q, want [renewed]", refreshed)
+			}
+			if len(grants) != 1 || grants[0] != tt.wantGrant {
+				t.Errorf("grant_type = %q, want %q", grants, tt.wantGrant)
+			}
+			if !a.TokenValid() {
+				t.Error("TokenValid() = false after refresh")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// httpTimeout トークンエンドポイントへのリクエストのタイムアウト
+	httpTimeout = 30 * time.Second
+	// maxTokenAttempts トークン取得の最大試行回数
+	maxTokenAttempts = 3
+	// initialBackoff 最初の再試行までの待機時間
+	initialBackoff = time.Second
+)
+
+// postTokenForm トークンエンドポイントにPOSTし、一時的なエラーの場合は指数バックオフで再試行する
+// 接続エラーと5xxのみ再試行し、4xx（invalid_grantなど）は再試行しない
+// ctxがキャンセルされた場合は再試行せずに戻る
+func (a *Authenticator) postTokenForm(ctx context.Context, endpoint string, data url.Values) (int, []byte, error) {
+	backoff := initialBackoff
+
+	var lastErr error
+	for attempt := 1; attempt <= maxTokenAttempts; attempt++ {
+		if attempt > 1 {
+			a.logger.Debug("トークンリクエストを再試行します", "attempt", attempt, "wait", backoff, "error", lastErr)
+			if err := sleepContext(ctx, backoff); err != nil {
+				return 0, nil, err
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(data.Encode()))
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := a.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			lastErr = newAuthError(resp.StatusCode, body)
+			continue
+		}
+
+		return resp.StatusCode, body, nil
+	}
+
+	return 0, nil, fmt.Errorf("一時的なエラーのためトークンを取得できませんでした（%d回試行）: %w", maxTokenAttempts, lastErr)
+}
+
+// sleepContext 指定した時間待機する（ctxがキャンセルされた場合は即座に戻る）
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// newHTTPClient 認証用のHTTPクライアントを作成
+// proxy がnilの場合はHTTP_PROXY/HTTPS_PROXY/NO_PROXYに従う
+func newHTTPClient(proxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Timeout: httpTimeout, Transport: transport}
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	// TokenStoreFile トークンをJSONファイルに保存する
+	TokenStoreFile = "file"
+	// TokenStoreKeyring トークンをOSのキーチェーンに保存する
+	TokenStoreKeyring = "keyring"
+
+	keyringService = "m3bridge"
+	// keyringAccount アカウントごとに保存する前のバージョンが使っていたエントリ
+	keyringAccount = "token"
+	// keyringIndex 保存しているアカウントの一覧のエントリ（キーチェーンはエントリを列挙できないため）
+	keyringIndex = ".accounts"
+)
+
+// TokenStore トークンの保存先
+// accountが空の場合は既定のアカウント（1つだけ保存されている場合はそのアカウント）を対象とする
+type TokenStore interface {
+	Load(account string) (*TokenResponse, error)
+	Save(account string, token *TokenResponse) error
+	Clear(account string) error
+}
+
+// Load トークンを読み込む（TokenStoreの実装）
+func (tcm *TokenCacheManager) Load(account string) (*TokenResponse, error) {
+	return tcm.LoadToken(account)
+}
+
+// Save トークンを保存（TokenStoreの実装）
+func (tcm *TokenCacheManager) Save(account string, token *TokenResponse) error {
+	return tcm.SaveToken(account, token)
+}
+
+// Clear トークンを削除（TokenStoreの実装）
+func (tcm *TokenCacheManager) Clear(account string) error {
+	return tcm.ClearCache(account)
+}
+
+// KeyringTokenStore OSのキーチェーン（macOS Keychain、Windows資格情報マネージャー、libsecret）に保存するトークンストア
+// アカウントごとに別のエントリとして保存し、保存したアカウントの一覧を別のエントリに記録する
+type KeyringTokenStore struct {
+	service string
+	logger  *log.Logger
+}
+
+// NewKeyringTokenStore 新しいキーチェーントークンストアを作成
+func NewKeyringTokenStore(logger *log.Logger) *KeyringTokenStore {
+	return &KeyringTokenStore{
+		service: keyringService,
+		logger:  logger,
+	}
+}
+
+// entry キーチェーンのエントリ名を決定
+func (ks *KeyringTokenStore) entry(account string) string {
+	return strings.ToLower(account)
+}
+
+// Accounts キーチェーンに保存されているアカウントの一覧
+func (ks *KeyringTokenStore) Accounts() ([]string, error) {
+	secret, err := keyring.Get(ks.service, keyringIndex)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var accounts []string
+	if err := json.Unmarshal([]byte(secret), &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// setAccounts 保存しているアカウントの一覧を書き込む（空の場合はエントリを削除する）
+func (ks *KeyringTokenStore) setAccounts(accounts []string) error {
+	if len(accounts) == 0 {
+		if err := keyring.Delete(ks.service, keyringIndex); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(accounts)
+	if err != nil {
+		return err
+	}
+	return keyring.Set(ks.service, keyringIndex, string(data))
+}
+
+// defaultEntry accountが空の場合に読み込むエントリを決定
+// ファイルのキャッシュと同じく、アカウントが1つだけ保存されている場合はそのアカウントとする。
+// 一覧がない場合は、アカウントごとに保存する前のバージョンのエントリを読み込む
+func (ks *KeyringTokenStore) defaultEntry() (string, error) {
+	accounts, err := ks.Accounts()
+	if err != nil {
+		return "", err
+	}
+	switch len(accounts) {
+	case 0:
+		return keyringAccount, nil
+	case 1:
+		return accounts[0], nil
+	}
+	return "", ErrAccountRequired
+}
+
+// Load トークンをキーチェーンから読み込む
+// accountが空の場合、アカウントが1つだけならそれを読み込む
+func (ks *KeyringTokenStore) Load(account string) (*TokenResponse, error) {
+	entry := ks.entry(account)
+	if account == "" {
+		var err error
+		if entry, err = ks.defaultEntry(); err != nil {
+			return nil, err
+		}
+	}
+	secret, err := keyring.Get(ks.service, entry)
+	if err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, os.ErrNotExist
+		}
+		ks.logger.Debug("キーチェーン読み込み失敗", "error", err)
+		return nil, err
+	}
+
+	var token TokenResponse
+	if err := json.Unmarshal([]byte(secret), &token); err != nil {
+		ks.logger.Error("キーチェーンのトークン解析失敗", "error", err)
+		return nil, err
+	}
+
+	token.Account = entry
+	ks.logger.Debug("キーチェーンからトークンを読み込みました", "account", entry)
+	return &token, nil
+}
+
+// Save トークンをキーチェーンに保存
+func (ks *KeyringTokenStore) Save(account string, token *TokenResponse) error {
+	token.CachedAt = timeNow()
+	entry := ks.entry(resolveAccountKey(account, token))
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	if err := keyring.Set(ks.service, entry, string(data)); err != nil {
+		ks.logger.Error("キーチェーン書き込み失敗", "error", err)
+		return err
+	}
+
+	accounts, err := ks.Accounts()
+	if err == nil && !slices.Contains(accounts, entry) {
+		err = ks.setAccounts(append(accounts, entry))
+	}
+	if err != nil {
+		ks.logger.Warn("キーチェーンのアカウント一覧を更新できません", "error", err)
+	}
+
+	token.Account = entry
+	ks.logger.Debug("トークンをキーチェーンに保存しました", "account", entry)
+	return nil
+}
+
+// Clear トークンをキーチェーンから削除
+// accountが空の場合は、ファイルのキャッシュと同じく全てのアカウントのトークンを削除する
+func (ks *KeyringTokenStore) Clear(account string) error {
+	accounts, listErr := ks.Accounts()
+	if listErr != nil {
+		ks.logger.Warn("キーチェーンのアカウント一覧を読み込めません", "error", listErr)
+	}
+
+	entries := []string{ks.entry(account)}
+	if account == "" {
+		entries = append(slices.Clone(accounts), keyringAccount)
+	}
+	for _, entry := range entries {
+		if err := keyring.Delete(ks.service, entry); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			ks.logger.Error("キーチェーン削除失敗", "error", err)
+			return err
+		}
+	}
+
+	if listErr == nil {
+		remaining := slices.DeleteFunc(accounts, func(a string) bool { return slices.Contains(entries, a) })
+		if err := ks.setAccounts(remaining); err != nil {
+			ks.logger.Warn("キーチェーンのアカウント一覧を更新できません", "error", err)
+		}
+	}
+
+	ks.logger.Debug("キーチェーンのトークンを削除しました")
+	return nil
+}
+
+// keyringAvailable キーチェーンが利用可能か確認
+func keyringAvailable() bool {
+	_, err := keyring.Get(keyringService, keyringAccount)
+	return err == nil || errors.Is(err, keyring.ErrNotFound)
+}
+
+// NewTokenStore 設定に応じたトークンストアを作成
+// キーチェーンが利用できない場合はファイルにフォールバックする
+func NewTokenStore(kind, tokenCachePath string, logger *log.Logger) TokenStore {
+	switch kind {
+	case TokenStoreKeyring:
+		if keyringAvailable() {
+			return NewKeyringTokenStore(logger)
+		}
+		logger.Warn("キーチェーンが利用できないため、ファイルにトークンを保存します", "path", tokenCachePath)
+	case "", TokenStoreFile:
+	default:
+		logger.Warn("不明なトークンストアのため、ファイルを使用します", "token_store", kind)
+	}
+	return NewTokenCacheManager(tokenCachePath, logger)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/zalando/go-keyring"
+)
+
+func TestKeyringTokenStoreDefaultAccount(t *testing.T) {
+	tests := []struct {
+		name string
+		// legacy アカウントごとに保存する前のバージョンのエントリ
+		legacy      bool
+		saved       []string
+		cleared     string
+		load        string
+		wantAccount string
+		wantErr     error
+	}{
+		{name: "トークンなし", wantErr: os.ErrNotExist},
+		{name: "1つのアカウント", saved: []string{"Alice@example.com"}, wantAccount: "alice@example.com"},
+		{name: "複数のアカウント", saved: []string{"alice@example.com", "bob@example.com"}, wantErr: ErrAccountRequired},
+		{name: "アカウントを指定", saved: []string{"alice@example.com", "bob@example.com"}, load: "Bob@example.com", wantAccount: "bob@example.com"},
+		{name: "削除して1つになったアカウント", saved: []string{"alice@example.com", "bob@example.com"}, cleared: "alice@example.com", wantAccount: "bob@example.com"},
+		{name: "以前のバージョンのエントリ", legacy: true, wantAccount: keyringAccount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring.MockInit()
+			store := NewKeyringTokenStore(log.New(io.Discard))
+			if tt.legacy {
+				if err := keyring.Set(keyringService, keyringAccount, `{"access_token":"legacy"}`); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, account := range tt.saved {
+				if err := store.Save(account, &TokenResponse{AccessToken: "token-" + account}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.cleared != "" {
+				if err := store.Clear(tt.cleared); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			token, err := store.Load(tt.load)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load(%q) error = %v, want %v", tt.load, err, tt.wantErr)
+			}
+			if err == nil && token.Account != tt.wantAccount {
+				t.Errorf("Load(%q) account = %q, want %q", tt.load, token.Account, tt.wantAccount)
+			}
+		})
+	}
+}
+
+func TestKeyringTokenStoreClearAll(t *testing.T) {
+	keyring.MockInit()
+	store := NewKeyringTokenStore(log.New(io.Discard))
+	for _, account := range []string{"alice@example.com", "bob@example.com"} {
+		if err := store.Save(account, &TokenResponse{AccessToken: "token"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// アカウントを指定しない場合は、ファイルのキャッシュと同じく全てのアカウントを削除する
+	if err := store.Clear(""); err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range []string{"", "alice@example.com", "bob@example.com"} {
+		if _, err := store.Load(account); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Load(%q) error = %v, want %v", account, err, os.ErrNotExist)
+		}
+	}
+	if accounts, err := store.Accounts(); err != nil || len(accounts) != 0 {
+		t.Errorf("Accounts() = %q, %v, want empty", accounts, err)
+	}
+}
+
+func TestTokenCacheManagerConcurrent(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{name: "平文"},
+		{name: "暗号化", key: "test-cache-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(CacheKeyEnv, tt.key)
+			path := filepath.Join(t.TempDir(), "token.json")
+			// 別々のプロセスと同じく、同じファイルを共有する複数のマネージャーから読み書きする
+			managers := make([]*TokenCacheManager, 4)
+			for i := range managers {
+				managers[i] = NewTokenCacheManager(path, log.New(io.Discard))
+			}
+			padding := strings.Repeat("x", 8*1024)
+			if err := managers[0].Save("reader@example.com", &TokenResponse{AccessToken: "initial" + padding}); err != nil {
+				t.Fatal(err)
+			}
+
+			const writes = 10
+			var wg sync.WaitGroup
+			errs := make(chan error, 100)
+			for w, m := range managers {
+				account := fmt.Sprintf("writer%d@example.com", w)
+				wg.Go(func() {
+					for i := range writes {
+						if err := m.Save(account, &TokenResponse{AccessToken: fmt.Sprintf("%s-%d%s", account, i, padding)}); err != nil {
+							errs <- fmt.Errorf("Save(%s): %w", account, err)
+							return
+						}
+					}
+				})
+				wg.Go(func() {
+					for range writes {
+						// 書き込み途中の内容を読まず、常に完全なトークンを読み込む
+						token, err := m.Load("reader@example.com")
+						if err != nil {
+							errs <- fmt.Errorf("Load(): %w", err)
+							return
+						}
+						if token.AccessToken != "initial"+padding {
+							errs <- fmt.Errorf("Load() = %d bytes, want the complete token", len(token.AccessToken))
+							return
+						}
+					}
+				})
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			// 同時に保存しても他のアカウントの更新を失わない
+			for w := range managers {
+				account := fmt.Sprintf("writer%d@example.com", w)
+				token, err := managers[0].Load(account)
+				if err != nil {
+					t.Fatalf("Load(%s) error = %v", account, err)
+				}
+				if want := fmt.Sprintf("%s-%d%s", account, writes-1, padding); token.AccessToken != want {
+					t.Errorf("Load(%s) = %.40q, want the last write", account, token.AccessToken)
+				}
+			}
+		})
+	}
+}
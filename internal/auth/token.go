@@ -2,11 +2,13 @@ package auth
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/canaria-computer/m3bridge/internal/fsutil"
 	"github.com/charmbracelet/log"
 )
 
@@ -16,23 +18,98 @@ const (
 
 // TokenResponse トークンレスポンス
 type TokenResponse struct {
-	AccessToken  string    `json:"access_token"`
-	TokenType    string    `json:"token_type"`
-	ExpiresIn    int       `json:"expires_in"`
-	RefreshToken string    `json:"refresh_token"`
-	Scope        string    `json:"scope"`
-	CachedAt     time.Time `json:"cached_at"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token,omitempty"`
+
+	// Name, PreferredUsername id_tokenから取得したサインインアカウントの情報
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+
+	CachedAt time.Time `json:"cached_at"`
+
+	// Account キャッシュ上のアカウントのキー（ファイルには保存しない）
+	Account string `json:"-"`
+
+	// Extra 未知のフィールド（新しいバージョンが書き込んだデータを保持するため）
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// tokenResponseFields TokenResponseのエイリアス（JSON変換の再帰を避けるため）
+type tokenResponseFields TokenResponse
+
+// UnmarshalJSON 既知のフィールドを読み込み、未知のフィールドをExtraに保持
+func (tr *TokenResponse) UnmarshalJSON(data []byte) error {
+	var fields tokenResponseFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, key := range knownTokenKeys() {
+		delete(all, key)
+	}
+
+	*tr = TokenResponse(fields)
+	if len(all) > 0 {
+		tr.Extra = all
+	}
+	return nil
+}
+
+// MarshalJSON 既知のフィールドとExtraに保持した未知のフィールドを書き出す
+func (tr TokenResponse) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(tokenResponseFields(tr))
+	if err != nil || len(tr.Extra) == 0 {
+		return data, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for key, value := range tr.Extra {
+		if _, exists := all[key]; !exists {
+			all[key] = value
+		}
+	}
+	return json.Marshal(all)
+}
+
+// knownTokenKeys TokenResponseが扱うJSONキーの一覧
+func knownTokenKeys() []string {
+	t := reflect.TypeOf(tokenResponseFields{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
 }
 
 // IsExpired トークンが有効期限切れか判定
 func (tr *TokenResponse) IsExpired() bool {
+	// 5分の余裕を持たせる
+	return tr.ExpiresWithin(time.Duration(tokenBufferSecs) * time.Second)
+}
+
+// ExpiresWithin 指定した余裕を持たせてトークンが有効期限切れか判定
+// 時計が進んでいる環境では余裕を大きくすることで期限切れのトークンの使用を避けられる
+func (tr *TokenResponse) ExpiresWithin(buffer time.Duration) bool {
 	if tr.CachedAt.IsZero() {
 		return true
 	}
 
 	expirationTime := tr.CachedAt.Add(time.Duration(tr.ExpiresIn) * time.Second)
-	// 5分の余裕を持たせる
-	return time.Now().Add(time.Duration(tokenBufferSecs) * time.Second).After(expirationTime)
+	return time.Now().Add(buffer).After(expirationTime)
 }
 
 // RemainingValidity トークンの残り有効期限を取得
@@ -51,77 +128,256 @@ func (tr *TokenResponse) RemainingValidity() time.Duration {
 	return remaining
 }
 
+// timeNow 現在時刻を取得
+var timeNow = time.Now
+
 // TokenCacheManager トークンキャッシュマネージャー
 type TokenCacheManager struct {
 	filePath string
+	key      *cacheKey // 暗号化キー（nilの場合は平文で保存）
 	mu       sync.RWMutex
 	logger   *log.Logger
+
+	plaintextWarning sync.Once
 }
 
 // NewTokenCacheManager 新しいトークンキャッシュマネージャーを作成
+// 環境変数 M3BRIDGE_CACHE_KEY が設定されている場合、キャッシュをAES-GCMで暗号化する
+// 値が keyring の場合は、キーチェーンに保存したランダムなキーを使う
 func NewTokenCacheManager(filePath string, logger *log.Logger) *TokenCacheManager {
+	key := newCacheKey(os.Getenv(CacheKeyEnv))
+	if key != nil && key.err != nil {
+		logger.Error("トークンキャッシュの暗号化キーを取得できません", "error", key.err)
+	}
 	return &TokenCacheManager{
 		filePath: filePath,
+		key:      key,
 		logger:   logger,
 	}
 }
 
-// LoadToken トークンをキャッシュから読み込む
-func (tcm *TokenCacheManager) LoadToken() (*TokenResponse, error) {
+// LoadToken 指定したアカウントのトークンをキャッシュから読み込む
+// accountが空の場合、キャッシュにアカウントが1つだけならそれを返す
+func (tcm *TokenCacheManager) LoadToken(account string) (*TokenResponse, error) {
 	tcm.mu.RLock()
-	defer tcm.mu.RUnlock()
-
-	data, err := os.ReadFile(tcm.filePath)
+	cache, current, err := tcm.readCacheShared()
+	tcm.mu.RUnlock()
 	if err != nil {
 		tcm.logger.Debug("キャッシュファイル読み込み失敗", "error", err)
 		return nil, err
 	}
 
-	var token TokenResponse
-	if err := json.Unmarshal(data, &token); err != nil {
-		tcm.logger.Error("キャッシュJSON解析失敗", "error", err)
+	token, err := cache.lookup(account)
+	if err != nil {
 		return nil, err
 	}
 
-	// トークンの有効期限をチェック
+	// キーが設定されている場合、平文や旧形式のキャッシュを現在の暗号化形式に移行する
+	if tcm.key != nil && !current {
+		tcm.mu.Lock()
+		err := tcm.withFileLock(true, func() error {
+			// 他のプロセスが先に書き換えている可能性があるため、ロック取得後に読み直す
+			latest, current, err := tcm.readCache()
+			if err != nil || current {
+				return err
+			}
+			return tcm.writeCache(latest)
+		})
+		if err != nil {
+			tcm.logger.Warn("キャッシュの暗号化移行に失敗しました", "error", err)
+		} else {
+			tcm.logger.Info("トークンキャッシュを現在の暗号化形式に移行しました")
+		}
+		tcm.mu.Unlock()
+	}
+
+	// 期限切れでもリフレッシュトークンを利用できるため、判定は呼び出し側に任せる
 	if token.IsExpired() {
-		tcm.logger.Debug("キャッシュトークンは期限切れです")
-		return nil, fmt.Errorf("token expired")
+		tcm.logger.Debug("キャッシュトークンは期限切れです", "account", token.Account)
 	}
 
-	tcm.logger.Debug("キャッシュトークン読み込み成功")
-	return &token, nil
+	tcm.logger.Debug("キャッシュトークン読み込み成功", "account", token.Account)
+	return token, nil
 }
 
-// SaveToken トークンをキャッシュに保存
-func (tcm *TokenCacheManager) SaveToken(token *TokenResponse) error {
+// SaveToken トークンを指定したアカウントとしてキャッシュに保存
+// accountが空の場合、トークンの読み込み元のアカウント、またはid_tokenのユーザー名を使用する
+func (tcm *TokenCacheManager) SaveToken(account string, token *TokenResponse) error {
 	tcm.mu.Lock()
 	defer tcm.mu.Unlock()
 
+	return tcm.withFileLock(true, func() error {
+		return tcm.saveToken(account, token)
+	})
+}
+
+// saveToken キャッシュを読み込み、トークンを追加して書き戻す（ロックは呼び出し側で取得する）
+func (tcm *TokenCacheManager) saveToken(account string, token *TokenResponse) error {
 	// トークンの取得時刻を記録
-	token.CachedAt = time.Now()
+	token.CachedAt = timeNow()
+
+	cache, _, err := tcm.readCache()
+	if err != nil {
+		cache = &tokenCacheFile{}
+	}
+	if cache.Accounts == nil {
+		cache.Accounts = make(map[string]*TokenResponse)
+	}
 
-	data, err := json.MarshalIndent(token, "", "  ")
+	key := resolveAccountKey(account, token)
+	token.Account = key
+
+	// 既存キャッシュの未知のフィールドを引き継ぐ
+	if existing, ok := cache.Accounts[key]; ok {
+		mergeExtra(token, existing)
+	}
+	cache.Accounts[key] = token
+
+	if err := tcm.writeCache(cache); err != nil {
+		return err
+	}
+
+	tcm.logger.Debug("トークンをキャッシュに保存しました", "account", key)
+	return nil
+}
+
+// readFile キャッシュファイルを読み込み、暗号化されている場合は復号する
+// 2つ目の戻り値は現在の形式で暗号化されているか
+func (tcm *TokenCacheManager) readFile() ([]byte, bool, error) {
+	data, err := os.ReadFile(tcm.filePath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !isEncrypted(data) {
+		return data, false, nil
+	}
+
+	plaintext, err := decryptCache(tcm.key, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return plaintext, isCurrentFormat(data), nil
+}
+
+// readCacheShared 共有ロックを取得してキャッシュファイルを読み込む
+func (tcm *TokenCacheManager) readCacheShared() (*tokenCacheFile, bool, error) {
+	var cache *tokenCacheFile
+	var current bool
+	err := tcm.withFileLock(false, func() error {
+		var err error
+		cache, current, err = tcm.readCache()
+		return err
+	})
+	return cache, current, err
+}
+
+// readCache キャッシュファイルを読み込む
+// 旧形式（トークン1つのみ）のファイルは1アカウントのキャッシュとして扱う
+func (tcm *TokenCacheManager) readCache() (*tokenCacheFile, bool, error) {
+	data, current, err := tcm.readFile()
+	if err != nil {
+		return nil, current, err
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		tcm.logger.Error("キャッシュJSON解析失敗", "error", err)
+		return nil, current, err
+	}
+
+	if _, ok := probe["accounts"]; !ok {
+		var token TokenResponse
+		if err := json.Unmarshal(data, &token); err != nil {
+			tcm.logger.Error("キャッシュJSON解析失敗", "error", err)
+			return nil, current, err
+		}
+		key := resolveAccountKey("", &token)
+		token.Account = key
+		return &tokenCacheFile{Accounts: map[string]*TokenResponse{key: &token}}, current, nil
+	}
+
+	var cache tokenCacheFile
+	if err := json.Unmarshal(data, &cache); err != nil {
+		tcm.logger.Error("キャッシュJSON解析失敗", "error", err)
+		return nil, current, err
+	}
+	for key, token := range cache.Accounts {
+		token.Account = key
+	}
+	return &cache, current, nil
+}
+
+// writeCache キャッシュをファイルに書き込む（キーがあれば暗号化）
+func (tcm *TokenCacheManager) writeCache(cache *tokenCacheFile) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
 	if err != nil {
 		tcm.logger.Error("キャッシュJSON作成失敗", "error", err)
 		return err
 	}
 
+	if tcm.key != nil {
+		data, err = encryptCache(tcm.key, data)
+		if err != nil {
+			tcm.logger.Error("キャッシュ暗号化失敗", "error", err)
+			return err
+		}
+	} else {
+		tcm.plaintextWarning.Do(func() {
+			tcm.logger.Warn("トークンキャッシュを平文で保存しています。暗号化するには環境変数を設定してください", "env", CacheKeyEnv)
+		})
+	}
+
 	// ファイルを安全に書き込む（0600: 所有者のみ読み書き可能）
-	if err := os.WriteFile(tcm.filePath, data, 0600); err != nil {
+	// 一時ファイルからのリネームで置き換え、書き込み途中の内容が読まれないようにする
+	if err := fsutil.WriteFileAtomic(tcm.filePath, data, 0600); err != nil {
 		tcm.logger.Error("キャッシュファイル書き込み失敗", "error", err)
 		return err
 	}
-
-	tcm.logger.Debug("トークンをキャッシュに保存しました")
 	return nil
 }
 
-// ClearCache トークンキャッシュをクリア
-func (tcm *TokenCacheManager) ClearCache() error {
+// mergeExtra 既存トークンにある未知のフィールドを新しいトークンに引き継ぐ
+func mergeExtra(token, existing *TokenResponse) {
+	for key, value := range existing.Extra {
+		if token.Extra == nil {
+			token.Extra = make(map[string]json.RawMessage)
+		}
+		if _, exists := token.Extra[key]; !exists {
+			token.Extra[key] = value
+		}
+	}
+}
+
+// ClearCache 指定したアカウントのトークンを削除
+// accountが空の場合はキャッシュ全体を削除する
+func (tcm *TokenCacheManager) ClearCache(account string) error {
 	tcm.mu.Lock()
 	defer tcm.mu.Unlock()
 
+	return tcm.withFileLock(true, func() error {
+		return tcm.clearCache(account)
+	})
+}
+
+// clearCache キャッシュからトークンを削除（ロックは呼び出し側で取得する）
+func (tcm *TokenCacheManager) clearCache(account string) error {
+	if account != "" {
+		cache, _, err := tcm.readCache()
+		if err == nil {
+			if key, ok := cache.find(account); ok {
+				delete(cache.Accounts, key)
+			}
+			if len(cache.Accounts) > 0 {
+				if err := tcm.writeCache(cache); err != nil {
+					return err
+				}
+				tcm.logger.Debug("アカウントのトークンを削除しました", "account", account)
+				return nil
+			}
+		}
+	}
+
 	if err := os.Remove(tcm.filePath); err != nil && !os.IsNotExist(err) {
 		tcm.logger.Error("キャッシュ削除失敗", "error", err)
 		return err
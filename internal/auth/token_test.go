@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestTokenResponseUnknownFields(t *testing.T) {
+	const data = `{"access_token":"a","expires_in":3600,"future_object":{"nested":[1,2]},"future_number":2}`
+	var token TokenResponse
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "a" || token.ExpiresIn != 3600 {
+		t.Errorf("known fields = %q %d, want a 3600", token.AccessToken, token.ExpiresIn)
+	}
+	if len(token.Extra) != 2 || string(token.Extra["future_object"]) != `{"nested":[1,2]}` || string(token.Extra["future_number"]) != "2" {
+		t.Errorf("Extra = %q, want future_object and future_number", token.Extra)
+	}
+
+	// 既知のフィールドを変更して書き出しても、未知のフィールドは元の値のまま残る
+	token.AccessToken = "b"
+	out, err := json.Marshal(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got["access_token"]) != `"b"` || string(got["future_object"]) != `{"nested":[1,2]}` || string(got["future_number"]) != "2" {
+		t.Errorf("MarshalJSON() = %s, want access_token b and the unknown fields", out)
+	}
+}
+
+func TestTokenCacheUnknownFieldsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "アカウントごとの形式", file: `{"accounts":{"alice@example.com":{"access_token":"old","refresh_token":"r","future_field":{"v":1}}}}`},
+		// 旧形式（トークン1つのみ）のファイルも移行時に未知のフィールドを引き継ぐ
+		{name: "旧形式", file: `{"access_token":"old","refresh_token":"r","preferred_username":"alice@example.com","future_field":{"v":1}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(CacheKeyEnv, "")
+			path := filepath.Join(t.TempDir(), "token.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			tcm := NewTokenCacheManager(path, log.New(io.Discard))
+
+			token, err := tcm.LoadToken("alice@example.com")
+			if err != nil {
+				t.Fatalf("LoadToken() error = %v", err)
+			}
+			if compactJSON(t, token.Extra["future_field"]) != `{"v":1}` {
+				t.Errorf("Extra = %q, want future_field", token.Extra)
+			}
+
+			// 更新したトークン（未知のフィールドを持たない）を保存しても、既存の未知のフィールドを引き継ぐ
+			if err := tcm.SaveToken("alice@example.com", &TokenResponse{AccessToken: "new", RefreshToken: "r2", ExpiresIn: 3600}); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cache struct {
+				Accounts map[string]map[string]json.RawMessage `json:"accounts"`
+			}
+			if err := json.Unmarshal(data, &cache); err != nil {
+				t.Fatal(err)
+			}
+			saved := cache.Accounts["alice@example.com"]
+			if string(saved["access_token"]) != `"new"` || compactJSON(t, saved["future_field"]) != `{"v":1}` {
+				t.Errorf("saved token = %s, want access_token new and future_field", data)
+			}
+
+			reloaded, err := tcm.LoadToken("alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reloaded.AccessToken != "new" || compactJSON(t, reloaded.Extra["future_field"]) != `{"v":1}` {
+				t.Errorf("reloaded = %q %q, want new with future_field", reloaded.AccessToken, reloaded.Extra)
+			}
+		})
+	}
+}
+
+// compactJSON 整形されたJSONの値を空白のない形にする（キャッシュファイルはインデントして保存するため）
+func compactJSON(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := json.Compact(&b, raw); err != nil {
+		return string(raw)
+	}
+	return b.String()
+}
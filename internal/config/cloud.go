@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Cloud Microsoftのクラウド環境（パブリックまたは各国のクラウド）
+type Cloud struct {
+	Name string
+	// LoginHost 認証機関（ログインエンドポイント）のホスト
+	LoginHost string
+	// GraphEndpoint Microsoft Graphのエンドポイント
+	GraphEndpoint string
+}
+
+// DefaultCloud cloudが指定されていない場合のクラウド
+const DefaultCloud = "public"
+
+// clouds 指定できるクラウドの一覧
+var clouds = []Cloud{
+	{Name: "public", LoginHost: "login.microsoftonline.com", GraphEndpoint: "https://graph.microsoft.com"},
+	{Name: "usgov", LoginHost: "login.microsoftonline.us", GraphEndpoint: "https://graph.microsoft.us"},
+	{Name: "usgovdod", LoginHost: "login.microsoftonline.us", GraphEndpoint: "https://dod-graph.microsoft.us"},
+	{Name: "china", LoginHost: "login.chinacloudapi.cn", GraphEndpoint: "https://microsoftgraph.chinacloudapi.cn"},
+}
+
+// LookupCloud 名前からクラウドを取得（空の場合はパブリッククラウド）
+func LookupCloud(name string) (Cloud, error) {
+	if name == "" {
+		name = DefaultCloud
+	}
+	for _, c := range clouds {
+		if strings.EqualFold(c.Name, name) {
+			return c, nil
+		}
+	}
+
+	names := make([]string, 0, len(clouds))
+	for _, c := range clouds {
+		names = append(names, c.Name)
+	}
+	return Cloud{}, fmt.Errorf("不明なcloudです: %s（指定できる値: %s）", name, strings.Join(names, ", "))
+}
+
+// Cloud 設定されたクラウドを取得
+// 不明な値の場合はパブリッククラウドを返す（検証はValidateCloudで行う）
+func (g GraphConfig) Cloud() Cloud {
+	c, err := LookupCloud(g.CloudName)
+	if err != nil {
+		c, _ = LookupCloud(DefaultCloud)
+	}
+	return c
+}
+
+// ValidateCloud cloudとauthority_urlが同じクラウドのものか検証
+func (g GraphConfig) ValidateCloud() error {
+	c, err := LookupCloud(g.CloudName)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(g.AuthorityURL)
+	if err != nil {
+		return fmt.Errorf("authority_urlを解析できません: %w", err)
+	}
+	if !strings.EqualFold(u.Host, c.LoginHost) {
+		return fmt.Errorf("authority_url (%s) はcloud %s のログインエンドポイントではありません（https://%s/<tenant> を指定してください）", g.AuthorityURL, c.Name, c.LoginHost)
+	}
+	return nil
+}
@@ -7,8 +7,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/canaria-computer/m3bridge/internal/fsutil"
 	"github.com/charmbracelet/log"
 )
 
@@ -17,10 +21,41 @@ const (
 	ConfigFileName = "config.json"
 )
 
-// Config SMTPサーバとMicrosoft Graphの設定
+// DefaultProfile プロファイルを指定しない場合に使うプロファイル名
+const DefaultProfile = "default"
+
+// Config 設定ファイルの内容
 type Config struct {
+	// Profiles 名前付きのプロファイル（アカウントごとのSMTPサーバとGraphの設定）
+	Profiles map[string]*Profile `json:"profiles"`
+
+	// SMTP, Graph, Relay 旧形式（プロファイルなし）の設定。読み込み時に default プロファイルへ移行する
+	SMTP  *SMTPConfig  `json:"smtp,omitempty"`
+	Graph *GraphConfig `json:"graph,omitempty"`
+	Relay *RelayConfig `json:"relay,omitempty"`
+}
+
+// Profile 1つのアカウントで送信するSMTPサーバとMicrosoft Graphの設定
+type Profile struct {
 	SMTP  SMTPConfig  `json:"smtp"`
 	Graph GraphConfig `json:"graph"`
+	// Relay Graph障害時のフォールバック先SMTPリレー
+	Relay RelayConfig `json:"relay,omitempty"`
+	// Queue 一時的なエラーで送信できなかったメッセージの再送キュー
+	Queue QueueConfig `json:"queue,omitempty"`
+}
+
+// QueueConfig 再送キューの設定
+type QueueConfig struct {
+	// Enabled 再送キューを使用する
+	Enabled bool `json:"enabled,omitempty"`
+	// Dir メッセージを保存するディレクトリ（空の場合は設定ディレクトリの queue、環境変数展開可）
+	Dir string `json:"dir,omitempty"`
+	// MaxAttempts 送信の最大試行回数（0の場合は10）
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// InitialBackoffSecs, MaxBackoffSecs 再送までの待機時間の初期値と上限の秒数（0の場合は60と3600）
+	InitialBackoffSecs int `json:"initial_backoff_secs,omitempty"`
+	MaxBackoffSecs     int `json:"max_backoff_secs,omitempty"`
 }
 
 // SMTPConfig SMTP関連の設定
@@ -29,6 +64,66 @@ type SMTPConfig struct {
 	Port     int    `json:"port"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// Listen 待ち受けるアドレス（host:port、末尾に :tls で暗黙的TLS）。指定した場合は host/port/tls_port の代わりに使う
+	Listen []string `json:"listen,omitempty"`
+
+	// DisableAuth SMTP AUTHなしでの送信を許可する（信頼できるネットワークでのみ使用すること）
+	DisableAuth bool `json:"disable_auth,omitempty"`
+	// XOAuth2 AUTH XOAUTH2の扱い（static: トークンをpasswordと照合、passthrough: トークンでGraphに送信、空の場合は無効）
+	XOAuth2 string `json:"xoauth2,omitempty"`
+
+	// Account このSMTP認証情報で送信するアカウント（空の場合はキャッシュ上の唯一のアカウント）
+	Account string `json:"account,omitempty"`
+
+	// MaxLineLength 1行の最大長（0の場合はデフォルトの8192）
+	MaxLineLength int `json:"max_line_length,omitempty"`
+	// MaxMessageBytes 1通のメッセージの最大サイズ（0の場合は10MB）
+	MaxMessageBytes int64 `json:"max_message_bytes,omitempty"`
+	// MaxBufferedBytes 全セッションで同時にメモリに保持するメッセージの合計サイズ（0の場合は無制限）
+	MaxBufferedBytes int64 `json:"max_buffered_bytes,omitempty"`
+	// MaxRecipients 1通のメッセージの最大受信者数（0の場合は50）
+	MaxRecipients int `json:"max_recipients,omitempty"`
+	// MaxConnections 同時に処理するSMTPセッションの最大数（0の場合は無制限）
+	MaxConnections int `json:"max_connections,omitempty"`
+	// AllowedNetworks 接続を許可するIPアドレスまたはCIDR（空の場合は全て許可）
+	AllowedNetworks []string `json:"allowed_networks,omitempty"`
+	// MaxReceivedHeaders メールループとみなすReceivedヘッダーの数の上限（0の場合は30）
+	MaxReceivedHeaders int `json:"max_received_headers,omitempty"`
+	// RejectAutoSubmitted メールループとみなして拒否するAuto-Submittedの値（未指定の場合は auto-replied と auto-generated）
+	RejectAutoSubmitted []string `json:"reject_auto_submitted,omitempty"`
+
+	// ReadTimeoutSecs, WriteTimeoutSecs クライアントとの読み書きのタイムアウトの秒数（0の場合は60）
+	ReadTimeoutSecs  int `json:"read_timeout_secs,omitempty"`
+	WriteTimeoutSecs int `json:"write_timeout_secs,omitempty"`
+
+	// TLSCertFile, TLSKeyFile STARTTLSに使う証明書と秘密鍵のPEMファイルのパス（環境変数展開可）
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`
+	// TLSSelfSigned 証明書の代わりにlocalhost向けの自己署名証明書を生成してSTARTTLSを有効にする
+	TLSSelfSigned bool `json:"tls_self_signed,omitempty"`
+	// RequireTLS STARTTLSの前のAUTHを拒否する
+	RequireTLS bool `json:"require_tls,omitempty"`
+	// TLSPort 暗黙的TLS（SMTPS）で待ち受けるポート（例: 465、0の場合は無効）
+	TLSPort int `json:"tls_port,omitempty"`
+
+	// Socket 待ち受けるUnixドメインソケットのパス（環境変数展開可、空の場合は無効）
+	Socket string `json:"socket,omitempty"`
+	// SocketOnly TCPで待ち受けず、Unixドメインソケットのみを使う
+	SocketOnly bool `json:"socket_only,omitempty"`
+
+	// AllowedRecipientDomains 送信を許可する受信者のドメイン（*.example.com でサブドメイン、空の場合は全て許可）
+	AllowedRecipientDomains []string `json:"allowed_recipient_domains,omitempty"`
+	// DeniedRecipients 送信を拒否する受信者のアドレスまたはドメイン（許可リストより優先）
+	DeniedRecipients []string `json:"denied_recipients,omitempty"`
+}
+
+// RelayConfig Graph障害時のフォールバック先SMTPリレーの設定
+type RelayConfig struct {
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
 }
 
 // GraphConfig Microsoft Graph関連の設定
@@ -37,14 +132,92 @@ type GraphConfig struct {
 	RedirectURI  string `json:"redirect_uri"`
 	AuthorityURL string `json:"authority_url"`
 	TokenCache   string `json:"token_cache"`
+	// CloudName クラウド環境（public, usgov, usgovdod, china、空の場合はpublic）
+	CloudName string `json:"cloud,omitempty"`
+	// TokenStore トークンの保存先（"file" または "keyring"、空の場合はfile）
+	TokenStore string `json:"token_store,omitempty"`
+
+	// ExpiryBufferSecs 有効期限の何秒前から期限切れとみなすか（0の場合は300秒）
+	ExpiryBufferSecs int `json:"expiry_buffer_secs,omitempty"`
+
+	// CallbackAddr 認証コールバックの待ち受けアドレス（空の場合はredirect_uriから決定）
+	CallbackAddr string `json:"callback_addr,omitempty"`
+	// SuccessPage, ErrorPage 認証後にブラウザに表示するHTMLテンプレートのパス（環境変数展開可）
+	SuccessPage string `json:"success_page,omitempty"`
+	ErrorPage   string `json:"error_page,omitempty"`
+
+	// Scopes 要求するOAuthスコープ（空の場合はデフォルト）
+	Scopes []string `json:"scopes,omitempty"`
+
+	// ClientSecret コンフィデンシャルクライアント用のシークレット（インライン指定）
+	ClientSecret string `json:"client_secret,omitempty"`
+	// ClientSecretFile シークレットを格納したファイルのパス（環境変数展開可）
+	ClientSecretFile string `json:"client_secret_file,omitempty"`
+	// ClientCertificateFile 証明書と秘密鍵を格納したPEMファイルのパス（環境変数展開可、シークレットより優先）
+	ClientCertificateFile string `json:"client_certificate_file,omitempty"`
+
+	// SenderUserID アプリのみの認証で送信に使用するユーザーID（またはUPN）
+	SenderUserID string `json:"sender_user_id,omitempty"`
+
+	// AllowedMailboxes X-M3Bridge-Mailboxヘッダーで送信元に指定できる共有メールボックス
+	AllowedMailboxes []string `json:"allowed_mailboxes,omitempty"`
+
+	// SendAs 送信元（From）に設定するアドレス（SendAs権限のある共有メールボックスなど）
+	SendAs string `json:"send_as,omitempty"`
+
+	// PassthroughHeaders Graphに引き継ぐヘッダー名（X-で始まるもののみ、末尾の*は前方一致、未指定の場合は X-*）
+	PassthroughHeaders []string `json:"passthrough_headers,omitempty"`
+
+	// TextAlternative HTMLの本文に添付するテキストの代替本文（attach: クライアントのテキストのパート、generate: なければHTMLから生成、空の場合は添付しない）
+	TextAlternative string `json:"text_alternative,omitempty"`
+
+	// FooterHTML, FooterText 本文の末尾に追加するフッターのテンプレート（{{.From}}、{{.Subject}}、{{.Date}}を使用可能）
+	FooterHTML string `json:"footer_html,omitempty"`
+	FooterText string `json:"footer_text,omitempty"`
+
+	// ArchiveBcc 記録用に全てのメッセージのBCCに追加するアドレス（ヘッダーには現れない）
+	ArchiveBcc string `json:"archive_bcc,omitempty"`
+
+	// SaveToSentItems 送信したメールを送信済みアイテムに保存するか（未指定の場合は保存する）
+	SaveToSentItems *bool `json:"save_to_sent_items,omitempty"`
+
+	// SendMaxAttempts スロットリング時などに送信を試行する最大回数（0の場合は3回）
+	SendMaxAttempts int `json:"send_max_attempts,omitempty"`
+
+	// HTTP Graphへの接続に使うHTTPクライアントの設定
+	HTTP HTTPConfig `json:"http,omitempty"`
+
+	// ProxyURL Graphと認証のリクエストに使うプロキシ（空の場合は環境変数に従う）
+	ProxyURL string `json:"proxy_url,omitempty"`
+}
+
+// HTTPConfig Graphへの接続の設定（0の場合はデフォルト）
+type HTTPConfig struct {
+	// MaxIdleConns 保持するアイドル接続の最大数（デフォルト: 100）
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+	// MaxIdleConnsPerHost ホストごとに保持するアイドル接続の最大数（デフォルト: 16）
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+	// IdleConnTimeoutSecs アイドル接続を閉じるまでの秒数（デフォルト: 90）
+	IdleConnTimeoutSecs int `json:"idle_conn_timeout_secs,omitempty"`
+	// KeepAliveSecs TCPキープアライブの間隔の秒数（デフォルト: 30）
+	KeepAliveSecs int `json:"keep_alive_secs,omitempty"`
+}
+
+// SaveToSent 送信済みアイテムに保存するか（未指定の場合はtrue）
+func (g GraphConfig) SaveToSent() bool {
+	return g.SaveToSentItems == nil || *g.SaveToSentItems
 }
 
 // Manager 設定ファイルマネージャー
 type Manager struct {
 	configPath string
-	config     *Config
+	config     *Config             // 設定ファイルの内容（保存対象）
+	effective  map[string]*Profile // 環境変数で上書きしたプロファイル（取得対象）
 	mu         sync.RWMutex
-	logger     *log.Logger
+
+	// clientSecrets ClientSecretFileから読み込んだプロファイルごとのシークレット（ファイルには書き戻さない）
+	clientSecrets map[string]string
+	logger        *log.Logger
 }
 
 // NewManager 新しい設定マネージャーを作成
@@ -87,20 +260,39 @@ func (m *Manager) initialize() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	profile, err := newProfile(DefaultProfile)
+	if err != nil {
+		return err
+	}
+	m.config = &Config{Profiles: map[string]*Profile{DefaultProfile: profile}}
+	m.clientSecrets = map[string]string{DefaultProfile: ""}
+	if err := m.applyEnv(); err != nil {
+		return err
+	}
+
+	return m.save()
+}
+
+// newProfile 初期値のプロファイルを作成
+// トークンキャッシュはプロファイルごとに分ける（default は従来と同じ token_cache.json）
+func newProfile(name string) (*Profile, error) {
 	// ランダムなパスワードを生成
 	password, err := generatePassword(32)
 	if err != nil {
-		return fmt.Errorf("パスワード生成エラー: %w", err)
+		return nil, fmt.Errorf("パスワード生成エラー: %w", err)
 	}
 
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("ホームディレクトリ取得エラー: %w", err)
+		return nil, fmt.Errorf("ホームディレクトリ取得エラー: %w", err)
 	}
 
-	tokenCachePath := filepath.Join(home, ConfigDirName, "token_cache.json")
+	cacheFile := "token_cache.json"
+	if name != DefaultProfile {
+		cacheFile = fmt.Sprintf("token_cache.%s.json", name)
+	}
 
-	m.config = &Config{
+	return &Profile{
 		SMTP: SMTPConfig{
 			Host:     "localhost",
 			Port:     2525,
@@ -111,11 +303,9 @@ func (m *Manager) initialize() error {
 			ClientID:     "b1fac4bf-c5c6-4170-89e0-7a7bb9ef35f2",
 			RedirectURI:  "http://localhost:5225/callback",
 			AuthorityURL: "https://login.microsoftonline.com/common",
-			TokenCache:   tokenCachePath,
+			TokenCache:   filepath.Join(home, ConfigDirName, cacheFile),
 		},
-	}
-
-	return m.save()
+	}, nil
 }
 
 // load 設定ファイルを読み込む
@@ -133,19 +323,146 @@ func (m *Manager) load() error {
 		return fmt.Errorf("JSON解析エラー: %w", err)
 	}
 
-	m.config = &config
-	m.logger.Debug("設定ファイル読み込み成功", "path", m.configPath)
+	// 検証が終わるまでは現在の設定を変更しない（再読み込みに失敗しても読み込み前の設定を使い続けられるように）
+	migrated := m.migrate(&config)
+	effective, err := m.effectiveProfiles(&config)
+	if err != nil {
+		return err
+	}
+
+	secrets := make(map[string]string, len(effective))
+	for name, profile := range effective {
+		secret, err := m.resolveClientSecret(name, profile.Graph)
+		if err != nil {
+			return fmt.Errorf("プロファイル %s: %w", name, err)
+		}
+		secrets[name] = secret
+		if _, err := profile.Graph.Proxy(); err != nil {
+			return fmt.Errorf("プロファイル %s: %w", name, err)
+		}
+		if err := profile.SMTP.ValidateLimits(); err != nil {
+			return fmt.Errorf("プロファイル %s: %w", name, err)
+		}
+	}
+
+	if migrated {
+		if err := m.write(&config); err != nil {
+			return err
+		}
+		m.logger.Info("旧形式の設定を default プロファイルに移行しました", "path", m.configPath)
+	}
+
+	m.config, m.effective, m.clientSecrets = &config, effective, secrets
+	m.logger.Debug("設定ファイル読み込み成功", "path", m.configPath, "profiles", len(config.Profiles))
+	return nil
+}
+
+// Reload 設定ファイルを読み込み直す
+// 読み込みや検証に失敗した場合は、読み込み前の設定を維持する
+func (m *Manager) Reload() error {
+	if err := m.load(); err != nil {
+		return fmt.Errorf("設定読み込みエラー: %w", err)
+	}
+	return nil
+}
+
+// migrate 旧形式（プロファイルなし）の設定を default プロファイルに移行する
+// 移行した場合はtrueを返す
+func (m *Manager) migrate(c *Config) bool {
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*Profile)
+	}
+	if c.SMTP == nil && c.Graph == nil && c.Relay == nil {
+		return false
+	}
+
+	if _, ok := c.Profiles[DefaultProfile]; !ok {
+		profile := &Profile{}
+		if c.SMTP != nil {
+			profile.SMTP = *c.SMTP
+		}
+		if c.Graph != nil {
+			profile.Graph = *c.Graph
+		}
+		if c.Relay != nil {
+			profile.Relay = *c.Relay
+		}
+		c.Profiles[DefaultProfile] = profile
+	} else {
+		m.logger.Warn("default プロファイルがあるため、旧形式の設定は破棄します")
+	}
+	c.SMTP, c.Graph, c.Relay = nil, nil, nil
+	return true
+}
+
+// applyEnv 環境変数による上書きを適用したプロファイルで、取得対象のプロファイルを更新する
+func (m *Manager) applyEnv() error {
+	effective, err := m.effectiveProfiles(m.config)
+	if err != nil {
+		return err
+	}
+	m.effective = effective
 	return nil
 }
 
-// save 設定ファイルに保存
+// effectiveProfiles 環境変数による上書きを適用したプロファイルを作成
+// 環境変数は全てのプロファイルに適用する（1つのプロセスでは1つのプロファイルのみ使う）
+func (m *Manager) effectiveProfiles(c *Config) (map[string]*Profile, error) {
+	effective := make(map[string]*Profile, len(c.Profiles))
+	var applied []string
+	for name, profile := range c.Profiles {
+		p, names, err := applyEnv(profile)
+		if err != nil {
+			return nil, err
+		}
+		effective[name] = p
+		applied = names
+	}
+	if len(applied) > 0 {
+		m.logger.Debug("環境変数で設定を上書きしました", "variables", applied)
+	}
+	return effective, nil
+}
+
+// resolveClientSecret プロファイルのシークレットを取得（ClientSecretFileがある場合はファイルから読み込む）
+func (m *Manager) resolveClientSecret(name string, graph GraphConfig) (string, error) {
+	if graph.ClientSecretFile == "" {
+		return graph.ClientSecret, nil
+	}
+
+	path := os.ExpandEnv(graph.ClientSecretFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("クライアントシークレットファイル読み込みエラー: %w", err)
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("クライアントシークレットファイルが空です: %s", path)
+	}
+
+	// インライン値とファイルの両方が指定され、内容が異なる場合はエラー
+	if graph.ClientSecret != "" && graph.ClientSecret != secret {
+		return "", fmt.Errorf("client_secret と client_secret_file の内容が一致しません")
+	}
+
+	m.logger.Debug("クライアントシークレットをファイルから読み込みました", "profile", name, "path", path)
+	return secret, nil
+}
+
+// save 設定ファイルに保存（呼び出し側で書き込みロックを保持する）
 func (m *Manager) save() error {
-	data, err := json.MarshalIndent(m.config, "", "  ")
+	return m.write(m.config)
+}
+
+// write 設定を設定ファイルに書き込む
+func (m *Manager) write(c *Config) error {
+	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
 		return fmt.Errorf("JSON作成エラー: %w", err)
 	}
 
-	if err := os.WriteFile(m.configPath, data, 0600); err != nil {
+	if err := fsutil.WriteFileAtomic(m.configPath, data, 0600); err != nil {
 		return fmt.Errorf("ファイル書き込みエラー: %w", err)
 	}
 
@@ -153,32 +470,166 @@ func (m *Manager) save() error {
 	return nil
 }
 
-// GetConfig 設定を取得
-func (m *Manager) GetConfig() *Config {
+// HasProfile プロファイルがあるか判定
+func (m *Manager) HasProfile(profile string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.config
+	_, ok := m.effective[profile]
+	return ok
 }
 
-// GetSMTPConfig SMTP設定を取得
-func (m *Manager) GetSMTPConfig() SMTPConfig {
+// ProfileNames プロファイル名の一覧を返す（名前順）
+func (m *Manager) ProfileNames() []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.config.SMTP
+	names := make([]string, 0, len(m.config.Profiles))
+	for name := range m.config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddProfile 初期値のプロファイルを追加して保存
+// SMTPポートは既存のプロファイルの次の番号にする
+func (m *Manager) AddProfile(profile string) error {
+	if !profilePattern.MatchString(profile) {
+		return fmt.Errorf("プロファイル名には英数字、-、_ を使用してください: %s", profile)
+	}
+
+	p, err := newProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.config.Profiles[profile]; ok {
+		return fmt.Errorf("プロファイル %s はすでにあります", profile)
+	}
+	// 既存のプロファイルとポートが重ならないようにする
+	for _, existing := range m.config.Profiles {
+		p.SMTP.Port = max(p.SMTP.Port, existing.SMTP.Port+1)
+	}
+	m.config.Profiles[profile] = p
+	if err := m.applyEnv(); err != nil {
+		return err
+	}
+	m.clientSecrets[profile] = ""
+	m.logger.Info("プロファイルを作成しました", "profile", profile)
+	return m.save()
 }
 
-// GetGraphConfig Graph設定を取得
-func (m *Manager) GetGraphConfig() GraphConfig {
+// profilePattern プロファイル名として使える文字（トークンキャッシュのファイル名にも使う）
+var profilePattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// GetSMTPConfig SMTP設定を取得（プロファイルがない場合はゼロ値）
+func (m *Manager) GetSMTPConfig(profile string) SMTPConfig {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.config.Graph
+	if p, ok := m.effective[profile]; ok {
+		return p.SMTP
+	}
+	return SMTPConfig{}
+}
+
+// GetGraphConfig Graph設定を取得（プロファイルがない場合はゼロ値）
+func (m *Manager) GetGraphConfig(profile string) GraphConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.effective[profile]
+	if !ok {
+		return GraphConfig{}
+	}
+	graph := p.Graph
+	graph.ClientSecret = m.clientSecrets[profile]
+	return graph
+}
+
+// GetRelayConfig リレー設定を取得（プロファイルがない場合はゼロ値）
+func (m *Manager) GetRelayConfig(profile string) RelayConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if p, ok := m.effective[profile]; ok {
+		return p.Relay
+	}
+	return RelayConfig{}
+}
+
+// GetQueueConfig 再送キューの設定を取得（プロファイルがない場合はゼロ値）
+// ディレクトリが指定されていない場合は設定ディレクトリの queue（default以外は queue.<プロファイル名>）
+func (m *Manager) GetQueueConfig(profile string) QueueConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.effective[profile]
+	if !ok {
+		return QueueConfig{}
+	}
+	queue := p.Queue
+	switch {
+	case queue.Dir != "":
+		queue.Dir = os.ExpandEnv(queue.Dir)
+	case profile == DefaultProfile:
+		queue.Dir = filepath.Join(filepath.Dir(m.configPath), "queue")
+	default:
+		queue.Dir = filepath.Join(filepath.Dir(m.configPath), "queue."+profile)
+	}
+	return queue
 }
 
 // UpdateSMTPPort SMTPポートを更新
-func (m *Manager) UpdateSMTPPort(port int) error {
+func (m *Manager) UpdateSMTPPort(profile string, port int) error {
+	return m.update(profile, func(p *Profile) {
+		p.SMTP.Port = port
+	})
+}
+
+// UpdateSMTPUsername SMTP認証のユーザー名を更新
+func (m *Manager) UpdateSMTPUsername(profile, username string) error {
+	return m.update(profile, func(p *Profile) {
+		p.SMTP.Username = username
+	})
+}
+
+// UpdateSMTPPassword SMTP認証のパスワードを更新
+func (m *Manager) UpdateSMTPPassword(profile, password string) error {
+	return m.update(profile, func(p *Profile) {
+		p.SMTP.Password = password
+	})
+}
+
+// RotateSMTPPassword SMTP認証のパスワードを新しく生成して保存し、新しいパスワードを返す
+func (m *Manager) RotateSMTPPassword(profile string) (string, error) {
+	password, err := generatePassword(32)
+	if err != nil {
+		return "", fmt.Errorf("パスワード生成エラー: %w", err)
+	}
+	if err := m.UpdateSMTPPassword(profile, password); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// OverriddenByEnv 指定した環境変数（接頭辞を除いた名前、例: SMTP_PASSWORD）で設定が上書きされているか
+func OverriddenByEnv(name string) bool {
+	_, ok := os.LookupEnv(EnvPrefix + name)
+	return ok
+}
+
+// update 書き込みロックを保持したままプロファイルを変更して保存する
+// 環境変数による上書きは変更後も優先する
+func (m *Manager) update(profile string, change func(p *Profile)) error {
 	m.mu.Lock()
-	m.config.SMTP.Port = port
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+
+	p, ok := m.config.Profiles[profile]
+	if !ok {
+		return fmt.Errorf("プロファイル %s がありません", profile)
+	}
+	change(p)
+	if err := m.applyEnv(); err != nil {
+		return err
+	}
 	return m.save()
 }
 
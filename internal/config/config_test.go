@@ -0,0 +1,61 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestReload(t *testing.T) {
+	const valid = `{"profiles":{"default":{"smtp":{"port":2525},"graph":{"client_secret_file":"secret.txt"}}}}`
+	tests := []struct {
+		name     string
+		config   string
+		secret   string
+		wantErr  bool
+		wantPort int
+		wantKey  string
+	}{
+		{name: "更新", config: `{"profiles":{"default":{"smtp":{"port":2526},"graph":{"client_secret_file":"secret.txt"}}}}`, secret: "new", wantPort: 2526, wantKey: "new"},
+		{name: "JSONの誤り", config: `{"profiles":`, secret: "new", wantErr: true, wantPort: 2525, wantKey: "old"},
+		{name: "シークレットファイルが空", config: valid, secret: "", wantErr: true, wantPort: 2525, wantKey: "old"},
+		{name: "制限値の誤り", config: `{"profiles":{"default":{"smtp":{"port":2526,"max_recipients":-1},"graph":{"client_secret_file":"secret.txt"}}}}`, secret: "new", wantErr: true, wantPort: 2525, wantKey: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+			path := filepath.Join(dir, ConfigFileName)
+			write := func(config, secret string) {
+				if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile("secret.txt", []byte(secret), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			write(valid, "old")
+			m := &Manager{configPath: path, logger: log.New(io.Discard)}
+			if err := m.load(); err != nil {
+				t.Fatal(err)
+			}
+
+			write(tt.config, tt.secret)
+			if err := m.Reload(); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// 失敗した場合は、ポートとシークレットのどちらも読み込み前の設定のまま
+			if got := m.GetSMTPConfig(DefaultProfile).Port; got != tt.wantPort {
+				t.Errorf("Port = %d, want %d", got, tt.wantPort)
+			}
+			if got := m.GetGraphConfig(DefaultProfile).ClientSecret; got != tt.wantKey {
+				t.Errorf("ClientSecret = %q, want %q", got, tt.wantKey)
+			}
+		})
+	}
+}
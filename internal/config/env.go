@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix 設定を上書きする環境変数の接頭辞
+const EnvPrefix = "M3BRIDGE_"
+
+// envOverride 環境変数で上書きできる設定項目
+type envOverride struct {
+	name  string // 接頭辞を除いた環境変数名
+	apply func(p *Profile, value string) error
+}
+
+// envOverrides 環境変数で上書きできる設定の一覧
+// コンテナなどで、シークレットをファイルに書かずに渡せるようにする
+var envOverrides = []envOverride{
+	{"SMTP_HOST", setString(func(p *Profile) *string { return &p.SMTP.Host })},
+	{"SMTP_PORT", setInt(func(p *Profile) *int { return &p.SMTP.Port })},
+	{"SMTP_USERNAME", setString(func(p *Profile) *string { return &p.SMTP.Username })},
+	{"SMTP_PASSWORD", setString(func(p *Profile) *string { return &p.SMTP.Password })},
+	{"SMTP_ACCOUNT", setString(func(p *Profile) *string { return &p.SMTP.Account })},
+	{"SMTP_TLS_CERT_FILE", setString(func(p *Profile) *string { return &p.SMTP.TLSCertFile })},
+	{"SMTP_TLS_KEY_FILE", setString(func(p *Profile) *string { return &p.SMTP.TLSKeyFile })},
+	{"SMTP_TLS_PORT", setInt(func(p *Profile) *int { return &p.SMTP.TLSPort })},
+
+	{"GRAPH_CLIENT_ID", setString(func(p *Profile) *string { return &p.Graph.ClientID })},
+	{"GRAPH_CLIENT_SECRET", setString(func(p *Profile) *string { return &p.Graph.ClientSecret })},
+	{"GRAPH_CLIENT_SECRET_FILE", setString(func(p *Profile) *string { return &p.Graph.ClientSecretFile })},
+	{"GRAPH_CLIENT_CERTIFICATE_FILE", setString(func(p *Profile) *string { return &p.Graph.ClientCertificateFile })},
+	{"GRAPH_REDIRECT_URI", setString(func(p *Profile) *string { return &p.Graph.RedirectURI })},
+	{"GRAPH_AUTHORITY_URL", setString(func(p *Profile) *string { return &p.Graph.AuthorityURL })},
+	{"GRAPH_CLOUD", setString(func(p *Profile) *string { return &p.Graph.CloudName })},
+	{"GRAPH_TOKEN_CACHE", setString(func(p *Profile) *string { return &p.Graph.TokenCache })},
+	{"GRAPH_TOKEN_STORE", setString(func(p *Profile) *string { return &p.Graph.TokenStore })},
+	{"GRAPH_SENDER_USER_ID", setString(func(p *Profile) *string { return &p.Graph.SenderUserID })},
+	{"GRAPH_SEND_AS", setString(func(p *Profile) *string { return &p.Graph.SendAs })},
+	{"GRAPH_PROXY_URL", setString(func(p *Profile) *string { return &p.Graph.ProxyURL })},
+
+	{"RELAY_HOST", setString(func(p *Profile) *string { return &p.Relay.Host })},
+	{"RELAY_PORT", setInt(func(p *Profile) *int { return &p.Relay.Port })},
+	{"RELAY_USERNAME", setString(func(p *Profile) *string { return &p.Relay.Username })},
+	{"RELAY_PASSWORD", setString(func(p *Profile) *string { return &p.Relay.Password })},
+}
+
+// applyEnv 設定ファイルの値に環境変数の値を上書きした設定を返す（優先順位: 環境変数 > 設定ファイル > デフォルト）
+// 元の設定は変更しないため、上書きした値が設定ファイルに保存されることはない
+func applyEnv(file *Profile) (*Profile, []string, error) {
+	profile := *file
+	var applied []string
+	for _, o := range envOverrides {
+		name := EnvPrefix + o.name
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := o.apply(&profile, value); err != nil {
+			return nil, nil, fmt.Errorf("環境変数 %s が不正です: %w", name, err)
+		}
+		applied = append(applied, name)
+	}
+	return &profile, applied, nil
+}
+
+// setString 文字列の設定項目を上書きする関数を返す
+func setString(field func(p *Profile) *string) func(p *Profile, value string) error {
+	return func(p *Profile, value string) error {
+		*field(p) = value
+		return nil
+	}
+}
+
+// setInt 整数の設定項目を上書きする関数を返す
+func setInt(field func(p *Profile) *int) func(p *Profile, value string) error {
+	return func(p *Profile, value string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		*field(p) = n
+		return nil
+	}
+}
+
+// OverriddenEnvNames 設定を上書きしている環境変数の名前（接頭辞を含む）
+func OverriddenEnvNames() []string {
+	var names []string
+	for _, o := range envOverrides {
+		if _, ok := os.LookupEnv(EnvPrefix + o.name); ok {
+			names = append(names, EnvPrefix+o.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantErr  string
+		wantHost string
+		wantPort int
+	}{
+		{name: "環境変数なし", wantHost: "file.example.com", wantPort: 2525},
+		{name: "環境変数が優先", env: map[string]string{"M3BRIDGE_SMTP_HOST": "env.example.com", "M3BRIDGE_SMTP_PORT": " 2600 "}, wantHost: "env.example.com", wantPort: 2600},
+		{name: "空文字でも上書き", env: map[string]string{"M3BRIDGE_SMTP_HOST": ""}, wantHost: "", wantPort: 2525},
+		{name: "整数でない", env: map[string]string{"M3BRIDGE_SMTP_PORT": "abc"}, wantErr: "M3BRIDGE_SMTP_PORT"},
+		{name: "小数", env: map[string]string{"M3BRIDGE_RELAY_PORT": "25.5"}, wantErr: "M3BRIDGE_RELAY_PORT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			file := &Profile{SMTP: SMTPConfig{Host: "file.example.com", Port: 2525}}
+
+			got, _, err := applyEnv(file)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyEnv() error = %v, want error mentioning %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.SMTP.Host != tt.wantHost || got.SMTP.Port != tt.wantPort {
+				t.Errorf("SMTP = %s:%d, want %s:%d", got.SMTP.Host, got.SMTP.Port, tt.wantHost, tt.wantPort)
+			}
+			// 元のプロファイルは変更しない
+			if file.SMTP.Host != "file.example.com" || file.SMTP.Port != 2525 {
+				t.Errorf("file profile was modified: %s:%d", file.SMTP.Host, file.SMTP.Port)
+			}
+		})
+	}
+}
+
+func TestEnvOverrideNotSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ConfigFileName)
+	config := `{"profiles":{"default":{"smtp":{"port":2525,"password":"file-password"},"graph":{"client_secret":"file-secret"}}}}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("M3BRIDGE_SMTP_PASSWORD", "env-password")
+	t.Setenv("M3BRIDGE_GRAPH_CLIENT_SECRET", "env-secret")
+
+	m := &Manager{configPath: path, logger: log.New(io.Discard)}
+	if err := m.load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpdateSMTPPort(DefaultProfile, 2600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 取得する設定には環境変数と更新した値の両方が反映される
+	smtp := m.GetSMTPConfig(DefaultProfile)
+	if smtp.Password != "env-password" || smtp.Port != 2600 {
+		t.Errorf("SMTPConfig = {Password: %q, Port: %d}, want {env-password 2600}", smtp.Password, smtp.Port)
+	}
+
+	// 設定ファイルには環境変数の値を書き込まない
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Config
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	profile := saved.Profiles[DefaultProfile]
+	if profile.SMTP.Password != "file-password" || profile.Graph.ClientSecret != "file-secret" {
+		t.Errorf("saved secrets = %q, %q, want file-password, file-secret", profile.SMTP.Password, profile.Graph.ClientSecret)
+	}
+	if profile.SMTP.Port != 2600 {
+		t.Errorf("saved port = %d, want 2600", profile.SMTP.Port)
+	}
+}
+
+func TestOverriddenEnvNames(t *testing.T) {
+	t.Setenv("M3BRIDGE_RELAY_HOST", "relay.example.com")
+	t.Setenv("M3BRIDGE_SMTP_PORT", "2600")
+	t.Setenv("M3BRIDGE_UNKNOWN", "ignored")
+
+	got := OverriddenEnvNames()
+	want := []string{"M3BRIDGE_SMTP_PORT", "M3BRIDGE_RELAY_HOST"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("OverriddenEnvNames() = %q, want %q", got, want)
+	}
+}
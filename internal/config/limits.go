@@ -0,0 +1,34 @@
+package config
+
+import "fmt"
+
+// maxGraphMessageBytes Graphで送信できるメッセージの最大サイズ
+// 3MBを超える添付ファイルはアップロードセッションで送るため、sendMailの上限（4MB）ではなくこちらが上限になる
+const maxGraphMessageBytes = 150 * 1024 * 1024
+
+// ValidateLimits SMTPのタイムアウトと上限の設定を検証
+func (s SMTPConfig) ValidateLimits() error {
+	if s.ReadTimeoutSecs < 0 || s.WriteTimeoutSecs < 0 {
+		return fmt.Errorf("smtp.read_timeout_secs と smtp.write_timeout_secs は0以上にしてください")
+	}
+	if s.MaxRecipients < 0 {
+		return fmt.Errorf("smtp.max_recipients は0以上にしてください")
+	}
+	if s.MaxReceivedHeaders < 0 {
+		return fmt.Errorf("smtp.max_received_headers は0以上にしてください")
+	}
+	if s.MaxConnections < 0 {
+		return fmt.Errorf("smtp.max_connections は0以上にしてください")
+	}
+	if s.MaxMessageBytes < 0 {
+		return fmt.Errorf("smtp.max_message_bytes は0以上にしてください")
+	}
+	if s.MaxMessageBytes > maxGraphMessageBytes {
+		return fmt.Errorf("smtp.max_message_bytes がGraphで送信できる上限（%dバイト）を超えています: %d", maxGraphMessageBytes, s.MaxMessageBytes)
+	}
+	// 1通分を確保できない場合は一時エラー（452）を返し続けることになる
+	if s.MaxBufferedBytes > 0 && s.MaxMessageBytes > s.MaxBufferedBytes {
+		return fmt.Errorf("smtp.max_buffered_bytes は smtp.max_message_bytes 以上にしてください")
+	}
+	return nil
+}
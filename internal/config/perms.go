@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+)
+
+const (
+	// privateDirMode 設定ディレクトリに求めるパーミッション
+	privateDirMode fs.FileMode = 0700
+	// privateFileMode 設定ファイルとトークンキャッシュに求めるパーミッション
+	privateFileMode fs.FileMode = 0600
+)
+
+// PermissionIssue 所有者以外に読み書きを許しているファイルやディレクトリ
+type PermissionIssue struct {
+	Path string
+	Mode fs.FileMode // 現在のパーミッション
+	Want fs.FileMode // 求めるパーミッション
+}
+
+// String 警告に表示する説明
+func (i PermissionIssue) String() string {
+	return fmt.Sprintf("%s のパーミッションが %#o です（%#o にしてください）", i.Path, i.Mode, i.Want)
+}
+
+// Fix パーミッションを求める値に変更する
+func (i PermissionIssue) Fix() error {
+	return os.Chmod(i.Path, i.Want)
+}
+
+// CheckPermissions ディレクトリとファイルのパーミッションを確認
+// シークレットを含むため、ディレクトリはグループやその他のユーザーがアクセスできず、
+// ファイルは0600より広くないことを求める。存在しないファイルは確認しない。
+// Windowsではパーミッションビットが意味を持たないため確認しない
+func CheckPermissions(dir string, files ...string) ([]PermissionIssue, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+
+	var issues []PermissionIssue
+	check := func(path string, want fs.FileMode) error {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("パーミッション確認エラー: %w", err)
+		}
+		if mode := info.Mode().Perm(); mode&^want != 0 {
+			issues = append(issues, PermissionIssue{Path: path, Mode: mode, Want: want})
+		}
+		return nil
+	}
+
+	if err := check(dir, privateDirMode); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := check(file, privateFileMode); err != nil {
+			return nil, err
+		}
+	}
+	return issues, nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Proxy HTTPリクエストに使うプロキシのURL
+// 未設定の場合はnilを返し、環境変数（HTTP_PROXY/HTTPS_PROXY/NO_PROXY）に従う
+func (g GraphConfig) Proxy() (*url.URL, error) {
+	if g.ProxyURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(g.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("プロキシURLが不正です: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("プロキシURLのスキームはhttp、https、socks5のいずれかにしてください: %s", g.ProxyURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("プロキシURLにホストがありません: %s", g.ProxyURL)
+	}
+	return u, nil
+}
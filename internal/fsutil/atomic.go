@@ -0,0 +1,38 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// rename 一時ファイルを元のファイルに置き換える（テストでは書き込みの中断を再現するために差し替える）
+var rename = os.Rename
+
+// WriteFileAtomic 同じディレクトリの一時ファイルに書き込んでからリネームする
+// 書き込み中のクラッシュやディスクの空き不足でも、元のファイルは壊れず、途中までの内容のファイルも残らない
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return rename(tmpPath, path)
+}
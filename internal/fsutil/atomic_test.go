@@ -0,0 +1,63 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	errInterrupted := errors.New("interrupted")
+	tests := []struct {
+		name      string
+		original  string
+		data      string
+		interrupt bool
+		want      string
+	}{
+		{name: "新規作成", data: `{"a":1}`, want: `{"a":1}`},
+		{name: "上書き", original: `{"a":1}`, data: `{"a":2}`, want: `{"a":2}`},
+		{name: "置き換え前に中断", original: `{"a":1}`, data: `{"a":2`, interrupt: true, want: `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.json")
+			if tt.original != "" {
+				if err := os.WriteFile(path, []byte(tt.original), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.interrupt {
+				// 一時ファイルへの書き込みの後、リネームの前にプロセスが終了した場合を再現する
+				rename = func(oldpath, newpath string) error { return errInterrupted }
+				t.Cleanup(func() { rename = os.Rename })
+			}
+
+			err := WriteFileAtomic(path, []byte(tt.data), 0600)
+			if tt.interrupt != errors.Is(err, errInterrupted) {
+				t.Fatalf("WriteFileAtomic() error = %v, interrupt %v", err, tt.interrupt)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("file = %q, want %q", got, tt.want)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0600 {
+				t.Errorf("file mode = %v, want 0600", perm)
+			}
+			// 中断した場合も一時ファイルを残さない
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("directory has %d entries, want 1", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+package fsutil
+
+import "errors"
+
+// ErrLocked 別のプロセスがファイルをロックしている（TryLockのみ）
+var ErrLocked = errors.New("ファイルは別のプロセスがロックしています")
@@ -0,0 +1,20 @@
+//go:build !unix && !windows
+
+package fsutil
+
+import "os"
+
+// Lock ファイルロックに対応していない環境では何もしない（プロセス内の排他のみ）
+func Lock(f *os.File, exclusive bool) error {
+	return nil
+}
+
+// TryLock ファイルロックに対応していない環境では何もしない
+func TryLock(f *os.File) error {
+	return nil
+}
+
+// Unlock ファイルロックに対応していない環境では何もしない
+func Unlock(f *os.File) error {
+	return nil
+}
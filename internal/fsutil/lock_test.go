@@ -0,0 +1,51 @@
+//go:build unix || windows
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	open := func() *os.File {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	holder, other := open(), open()
+
+	tests := []struct {
+		name      string
+		exclusive bool
+	}{
+		{name: "排他ロック", exclusive: true},
+		{name: "共有ロック"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Lock(holder, tt.exclusive); err != nil {
+				t.Fatal(err)
+			}
+			// ロックが解除されるのを待たずに戻る
+			if err := TryLock(other); !errors.Is(err, ErrLocked) {
+				t.Fatalf("TryLock() while locked error = %v, want ErrLocked", err)
+			}
+			if err := Unlock(holder); err != nil {
+				t.Fatal(err)
+			}
+			if err := TryLock(other); err != nil {
+				t.Fatalf("TryLock() after unlock error = %v", err)
+			}
+			if err := Unlock(other); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
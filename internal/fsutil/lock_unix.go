@@ -0,0 +1,32 @@
+//go:build unix
+
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Lock ファイルにアドバイザリロックをかける（exclusiveがfalseの場合は共有ロック）
+func Lock(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(f.Fd()), how)
+}
+
+// TryLock 待たずに排他ロックをかける（別のプロセスがロックしている場合は ErrLocked を返す）
+func TryLock(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
+
+// Unlock ファイルのロックを解除
+func Unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
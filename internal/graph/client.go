@@ -3,25 +3,51 @@ package graph
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/canaria-computer/m3bridge/internal/auth"
 	"github.com/charmbracelet/log"
 	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	"github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/microsoftgraph/msgraph-sdk-go/users"
 )
 
 // Client Microsoft Graph APIクライアント
 type Client struct {
-	graphClient *msgraphsdk.GraphServiceClient
-	logger      *log.Logger
+	adapter      *msgraphsdk.GraphRequestAdapter
+	graphClient  *msgraphsdk.GraphServiceClient
+	authProvider *auth.BearerTokenAuthenticationProvider
+	mailbox      string // 空の場合はサインインユーザー（/me）
+	logger       *log.Logger
+
+	// saveToSentItems 送信したメールを送信済みアイテムに保存するか（メッセージごとに上書き可能）
+	saveToSentItems bool
+	// archiveBcc 全てのメッセージのBCCに追加するアドレス（空の場合は追加しない）
+	archiveBcc string
+	// maxSendAttempts 送信の最大試行回数
+	maxSendAttempts int
+	// httpClient Graphへのリクエストに使うHTTPクライアント（WithAccessTokenで作成したクライアントと共有）
+	httpClient *http.Client
+	// uploadClient アップロードセッションへのチャンク送信に使うHTTPクライアント
+	uploadClient *http.Client
+	// refresh 401を受けた場合のトークンの再取得（ForMailboxで作成したクライアントと共有）
+	refresh *tokenRefresh
 }
 
 // NewClient 新しいGraphクライアントを作成
 func NewClient(accessToken string, logger *log.Logger) (*Client, error) {
+	return NewClientWithHTTPConfig(accessToken, HTTPConfig{}, logger)
+}
+
+// NewClientWithHTTPConfig HTTPクライアントの設定を指定してGraphクライアントを作成
+// アダプターとHTTP接続はForMailboxで作成したクライアントを含めて共有される
+func NewClientWithHTTPConfig(accessToken string, httpConfig HTTPConfig, logger *log.Logger) (*Client, error) {
 	authProvider := auth.NewBearerTokenAuthenticationProvider(accessToken, logger)
+	transport := newTransport(httpConfig)
+	httpClient := newGraphHTTPClient(transport)
 
-	adapter, err := msgraphsdk.NewGraphRequestAdapter(authProvider)
+	adapter, err := msgraphsdk.NewGraphRequestAdapterWithParseNodeFactoryAndSerializationWriterFactoryAndHttpClient(
+		authProvider, nil, nil, httpClient)
 	if err != nil {
 		return nil, fmt.Errorf("アダプター作成失敗: %w", err)
 	}
@@ -29,145 +55,162 @@ func NewClient(accessToken string, logger *log.Logger) (*Client, error) {
 	graphClient := msgraphsdk.NewGraphServiceClient(adapter)
 
 	return &Client{
-		graphClient: graphClient,
-		logger:      logger,
+		adapter:         adapter,
+		graphClient:     graphClient,
+		authProvider:    authProvider,
+		logger:          logger,
+		saveToSentItems: true,
+		maxSendAttempts: defaultSendAttempts,
+		httpClient:      httpClient,
+		uploadClient:    &http.Client{Transport: transport},
+		refresh:         &tokenRefresh{},
 	}, nil
 }
 
+// SetSaveToSentItems 送信したメールを送信済みアイテムに保存するかを設定
+func (c *Client) SetSaveToSentItems(save bool) {
+	c.saveToSentItems = save
+}
+
+// SetArchiveBcc 記録用に全てのメッセージのBCCに追加するアドレスを設定（空の場合は追加しない）
+func (c *Client) SetArchiveBcc(address string) {
+	c.archiveBcc = address
+}
+
+// SetEndpoint Microsoft Graphのエンドポイントを設定（各国のクラウド向け）
+// 例: https://graph.microsoft.us
+func (c *Client) SetEndpoint(endpoint string) {
+	baseURL := strings.TrimRight(endpoint, "/") + "/v1.0"
+	c.adapter.SetBaseUrl(baseURL)
+	c.logger.Debug("Graphのエンドポイントを設定しました", "base_url", baseURL)
+}
+
+// SetAccessToken 送信に使用するアクセストークンを差し替える
+func (c *Client) SetAccessToken(accessToken string) {
+	c.authProvider.SetAccessToken(accessToken)
+	c.logger.Debug("アクセストークンを更新しました")
+}
+
+// ForMailbox 指定したメールボックスから送信するクライアントを返す
+func (c *Client) ForMailbox(mailbox string) *Client {
+	clone := *c
+	clone.mailbox = mailbox
+	return &clone
+}
+
+// WithAccessToken 別のアクセストークンで送信するクライアントを返す
+// SMTPクライアントが提示したユーザーのトークンで送信する場合に使い、送信元はそのユーザー（/me）になる。
+// HTTP接続とエンドポイントは共有し、トークンの再取得は行わない
+func (c *Client) WithAccessToken(accessToken string) (*Client, error) {
+	authProvider := auth.NewBearerTokenAuthenticationProvider(accessToken, c.logger)
+	adapter, err := msgraphsdk.NewGraphRequestAdapterWithParseNodeFactoryAndSerializationWriterFactoryAndHttpClient(
+		authProvider, nil, nil, c.httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("アダプター作成失敗: %w", err)
+	}
+	adapter.SetBaseUrl(c.adapter.GetBaseUrl())
+
+	clone := *c
+	clone.adapter = adapter
+	clone.graphClient = msgraphsdk.NewGraphServiceClient(adapter)
+	clone.authProvider = authProvider
+	clone.mailbox = ""
+	clone.refresh = &tokenRefresh{}
+	return &clone, nil
+}
+
+// user 送信に使用するユーザーのリクエストビルダーを返す
+func (c *Client) user() *users.UserItemRequestBuilder {
+	if c.mailbox == "" {
+		return c.graphClient.Me()
+	}
+	return c.graphClient.Users().ByUserId(c.mailbox)
+}
+
+// UserInfo ユーザー情報
+type UserInfo struct {
+	ID                string
+	DisplayName       string
+	UserPrincipalName string
+	Mail              string
+}
+
+// Address 送信に使われるアドレス（メールアドレスがない場合はUPN）
+func (u *UserInfo) Address() string {
+	if u.Mail != "" {
+		return u.Mail
+	}
+	return u.UserPrincipalName
+}
+
 // GetUserInfo ユーザー情報を取得
-func (c *Client) GetUserInfo(ctx context.Context) error {
+func (c *Client) GetUserInfo(ctx context.Context) (*UserInfo, error) {
 	c.logger.Debug("ユーザー情報取得開始")
 
-	user, err := c.graphClient.Me().Get(ctx, nil)
+	user, err := c.user().Get(ctx, nil)
 	if err != nil {
 		c.logger.Error("ユーザー情報取得失敗", "error", err)
-		return err
+		return nil, err
 	}
 
-	displayName := ""
+	info := &UserInfo{}
+	if user.GetId() != nil {
+		info.ID = *user.GetId()
+	}
 	if user.GetDisplayName() != nil {
-		displayName = *user.GetDisplayName()
+		info.DisplayName = *user.GetDisplayName()
 	}
-	upn := ""
 	if user.GetUserPrincipalName() != nil {
-		upn = *user.GetUserPrincipalName()
+		info.UserPrincipalName = *user.GetUserPrincipalName()
 	}
-	mail := ""
 	if user.GetMail() != nil {
-		mail = *user.GetMail()
+		info.Mail = *user.GetMail()
 	}
 
-	c.logger.Info("ユーザー情報取得成功",
-		"displayName", displayName,
-		"userPrincipalName", upn,
-		"mail", mail)
+	c.logger.Debug("ユーザー情報取得成功",
+		"id", info.ID,
+		"displayName", info.DisplayName,
+		"userPrincipalName", info.UserPrincipalName,
+		"mail", info.Mail)
 
-	return nil
+	return info, nil
 }
 
 // SendMail メールを送信
 func (c *Client) SendMail(ctx context.Context, to, subject, body string, isHTML bool) error {
-	c.logger.Debug("メール送信開始", "to", to, "subject", subject)
-
-	// メッセージの作成
-	message := models.NewMessage()
-	message.SetSubject(&subject)
-
-	// ボディの設定
-	messageBody := models.NewItemBody()
-	var contentType models.BodyType
-	if isHTML {
-		contentType = models.HTML_BODYTYPE
-	} else {
-		contentType = models.TEXT_BODYTYPE
-	}
-	messageBody.SetContentType(&contentType)
-	messageBody.SetContent(&body)
-	message.SetBody(messageBody)
-
-	// 受信者の設定
-	recipient := models.NewRecipient()
-	emailAddress := models.NewEmailAddress()
-	emailAddress.SetAddress(&to)
-	recipient.SetEmailAddress(emailAddress)
-	message.SetToRecipients([]models.Recipientable{recipient})
-
-	// メール送信リクエストボディの作成
-	sendMailBody := users.NewItemSendMailPostRequestBody()
-	sendMailBody.SetMessage(message)
-	saveToSentItems := true
-	sendMailBody.SetSaveToSentItems(&saveToSentItems)
-
-	c.logger.Debug("メール送信リクエスト送信中")
-	err := c.graphClient.Me().SendMail().Post(ctx, sendMailBody, nil)
-	if err != nil {
-		c.logger.Error("メール送信失敗", "error", err)
-		return err
-	}
+	return c.SendMessage(ctx, &Message{
+		To:      []string{to},
+		Subject: subject,
+		Body:    body,
+		IsHTML:  isHTML,
+	})
+}
 
-	c.logger.Info("メール送信成功", "to", to)
-	return nil
+// SendMailAsUser 指定したユーザーとしてメールを送信（/users/{id}/sendMail）
+// アプリのみのトークンでは/meが使えないため、こちらを使用する
+func (c *Client) SendMailAsUser(ctx context.Context, userID, to, subject, body string, isHTML bool) error {
+	return c.ForMailbox(userID).SendMail(ctx, to, subject, body, isHTML)
 }
 
 // SendMailWithMultipleRecipients 複数の受信者にメールを送信
 func (c *Client) SendMailWithMultipleRecipients(ctx context.Context, to []string, cc []string, subject, body string, isHTML bool) error {
-	c.logger.Debug("メール送信開始", "to_count", len(to), "cc_count", len(cc), "subject", subject)
-
-	// メッセージの作成
-	message := models.NewMessage()
-	message.SetSubject(&subject)
-
-	// ボディの設定
-	messageBody := models.NewItemBody()
-	var contentType models.BodyType
-	if isHTML {
-		contentType = models.HTML_BODYTYPE
-	} else {
-		contentType = models.TEXT_BODYTYPE
-	}
-	messageBody.SetContentType(&contentType)
-	messageBody.SetContent(&body)
-	message.SetBody(messageBody)
-
-	// To受信者の設定
-	if len(to) > 0 {
-		toRecipients := make([]models.Recipientable, 0, len(to))
-		for _, addr := range to {
-			recipient := models.NewRecipient()
-			emailAddress := models.NewEmailAddress()
-			emailAddress.SetAddress(&addr)
-			recipient.SetEmailAddress(emailAddress)
-			toRecipients = append(toRecipients, recipient)
-		}
-		message.SetToRecipients(toRecipients)
-	}
-
-	// CC受信者の設定
-	if len(cc) > 0 {
-		ccRecipients := make([]models.Recipientable, 0, len(cc))
-		for _, addr := range cc {
-			recipient := models.NewRecipient()
-			emailAddress := models.NewEmailAddress()
-			emailAddress.SetAddress(&addr)
-			recipient.SetEmailAddress(emailAddress)
-			ccRecipients = append(ccRecipients, recipient)
-		}
-		message.SetCcRecipients(ccRecipients)
-	}
-
-	// メール送信リクエストボディの作成
-	sendMailBody := users.NewItemSendMailPostRequestBody()
-	sendMailBody.SetMessage(message)
-	saveToSentItems := true
-	sendMailBody.SetSaveToSentItems(&saveToSentItems)
-
-	c.logger.Debug("メール送信リクエスト送信中")
-	err := c.graphClient.Me().SendMail().Post(ctx, sendMailBody, nil)
-	if err != nil {
-		c.logger.Error("メール送信失敗", "error", err)
-		return err
-	}
+	return c.SendMessage(ctx, &Message{
+		To:      to,
+		Cc:      cc,
+		Subject: subject,
+		Body:    body,
+		IsHTML:  isHTML,
+	})
+}
 
-	c.logger.Info("メール送信成功", "to_count", len(to), "cc_count", len(cc))
-	return nil
+// SendMailWithAttachments 添付ファイル付きのメールを送信
+func (c *Client) SendMailWithAttachments(ctx context.Context, to []string, cc []string, subject, body string, isHTML bool, attachments []Attachment) error {
+	return c.SendMessage(ctx, &Message{
+		To:          to,
+		Cc:          cc,
+		Subject:     subject,
+		Body:        body,
+		IsHTML:      isHTML,
+		Attachments: attachments,
+	})
 }
@@ -0,0 +1,52 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+// ErrorCode Graphのエラーレスポンスからエラーコードを取得
+// Graphのエラーでない場合は空を返す
+func ErrorCode(err error) string {
+	var odataErr *odataerrors.ODataError
+	if !errors.As(err, &odataErr) {
+		return ""
+	}
+	mainErr := odataErr.GetErrorEscaped()
+	if mainErr == nil || mainErr.GetCode() == nil {
+		return ""
+	}
+	return *mainErr.GetCode()
+}
+
+// StatusCode Graphのエラーレスポンスからステータスコードを取得
+// Graphのエラーでない場合（接続エラーなど）は0を返す
+func StatusCode(err error) int {
+	var apiErr abstractions.ApiErrorable
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+	return apiErr.GetStatusCode()
+}
+
+// IsTimeout タイムアウトによるエラーか判定
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsSendAsDenied 送信元に指定したメールボックスとして送信する権限がないエラーか判定
+func IsSendAsDenied(err error) bool {
+	switch ErrorCode(err) {
+	case "ErrorSendAsDenied", "ErrorSendOnBehalfOfDenied":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,331 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/metrics"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+// Message Graphで送信するメール
+type Message struct {
+	// From 送信元のアドレス（空の場合はサインインユーザー）
+	// 共有メールボックスなど、SendAs/SendOnBehalf権限のあるアドレスを指定する
+	From string
+
+	To      []string
+	Cc      []string
+	Bcc     []string
+	Subject string
+	Body    string
+	IsHTML  bool
+
+	// ReplyTo 返信先（空の場合は送信者）
+	ReplyTo []string
+
+	// DisplayNames 送信元・受信者の表示名（キーは小文字のアドレス）
+	DisplayNames map[string]string
+
+	// Importance 重要度（空の場合は標準）
+	Importance Importance
+
+	// ReadReceiptRequested 開封確認を要求するか
+	ReadReceiptRequested bool
+	// DeliveryReceiptRequested 配信確認を要求するか
+	DeliveryReceiptRequested bool
+
+	// SentAt 作成日時（Dateヘッダー、ゼロ値の場合はGraphが送信時刻を設定する）
+	SentAt time.Time
+
+	// InternetMessageID Message-ID（例: <id@example.com>、空の場合はGraphが割り当てる）
+	InternetMessageID string
+	// Headers メールに追加するインターネットメッセージヘッダー（GraphはX-で始まるヘッダーのみ受け付ける）
+	Headers []Header
+
+	// SaveToSentItems 送信済みアイテムに保存するか（nilの場合はクライアントの設定）
+	SaveToSentItems *bool
+
+	// Attachments 添付ファイル
+	Attachments []Attachment
+}
+
+// Importance メールの重要度
+type Importance string
+
+const (
+	ImportanceLow    Importance = "low"
+	ImportanceNormal Importance = "normal"
+	ImportanceHigh   Importance = "high"
+)
+
+// Header インターネットメッセージヘッダー
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Attachment 添付ファイル
+type Attachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+
+	// IsInline HTML本文から cid: で参照されるインライン添付か
+	IsInline bool
+	// ContentID インライン添付のContent-ID（山括弧なし）
+	ContentID string
+}
+
+// SendMessage メッセージを送信
+func (c *Client) SendMessage(ctx context.Context, msg *Message) error {
+	msg = c.withRecipients(msg)
+	started := time.Now()
+	err := c.sendMessage(ctx, msg)
+	metrics.GraphSendDuration.Observe(time.Since(started).Seconds())
+	return err
+}
+
+// withRecipients 受信者を正規化し、アーカイブ用のアドレスをBCCに追加したメッセージを返す
+// 呼び出し元のメッセージは変更しないため、再送キューに保存したメッセージを送り直しても重複しない。
+// To、Cc、BCCの順に重複を取り除くため、すでに受信者に含まれるアーカイブ用のアドレスは追加されない
+func (c *Client) withRecipients(msg *Message) *Message {
+	normalized := *msg
+	bcc := msg.Bcc
+	if c.archiveBcc != "" {
+		bcc = append(slices.Clip(bcc), c.archiveBcc)
+	}
+	seen := make(map[string]bool)
+	normalized.To = dedupeAddresses(msg.To, seen)
+	normalized.Cc = dedupeAddresses(msg.Cc, seen)
+	normalized.Bcc = dedupeAddresses(bcc, seen)
+
+	if removed := len(msg.To) + len(msg.Cc) + len(bcc) - len(normalized.To) - len(normalized.Cc) - len(normalized.Bcc); removed > 0 {
+		c.logger.Debug("重複した受信者を取り除きました", "count", removed)
+	}
+	return &normalized
+}
+
+// dedupeAddresses アドレスを正規化し、seenに含まれるものを取り除く（大文字小文字を区別しない）
+func dedupeAddresses(addresses []string, seen map[string]bool) []string {
+	var result []string
+	for _, address := range addresses {
+		address = normalizeAddress(address)
+		key := strings.ToLower(address)
+		if address == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, address)
+	}
+	return result
+}
+
+// normalizeAddress アドレスの前後の空白を取り除き、ドメインを小文字にする
+// ローカル部は大文字小文字を区別するサーバもあるためそのまま残す
+func normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		address = address[:at+1] + strings.ToLower(address[at+1:])
+	}
+	return address
+}
+
+// SendMessageFrom メッセージを指定したメールボックスから送信（空の場合はこのクライアントの送信元）
+func (c *Client) SendMessageFrom(ctx context.Context, mailbox string, msg *Message) error {
+	if mailbox == "" {
+		return c.SendMessage(ctx, msg)
+	}
+	return c.ForMailbox(mailbox).SendMessage(ctx, msg)
+}
+
+// sendMessage メッセージを送信（トークンの再取得を含む）
+func (c *Client) sendMessage(ctx context.Context, msg *Message) error {
+	c.logger.Debug("メール送信開始",
+		"from", msg.From,
+		"to_count", len(msg.To),
+		"cc_count", len(msg.Cc),
+		"bcc_count", len(msg.Bcc),
+		"attachments", len(msg.Attachments),
+		"subject", msg.Subject)
+
+	saveToSentItems := c.saveToSentItems
+	if msg.SaveToSentItems != nil {
+		saveToSentItems = *msg.SaveToSentItems
+	}
+
+	usedToken := c.authProvider.AccessToken()
+	err := c.post(ctx, msg, saveToSentItems)
+	if err != nil && isUnauthorized(err) {
+		// 長時間の稼働中にトークンが期限切れ・失効した場合は、取得し直して一度だけ再試行する
+		refreshed, refreshErr := c.refreshAccessToken(ctx, usedToken)
+		if refreshErr != nil {
+			c.logger.Error("メール送信失敗", "error", err, "refresh_error", refreshErr)
+			return fmt.Errorf("%v: %w", refreshErr, err)
+		}
+		if refreshed {
+			err = c.post(ctx, msg, saveToSentItems)
+		}
+	}
+	if err != nil {
+		c.logger.Error("メール送信失敗", "error", err)
+		return err
+	}
+
+	c.logger.Info("メール送信成功", "to_count", len(msg.To), "cc_count", len(msg.Cc), "bcc_count", len(msg.Bcc), "attachments", len(msg.Attachments))
+	return nil
+}
+
+// post メッセージをGraphに送信する
+func (c *Client) post(ctx context.Context, msg *Message, saveToSentItems bool) error {
+	if _, large := splitAttachments(msg); len(large) > 0 {
+		// sendMailに収まらない添付ファイルは、下書きにアップロードしてから送信する
+		c.logger.Debug("大きな添付ファイルがあるため、下書きを経由して送信します", "mailbox", c.mailbox, "large_attachments", len(large))
+		return c.sendWithUploadSession(ctx, msg, large, saveToSentItems)
+	}
+
+	// メール送信リクエストボディの作成
+	sendMailBody := users.NewItemSendMailPostRequestBody()
+	sendMailBody.SetMessage(buildMessage(msg))
+	sendMailBody.SetSaveToSentItems(&saveToSentItems)
+
+	c.logger.Debug("メール送信リクエスト送信中", "mailbox", c.mailbox, "save_to_sent_items", saveToSentItems)
+	return c.withRetry(ctx, func() error {
+		return c.user().SendMail().Post(ctx, sendMailBody, nil)
+	})
+}
+
+// buildMessage GraphのMessageを組み立てる
+func buildMessage(msg *Message) models.Messageable {
+	message := models.NewMessage()
+	subject := msg.Subject
+	message.SetSubject(&subject)
+
+	// ボディの設定
+	messageBody := models.NewItemBody()
+	var contentType models.BodyType
+	if msg.IsHTML {
+		contentType = models.HTML_BODYTYPE
+	} else {
+		contentType = models.TEXT_BODYTYPE
+	}
+	messageBody.SetContentType(&contentType)
+	body := msg.Body
+	messageBody.SetContent(&body)
+	message.SetBody(messageBody)
+
+	// 送信元の設定
+	if msg.From != "" {
+		message.SetFrom(recipients([]string{msg.From}, msg.DisplayNames)[0])
+	}
+
+	// 重要度の設定
+	importance := models.NORMAL_IMPORTANCE
+	switch msg.Importance {
+	case ImportanceLow:
+		importance = models.LOW_IMPORTANCE
+	case ImportanceHigh:
+		importance = models.HIGH_IMPORTANCE
+	}
+	message.SetImportance(&importance)
+
+	// 開封確認・配信確認の要求（要求された場合のみ設定）
+	if msg.ReadReceiptRequested {
+		requested := true
+		message.SetIsReadReceiptRequested(&requested)
+	}
+	if msg.DeliveryReceiptRequested {
+		requested := true
+		message.SetIsDeliveryReceiptRequested(&requested)
+	}
+
+	// 作成日時の設定
+	if !msg.SentAt.IsZero() {
+		sentAt := msg.SentAt
+		message.SetSentDateTime(&sentAt)
+	}
+
+	// Message-IDとヘッダーの設定
+	if msg.InternetMessageID != "" {
+		messageID := msg.InternetMessageID
+		message.SetInternetMessageId(&messageID)
+	}
+	if len(msg.Headers) > 0 {
+		headers := make([]models.InternetMessageHeaderable, 0, len(msg.Headers))
+		for _, h := range msg.Headers {
+			name, value := h.Name, h.Value
+			header := models.NewInternetMessageHeader()
+			header.SetName(&name)
+			header.SetValue(&value)
+			headers = append(headers, header)
+		}
+		message.SetInternetMessageHeaders(headers)
+	}
+
+	// 受信者の設定
+	if len(msg.To) > 0 {
+		message.SetToRecipients(recipients(msg.To, msg.DisplayNames))
+	}
+	if len(msg.Cc) > 0 {
+		message.SetCcRecipients(recipients(msg.Cc, msg.DisplayNames))
+	}
+	if len(msg.Bcc) > 0 {
+		message.SetBccRecipients(recipients(msg.Bcc, msg.DisplayNames))
+	}
+	if len(msg.ReplyTo) > 0 {
+		message.SetReplyTo(recipients(msg.ReplyTo, msg.DisplayNames))
+	}
+
+	// 添付ファイルの設定
+	if len(msg.Attachments) > 0 {
+		attachments := make([]models.Attachmentable, 0, len(msg.Attachments))
+		for _, a := range msg.Attachments {
+			attachments = append(attachments, fileAttachment(a))
+		}
+		message.SetAttachments(attachments)
+	}
+
+	return message
+}
+
+// recipients アドレスの一覧をGraphの受信者に変換（表示名がある場合は設定する）
+func recipients(addrs []string, names map[string]string) []models.Recipientable {
+	result := make([]models.Recipientable, 0, len(addrs))
+	for _, addr := range addrs {
+		address := addr
+		recipient := models.NewRecipient()
+		emailAddress := models.NewEmailAddress()
+		emailAddress.SetAddress(&address)
+		if name := names[strings.ToLower(addr)]; name != "" {
+			emailAddress.SetName(&name)
+		}
+		recipient.SetEmailAddress(emailAddress)
+		result = append(result, recipient)
+	}
+	return result
+}
+
+// fileAttachment 添付ファイルをGraphのFileAttachmentに変換
+func fileAttachment(a Attachment) *models.FileAttachment {
+	attachment := models.NewFileAttachment()
+	name := a.Name
+	attachment.SetName(&name)
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	attachment.SetContentType(&contentType)
+	attachment.SetContentBytes(a.Content)
+	// 通常の添付ファイルもisInlineをfalseとし、Graphがインラインと判定しないようにする
+	isInline := a.IsInline
+	attachment.SetIsInline(&isInline)
+	if a.IsInline {
+		contentID := a.ContentID
+		attachment.SetContentId(&contentID)
+	}
+	return attachment
+}
@@ -0,0 +1,141 @@
+package graph
+
+import (
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: "alice@example.com", want: "alice@example.com"},
+		{address: "  Alice@Example.COM ", want: "Alice@example.com"},
+		{address: "\"a@b\"@Example.com", want: "\"a@b\"@example.com"},
+		{address: "undisclosed", want: "undisclosed"},
+		{address: "  ", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := normalizeAddress(tt.address); got != tt.want {
+				t.Errorf("normalizeAddress(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRecipients(t *testing.T) {
+	tests := []struct {
+		name       string
+		archiveBcc string
+		msg        Message
+		want       Message
+	}{
+		{
+			name: "重複がない",
+			msg:  Message{To: []string{"a@example.com"}, Cc: []string{"b@example.com"}, Bcc: []string{"c@example.com"}},
+			want: Message{To: []string{"a@example.com"}, Cc: []string{"b@example.com"}, Bcc: []string{"c@example.com"}},
+		},
+		{
+			name: "ToとCcの重複はToに残す",
+			msg:  Message{To: []string{"a@example.com"}, Cc: []string{"A@EXAMPLE.com", "b@example.com"}, Bcc: []string{"b@Example.com"}},
+			want: Message{To: []string{"a@example.com"}, Cc: []string{"b@example.com"}},
+		},
+		{
+			name: "同じ欄の重複と空白",
+			msg:  Message{To: []string{" a@Example.com", "a@example.com ", "", "b@example.com"}},
+			want: Message{To: []string{"a@example.com", "b@example.com"}},
+		},
+		{
+			name:       "アーカイブ用のアドレスを追加",
+			archiveBcc: "archive@example.com",
+			msg:        Message{To: []string{"a@example.com"}},
+			want:       Message{To: []string{"a@example.com"}, Bcc: []string{"archive@example.com"}},
+		},
+		{
+			name:       "受信者に含まれるアーカイブ用のアドレス",
+			archiveBcc: "archive@example.com",
+			msg:        Message{To: []string{"Archive@example.com"}, Bcc: []string{"c@example.com"}},
+			want:       Message{To: []string{"Archive@example.com"}, Bcc: []string{"c@example.com"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{archiveBcc: tt.archiveBcc, logger: log.New(io.Discard)}
+			original := slices.Clone(tt.msg.Bcc)
+			got := c.withRecipients(&tt.msg)
+			if !slices.Equal(got.To, tt.want.To) || !slices.Equal(got.Cc, tt.want.Cc) || !slices.Equal(got.Bcc, tt.want.Bcc) {
+				t.Errorf("withRecipients() = To %v Cc %v Bcc %v, want To %v Cc %v Bcc %v",
+					got.To, got.Cc, got.Bcc, tt.want.To, tt.want.Cc, tt.want.Bcc)
+			}
+			// 再送キューのメッセージを送り直してもアーカイブ用のアドレスが重複しないよう、元のメッセージは変更しない
+			if !slices.Equal(tt.msg.Bcc, original) {
+				t.Errorf("withRecipients() modified Bcc = %v, want %v", tt.msg.Bcc, original)
+			}
+		})
+	}
+}
+
+func TestFileAttachment(t *testing.T) {
+	tests := []struct {
+		name          string
+		attachment    Attachment
+		wantInline    bool
+		wantContentID string
+	}{
+		{
+			name:          "インライン画像",
+			attachment:    Attachment{Name: "chart.png", ContentType: "image/png", Content: []byte("png"), IsInline: true, ContentID: "chart@example.com"},
+			wantInline:    true,
+			wantContentID: "chart@example.com",
+		},
+		{
+			name:       "PDFの添付ファイル",
+			attachment: Attachment{Name: "report.pdf", ContentType: "application/pdf", Content: []byte("pdf")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fileAttachment(tt.attachment)
+			if got.GetIsInline() == nil || *got.GetIsInline() != tt.wantInline {
+				t.Errorf("isInline = %v, want %v", got.GetIsInline(), tt.wantInline)
+			}
+			var contentID string
+			if got.GetContentId() != nil {
+				contentID = *got.GetContentId()
+			}
+			if contentID != tt.wantContentID {
+				t.Errorf("contentId = %q, want %q", contentID, tt.wantContentID)
+			}
+			if *got.GetName() != tt.attachment.Name || *got.GetContentType() != tt.attachment.ContentType {
+				t.Errorf("attachment = %s (%s), want %s (%s)", *got.GetName(), *got.GetContentType(), tt.attachment.Name, tt.attachment.ContentType)
+			}
+		})
+	}
+}
+
+func TestBuildMessageSentAt(t *testing.T) {
+	sentAt := time.Date(2026, 1, 2, 6, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		sentAt time.Time
+		want   *time.Time
+	}{
+		{name: "Dateヘッダーの日時", sentAt: sentAt, want: &sentAt},
+		// ゼロ値の場合は設定せず、Graphに送信時刻を決めさせる
+		{name: "ゼロ値"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildMessage(&Message{To: []string{"a@example.com"}, SentAt: tt.sentAt}).GetSentDateTime()
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("sentDateTime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+)
+
+const (
+	// defaultSendAttempts 送信の最大試行回数のデフォルト値
+	defaultSendAttempts = 3
+	// sendInitialBackoff 最初の再試行までの待機時間
+	sendInitialBackoff = time.Second
+	// sendMaxBackoff 再試行までの待機時間の上限
+	sendMaxBackoff = 30 * time.Second
+)
+
+// SetMaxSendAttempts 送信の最大試行回数を設定（1以下の場合は再試行しない）
+func (c *Client) SetMaxSendAttempts(attempts int) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	c.maxSendAttempts = attempts
+}
+
+// withRetry スロットリングや一時的なエラーの場合に指数バックオフで再試行する
+// 429/503/504と接続のリセットのみ再試行し、最後のエラーを返す
+func (c *Client) withRetry(ctx context.Context, fn func() error) error {
+	attempts := c.maxSendAttempts
+	if attempts < 1 {
+		attempts = defaultSendAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+
+		// Retry-Afterが指定されている場合は、少なくともその時間待機する
+		wait := backoffDelay(attempt)
+		if retryAfter, ok := retryAfterDelay(err); ok && retryAfter > wait {
+			wait = retryAfter
+		}
+		c.logger.Debug("Graphリクエストを再試行します", "attempt", attempt+1, "wait", wait, "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable 再試行で解決する可能性のあるエラーか判定
+func retryable(err error) bool {
+	var apiErr abstractions.ApiErrorable
+	if errors.As(err, &apiErr) {
+		switch apiErr.GetStatusCode() {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfterDelay エラーレスポンスのRetry-Afterヘッダーから待機時間を取得
+// 秒数とHTTP日付の両方の形式に対応する
+func retryAfterDelay(err error) (time.Duration, bool) {
+	var apiErr abstractions.ApiErrorable
+	if !errors.As(err, &apiErr) || apiErr.GetResponseHeaders() == nil {
+		return 0, false
+	}
+
+	values := apiErr.GetResponseHeaders().Get("Retry-After")
+	if len(values) == 0 {
+		return 0, false
+	}
+	return parseRetryAfter(values[0], time.Now())
+}
+
+// parseRetryAfter Retry-Afterの値を待機時間に変換
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoffDelay 試行回数に応じた待機時間（ジッター付き）
+func backoffDelay(attempt int) time.Duration {
+	wait := sendInitialBackoff << (attempt - 1)
+	if wait > sendMaxBackoff || wait <= 0 {
+		wait = sendMaxBackoff
+	}
+	// 同時に失敗したリクエストが一斉に再試行しないよう、最大50%のジッターを加える
+	return wait + rand.N(wait/2+1)
+}
@@ -0,0 +1,53 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// TokenRefresher アクセストークンを取得し直す関数
+type TokenRefresher func(ctx context.Context) (string, error)
+
+// tokenRefresh トークンの再取得を直列化する（同時に401を受けたセッションで一度だけ再取得する）
+type tokenRefresh struct {
+	mu        sync.Mutex
+	refresher TokenRefresher
+}
+
+// SetTokenRefresher Graphがトークンを拒否した場合（401）にトークンを取得し直す関数を設定
+// 設定した場合、送信は新しいトークンで一度だけ再試行する
+func (c *Client) SetTokenRefresher(refresher TokenRefresher) {
+	c.refresh.mu.Lock()
+	defer c.refresh.mu.Unlock()
+	c.refresh.refresher = refresher
+}
+
+// isUnauthorized トークンの期限切れや失効によるエラーか判定
+func isUnauthorized(err error) bool {
+	return StatusCode(err) == http.StatusUnauthorized || ErrorCode(err) == "InvalidAuthenticationToken"
+}
+
+// refreshAccessToken 拒否されたトークンを取得し直してクライアントに設定
+// rejected は拒否されたトークンで、他のセッションがすでに更新していれば再取得しない
+func (c *Client) refreshAccessToken(ctx context.Context, rejected string) (bool, error) {
+	c.refresh.mu.Lock()
+	defer c.refresh.mu.Unlock()
+
+	if c.refresh.refresher == nil {
+		return false, nil
+	}
+	if c.authProvider.AccessToken() != rejected {
+		c.logger.Debug("アクセストークンは他のセッションで更新済みです")
+		return true, nil
+	}
+
+	c.logger.Info("Graphがアクセストークンを拒否したため、取得し直します")
+	accessToken, err := c.refresh.refresher(ctx)
+	if err != nil {
+		return false, fmt.Errorf("アクセストークンの再取得に失敗しました: %w", err)
+	}
+	c.SetAccessToken(accessToken)
+	return true, nil
+}
@@ -0,0 +1,87 @@
+package graph
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	khttp "github.com/microsoft/kiota-http-go"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+)
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 16
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	dialTimeout                = 30 * time.Second
+)
+
+// HTTPConfig Graphへの接続に使うHTTPクライアントの設定（0の場合はデフォルト）
+// 接続を使い回してTLSハンドシェイクを省くため、ホストごとのアイドル接続数は標準（2）より多くする
+type HTTPConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	KeepAlive           time.Duration
+
+	// Proxy 使用するプロキシ（nilの場合はHTTP_PROXY/HTTPS_PROXY/NO_PROXYに従う）
+	Proxy *url.URL
+}
+
+// newTransport 接続の再利用を設定したトランスポートを作成
+func newTransport(config HTTPConfig) *http.Transport {
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = defaultMaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost <= 0 {
+		config.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout <= 0 {
+		config.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if config.KeepAlive <= 0 {
+		config.KeepAlive = defaultKeepAlive
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.Proxy = http.ProxyFromEnvironment
+	if config.Proxy != nil {
+		transport.Proxy = http.ProxyURL(config.Proxy)
+	}
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	transport.DialContext = (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: config.KeepAlive,
+	}).DialContext
+	return transport
+}
+
+// newGraphHTTPClient SDKの標準ミドルウェア（リダイレクト、テレメトリなど）を通すHTTPクライアントを作成
+// 再試行は試行回数を設定できる withRetry で行うため、SDKの再試行ミドルウェアは外す
+func newGraphHTTPClient(transport http.RoundTripper) *http.Client {
+	options := msgraphsdk.GetDefaultClientOptions()
+	middlewares := withoutRetryHandler(msgraphcore.GetDefaultMiddlewaresWithOptions(&options))
+
+	client := khttp.GetDefaultClient(middlewares...)
+	client.Transport = khttp.NewCustomTransportWithParentTransport(transport, middlewares...)
+	return client
+}
+
+// withoutRetryHandler ミドルウェアから再試行ミドルウェアを除く
+// 両方で再試行すると、1回の送信で最大 send_max_attempts × SDKの再試行回数のリクエストになる
+func withoutRetryHandler(middlewares []khttp.Middleware) []khttp.Middleware {
+	result := make([]khttp.Middleware, 0, len(middlewares))
+	for _, m := range middlewares {
+		if _, ok := m.(*khttp.RetryHandler); ok {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+package graph
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestGraphHTTPClientDoesNotRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "スロットリング", status: http.StatusTooManyRequests},
+		{name: "一時的なエラー", status: http.StatusServiceUnavailable},
+		{name: "タイムアウト", status: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			client := newGraphHTTPClient(newTransport(HTTPConfig{}))
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			// 再試行は withRetry で行うため、HTTPクライアントは1回だけリクエストする
+			if got := requests.Load(); got != 1 {
+				t.Errorf("requests = %d, want 1", got)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestHTTPConfigProxy(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		tunnels []string
+	)
+	// CONNECTの宛先を記録して拒否するプロキシ
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tunnels = append(tunnels, r.Method+" "+r.Host)
+		mu.Unlock()
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClientWithHTTPConfig("token", HTTPConfig{Proxy: proxyURL}, log.New(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.maxSendAttempts = 1
+	client.SetEndpoint("https://graph.example.test")
+
+	err = client.SendMessage(context.Background(), &Message{To: []string{"bob@example.com"}, Subject: "proxy"})
+	if err == nil {
+		t.Fatal("SendMessage() error = nil, want the proxy to refuse the tunnel")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tunnels) != 1 || tunnels[0] != "CONNECT graph.example.test:443" {
+		t.Errorf("proxy requests = %q, want [CONNECT graph.example.test:443]", tunnels)
+	}
+}
+
+// BenchmarkSendMail sendMail相当のリクエストをN回送り、接続を使い回した場合とそうでない場合を比べる
+// conns/op は1回の送信あたりの新しい接続（TLSハンドシェイク）の数
+func BenchmarkSendMail(b *testing.B) {
+	tests := []struct {
+		name      string
+		keepAlive bool
+	}{
+		{name: "接続を使い回す", keepAlive: true},
+		{name: "毎回接続する", keepAlive: false},
+	}
+	const body = `{"message":{"subject":"benchmark","body":{"contentType":"text","content":"hello"}},"saveToSentItems":true}`
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			var conns atomic.Int64
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			server.StartTLS()
+			defer server.Close()
+
+			transport := newTransport(HTTPConfig{})
+			transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+			transport.DisableKeepAlives = !tt.keepAlive
+			defer transport.CloseIdleConnections()
+			client := newGraphHTTPClient(transport)
+			url := server.URL + "/v1.0/me/sendMail"
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for b.Loop() {
+				resp, err := client.Post(url, "application/json", strings.NewReader(body))
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusAccepted {
+					b.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+				}
+			}
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package graph
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+)
+
+const (
+	// inlineAttachmentLimit sendMailに直接含められる添付ファイルの最大サイズ
+	// これを超える添付ファイルはアップロードセッションでアップロードする
+	inlineAttachmentLimit = 3 * 1024 * 1024
+	// sendMailRequestLimit sendMailのリクエストの上限（添付ファイルはBase64で約33%大きくなる）
+	sendMailRequestLimit = 4 * 1024 * 1024
+	// messageOverhead 本文と件名以外（宛先、ヘッダー、JSONの構造など）に見込むリクエストのサイズ
+	messageOverhead = 64 * 1024
+	// uploadChunkSize アップロードセッションで1回に送るサイズ
+	// Graphの要件により320KiBの倍数にする必要がある（1回の上限は60MiB）
+	uploadChunkSize = 10 * 320 * 1024
+	// uploadTimeout チャンク1つのアップロードのタイムアウト
+	uploadTimeout = 2 * time.Minute
+)
+
+// splitAttachments 添付ファイルをsendMailに含めるものとアップロードセッションを使うものに分ける
+// 1つずつは上限以下でも、Base64でエンコードした合計がリクエストの上限を超える場合は、
+// 収まるまで大きいものから順にアップロードセッションに回す。それぞれ元の順序を保つ
+func splitAttachments(msg *Message) (inline, large []Attachment) {
+	requestSize := len(msg.Subject) + len(msg.Body) + messageOverhead
+	moved := make([]bool, len(msg.Attachments))
+	var candidates []int
+	for i, a := range msg.Attachments {
+		if len(a.Content) > inlineAttachmentLimit {
+			moved[i] = true
+			continue
+		}
+		requestSize += base64.StdEncoding.EncodedLen(len(a.Content))
+		candidates = append(candidates, i)
+	}
+
+	slices.SortStableFunc(candidates, func(i, j int) int {
+		return cmp.Compare(len(msg.Attachments[j].Content), len(msg.Attachments[i].Content))
+	})
+	for _, i := range candidates {
+		if requestSize <= sendMailRequestLimit {
+			break
+		}
+		moved[i] = true
+		requestSize -= base64.StdEncoding.EncodedLen(len(msg.Attachments[i].Content))
+	}
+
+	for i, a := range msg.Attachments {
+		if moved[i] {
+			large = append(large, a)
+		} else {
+			inline = append(inline, a)
+		}
+	}
+	return inline, large
+}
+
+// sendWithUploadSession 下書きを作成して大きな添付ファイルをアップロードしてから送信する
+func (c *Client) sendWithUploadSession(ctx context.Context, msg *Message, large []Attachment, saveToSentItems bool) error {
+	if !saveToSentItems {
+		// 下書きの送信（/send）では保存先を指定できない
+		c.logger.Warn("大きな添付ファイルを含むメールは送信済みアイテムに保存されます", "attachments", len(large))
+	}
+
+	// 小さな添付ファイルは下書きの作成時に含める
+	draftMsg := *msg
+	draftMsg.Attachments, _ = splitAttachments(msg)
+
+	var draft models.Messageable
+	err := c.withRetry(ctx, func() error {
+		var err error
+		draft, err = c.user().Messages().Post(ctx, buildMessage(&draftMsg), nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("下書き作成失敗: %w", err)
+	}
+	if draft.GetId() == nil {
+		return fmt.Errorf("下書き作成失敗: メッセージIDがありません")
+	}
+	messageID := *draft.GetId()
+	c.logger.Debug("下書きを作成しました", "message_id", messageID)
+
+	sent := false
+	defer func() {
+		if sent {
+			return
+		}
+		// 送信できなかった下書きは削除する（失敗しても送信エラーを優先する）
+		if err := c.user().Messages().ByMessageId(messageID).Delete(context.WithoutCancel(ctx), nil); err != nil {
+			c.logger.Warn("下書きの削除に失敗しました", "message_id", messageID, "error", err)
+		}
+	}()
+
+	for _, a := range large {
+		if err := c.uploadAttachment(ctx, messageID, a); err != nil {
+			return fmt.Errorf("添付ファイルのアップロード失敗 (%s): %w", a.Name, err)
+		}
+	}
+
+	err = c.withRetry(ctx, func() error {
+		return c.user().Messages().ByMessageId(messageID).Send().Post(ctx, nil)
+	})
+	if err != nil {
+		return err
+	}
+	sent = true
+	return nil
+}
+
+// uploadAttachment アップロードセッションを作成し、添付ファイルをチャンクに分けてアップロードする
+func (c *Client) uploadAttachment(ctx context.Context, messageID string, a Attachment) error {
+	size := int64(len(a.Content))
+	item := models.NewAttachmentItem()
+	attachmentType := models.FILE_ATTACHMENTTYPE
+	item.SetAttachmentType(&attachmentType)
+	name := a.Name
+	item.SetName(&name)
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	item.SetContentType(&contentType)
+	item.SetSize(&size)
+	isInline := a.IsInline
+	item.SetIsInline(&isInline)
+	if a.IsInline {
+		contentID := a.ContentID
+		item.SetContentId(&contentID)
+	}
+
+	body := users.NewItemMessagesItemAttachmentsCreateUploadSessionPostRequestBody()
+	body.SetAttachmentItem(item)
+
+	var session models.UploadSessionable
+	err := c.withRetry(ctx, func() error {
+		var err error
+		session, err = c.user().Messages().ByMessageId(messageID).Attachments().CreateUploadSession().Post(ctx, body, nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("アップロードセッション作成失敗: %w", err)
+	}
+	if session.GetUploadUrl() == nil {
+		return fmt.Errorf("アップロードセッション作成失敗: アップロードURLがありません")
+	}
+	uploadURL := *session.GetUploadUrl()
+
+	c.logger.Debug("添付ファイルのアップロード開始", "name", a.Name, "size", size, "chunk_size", uploadChunkSize)
+	for start := int64(0); start < size; start += uploadChunkSize {
+		end := min(start+uploadChunkSize, size)
+		err := c.withRetry(ctx, func() error {
+			return c.uploadChunk(ctx, uploadURL, a.Content[start:end], start, size)
+		})
+		if err != nil {
+			return err
+		}
+		c.logger.Debug("添付ファイルのアップロード中",
+			"name", a.Name,
+			"uploaded", end,
+			"size", size,
+			"progress", fmt.Sprintf("%d%%", end*100/size))
+	}
+
+	c.logger.Debug("添付ファイルのアップロード完了", "name", a.Name, "size", size)
+	return nil
+}
+
+// uploadChunk アップロードURLにチャンクをPUTする
+// アップロードURLは認証済みのため、Authorizationヘッダーは付けない
+func (c *Client) uploadChunk(ctx context.Context, uploadURL string, chunk []byte, start, total int64) error {
+	reqCtx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPut, uploadURL, bytes.NewReader(chunk))
+	if err != nil {
+		return fmt.Errorf("リクエスト作成失敗: %w", err)
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+int64(len(chunk))-1, total))
+
+	resp, err := c.uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	// 再試行の判定とRetry-Afterの取得ができるよう、ApiErrorとして返す
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	apiErr := abstractions.NewApiError()
+	apiErr.Message = fmt.Sprintf("チャンクのアップロード失敗 (status: %d): %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	apiErr.SetStatusCode(resp.StatusCode)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			apiErr.GetResponseHeaders().Add(key, value)
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitAttachments(t *testing.T) {
+	const mib = 1024 * 1024
+	attachment := func(name string, size int) Attachment {
+		return Attachment{Name: name, Content: make([]byte, size)}
+	}
+	tests := []struct {
+		name        string
+		body        string
+		attachments []Attachment
+		wantInline  []string
+		wantLarge   []string
+	}{
+		{name: "添付ファイルなし"},
+		{
+			name:        "小さな添付ファイル",
+			attachments: []Attachment{attachment("a.txt", 1024), attachment("b.txt", 2048)},
+			wantInline:  []string{"a.txt", "b.txt"},
+		},
+		{
+			name:        "上限を超える添付ファイル",
+			attachments: []Attachment{attachment("small.txt", 1024), attachment("big.zip", 3*mib+1)},
+			wantInline:  []string{"small.txt"},
+			wantLarge:   []string{"big.zip"},
+		},
+		{
+			// Base64で約4MBになるため、1つでもリクエストに収まらない
+			name:        "Base64で上限を超える",
+			attachments: []Attachment{attachment("a.pdf", 3*mib)},
+			wantLarge:   []string{"a.pdf"},
+		},
+		{
+			// 合計はBase64で約5.3MBになるため、大きいものから順にアップロードする
+			name:        "合計が上限を超える",
+			attachments: []Attachment{attachment("a.png", mib), attachment("b.pdf", 2*mib), attachment("c.txt", 1024), attachment("d.png", mib)},
+			wantInline:  []string{"a.png", "c.txt", "d.png"},
+			wantLarge:   []string{"b.pdf"},
+		},
+		{
+			name:        "合計が上限を大きく超える",
+			attachments: []Attachment{attachment("a.png", 2*mib), attachment("b.png", 2*mib), attachment("c.png", 2*mib), attachment("d.txt", 1024)},
+			wantInline:  []string{"c.png", "d.txt"},
+			wantLarge:   []string{"a.png", "b.png"},
+		},
+		{
+			name:        "本文を含めると上限を超える",
+			body:        strings.Repeat("x", 2*mib),
+			attachments: []Attachment{attachment("a.png", 2*mib), attachment("b.txt", 1024)},
+			wantInline:  []string{"b.txt"},
+			wantLarge:   []string{"a.png"},
+		},
+	}
+	names := func(attachments []Attachment) []string {
+		var result []string
+		for _, a := range attachments {
+			result = append(result, a.Name)
+		}
+		return result
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inline, large := splitAttachments(&Message{Body: tt.body, Attachments: tt.attachments})
+			if got := names(inline); !slices.Equal(got, tt.wantInline) {
+				t.Errorf("splitAttachments() inline = %q, want %q", got, tt.wantInline)
+			}
+			if got := names(large); !slices.Equal(got, tt.wantLarge) {
+				t.Errorf("splitAttachments() large = %q, want %q", got, tt.wantLarge)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultMaxBackups ローテーションで残す古いファイル数のデフォルト値
+const defaultMaxBackups = 3
+
+// Config ログファイルの設定
+type Config struct {
+	// Path ログファイルのパス
+	Path string
+	// MaxSize ローテーションするサイズ（バイト、0の場合はローテーションしない）
+	MaxSize int64
+	// MaxBackups 残す古いファイル数（0の場合はデフォルト、<path>.1 が最も新しい）
+	MaxBackups int
+}
+
+// Writer サイズでローテーションするログファイル
+// 1回のWriteは1行のログに相当するため、ローテーションはWriteの前に行い、行の途中でファイルを切り替えない
+type Writer struct {
+	config Config
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open ログファイルを追記モードで開く
+func Open(config Config) (*Writer, error) {
+	if config.MaxBackups <= 0 {
+		config.MaxBackups = defaultMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0700); err != nil {
+		return nil, fmt.Errorf("ログディレクトリ作成エラー: %w", err)
+	}
+
+	w := &Writer{config: config}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write ログを書き込む（必要であれば先にローテーションする）
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.config.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.config.MaxSize {
+		if err := w.rotate(); err != nil {
+			// ローテーションに失敗しても、ログを失わないよう現在のファイルに書き込む
+			fmt.Fprintf(os.Stderr, "ログファイルのローテーションに失敗しました: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close ログファイルを閉じる
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// open ログファイルを開き、現在のサイズを取得する
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("ログファイルを開けません: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("ログファイルを開けません: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// rotate 古いファイルの番号を繰り上げ、新しいファイルを開く
+// <path>.<MaxBackups> を超えるファイルは削除される
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	for i := w.config.MaxBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", w.config.Path, i)
+		if _, err := os.Stat(from); err == nil {
+			os.Rename(from, fmt.Sprintf("%s.%d", w.config.Path, i+1))
+		}
+	}
+	renameErr := os.Rename(w.config.Path, w.config.Path+".1")
+
+	// 名前の変更に失敗した場合も、元のファイルを開き直して書き込みを続ける
+	if err := w.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
@@ -0,0 +1,400 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxDepth 引用やリストの入れ子の上限（これより深いものは段落として扱う）
+const maxDepth = 16
+
+var (
+	headingPattern  = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	bulletPattern   = regexp.MustCompile(`^( {0,3})([-*+])[ \t]+`)
+	orderedPattern  = regexp.MustCompile(`^( {0,3})(\d{1,9})[.)][ \t]+`)
+	fencePattern    = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+	quotePattern    = regexp.MustCompile(`^ {0,3}> ?`)
+	autolinkPattern = regexp.MustCompile(`^<((?:https?|mailto):[^<>\s]+)>`)
+)
+
+// ToHTML Markdownを HTML に変換する
+// 見出し、段落、強調、打ち消し線、インラインコード、コードブロック、引用、リスト、水平線、リンク、画像に対応する。
+// Markdown中の生のHTMLはタグとして解釈せずエスケープし、リンクと画像のURLは http、https、mailto、cid のみ許可するため、
+// 出力には変換で生成したタグと属性だけが含まれる
+func ToHTML(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), 0)
+	return b.String()
+}
+
+// renderBlocks 行の並びをブロック要素に変換する
+func renderBlocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case fencePattern.MatchString(line):
+			fence := fencePattern.FindStringSubmatch(line)[1]
+			i++
+			var code []string
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+				code = append(code, lines[i])
+				i++
+			}
+			i++ // 閉じるフェンス
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+
+		case headingPattern.MatchString(trimmed):
+			m := headingPattern.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(m[1])))
+			b.WriteString("<h" + level + ">")
+			b.WriteString(renderInline(m[2]))
+			b.WriteString("</h" + level + ">\n")
+			i++
+
+		case isHR(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case depth < maxDepth && quotePattern.MatchString(line):
+			var quoted []string
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+				quoted = append(quoted, quotePattern.ReplaceAllString(lines[i], ""))
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, depth+1)
+			b.WriteString("</blockquote>\n")
+
+		case depth < maxDepth && (bulletPattern.MatchString(line) || orderedPattern.MatchString(line)):
+			i = renderList(b, lines, i, depth)
+
+		default:
+			var para []string
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(para) == 0 || !startsBlock(lines[i])) {
+				para = append(para, lines[i])
+				i++
+			}
+			b.WriteString("<p>")
+			b.WriteString(renderParagraph(para))
+			b.WriteString("</p>\n")
+		}
+	}
+}
+
+// isHR 水平線（同じ記号 -、*、_ が3つ以上）の行か判定
+func isHR(line string) bool {
+	if leadingSpaces(line) > 3 {
+		return false
+	}
+	marks := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, line)
+	if len(marks) < 3 || strings.Trim(marks, marks[:1]) != "" {
+		return false
+	}
+	return marks[0] == '-' || marks[0] == '*' || marks[0] == '_'
+}
+
+// startsBlock 段落を終わらせる行か判定
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return fencePattern.MatchString(line) || headingPattern.MatchString(trimmed) || isHR(line) ||
+		quotePattern.MatchString(line) || bulletPattern.MatchString(line) || orderedPattern.MatchString(line)
+}
+
+// renderList リストを変換し、次に処理する行の位置を返す
+// 項目の行より深くインデントされた行は項目の続きとして扱い、入れ子のリストも変換する
+func renderList(b *strings.Builder, lines []string, i, depth int) int {
+	ordered := orderedPattern.MatchString(lines[i])
+	marker := bulletPattern
+	tag := "ul"
+	if ordered {
+		marker = orderedPattern
+		tag = "ol"
+	}
+
+	b.WriteString("<" + tag + ">\n")
+	for i < len(lines) {
+		m := marker.FindStringSubmatchIndex(lines[i])
+		if m == nil {
+			break
+		}
+		indent := m[1]
+		item := []string{lines[i][indent:]}
+		i++
+
+		// 続きの行（インデントされた行、または空行を挟まない行）を集める
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// 空行の次がインデントされていれば項目の続き
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= indent && strings.TrimSpace(lines[i+1]) != "" {
+					item = append(item, "")
+					i++
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) >= indent {
+				item = append(item, line[indent:])
+			} else if marker.MatchString(line) || startsBlock(line) {
+				break
+			} else {
+				item = append(item, line)
+			}
+			i++
+		}
+
+		var inner strings.Builder
+		renderBlocks(&inner, item, depth+1)
+		content := strings.TrimSuffix(inner.String(), "\n")
+		// 段落1つだけの項目は <p> で囲まない
+		if strings.HasPrefix(content, "<p>") && strings.Count(content, "<p>") == 1 {
+			content = strings.Replace(strings.Replace(content, "<p>", "", 1), "</p>", "", 1)
+		}
+		b.WriteString("<li>" + content + "</li>\n")
+
+		// 空行の後に同じ種類の項目が続く場合は同じリストとする
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" && i+1 < len(lines) && marker.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// leadingSpaces 行頭の空白の数（タブは4つとして数える）
+func leadingSpaces(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// renderParagraph 段落の行を変換する（行末の2つの空白またはバックスラッシュは改行）
+func renderParagraph(lines []string) string {
+	parts := make([]string, len(lines))
+	for i, line := range lines {
+		hardBreak := strings.HasSuffix(line, "  ") || strings.HasSuffix(line, "\\")
+		line = strings.TrimSpace(line)
+		if hardBreak {
+			line = strings.TrimSuffix(line, "\\")
+		}
+		parts[i] = renderInline(line)
+		if hardBreak && i < len(lines)-1 {
+			parts[i] += "<br>"
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// renderInline インライン要素を変換する
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		rest := s[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!~<>|", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[ticks:], rest[:ticks]); end >= 0 {
+				code := strings.TrimSpace(rest[ticks : ticks+end])
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += ticks + end + ticks
+				continue
+			}
+
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if text, target, n, ok := parseLink(rest[1:]); ok {
+				if safe, ok := safeURL(target); ok {
+					b.WriteString(`<img src="` + html.EscapeString(safe) + `" alt="` + html.EscapeString(text) + `">`)
+				} else {
+					b.WriteString(html.EscapeString(text))
+				}
+				i += 1 + n
+				continue
+			}
+
+		case c == '[':
+			if text, target, n, ok := parseLink(rest); ok {
+				if safe, ok := safeURL(target); ok {
+					b.WriteString(`<a href="` + html.EscapeString(safe) + `">` + renderInline(text) + `</a>`)
+				} else {
+					b.WriteString(renderInline(text))
+				}
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if m := autolinkPattern.FindStringSubmatch(rest); m != nil {
+				if safe, ok := safeURL(m[1]); ok {
+					label := strings.TrimPrefix(m[1], "mailto:")
+					b.WriteString(`<a href="` + html.EscapeString(safe) + `">` + html.EscapeString(label) + `</a>`)
+					i += len(m[0])
+					continue
+				}
+			}
+
+		case strings.HasPrefix(rest, "***") || strings.HasPrefix(rest, "___"):
+			if inner, n, ok := delimited(s, i, rest[:3]); ok {
+				b.WriteString("<em><strong>" + renderInline(inner) + "</strong></em>")
+				i += n
+				continue
+			}
+			fallthrough
+
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if inner, n, ok := delimited(s, i, rest[:2]); ok {
+				b.WriteString("<strong>" + renderInline(inner) + "</strong>")
+				i += n
+				continue
+			}
+
+		case strings.HasPrefix(rest, "~~"):
+			if inner, n, ok := delimited(s, i, "~~"); ok {
+				b.WriteString("<del>" + renderInline(inner) + "</del>")
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_':
+			if inner, n, ok := delimited(s, i, rest[:1]); ok {
+				b.WriteString("<em>" + renderInline(inner) + "</em>")
+				i += n
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// delimited s[i:] が delim で始まり delim で閉じられていれば、内側の文字列と全体の長さを返す
+// _ は単語の途中（snake_case など）では強調にしない
+func delimited(s string, i int, delim string) (inner string, n int, ok bool) {
+	rest := s[i+len(delim):]
+	if rest == "" || rest[0] == ' ' {
+		return "", 0, false
+	}
+	if delim[0] == '_' && i > 0 && isWordByte(s[i-1]) {
+		return "", 0, false
+	}
+
+	for from := 0; ; {
+		end := strings.Index(rest[from:], delim)
+		if end < 0 {
+			return "", 0, false
+		}
+		end += from
+		after := i + len(delim) + end + len(delim)
+		switch {
+		case len(delim) == 1 && end+1 < len(rest) && rest[end+1] == delim[0]:
+			// *a **b** c* のように内側の ** の一部は閉じの記号にしない
+			from = end + 2
+			continue
+		case end == 0 || rest[end-1] == ' ':
+		case delim[0] == '_' && after < len(s) && isWordByte(s[after]):
+		default:
+			return rest[:end], len(delim) + end + len(delim), true
+		}
+		from = end + 1
+	}
+}
+
+// isWordByte 英数字か判定
+func isWordByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseLink [text](url) を解析し、テキスト、URL、全体の長さを返す
+func parseLink(s string) (text, target string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				if i+1 >= len(s) || s[i+1] != '(' {
+					return "", "", 0, false
+				}
+				end := closingParen(s[i+2:])
+				if end < 0 {
+					return "", "", 0, false
+				}
+				target = strings.TrimSpace(s[i+2 : i+2+end])
+				// タイトル（"..."）は使わない
+				if sp := strings.IndexAny(target, " \t"); sp >= 0 {
+					target = target[:sp]
+				}
+				return s[1:i], strings.Trim(target, "<>"), i + 2 + end + 1, true
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+// closingParen URLの終わりの ) の位置を返す（URL中の対になった括弧は含める、ない場合は-1）
+func closingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// safeURL リンクや画像に使えるURLか判定（javascript: や data: などは使わない）
+func safeURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto", "cid":
+		return u.String(), true
+	}
+	return "", false
+}
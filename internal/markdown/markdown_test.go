@@ -0,0 +1,57 @@
+package markdown
+
+import "testing"
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		// 生のHTMLはタグとして解釈しない
+		{name: "scriptタグ", src: "<script>alert(1)</script>", want: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{name: "onerror属性", src: "<img src=x onerror=alert(1)>", want: "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n"},
+		{name: "インラインのタグ", src: `a <b onclick="x">b</b>`, want: "<p>a &lt;b onclick=&#34;x&#34;&gt;b&lt;/b&gt;</p>\n"},
+		{name: "コードブロック中のタグ", src: "```\n<script>\n```", want: "<pre><code>&lt;script&gt;</code></pre>\n"},
+		{name: "インラインコード中のタグ", src: "`<b>`", want: "<p><code>&lt;b&gt;</code></p>\n"},
+
+		// 許可しないスキームのリンクと画像はテキストだけを残す
+		{name: "javascriptのリンク", src: "[click](javascript:alert(1))", want: "<p>click</p>\n"},
+		{name: "大文字のjavascriptのリンク", src: "[click](JavaScript:alert(1))", want: "<p>click</p>\n"},
+		{name: "dataのリンク", src: "[x](data:text/html;base64,PHNjcmlwdD4=)", want: "<p>x</p>\n"},
+		{name: "dataの画像", src: "![img](data:image/png;base64,AAAA)", want: "<p>img</p>\n"},
+		{name: "javascriptの画像", src: "![img](javascript:alert(1))", want: "<p>img</p>\n"},
+		{name: "javascriptの自動リンク", src: "<javascript:alert(1)>", want: "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+		{name: "httpsのリンク", src: `[ok](https://example.com/?a=1&b="2")`, want: "<p><a href=\"https://example.com/?a=1&amp;b=&#34;2&#34;\">ok</a></p>\n"},
+		{name: "括弧を含むURL", src: "[wiki](https://example.com/a_(b))", want: "<p><a href=\"https://example.com/a_(b)\">wiki</a></p>\n"},
+		{name: "cidの画像", src: "![logo](cid:logo@example.com)", want: "<p><img src=\"cid:logo@example.com\" alt=\"logo\"></p>\n"},
+		{name: "mailtoの自動リンク", src: "<mailto:a@example.com>", want: "<p><a href=\"mailto:a@example.com\">a@example.com</a></p>\n"},
+
+		// リスト
+		{name: "入れ子のリスト", src: "- a\n- b\n  - c\n  - d\n- e", want: "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n<li>d</li>\n</ul></li>\n<li>e</li>\n</ul>\n"},
+		{name: "番号付きリストの入れ子", src: "1. one\n2. two\n   - nested\n", want: "<ol>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul></li>\n</ol>\n"},
+		{name: "空行を挟んだ項目", src: "- a\n\n- b", want: "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+
+		// 強調
+		{name: "強調", src: "**bold** *em* ~~del~~", want: "<p><strong>bold</strong> <em>em</em> <del>del</del></p>\n"},
+		{name: "強調と太字の組み合わせ", src: "***both***", want: "<p><em><strong>both</strong></em></p>\n"},
+		{name: "強調の中の太字", src: "*a **b** c*", want: "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{name: "単語の途中の_", src: "snake_case_name", want: "<p>snake_case_name</p>\n"},
+		{name: "_による強調", src: "_em_ __strong__", want: "<p><em>em</em> <strong>strong</strong></p>\n"},
+		{name: "閉じていない記号", src: "**unclosed", want: "<p>**unclosed</p>\n"},
+		{name: "空白に囲まれた記号", src: "2 * 3 * 4", want: "<p>2 * 3 * 4</p>\n"},
+		{name: "エスケープした記号", src: `\*literal\*`, want: "<p>*literal*</p>\n"},
+
+		// ブロック要素
+		{name: "見出しと段落", src: "# Title\n\nline1  \nline2", want: "<h1>Title</h1>\n<p>line1<br>\nline2</p>\n"},
+		{name: "引用", src: "> quote", want: "<blockquote>\n<p>quote</p>\n</blockquote>\n"},
+		{name: "水平線", src: "---", want: "<hr>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToHTML(tt.src); got != tt.want {
+				t.Errorf("ToHTML(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
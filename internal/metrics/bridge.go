@@ -0,0 +1,24 @@
+package metrics
+
+// m3bridgeのメトリクス
+var (
+	// MessagesReceived SMTPで受信したメッセージ数
+	MessagesReceived = NewCounter("m3bridge_messages_received_total", "SMTPで受信したメッセージ数")
+	// MessagesSent Graphで送信したメッセージ数
+	MessagesSent = NewCounter("m3bridge_messages_sent_total", "Microsoft Graphで送信したメッセージ数")
+	// MessagesRelayed Graphの送信に失敗し、SMTPリレーへ転送したメッセージ数
+	MessagesRelayed = NewCounter("m3bridge_messages_relayed_total", "SMTPリレーへ転送したメッセージ数")
+	// MessagesQueued Graphの送信に一時的なエラーで失敗し、再送キューに保存したメッセージ数
+	MessagesQueued = NewCounter("m3bridge_messages_queued_total", "再送キューに保存したメッセージ数")
+	// MessagesDeadLettered 再送を諦めたメッセージ数
+	MessagesDeadLettered = NewCounter("m3bridge_messages_dead_lettered_total", "再送を諦めたメッセージ数")
+	// MessagesFailed 送信に失敗したメッセージ数（エラーの分類ごと）
+	MessagesFailed = NewCounterVec("m3bridge_messages_failed_total", "送信に失敗したメッセージ数", "class")
+	// SessionsRejected 同時セッション数の上限に達して拒否したセッション数
+	SessionsRejected = NewCounter("m3bridge_sessions_rejected_total", "同時セッション数の上限に達して拒否したセッション数")
+	// TokenRefreshes リフレッシュトークンによるトークンの更新回数（結果ごと）
+	TokenRefreshes = NewCounterVec("m3bridge_token_refreshes_total", "アクセストークンの更新回数", "result")
+	// GraphSendDuration Graphへの送信にかかった時間（秒、再試行を含む）
+	GraphSendDuration = NewHistogram("m3bridge_graph_send_duration_seconds", "Microsoft Graphへの送信にかかった時間",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120})
+)
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// collector Prometheusのテキスト形式で出力できるメトリクス
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+// register メトリクスを出力の対象に追加
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// Handler 登録された全てのメトリクスをPrometheusのテキスト形式で返すハンドラー
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		registryMu.Lock()
+		collectors := slices.Clone(registry)
+		registryMu.Unlock()
+
+		bw := bufio.NewWriter(w)
+		for _, c := range collectors {
+			c.write(bw)
+		}
+		bw.Flush()
+	})
+}
+
+// writeHeader HELPとTYPEの行を出力
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Counter 増加のみするカウンター
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+// NewCounter カウンターを作成して登録
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(c)
+	return c
+}
+
+// Inc カウンターを1増やす
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.value.Load())
+}
+
+// CounterVec ラベルの値ごとのカウンター
+// ラベルの値は種類が限られるもの（エラーの分類など）に限ること
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounterVec ラベル付きのカウンターを作成して登録
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+	register(c)
+	return c
+}
+
+// Inc 指定したラベルの値のカウンターを1増やす
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[value]++
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", c.name, c.label, strconv.Quote(k), c.values[k])
+	}
+}
+
+// Histogram 観測値の分布
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64 // 昇順の上限値
+
+	mu     sync.Mutex
+	counts []uint64 // バケットごとの件数（累積ではない）
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram ヒストグラムを作成して登録
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: slices.Sorted(slices.Values(buckets)),
+		counts:  make([]uint64, len(buckets)),
+	}
+	register(h)
+	return h
+}
+
+// Observe 値を記録
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(upper), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// formatFloat Prometheusのテキスト形式の数値
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
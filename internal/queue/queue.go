@@ -0,0 +1,303 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/fsutil"
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/canaria-computer/m3bridge/internal/metrics"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	// deadDirName 再送を諦めたメッセージを移すディレクトリ
+	deadDirName = "dead"
+	// lockFileName 再送処理の排他に使うロックファイル
+	lockFileName = ".lock"
+	// entrySuffix キューのファイルの拡張子
+	entrySuffix = ".json"
+	// pollInterval 再送の時刻になったメッセージを確認する間隔
+	pollInterval = 10 * time.Second
+	// sendTimeout メッセージ1通の再送のタイムアウト
+	sendTimeout = 5 * time.Minute
+
+	defaultMaxAttempts    = 10
+	defaultInitialBackoff = time.Minute
+	defaultMaxBackoff     = time.Hour
+)
+
+// ErrBusy 別のプロセス（serveまたはflushコマンド）が再送キューを処理中
+var ErrBusy = errors.New("再送キューは別のプロセスが処理中です")
+
+// Config 再送キューの設定（0の場合はデフォルト）
+type Config struct {
+	// Dir メッセージを保存するディレクトリ
+	Dir string
+	// MaxAttempts 送信の最大試行回数（SMTPで受信した時の送信を含む）。超えたメッセージは dead に移す
+	MaxAttempts int
+	// InitialBackoff, MaxBackoff 再送までの待機時間の初期値と上限（失敗するたびに2倍にする）
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Entry キューに保存したメッセージ
+type Entry struct {
+	ID string `json:"id"`
+	// Mailbox 送信元のメールボックス（空の場合はデフォルト）
+	Mailbox string        `json:"mailbox,omitempty"`
+	Message graph.Message `json:"message"`
+
+	CreatedAt   time.Time `json:"created_at"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// SendFunc キューのメッセージを送信する関数
+type SendFunc func(ctx context.Context, entry *Entry) error
+
+// Queue 一時的なエラーで送信できなかったメッセージをディスクに保存し、バックオフしながら再送するキュー
+type Queue struct {
+	config Config
+	logger *log.Logger
+}
+
+// New 再送キューを作成（ディレクトリがなければ作成する）
+func New(config Config, logger *log.Logger) (*Queue, error) {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaultInitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	if err := os.MkdirAll(filepath.Join(config.Dir, deadDirName), 0700); err != nil {
+		return nil, fmt.Errorf("キューディレクトリ作成エラー: %w", err)
+	}
+	return &Queue{config: config, logger: logger}, nil
+}
+
+// Dir メッセージを保存するディレクトリ
+func (q *Queue) Dir() string {
+	return q.config.Dir
+}
+
+// Enqueue 送信に失敗したメッセージを保存する
+// causeは最初の送信のエラーで、1回目の試行として数える
+func (q *Queue) Enqueue(mailbox string, msg *graph.Message, cause error) (*Entry, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entry := &Entry{
+		ID:          id,
+		Mailbox:     mailbox,
+		Message:     *msg,
+		CreatedAt:   now,
+		Attempts:    1,
+		NextAttempt: now.Add(q.backoff(1)),
+	}
+	if cause != nil {
+		entry.LastError = cause.Error()
+	}
+	if err := q.write(q.config.Dir, entry); err != nil {
+		return nil, err
+	}
+	q.logger.Info("メッセージを再送キューに保存しました", "id", entry.ID, "next_attempt", entry.NextAttempt)
+	return entry, nil
+}
+
+// List 再送待ちのメッセージを作成日時の順に返す
+func (q *Queue) List() ([]*Entry, error) {
+	return q.list(q.config.Dir)
+}
+
+// Dead 再送を諦めたメッセージを作成日時の順に返す
+func (q *Queue) Dead() ([]*Entry, error) {
+	return q.list(filepath.Join(q.config.Dir, deadDirName))
+}
+
+// Run 再送の時刻になったメッセージを定期的に再送する。ctxがキャンセルされると終了する
+// retryableがfalseを返すエラーや、最大試行回数に達したメッセージは dead に移す
+func (q *Queue) Run(ctx context.Context, send SendFunc, retryable func(error) bool) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		_, _, err := q.process(ctx, send, retryable, false)
+		switch {
+		case errors.Is(err, ErrBusy):
+			// flushコマンドが処理中のため、次の確認まで待つ
+			q.logger.Debug("再送キューは別のプロセスが処理中です")
+		case err != nil:
+			q.logger.Warn("再送キューの処理に失敗しました", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush 再送の時刻を待たずに全てのメッセージを再送し、送信できた数と失敗した数を返す
+// serveなど別のプロセスが処理中の場合は ErrBusy を返す
+func (q *Queue) Flush(ctx context.Context, send SendFunc, retryable func(error) bool) (sent, failed int, err error) {
+	return q.process(ctx, send, retryable, true)
+}
+
+// process キューのメッセージを再送する（forceがfalseの場合は再送の時刻になったもののみ）
+// serveとflushコマンドが同時に同じメッセージを送らないよう、ロックを取得してから処理する
+// 別のプロセスが処理中の場合は、終わるのを待たずに ErrBusy を返す
+func (q *Queue) process(ctx context.Context, send SendFunc, retryable func(error) bool, force bool) (sent, failed int, err error) {
+	lock, err := os.OpenFile(filepath.Join(q.config.Dir, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ロックファイルを作成できません: %w", err)
+	}
+	defer lock.Close()
+	if err := fsutil.TryLock(lock); err != nil {
+		if errors.Is(err, fsutil.ErrLocked) {
+			return 0, 0, ErrBusy
+		}
+		return 0, 0, fmt.Errorf("キューのロックに失敗しました: %w", err)
+	}
+	defer fsutil.Unlock(lock)
+
+	entries, err := q.List()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if !force && entry.NextAttempt.After(now) {
+			continue
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		sendErr := send(sendCtx, entry)
+		cancel()
+
+		if sendErr == nil {
+			q.logger.Info("再送キューのメッセージを送信しました", "id", entry.ID, "attempts", entry.Attempts+1)
+			if err := os.Remove(q.path(q.config.Dir, entry.ID)); err != nil {
+				q.logger.Warn("送信したメッセージを削除できません", "id", entry.ID, "error", err)
+			}
+			metrics.MessagesSent.Inc()
+			sent++
+			continue
+		}
+
+		failed++
+		entry.Attempts++
+		entry.LastError = sendErr.Error()
+		if !retryable(sendErr) || entry.Attempts >= q.config.MaxAttempts {
+			q.logger.Error("メッセージの再送を諦めました", "id", entry.ID, "attempts", entry.Attempts, "error", sendErr)
+			metrics.MessagesDeadLettered.Inc()
+			if err := q.moveToDead(entry); err != nil {
+				q.logger.Warn("メッセージを dead に移せません", "id", entry.ID, "error", err)
+			}
+			continue
+		}
+
+		entry.NextAttempt = time.Now().Add(q.backoff(entry.Attempts))
+		q.logger.Warn("メッセージの再送に失敗しました", "id", entry.ID, "attempts", entry.Attempts, "next_attempt", entry.NextAttempt, "error", sendErr)
+		if err := q.write(q.config.Dir, entry); err != nil {
+			q.logger.Warn("再送キューを更新できません", "id", entry.ID, "error", err)
+		}
+	}
+	return sent, failed, nil
+}
+
+// backoff attempts回失敗した後の再送までの待機時間
+func (q *Queue) backoff(attempts int) time.Duration {
+	wait := q.config.InitialBackoff
+	for i := 1; i < attempts && wait < q.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, q.config.MaxBackoff)
+}
+
+// moveToDead メッセージを dead ディレクトリに移す
+func (q *Queue) moveToDead(entry *Entry) error {
+	if err := q.write(filepath.Join(q.config.Dir, deadDirName), entry); err != nil {
+		return err
+	}
+	return os.Remove(q.path(q.config.Dir, entry.ID))
+}
+
+// list ディレクトリのメッセージを読み込む（読み込めないファイルは警告して飛ばす）
+func (q *Queue) list(dir string) ([]*Entry, error) {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("キュー読み込みエラー: %w", err)
+	}
+
+	var entries []*Entry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), entrySuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			q.logger.Warn("キューのファイルを読み込めません", "file", file.Name(), "error", err)
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			q.logger.Warn("キューのファイルを解析できません", "file", file.Name(), "error", err)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return entries, nil
+}
+
+// write メッセージをディレクトリに保存する
+func (q *Queue) write(dir string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("キューのシリアライズエラー: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(q.path(dir, entry.ID), data, 0600); err != nil {
+		return fmt.Errorf("キュー書き込みエラー: %w", err)
+	}
+	return nil
+}
+
+// path メッセージのファイルのパス
+func (q *Queue) path(dir, id string) string {
+	return filepath.Join(dir, id+entrySuffix)
+}
+
+// newID 作成日時の順に並ぶIDを生成
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("ID生成エラー: %w", err)
+	}
+	return time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(b), nil
+}
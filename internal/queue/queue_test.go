@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/canaria-computer/m3bridge/internal/fsutil"
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/charmbracelet/log"
+)
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name       string
+		locked     bool
+		wantErr    error
+		wantSent   int
+		wantQueued int
+	}{
+		{name: "再送", wantSent: 1},
+		// serveが処理中の場合は、ロックの解除を待たずにErrBusyを返す
+		{name: "別のプロセスが処理中", locked: true, wantErr: ErrBusy, wantQueued: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q, err := New(Config{Dir: dir}, log.New(io.Discard))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := q.Enqueue("", &graph.Message{To: []string{"alice@example.com"}, Subject: "test"}, errors.New("throttled")); err != nil {
+				t.Fatal(err)
+			}
+			if tt.locked {
+				lock, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer lock.Close()
+				if err := fsutil.Lock(lock, true); err != nil {
+					t.Fatal(err)
+				}
+				defer fsutil.Unlock(lock)
+			}
+
+			send := func(ctx context.Context, entry *Entry) error { return nil }
+			sent, _, err := q.Flush(context.Background(), send, func(error) bool { return true })
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Flush() error = %v, want %v", err, tt.wantErr)
+			}
+			if sent != tt.wantSent {
+				t.Errorf("Flush() sent = %d, want %d", sent, tt.wantSent)
+			}
+			entries, err := q.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.wantQueued {
+				t.Errorf("List() = %d entries, want %d", len(entries), tt.wantQueued)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"unicode"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+)
+
+const (
+	// defaultAttachmentName ファイル名が指定されていない添付ファイルの名前
+	defaultAttachmentName = "attachment"
+	// forwardedMessageName 件名もファイル名もない転送メッセージの添付ファイル名
+	forwardedMessageName = "forwarded.eml"
+	// maxForwardedNameLength 転送メッセージの件名から付けるファイル名の最大文字数（拡張子を除く）
+	maxForwardedNameLength = 100
+	// maxMultipartDepth 処理するマルチパートのネストの深さの上限
+	maxMultipartDepth = 10
+)
+
+// messageContent メッセージから抽出した本文と添付ファイル
+type messageContent struct {
+	body   string
+	isHTML bool
+	// text 本文がHTMLの場合のテキストの代替本文（multipart/alternativeのテキストのパートやMarkdownの原文）
+	text        string
+	attachments []graph.Attachment
+}
+
+// isAttachmentPart パートを添付ファイルとして扱うか判定
+// Content-Disposition: attachment のパートと、本文にならないパート（画像やPDFなど）を添付ファイルとする
+func isAttachmentPart(part *multipart.Part, mediaType string) bool {
+	disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if strings.EqualFold(disposition, "attachment") {
+		return true
+	}
+	return !strings.HasPrefix(mediaType, "text/") && !strings.HasPrefix(mediaType, "multipart/")
+}
+
+// newAttachment パートの内容から添付ファイルを作成
+// Content-IDのあるパートは、Content-Disposition: inline の場合と、multipart/relatedの中で
+// Content-Dispositionがない場合に、HTMLから cid: で参照されるインライン添付にする。
+// Content-Disposition: attachment のパートはContent-IDがあっても通常の添付ファイルとする
+func newAttachment(part *multipart.Part, mediaType string, content []byte, related bool) graph.Attachment {
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	attachment := graph.Attachment{
+		Name:        partFilename(part, mediaType),
+		ContentType: mediaType,
+		Content:     content,
+	}
+
+	disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	inline := strings.EqualFold(disposition, "inline") || (related && disposition == "")
+	if contentID := partContentID(part); contentID != "" && inline {
+		attachment.IsInline = true
+		attachment.ContentID = contentID
+	}
+	return attachment
+}
+
+// isForwardedMessage 添付として転送されたメッセージ（message/rfc822）のパートか判定
+func isForwardedMessage(mediaType string) bool {
+	return strings.EqualFold(mediaType, "message/rfc822")
+}
+
+// newForwardedAttachment 転送されたメッセージを元のバイト列のまま .eml の添付ファイルにする
+// ファイル名がない場合は元のメッセージの件名から付ける
+func newForwardedAttachment(part *multipart.Part, content []byte) graph.Attachment {
+	name := forwardedMessageName
+	if part.FileName() != "" {
+		name = partFilename(part, "message/rfc822")
+	} else if msg, err := mail.ReadMessage(bytes.NewReader(content)); err == nil {
+		if subject := sanitizeFilename(decodeHeader(msg.Header.Get("Subject"))); subject != "" {
+			name = subject + ".eml"
+		}
+	}
+	return graph.Attachment{
+		Name:        name,
+		ContentType: "message/rfc822",
+		Content:     content,
+	}
+}
+
+// sanitizeFilename ファイル名に使えない文字を _ に置き換え、長すぎる場合は切り詰める
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if runes := []rune(name); len(runes) > maxForwardedNameLength {
+		name = string(runes[:maxForwardedNameLength])
+	}
+	return strings.TrimSpace(name)
+}
+
+// partContentID パートのContent-IDを取得（山括弧は取り除く）
+func partContentID(part *multipart.Part) string {
+	id := strings.TrimSpace(part.Header.Get("Content-ID"))
+	return strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+}
+
+// partFilename パートのファイル名を取得
+// Content-Dispositionのfilename、Content-Typeのnameの順に参照し、RFC 2047でエンコードされた名前はデコードする
+func partFilename(part *multipart.Part, mediaType string) string {
+	if name := part.FileName(); name != "" {
+		return decodeHeader(name)
+	}
+	if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil && params["name"] != "" {
+		return decodeHeader(params["name"])
+	}
+
+	// 名前がない場合はメディアタイプから拡張子を補う
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return defaultAttachmentName + exts[0]
+	}
+	return defaultAttachmentName
+}
+
+// decodeTransferEncoding Content-Transfer-Encodingに従ってパートの内容をデコード
+// デコードに失敗した場合は元の内容を返す
+func decodeTransferEncoding(encoding string, data []byte) []byte {
+	switch {
+	case strings.EqualFold(encoding, "base64"):
+		if decoded, err := decodeBase64(data); err == nil {
+			return decoded
+		}
+	case strings.EqualFold(encoding, "quoted-printable"):
+		return []byte(decodeQuotedPrintable(string(data)))
+	}
+	return data
+}
+
+// transferDecoder Content-Transfer-Encodingに従ってパートの内容を読みながらデコードするReader
+// 添付ファイルはエンコードされた内容をメモリに保持せずにデコードする
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch {
+	case strings.EqualFold(encoding, "base64"):
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: r})
+	case strings.EqualFold(encoding, "quoted-printable"):
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// base64Cleaner MIMEのBase64から改行や空白を取り除き、パディングのない内容は末尾に補うReader
+type base64Cleaner struct {
+	r       io.Reader
+	count   int // これまでに渡したBase64の文字数
+	padding []byte
+	eof     bool
+}
+
+// Read 改行と空白を除いた内容を読み込む
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	for {
+		if c.eof {
+			n := copy(p, c.padding)
+			c.padding = c.padding[n:]
+			if len(c.padding) == 0 {
+				return n, io.EOF
+			}
+			return n, nil
+		}
+
+		n, err := c.r.Read(p)
+		kept := 0
+		for _, b := range p[:n] {
+			switch b {
+			case ' ', '\t', '\r', '\n':
+				continue
+			}
+			p[kept] = b
+			kept++
+		}
+		c.count += kept
+		if err == io.EOF {
+			c.eof = true
+			if rem := c.count % 4; rem != 0 {
+				c.padding = bytes.Repeat([]byte("="), 4-rem)
+			}
+			if kept > 0 || len(c.padding) == 0 {
+				return kept, nil
+			}
+			continue
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// decodeBase64 MIMEのBase64をデコード
+// 76桁での折り返しや行末の空白などを取り除き、パディングのない内容も受け付ける
+func decodeBase64(data []byte) ([]byte, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, string(data))
+
+	if len(cleaned)%4 != 0 {
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(cleaned, "="))
+	}
+	return base64.StdEncoding.DecodeString(cleaned)
+}
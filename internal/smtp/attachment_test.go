@@ -0,0 +1,327 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Report", want: "Report"},
+		{name: "  Re: 見積もり  ", want: "Re_ 見積もり"},
+		{name: `a/b\c*d?e"f<g>h|i`, want: "a_b_c_d_e_f_g_h_i"},
+		{name: "tab\there", want: "tab_here"},
+		{name: strings.Repeat("あ", maxForwardedNameLength+10), want: strings.Repeat("あ", maxForwardedNameLength)},
+		{name: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.name); got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBodyForwarded(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   string
+		forwarded string
+		wantName  string
+	}{
+		{
+			name:      "件名から名前を付ける",
+			forwarded: "Subject: Invoice: March\nFrom: a@example.com\n\nOriginal\n",
+			wantName:  "Invoice_ March.eml",
+		},
+		{
+			name:      "エンコードされた件名",
+			forwarded: "Subject: =?UTF-8?B?6KuL5rGC5pu4?=\n\nOriginal\n",
+			wantName:  "請求書.eml",
+		},
+		{
+			name:      "ファイル名を優先",
+			headers:   "Content-Disposition: attachment; filename=\"original.eml\"\n",
+			forwarded: "Subject: Invoice\n\nOriginal\n",
+			wantName:  "original.eml",
+		},
+		{
+			name:      "件名がない",
+			forwarded: "From: a@example.com\n\nOriginal\n",
+			wantName:  forwardedMessageName,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := readTestMessage(t, `Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+FYI
+--b
+Content-Type: message/rfc822
+`+tt.headers+`
+`+tt.forwarded+`--b--
+`)
+			content, err := extractBody(msg)
+			if err != nil {
+				t.Fatalf("extractBody() error = %v", err)
+			}
+			if content.body != "FYI" {
+				t.Errorf("extractBody() body = %q, want %q", content.body, "FYI")
+			}
+			if len(content.attachments) != 1 {
+				t.Fatalf("extractBody() attachments = %d, want 1", len(content.attachments))
+			}
+			attachment := content.attachments[0]
+			if attachment.Name != tt.wantName || attachment.ContentType != "message/rfc822" {
+				t.Errorf("attachment = %q (%s), want %q (message/rfc822)", attachment.Name, attachment.ContentType, tt.wantName)
+			}
+			// 転送されたメッセージは元のバイト列のまま添付する
+			if want := strings.ReplaceAll(tt.forwarded, "\n", "\r\n"); string(attachment.Content) != strings.TrimSuffix(want, "\r\n") {
+				t.Errorf("attachment content = %q, want %q", attachment.Content, want)
+			}
+		})
+	}
+}
+
+// inlineImageAndPDF インライン画像とPDFの添付ファイルを含むHTMLメール（一般的なメールクライアントの構造）
+const inlineImageAndPDF = `From: App <app@example.com>
+To: alice@example.com
+Subject: Report
+Message-ID: <report-1@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="mixed"
+
+--mixed
+Content-Type: multipart/related; boundary="related"
+
+--related
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=utf-8
+
+See the chart.
+--alt
+Content-Type: text/html; charset=utf-8
+
+<p>See the chart.</p><img src="cid:chart@example.com">
+--alt--
+--related
+Content-Type: image/png; name="chart.png"
+Content-Transfer-Encoding: base64
+Content-ID: <chart@example.com>
+Content-Disposition: inline; filename="chart.png"
+
+iVBORw0KGgo=
+--related--
+--mixed
+Content-Type: application/pdf; name="report.pdf"
+Content-Transfer-Encoding: base64
+Content-Disposition: attachment; filename="report.pdf"
+
+JVBERi0xLjQK
+--mixed--
+`
+
+func ExampleSession_Data_inlineImageAndPDF() {
+	sender := &fakeSender{}
+	s := newTestSession(Config{AuthDisabled: true}, sender)
+
+	printResponse(sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, inlineImageAndPDF))
+	msg := sender.messages[0]
+	fmt.Printf("body: %q html: %v\n", msg.Body, msg.IsHTML)
+	for _, a := range msg.Attachments {
+		fmt.Printf("%s %s inline=%v cid=%q bytes=%d\n", a.Name, a.ContentType, a.IsInline, a.ContentID, len(a.Content))
+	}
+	// Output:
+	// 250 OK: queued as <report-1@example.com>
+	// body: "<p>See the chart.</p><img src=\"cid:chart@example.com\">" html: true
+	// chart.png image/png inline=true cid="chart@example.com" bytes=8
+	// report.pdf application/pdf inline=false cid="" bytes=9
+}
+
+func TestExtractBodyDisposition(t *testing.T) {
+	const image = "Content-Type: image/png\nContent-Transfer-Encoding: base64\n"
+	tests := []struct {
+		name        string
+		contentType string
+		parts       []string
+		wantInline  bool
+	}{
+		{
+			name:        "relatedのContent-IDのあるパート",
+			contentType: "multipart/related",
+			parts:       []string{"Content-Type: text/html\n\n<img src=\"cid:a\">", image + "Content-ID: <a>\n\niVBORw0KGgo="},
+			wantInline:  true,
+		},
+		{
+			name:        "mixedのinlineのパート",
+			contentType: "multipart/mixed",
+			parts:       []string{"Content-Type: text/html\n\n<img src=\"cid:a\">", image + "Content-ID: <a>\nContent-Disposition: inline\n\niVBORw0KGgo="},
+			wantInline:  true,
+		},
+		{
+			name:        "relatedのattachmentのパート",
+			contentType: "multipart/related",
+			parts:       []string{"Content-Type: text/html\n\n<p>x</p>", image + "Content-ID: <a>\nContent-Disposition: attachment; filename=\"a.png\"\n\niVBORw0KGgo="},
+		},
+		{
+			name:        "mixedのContent-Dispositionのないパート",
+			contentType: "multipart/mixed",
+			parts:       []string{"Content-Type: text/html\n\n<p>x</p>", image + "Content-ID: <a>\n\niVBORw0KGgo="},
+		},
+		{
+			name:        "Content-IDのないinlineのパート",
+			contentType: "multipart/related",
+			parts:       []string{"Content-Type: text/html\n\n<p>x</p>", image + "Content-Disposition: inline\n\niVBORw0KGgo="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "Content-Type: " + tt.contentType + "; boundary=\"b\"\n\n"
+			for _, part := range tt.parts {
+				raw += "--b\n" + part + "\n"
+			}
+			raw += "--b--\n"
+
+			content, err := extractBody(readTestMessage(t, raw))
+			if err != nil {
+				t.Fatalf("extractBody() error = %v", err)
+			}
+			if len(content.attachments) != 1 {
+				t.Fatalf("extractBody() attachments = %d, want 1", len(content.attachments))
+			}
+			attachment := content.attachments[0]
+			if attachment.IsInline != tt.wantInline {
+				t.Errorf("IsInline = %v, want %v", attachment.IsInline, tt.wantInline)
+			}
+			if tt.wantInline && attachment.ContentID != "a" {
+				t.Errorf("ContentID = %q, want %q", attachment.ContentID, "a")
+			}
+		})
+	}
+}
+
+func TestExtractBodyQuotedPrintable(t *testing.T) {
+	const qp = "Content-Transfer-Encoding: quoted-printable\n"
+	tests := []struct {
+		name           string
+		message        string
+		wantBody       string
+		wantAttachment string
+	}{
+		{
+			// 行末の = は改行として扱わず、次の行とつなげる
+			name:     "ソフト改行",
+			message:  "Content-Type: text/plain; charset=utf-8\n" + qp + "\nThis is a long line that is=\n wrapped=\nhere\n",
+			wantBody: "This is a long line that is wrappedhere\r\n",
+		},
+		{
+			name:     "=3D",
+			message:  "Content-Type: text/html; charset=utf-8\n" + qp + "\n<a href=3D\"https://example.com/?a=3D1&b=3D2\">=E3=81=82</a>\n",
+			wantBody: "<a href=\"https://example.com/?a=1&b=2\">あ</a>\r\n",
+		},
+		{
+			name:     "マルチパートのソフト改行と=3D",
+			message:  "Content-Type: multipart/alternative; boundary=\"b\"\n\n--b\nContent-Type: text/plain\n" + qp + "\nx=3Dy and=\n z\n--b--\n",
+			wantBody: "x=y and z",
+		},
+		{
+			name: "添付ファイルのソフト改行と=3D",
+			message: "Content-Type: multipart/mixed; boundary=\"b\"\n\n--b\nContent-Type: text/plain\n\nbody\n" +
+				"--b\nContent-Type: text/csv\nContent-Disposition: attachment; filename=\"a.csv\"\n" + qp + "\nkey=3Dvalue,=\nlong\n--b--\n",
+			wantBody:       "body",
+			wantAttachment: "key=value,long",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := extractBody(readTestMessage(t, tt.message))
+			if err != nil {
+				t.Fatalf("extractBody() error = %v", err)
+			}
+			if content.body != tt.wantBody {
+				t.Errorf("body = %q, want %q", content.body, tt.wantBody)
+			}
+			var attachment string
+			if len(content.attachments) > 0 {
+				attachment = string(content.attachments[0].Content)
+			}
+			if attachment != tt.wantAttachment {
+				t.Errorf("attachment = %q, want %q", attachment, tt.wantAttachment)
+			}
+		})
+	}
+}
+
+// wrapBase64 76桁で折り返したBase64（改行はCRLF）
+func wrapBase64(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.String()
+}
+
+func TestExtractBodyWrappedBase64(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 31)
+	}
+	// readTestMessageは改行をCRLFにするため、LFで組み立てる
+	encoded := strings.ReplaceAll(wrapBase64(data), "\r\n", "\n")
+	message := "Content-Type: multipart/mixed; boundary=\"b\"\n\n--b\nContent-Type: text/plain\n\nbody\n" +
+		"--b\nContent-Type: application/octet-stream\nContent-Disposition: attachment; filename=\"a.bin\"\nContent-Transfer-Encoding: base64\n\n" +
+		encoded + "--b--\n"
+
+	content, err := extractBody(readTestMessage(t, message))
+	if err != nil {
+		t.Fatalf("extractBody() error = %v", err)
+	}
+	if len(content.attachments) != 1 || !bytes.Equal(content.attachments[0].Content, data) {
+		t.Fatalf("attachments = %d, want the decoded 1000 bytes", len(content.attachments))
+	}
+}
+
+func TestTransferDecoder(t *testing.T) {
+	// 400バイト（Base64の末尾に == のパディングが付く長さ）
+	data := []byte(strings.Repeat("m3bridge attachment ", 20))
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{name: "76桁でCRLFで折り返し", encoded: wrapBase64(data)},
+		{name: "LFで折り返し", encoded: strings.ReplaceAll(wrapBase64(data), "\r\n", "\n")},
+		{name: "行末の空白", encoded: strings.ReplaceAll(wrapBase64(data), "\r\n", " \t\r\n")},
+		{name: "パディングなし", encoded: strings.TrimRight(wrapBase64(data), "=\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1バイトずつ読み込んでも、行の途中で区切られた場合と同じ結果になる
+			for _, r := range []io.Reader{strings.NewReader(tt.encoded), iotest.OneByteReader(strings.NewReader(tt.encoded))} {
+				got, err := io.ReadAll(transferDecoder("base64", r))
+				if err != nil {
+					t.Fatalf("transferDecoder() error = %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("transferDecoder() = %q, want %q", got, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package smtp
+
+import (
+	"io"
+	"sync"
+
+	"github.com/emersion/go-smtp"
+)
+
+// defaultMaxMessageBytes 1通のメッセージの最大サイズのデフォルト値
+const defaultMaxMessageBytes = 10 * 1024 * 1024 // 10MB
+
+// errInsufficientStorage 全セッションで保持中のメッセージが上限に達した場合のエラー
+// 一時的なエラーとして返し、クライアントに再送させる
+var errInsufficientStorage = &smtp.SMTPError{
+	Code:         452,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 1},
+	Message:      "Insufficient system storage, try again later",
+}
+
+// bufferBudget 全セッションで同時にメモリに保持するメッセージの合計サイズを制限する
+type bufferBudget struct {
+	mu    sync.Mutex
+	limit int64 // 0以下の場合は無制限
+	used  int64
+}
+
+// reserve n バイトを確保する（上限を超える場合はfalse）
+func (b *bufferBudget) reserve(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// available 現時点で n バイトを確保できるか判定する（確保はしない）
+func (b *bufferBudget) available(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit <= 0 || b.used+n <= b.limit
+}
+
+// release 確保したバイトを解放する
+func (b *bufferBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+}
+
+// budgetReader 読み込んだバイト数をbufferBudgetから確保しながら読み込むReader
+type budgetReader struct {
+	r        io.Reader
+	budget   *bufferBudget
+	reserved int64
+}
+
+// Read 読み込んだ分を確保し、上限を超えた場合はerrInsufficientStorageを返す
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if !r.budget.reserve(int64(n)) {
+			return 0, errInsufficientStorage
+		}
+		r.reserved += int64(n)
+	}
+	return n, err
+}
+
+// Release 確保した全てのバイトを解放する
+func (r *budgetReader) Release() {
+	r.budget.release(r.reserved)
+	r.reserved = 0
+}
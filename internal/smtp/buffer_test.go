@@ -0,0 +1,90 @@
+package smtp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestBudgetReader(t *testing.T) {
+	const limit = 100
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{name: "上限-1", size: limit - 1},
+		{name: "上限", size: limit},
+		{name: "上限+1", size: limit + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 一度に読み込む場合と、1バイトずつ読み込む場合
+			for _, src := range []io.Reader{strings.NewReader(strings.Repeat("x", tt.size)), iotest.OneByteReader(strings.NewReader(strings.Repeat("x", tt.size)))} {
+				budget := &bufferBudget{limit: limit}
+				r := &budgetReader{r: src, budget: budget}
+				_, err := io.ReadAll(r)
+				if errors.Is(err, errInsufficientStorage) != tt.wantErr {
+					t.Fatalf("ReadAll(%d bytes) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+				}
+				if !tt.wantErr && budget.used != int64(tt.size) {
+					t.Errorf("used = %d, want %d", budget.used, tt.size)
+				}
+				r.Release()
+				if budget.used != 0 {
+					t.Errorf("used after Release() = %d, want 0", budget.used)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxBufferedBytes(t *testing.T) {
+	const header = "Subject: test\r\n\r\n"
+	const limit = 200
+	tests := []struct {
+		name     string
+		size     int
+		wantCode int
+	}{
+		{name: "上限-1", size: limit - 1, wantCode: 250},
+		{name: "上限", size: limit, wantCode: 250},
+		{name: "上限+1", size: limit + 1, wantCode: 452},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSession(Config{AuthDisabled: true, MaxBufferedBytes: limit}, &fakeSender{})
+
+			// MAIL FROMのSIZE=は確保できるかだけを判定する
+			var smtpErr *smtp.SMTPError
+			err := s.Mail("app@example.com", &smtp.MailOptions{Size: int64(tt.size)})
+			if tt.wantCode == 452 {
+				if !errors.As(err, &smtpErr) || smtpErr.Code != 452 {
+					t.Fatalf("MAIL FROM SIZE=%d = %v, want 452", tt.size, err)
+				}
+				// 宣言せずに送ったDATAも、読み込みの途中で上限を超えた時点で拒否する
+				if err := s.Mail("app@example.com", nil); err != nil {
+					t.Fatal(err)
+				}
+			} else if err != nil {
+				t.Fatalf("MAIL FROM SIZE=%d = %v, want 250", tt.size, err)
+			}
+			if err := s.Rcpt("alice@example.com", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			data := header + strings.Repeat("x", tt.size-len(header))
+			err = s.Data(strings.NewReader(data))
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+				t.Fatalf("Data(%d bytes) = %v, want %d", len(data), err, tt.wantCode)
+			}
+			if s.backend.buffers.used != 0 {
+				t.Errorf("used after Data() = %d, want 0", s.backend.buffers.used)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package smtp
+
+import (
+	"bufio"
+	"cmp"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+)
+
+// calendarName ファイル名が指定されていないiCalendarのパートの添付ファイル名
+const calendarName = "invite.ics"
+
+// isCalendar 会議の招待などのiCalendar（text/calendar）のパートか判定
+func isCalendar(mediaType string) bool {
+	return strings.EqualFold(mediaType, "text/calendar")
+}
+
+// newCalendarAttachment iCalendarのパートを .ics の添付ファイルにする
+// Graphのメッセージには本文を1種類しか設定できないため、受信者のクライアントが招待として表示できるよう添付する。
+// Content-Typeにはmethod（REQUEST、CANCELなど）を残し、内容はUTF-8に変換する
+func newCalendarAttachment(part *multipart.Part, params map[string]string, content []byte) graph.Attachment {
+	text := decodeCharset(params["charset"], content)
+
+	name := calendarName
+	if part != nil && (part.FileName() != "" || params["name"] != "") {
+		name = partFilename(part, "text/calendar")
+	}
+
+	typeParams := map[string]string{"charset": "utf-8"}
+	if method := strings.ToUpper(strings.TrimSpace(cmp.Or(params["method"], calendarMethod(text)))); method != "" {
+		typeParams["method"] = method
+	}
+	return graph.Attachment{
+		Name:        name,
+		ContentType: mime.FormatMediaType("text/calendar", typeParams),
+		Content:     []byte(text),
+	}
+}
+
+// calendarMethod iCalendarのVCALENDARのMETHODプロパティを取得（ない場合は空）
+func calendarMethod(text string) string {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(name, "METHOD") {
+			return value
+		}
+		// METHODはVCALENDARのプロパティのため、最初のコンポーネントより前にある
+		if strings.EqualFold(name, "BEGIN") && !strings.EqualFold(value, "VCALENDAR") {
+			return ""
+		}
+	}
+	return ""
+}
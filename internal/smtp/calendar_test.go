@@ -0,0 +1,101 @@
+package smtp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalendarMethod(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "METHOD", text: "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nMETHOD:REQUEST\r\nBEGIN:VEVENT\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", want: "REQUEST"},
+		{name: "小文字", text: "begin:vcalendar\nmethod:CANCEL\n", want: "CANCEL"},
+		{name: "METHODがない", text: "BEGIN:VCALENDAR\nVERSION:2.0\nEND:VCALENDAR\n"},
+		{name: "コンポーネントの中のMETHOD", text: "BEGIN:VCALENDAR\nBEGIN:VEVENT\nMETHOD:REQUEST\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{name: "空", text: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calendarMethod(tt.text); got != tt.want {
+				t.Errorf("calendarMethod() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBodyCalendar(t *testing.T) {
+	const invite = "BEGIN:VCALENDAR\nMETHOD:REQUEST\nBEGIN:VEVENT\nSUMMARY:Meeting\nEND:VEVENT\nEND:VCALENDAR\n"
+	tests := []struct {
+		name            string
+		message         string
+		wantBody        string
+		wantName        string
+		wantContentType string
+	}{
+		{
+			name:            "招待のみ",
+			message:         "Content-Type: text/calendar; method=request; charset=utf-8\n\n" + invite,
+			wantName:        calendarName,
+			wantContentType: "text/calendar; charset=utf-8; method=REQUEST",
+		},
+		{
+			name: "テキストと招待",
+			message: `Content-Type: multipart/alternative; boundary="b"
+
+--b
+Content-Type: text/plain; charset=utf-8
+
+Please join.
+--b
+Content-Type: text/calendar; charset=utf-8
+
+` + invite + `--b--
+`,
+			wantBody:        "Please join.",
+			wantName:        calendarName,
+			wantContentType: "text/calendar; charset=utf-8; method=REQUEST",
+		},
+		{
+			name: "ファイル名のある招待",
+			message: `Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+See attached.
+--b
+Content-Type: text/calendar; method=CANCEL; name="cancel.ics"
+Content-Disposition: attachment; filename="cancel.ics"
+
+` + strings.Replace(invite, "REQUEST", "CANCEL", 1) + `--b--
+`,
+			wantBody:        "See attached.",
+			wantName:        "cancel.ics",
+			wantContentType: "text/calendar; charset=utf-8; method=CANCEL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := extractBody(readTestMessage(t, tt.message))
+			if err != nil {
+				t.Fatalf("extractBody() error = %v", err)
+			}
+			if content.body != tt.wantBody {
+				t.Errorf("extractBody() body = %q, want %q", content.body, tt.wantBody)
+			}
+			if len(content.attachments) != 1 {
+				t.Fatalf("extractBody() attachments = %d, want 1", len(content.attachments))
+			}
+			attachment := content.attachments[0]
+			if attachment.Name != tt.wantName || attachment.ContentType != tt.wantContentType {
+				t.Errorf("attachment = %q (%s), want %q (%s)", attachment.Name, attachment.ContentType, tt.wantName, tt.wantContentType)
+			}
+			if !strings.Contains(string(attachment.Content), "SUMMARY:Meeting") {
+				t.Errorf("attachment content = %q", attachment.Content)
+			}
+		})
+	}
+}
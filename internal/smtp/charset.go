@@ -0,0 +1,50 @@
+package smtp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// decodeCharset Content-Typeのcharsetに従って本文をUTF-8に変換
+// charsetがない場合やUTF-8の場合、未知のcharsetや変換に失敗した場合はそのまま返す
+func decodeCharset(charset string, data []byte) string {
+	if isUTF8Charset(charset) {
+		return string(data)
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// charsetReader RFC 2047のエンコードされたヘッダーをUTF-8に変換するReaderを返す
+// mime.WordDecoderのCharsetReaderとして使う
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if isUTF8Charset(charset) {
+		return input, nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("未対応のcharset: %s", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// isUTF8Charset 変換が不要なcharsetか判定
+func isUTF8Charset(charset string) bool {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return true
+	}
+	return false
+}
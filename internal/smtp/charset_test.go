@@ -0,0 +1,86 @@
+package smtp
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestCharsetReader(t *testing.T) {
+	const text = "日本語の件名：テスト①"
+	tests := []struct {
+		charset  string
+		encoding encoding.Encoding
+	}{
+		{charset: "ISO-2022-JP", encoding: japanese.ISO2022JP},
+		{charset: "iso-2022-jp", encoding: japanese.ISO2022JP},
+		{charset: "Shift_JIS", encoding: japanese.ShiftJIS},
+		{charset: "EUC-JP", encoding: japanese.EUCJP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.charset, func(t *testing.T) {
+			encoded, err := tt.encoding.NewEncoder().String(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := charsetReader(tt.charset, strings.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("charsetReader() error = %v", err)
+			}
+			if got, err := io.ReadAll(r); err != nil || string(got) != text {
+				t.Errorf("charsetReader() = %q, %v, want %q", got, err, text)
+			}
+
+			// 件名（RFC 2047のエンコードされた単語）
+			subject := "=?" + tt.charset + "?B?" + base64.StdEncoding.EncodeToString([]byte(encoded)) + "?="
+			if got := decodeHeader(subject); got != text {
+				t.Errorf("decodeHeader(%q) = %q, want %q", subject, got, text)
+			}
+
+			// 本文
+			if got := decodeCharset(tt.charset, []byte(encoded)); got != text {
+				t.Errorf("decodeCharset() = %q, want %q", got, text)
+			}
+		})
+	}
+
+	t.Run("未対応のcharset", func(t *testing.T) {
+		if _, err := charsetReader("x-unknown", strings.NewReader("a")); err == nil {
+			t.Error("charsetReader() error = nil, want error")
+		}
+		const subject = "=?x-unknown?B?YQ==?="
+		if got := decodeHeader(subject); got != subject {
+			t.Errorf("decodeHeader(%q) = %q, want unchanged", subject, got)
+		}
+		if got := decodeCharset("x-unknown", []byte("body")); got != "body" {
+			t.Errorf("decodeCharset() = %q, want unchanged", got)
+		}
+	})
+}
+
+func TestDataISO2022JP(t *testing.T) {
+	encode := func(s string) string {
+		encoded, err := japanese.ISO2022JP.NewEncoder().String(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+	sender := &fakeSender{}
+	s := newTestSession(Config{AuthDisabled: true}, sender)
+	err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, "Subject: =?ISO-2022-JP?B?"+
+		base64.StdEncoding.EncodeToString([]byte(encode("お知らせ")))+"?=\n"+
+		"Content-Type: text/plain; charset=ISO-2022-JP\nContent-Transfer-Encoding: 7bit\n\n"+encode("本文です")+"\n")
+	if !isAccepted(err) {
+		t.Fatalf("Data() = %v, want 250", err)
+	}
+	msg := sender.messages[0]
+	if msg.Subject != "お知らせ" || msg.Body != "本文です\r\n" {
+		t.Errorf("subject = %q body = %q, want お知らせ 本文です", msg.Subject, msg.Body)
+	}
+}
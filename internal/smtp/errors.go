@@ -0,0 +1,143 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/emersion/go-smtp"
+)
+
+// acceptedResponse 送信を受け付けた場合の応答（250の応答にMessage-IDを含める）
+// go-smtpはDATAの成功時の応答を変更できないため、コード250のSMTPErrorとして返す
+func acceptedResponse(messageID string) *smtp.SMTPError {
+	return &smtp.SMTPError{
+		Code:         250,
+		EnhancedCode: smtp.EnhancedCode{2, 0, 0},
+		Message:      "OK: queued as " + messageID,
+	}
+}
+
+// sendError Graphの送信エラーを対応するSMTPのエラーに変換
+// スロットリングやタイムアウト、トークンの期限切れなど再送で解決する可能性があるものは4xx、
+// 宛先や権限の問題など再送しても解決しないものは5xxとし、クライアントが再送するか判断できるようにする
+func sendError(err error, from string) *smtp.SMTPError {
+	switch code := graph.ErrorCode(err); {
+	case graph.IsSendAsDenied(err):
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      fmt.Sprintf("Not permitted to send as %s", from),
+		}
+	case code == "ErrorInvalidRecipients" || code == "ErrorRecipientNotFound":
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "Recipient address rejected by Microsoft Graph",
+		}
+	case code == "ErrorMessageSizeExceeded":
+		return &smtp.SMTPError{
+			Code:         552,
+			EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+			Message:      "Message size exceeds the Microsoft Graph limit",
+		}
+	}
+
+	switch status := graph.StatusCode(err); {
+	case status == http.StatusTooManyRequests:
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+			Message:      "Throttled by Microsoft Graph, try again later",
+		}
+	case status == http.StatusUnauthorized:
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+			Message:      "Access token expired, try again later",
+		}
+	case status == http.StatusForbidden:
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Sending not permitted by Microsoft Graph",
+		}
+	case status == http.StatusRequestEntityTooLarge:
+		return &smtp.SMTPError{
+			Code:         552,
+			EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+			Message:      "Message size exceeds the Microsoft Graph limit",
+		}
+	case status == http.StatusRequestTimeout || status >= 500:
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Microsoft Graph temporarily unavailable, try again later",
+		}
+	case status >= 400:
+		return &smtp.SMTPError{
+			Code:         554,
+			EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+			Message:      "Message rejected by Microsoft Graph",
+		}
+	}
+
+	// ステータスコードのないエラー（停止による中断、タイムアウトや接続エラー）は一時的なものとして扱う
+	if errors.Is(err, context.Canceled) {
+		return errShuttingDown
+	}
+	if graph.IsTimeout(err) {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 4, 2},
+			Message:      "Timed out connecting to Microsoft Graph, try again later",
+		}
+	}
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 4, 0},
+		Message:      "Could not reach Microsoft Graph, try again later",
+	}
+}
+
+// IsTemporary Graphの送信エラーが再送で解決する可能性のあるもの（4xxを返すもの）か判定
+func IsTemporary(err error) bool {
+	return sendError(err, "").Code/100 == 4
+}
+
+// errorClass メトリクスに記録するGraphの送信エラーの分類
+func errorClass(err error) string {
+	switch code := graph.ErrorCode(err); {
+	case graph.IsSendAsDenied(err):
+		return "send_as_denied"
+	case code == "ErrorInvalidRecipients" || code == "ErrorRecipientNotFound":
+		return "invalid_recipient"
+	case code == "ErrorMessageSizeExceeded":
+		return "too_large"
+	}
+
+	switch status := graph.StatusCode(err); {
+	case status == http.StatusTooManyRequests:
+		return "throttled"
+	case status == http.StatusUnauthorized:
+		return "unauthorized"
+	case status == http.StatusForbidden:
+		return "forbidden"
+	case status == http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case status == http.StatusRequestTimeout || status >= 500:
+		return "unavailable"
+	case status >= 400:
+		return "rejected"
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	if graph.IsTimeout(err) {
+		return "timeout"
+	}
+	return "network"
+}
@@ -0,0 +1,49 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestSendError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCode  int
+		wantClass string
+	}{
+		{name: "400", err: graphError("BadRequest", 400), wantCode: 554, wantClass: "rejected"},
+		{name: "400 宛先の誤り", err: graphError("ErrorInvalidRecipients", 400), wantCode: 550, wantClass: "invalid_recipient"},
+		{name: "401", err: graphError("InvalidAuthenticationToken", 401), wantCode: 451, wantClass: "unauthorized"},
+		{name: "403", err: graphError("ErrorAccessDenied", 403), wantCode: 550, wantClass: "forbidden"},
+		{name: "403 別のユーザーとしての送信", err: graphError("ErrorSendAsDenied", 403), wantCode: 550, wantClass: "send_as_denied"},
+		{name: "404", err: graphError("ErrorRecipientNotFound", 404), wantCode: 550, wantClass: "invalid_recipient"},
+		{name: "404 その他", err: graphError("ResourceNotFound", 404), wantCode: 554, wantClass: "rejected"},
+		{name: "413", err: graphError("RequestEntityTooLarge", 413), wantCode: 552, wantClass: "too_large"},
+		{name: "サイズ超過のエラーコード", err: graphError("ErrorMessageSizeExceeded", 400), wantCode: 552, wantClass: "too_large"},
+		{name: "429", err: graphError("ApplicationThrottled", 429), wantCode: 451, wantClass: "throttled"},
+		{name: "500", err: graphError("InternalServerError", 500), wantCode: 451, wantClass: "unavailable"},
+		{name: "503", err: graphError("ServiceUnavailable", 503), wantCode: 451, wantClass: "unavailable"},
+		{name: "504", err: graphError("GatewayTimeout", 504), wantCode: 451, wantClass: "unavailable"},
+		{name: "ラップされたエラー", err: fmt.Errorf("送信エラー: %w", graphError("ApplicationThrottled", 429)), wantCode: 451, wantClass: "throttled"},
+		{name: "接続エラー", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, wantCode: 451, wantClass: "network"},
+		{name: "タイムアウト", err: fmt.Errorf("送信エラー: %w", context.DeadlineExceeded), wantCode: 451, wantClass: "timeout"},
+		{name: "停止による中断", err: context.Canceled, wantCode: errShuttingDown.Code, wantClass: "canceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sendError(tt.err, "app@example.com"); got.Code != tt.wantCode {
+				t.Errorf("sendError() = %d %s, want %d", got.Code, got.Message, tt.wantCode)
+			}
+			if got := errorClass(tt.err); got != tt.wantClass {
+				t.Errorf("errorClass() = %q, want %q", got, tt.wantClass)
+			}
+			if got, want := IsTemporary(tt.err), tt.wantCode/100 == 4; got != want {
+				t.Errorf("IsTemporary() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Footer 送信する全てのメッセージの本文の末尾に追加するフッター（免責事項など）
+// テンプレートでは {{.From}}（送信元のアドレス）、{{.Subject}}（件名）、{{.Date}}（送信日）を使用できる
+type Footer struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+
+	// htmlSource, textSource 解析前のテンプレート（設定の変更の確認に使う）
+	htmlSource string
+	textSource string
+}
+
+// footerData フッターのテンプレートに渡す値
+type footerData struct {
+	From    string
+	Subject string
+	Date    string
+}
+
+// ParseFooter HTMLとテキストのフッターのテンプレートを解析（どちらも空の場合はnil）
+// HTMLのフッターでは値をエスケープする
+func ParseFooter(htmlFooter, textFooter string) (*Footer, error) {
+	if htmlFooter == "" && textFooter == "" {
+		return nil, nil
+	}
+	footer := &Footer{htmlSource: htmlFooter, textSource: textFooter}
+	if htmlFooter != "" {
+		tmpl, err := htmltemplate.New("footer_html").Option("missingkey=error").Parse(htmlFooter)
+		if err != nil {
+			return nil, fmt.Errorf("footer_html の解析エラー: %w", err)
+		}
+		footer.html = tmpl
+	}
+	if textFooter != "" {
+		tmpl, err := texttemplate.New("footer_text").Option("missingkey=error").Parse(textFooter)
+		if err != nil {
+			return nil, fmt.Errorf("footer_text の解析エラー: %w", err)
+		}
+		footer.text = tmpl
+	}
+	return footer, nil
+}
+
+// Equal 同じテンプレートのフッターか判定（どちらもnilの場合はtrue）
+func (f *Footer) Equal(other *Footer) bool {
+	if f == nil || other == nil {
+		return f == other
+	}
+	return f.htmlSource == other.htmlSource && f.textSource == other.textSource
+}
+
+// apply 本文の種類に応じたフッターを追加する
+// HTMLの本文は </body> の前（なければ末尾）に追加し、テキストの代替本文にはテキストのフッターを追加する
+// 添付ファイルには触れないため、multipart/relatedのインライン画像の cid: 参照はそのまま使える
+func (f *Footer) apply(content *messageContent, data footerData) error {
+	if content.isHTML {
+		if f.html != nil {
+			var b bytes.Buffer
+			if err := f.html.Execute(&b, data); err != nil {
+				return fmt.Errorf("HTMLのフッターの生成エラー: %w", err)
+			}
+			content.body = insertHTMLFooter(content.body, b.String())
+		}
+		if content.text == "" {
+			return nil
+		}
+	}
+	if f.text == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := f.text.Execute(&b, data); err != nil {
+		return fmt.Errorf("テキストのフッターの生成エラー: %w", err)
+	}
+	if content.isHTML {
+		content.text = appendTextFooter(content.text, b.String())
+	} else {
+		content.body = appendTextFooter(content.body, b.String())
+	}
+	return nil
+}
+
+// newFooterData フッターのテンプレートに渡す値を作成
+func newFooterData(from, subject string) footerData {
+	return footerData{
+		From:    from,
+		Subject: subject,
+		Date:    time.Now().Format(time.DateOnly),
+	}
+}
+
+// insertHTMLFooter HTMLの </body> の前にフッターを挿入（</body> がなければ末尾に追加）
+func insertHTMLFooter(body, footer string) string {
+	if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+		return body[:i] + footer + body[i:]
+	}
+	return body + footer
+}
+
+// appendTextFooter テキストの本文の後に空行を挟んでフッターを追加
+func appendTextFooter(body, footer string) string {
+	return strings.TrimRight(body, "\r\n") + "\n\n" + footer
+}
@@ -0,0 +1,134 @@
+package smtp
+
+import "testing"
+
+func TestParseFooter(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		text    string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "フッターなし", wantNil: true},
+		{name: "HTMLのみ", html: "<p>{{.From}}</p>"},
+		{name: "テキストのみ", text: "-- {{.From}}"},
+		{name: "HTMLの構文エラー", html: "{{.From", wantErr: true},
+		{name: "テキストの構文エラー", text: "{{if}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			footer, err := ParseFooter(tt.html, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFooter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (footer == nil) != tt.wantNil {
+				t.Errorf("ParseFooter() = %v, wantNil %v", footer, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestFooterApply(t *testing.T) {
+	data := footerData{From: "app@example.com", Subject: "<Report>", Date: "2026-01-02"}
+	tests := []struct {
+		name     string
+		html     string
+		text     string
+		content  messageContent
+		wantBody string
+		wantText string
+		wantErr  bool
+	}{
+		{
+			name:     "テキストの本文",
+			html:     "<p>HTML</p>",
+			text:     "Sent by {{.From}} on {{.Date}}",
+			content:  messageContent{body: "Hello\r\n\r\n"},
+			wantBody: "Hello\n\nSent by app@example.com on 2026-01-02",
+		},
+		{
+			name:     "HTMLの本文は</body>の前",
+			html:     "<p>{{.Subject}}</p>",
+			content:  messageContent{body: "<html><body><p>Hello</p></BODY></html>", isHTML: true},
+			wantBody: "<html><body><p>Hello</p><p>&lt;Report&gt;</p></BODY></html>",
+		},
+		{
+			name:     "</body>がないHTML",
+			html:     "<hr>",
+			content:  messageContent{body: "<p>Hello</p>", isHTML: true},
+			wantBody: "<p>Hello</p><hr>",
+		},
+		{
+			name:     "HTMLとテキストの代替本文",
+			html:     "<hr>",
+			text:     "-- {{.Subject}}",
+			content:  messageContent{body: "<p>Hello</p>", isHTML: true, text: "Hello\n"},
+			wantBody: "<p>Hello</p><hr>",
+			wantText: "Hello\n\n-- <Report>",
+		},
+		{
+			name:     "HTMLのフッターがないHTML",
+			text:     "-- footer",
+			content:  messageContent{body: "<p>Hello</p>", isHTML: true},
+			wantBody: "<p>Hello</p>",
+		},
+		{
+			name:     "テキストのフッターがないテキスト",
+			html:     "<hr>",
+			content:  messageContent{body: "Hello"},
+			wantBody: "Hello",
+		},
+		{
+			name:    "存在しない値",
+			text:    "{{.To}}",
+			content: messageContent{body: "Hello"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			footer, err := ParseFooter(tt.html, tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content := tt.content
+			err = footer.apply(&content, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if content.body != tt.wantBody {
+				t.Errorf("apply() body = %q, want %q", content.body, tt.wantBody)
+			}
+			if content.text != tt.wantText {
+				t.Errorf("apply() text = %q, want %q", content.text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestFooterEqual(t *testing.T) {
+	a, _ := ParseFooter("<hr>", "--")
+	b, _ := ParseFooter("<hr>", "--")
+	c, _ := ParseFooter("<hr>", "")
+	tests := []struct {
+		name  string
+		f, o  *Footer
+		equal bool
+	}{
+		{name: "同じテンプレート", f: a, o: b, equal: true},
+		{name: "異なるテンプレート", f: a, o: c},
+		{name: "一方がnil", f: a, o: nil},
+		{name: "両方nil", f: nil, o: nil, equal: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.f.Equal(tt.o); got != tt.equal {
+				t.Errorf("Equal() = %v, want %v", got, tt.equal)
+			}
+		})
+	}
+}
@@ -2,17 +2,25 @@ package smtp
 
 import (
 	"bufio"
-	"bytes"
+	"cmp"
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
 	"net/mail"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/canaria-computer/m3bridge/internal/markdown"
+	"github.com/canaria-computer/m3bridge/internal/metrics"
+	"github.com/canaria-computer/m3bridge/internal/queue"
 	"github.com/charmbracelet/log"
 	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
@@ -20,31 +28,133 @@ import (
 
 // Backend SMTPバックエンド
 type Backend struct {
-	graphClient *graph.Client
-	username    string
-	password    string
-	logger      *log.Logger
+	sender   MailSender
+	username string
+	password string
+	relay    *Relay
+	queue    *queue.Queue
+	logger   *log.Logger
+	// ctx セッションのコンテキストの元（停止の待機時間を過ぎるとキャンセルし、処理中の送信を中断する）
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	allowedMailboxes   []string
+	sendAs             string
+	authDisabled       bool
+	passthroughHeaders []string
+	textAlternative    TextAlternativeMode
+	footer             *Footer
+	recipients         RecipientPolicy
+	dryRun             bool
+	xoauth2            XOAuth2Mode
+	recent             *RecentMessages
+	buffers            *bufferBudget
+	// sessions 処理中のセッション数を制限するセマフォ（nilの場合は無制限）
+	sessions chan struct{}
+	// maxReceived, rejectAutoSubmitted メールループとみなすReceivedヘッダーの数とAuto-Submittedの値
+	maxReceived         int
+	rejectAutoSubmitted []string
+
+	// shuttingDown 停止中は新しいメッセージを受け付けない（送信中のDATAは完了させる）
+	shuttingDown atomic.Bool
 }
 
-// NewBackend 新しいバックエンドを作成
-func NewBackend(graphClient *graph.Client, username, password string, logger *log.Logger) *Backend {
+// errAuthRequired 認証前のMAIL/RCPT/DATAに返すエラー
+var errAuthRequired = &smtp.SMTPError{
+	Code:         530,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+	Message:      "Authentication required",
+}
+
+// errTooManyConnections 同時に処理するセッション数の上限に達した場合のエラー
+var errTooManyConnections = &smtp.SMTPError{
+	Code:         421,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Too many connections, try again later",
+}
+
+// errMailLoop メールループの可能性があるメッセージのエラー
+var errMailLoop = &smtp.SMTPError{
+	Code:         554,
+	EnhancedCode: smtp.EnhancedCode{5, 4, 6},
+	Message:      "Mail loop detected",
+}
+
+// errShuttingDown 停止中に新しいメッセージを受け付けない場合のエラー
+var errShuttingDown = &smtp.SMTPError{
+	Code:         421,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Service shutting down, try again later",
+}
+
+// NewBackend 新しいバックエンドを作成（senderは通常 GraphSender）
+func NewBackend(sender MailSender, username, password string, logger *log.Logger) *Backend {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Backend{
-		graphClient: graphClient,
-		username:    username,
-		password:    password,
-		logger:      logger,
+		sender:   sender,
+		username: username,
+		password: password,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+		buffers:  &bufferBudget{},
 	}
 }
 
 // NewSession 新しいSMTPセッションを作成
 func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
-	b.logger.Debug("新しいSMTPセッション開始")
+	// 許可リストにない接続元は、挨拶を送る前にリスナー（allowedListener）で切断している
+	remote := c.Conn().RemoteAddr()
+	logger := b.logger.With("remote", remoteName(remote))
+
+	// セッションはEHLO/HELOで作成され、Logoutで枠を返す
+	release := func() {}
+	if b.sessions != nil {
+		select {
+		case b.sessions <- struct{}{}:
+			var once sync.Once
+			release = func() { once.Do(func() { <-b.sessions }) }
+		default:
+			logger.Warn("同時セッション数の上限に達したため接続を拒否しました", "max_connections", cap(b.sessions))
+			metrics.SessionsRejected.Inc()
+			return nil, errTooManyConnections
+		}
+	}
+
+	logger.Debug("新しいSMTPセッション開始")
+	ctx, cancel := context.WithCancel(b.ctx)
 	return &Session{
-		backend: b,
-		logger:  b.logger,
+		backend:  b,
+		ctx:      ctx,
+		cancel:   cancel,
+		release:  release,
+		logger:   logger,
+		loopback: isLoopback(remote),
 	}, nil
 }
 
+// isLoopback ループバックアドレスまたはUnixドメインソケットからの接続か判定
+func isLoopback(addr net.Addr) bool {
+	if isUnixSocket(addr) {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return tcpAddr.IP.IsLoopback()
+}
+
+// mailboxAllowed 許可リストに含まれるメールボックスか判定
+func (b *Backend) mailboxAllowed(mailbox string) bool {
+	for _, allowed := range b.allowedMailboxes {
+		if strings.EqualFold(allowed, mailbox) {
+			return true
+		}
+	}
+	return false
+}
+
 // Session SMTPセッション
 type Session struct {
 	backend       *Backend
@@ -52,6 +162,16 @@ type Session struct {
 	to            []string
 	logger        *log.Logger
 	authenticated bool
+	loopback      bool
+
+	// ctx 送信に使うコンテキスト（接続が閉じられるか、停止の待機時間を過ぎるとキャンセルされる）
+	ctx    context.Context
+	cancel context.CancelFunc
+	// release 同時セッション数の枠を返す
+	release func()
+
+	// tokenSender XOAUTH2のパススルーで認証したユーザーのクライアント（nilの場合はバックエンドのもの）
+	tokenSender MailSender
 }
 
 // Reset セッションをリセット
@@ -62,42 +182,124 @@ func (s *Session) Reset() {
 }
 
 // Logout セッションを終了
+// 停止時に残りの接続を切断した場合も呼ばれ、処理中の送信を中断する
 func (s *Session) Logout() error {
 	s.logger.Debug("セッション終了")
+	s.cancel()
+	s.release()
 	return nil
 }
 
 // AuthMechanisms サポートする認証メカニズムを返す
 func (s *Session) AuthMechanisms() []string {
-	return []string{sasl.Plain}
+	if s.backend.xoauth2 != XOAuth2Disabled {
+		return []string{sasl.Plain, sasl.Login, xoauth2Mechanism}
+	}
+	return []string{sasl.Plain, sasl.Login}
 }
 
 // Auth 認証を実行
 func (s *Session) Auth(mech string) (sasl.Server, error) {
-	if mech != sasl.Plain {
-		return nil, fmt.Errorf("unsupported auth mechanism")
-	}
-	
-	return sasl.NewPlainServer(func(identity, username, password string) error {
-		if username != s.backend.username || password != s.backend.password {
-			s.logger.Warn("認証失敗", "username", username)
-			return fmt.Errorf("invalid credentials")
+	switch mech {
+	case sasl.Plain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			return s.checkCredentials(mech, username, password)
+		}), nil
+	case sasl.Login:
+		// 古いクライアントや複合機などPLAINに対応していないクライアント向け
+		return newLoginServer(func(username, password string) error {
+			return s.checkCredentials(mech, username, password)
+		}), nil
+	case xoauth2Mechanism:
+		if s.backend.xoauth2 != XOAuth2Disabled {
+			return newXOAuth2Server(s.checkXOAuth2), nil
 		}
-		s.logger.Debug("認証成功", "username", username)
-		s.authenticated = true
+	}
+	return nil, fmt.Errorf("unsupported auth mechanism")
+}
+
+// checkCredentials ユーザー名とパスワードを確認
+func (s *Session) checkCredentials(mech, username, password string) error {
+	if username != s.backend.username || password != s.backend.password {
+		s.logger.Warn("認証失敗", "username", username, "mechanism", mech)
+		// 535を返す（SMTPError以外では454となり、クライアントが一時的なエラーとして再試行する）
+		return smtp.ErrAuthFailed
+	}
+	s.logger.Debug("認証成功", "username", username, "mechanism", mech)
+	s.authenticated = true
+	return nil
+}
+
+// requireAuth 認証済みでなければエラーを返す（認証が無効の場合は常に許可）
+func (s *Session) requireAuth() error {
+	if s.authenticated || s.backend.authDisabled {
 		return nil
-	}), nil
+	}
+	return errAuthRequired
 }
 
 // Mail 送信者を設定
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
+	if s.backend.shuttingDown.Load() {
+		s.logger.Debug("停止中のためMAIL FROMを拒否しました", "from", from)
+		return errShuttingDown
+	}
+	if err := s.requireAuth(); err != nil {
+		s.logger.Warn("認証前のMAIL FROMを拒否しました", "from", from)
+		return err
+	}
+	if from != "" && !validAddress(from) {
+		s.logger.Warn("不正な送信者アドレスを拒否しました", "from", from)
+		return &smtp.SMTPError{
+			Code:         553,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 7},
+			Message:      "Invalid sender address",
+		}
+	}
+	// SIZEの上限はgo-smtpがEHLOで広告し、超える宣言には552を返す。
+	// 宣言されたサイズを全セッションの保持上限から確保できない場合は、本文を受け取る前に一時エラーを返す
+	size := sizeOf(opts)
+	if size > 0 && !s.backend.buffers.available(size) {
+		s.logger.Warn("宣言されたサイズのメッセージを保持できません", "from", from, "size", size)
+		return errInsufficientStorage
+	}
 	s.from = from
-	s.logger.Debug("送信者設定", "from", from)
+	s.logger.Debug("送信者設定", "from", from, "size", size)
 	return nil
 }
 
+// sizeOf MAIL FROMのSIZEパラメーターで宣言されたサイズ（指定がない場合は0）
+func sizeOf(opts *smtp.MailOptions) int64 {
+	if opts == nil {
+		return 0
+	}
+	return opts.Size
+}
+
 // Rcpt 受信者を追加
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	if s.backend.shuttingDown.Load() {
+		return errShuttingDown
+	}
+	if err := s.requireAuth(); err != nil {
+		return err
+	}
+	if !validAddress(to) {
+		s.logger.Warn("不正な受信者アドレスを拒否しました", "to", to)
+		return &smtp.SMTPError{
+			Code:         553,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 3},
+			Message:      "Invalid recipient address",
+		}
+	}
+	if reason, ok := s.backend.recipients.check(to); !ok {
+		s.logger.Info("許可されていない受信者を拒否しました", "from", s.from, "to", to, "rule", reason)
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Recipient not allowed",
+		}
+	}
 	s.to = append(s.to, to)
 	s.logger.Debug("受信者追加", "to", to)
 	return nil
@@ -105,64 +307,268 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 
 // Data メールデータを受信して送信
 func (s *Session) Data(r io.Reader) error {
+	if err := s.requireAuth(); err != nil {
+		return err
+	}
 	s.logger.Debug("メールデータ受信開始")
 
+	// リレーへのフォールバックに備えて生データを保持（大きなメッセージは一時ファイルに書き出す）
+	// 全セッションで保持する合計サイズを制限し、送信が終わるまで確保したままにする
+	br := &budgetReader{r: r, budget: s.backend.buffers}
+	defer br.Release()
+	raw := &spool{}
+	defer raw.Close()
+	if _, err := io.Copy(raw, br); err != nil {
+		// サイズ超過などのSMTPエラーはそのままクライアントに返す（go-smtpはラップされたエラーを認識しない）
+		var smtpErr *smtp.SMTPError
+		if errors.As(err, &smtpErr) {
+			s.logger.Warn("メッセージを受け付けられません", "code", smtpErr.Code, "error", smtpErr.Message)
+			metrics.MessagesFailed.Inc("limit")
+			return smtpErr
+		}
+		s.logger.Error("メッセージ読み込みエラー", "error", err)
+		metrics.MessagesFailed.Inc("read")
+		return fmt.Errorf("メッセージ読み込みエラー: %w", err)
+	}
+	metrics.MessagesReceived.Inc()
+
 	// メッセージをパース
-	msg, err := mail.ReadMessage(r)
+	msg, err := mail.ReadMessage(bufio.NewReader(raw.Reader()))
 	if err != nil {
 		s.logger.Error("メッセージパースエラー", "error", err)
+		metrics.MessagesFailed.Inc("parse")
 		return fmt.Errorf("メッセージパースエラー: %w", err)
 	}
 
 	// ヘッダーを解析
-	subject := decodeHeader(msg.Header.Get("Subject"))
+	// デコード後の件名に改行が含まれる場合があるため取り除く
+	subject := sanitizeHeaderValue(decodeHeader(msg.Header.Get("Subject")))
 	s.logger.Debug("メッセージ解析", "subject", subject, "from", s.from, "to_count", len(s.to))
 
-	// CC受信者を取得
-	var ccAddresses []string
-	if ccHeader := msg.Header.Get("Cc"); ccHeader != "" {
-		ccList, err := mail.ParseAddressList(ccHeader)
-		if err == nil {
-			for _, addr := range ccList {
-				ccAddresses = append(ccAddresses, addr.Address)
-			}
-		}
+	// 自動応答などがブリッジに送り返されるループを防ぐ
+	if reason, loop := s.backend.detectLoop(msg.Header); loop {
+		s.logger.Warn("メールループの可能性があるためメッセージを拒否しました",
+			"reason", reason,
+			"received", len(msg.Header["Received"]),
+			"auto_submitted", autoSubmitted(msg.Header),
+			"from", s.from,
+			"subject", subject)
+		metrics.MessagesFailed.Inc("loop")
+		return errMailLoop
+	}
+
+	// 封筒の受信者をヘッダーのTo/Ccに従って振り分ける（ヘッダーにない受信者はBCC）
+	toAddresses, ccAddresses, bccAddresses := splitRecipients(s.to, parseAddresses(msg.Header.Get("To")), parseAddresses(msg.Header.Get("Cc")))
+
+	// 返信先を取得（不正な値の場合は無視して送信を続ける）
+	replyTo := parseAddresses(msg.Header.Get("Reply-To"))
+	if header := msg.Header.Get("Reply-To"); header != "" && len(replyTo) == 0 {
+		s.logger.Warn("Reply-Toヘッダーを解析できないため無視します", "reply_to", header)
 	}
 
-	// メール本文を抽出
-	body, isHTML, err := extractBody(msg)
+	// メール本文と添付ファイルを抽出
+	content, err := extractBody(msg)
 	if err != nil {
 		s.logger.Warn("本文抽出エラー、デフォルトテキストで送信", "error", err)
-		body = "（本文を抽出できませんでした）"
-		isHTML = false
+		content.body = "（本文を抽出できませんでした）"
+		content.isHTML = false
 	}
 
-	s.logger.Debug("本文抽出完了", "length", len(body), "isHTML", isHTML)
+	// X-Content-Format: markdown の場合はテキストの本文をHTMLに変換する
+	if isMarkdown(msg.Header) && !content.isHTML {
+		content.text = content.body
+		content.body = markdown.ToHTML(content.body)
+		content.isHTML = true
+	}
+
+	s.logger.Debug("本文抽出完了", "length", len(content.body), "isHTML", content.isHTML, "attachments", len(content.attachments))
 
-	// Microsoft Graphで送信
-	ctx := context.Background()
-	if len(ccAddresses) > 0 {
-		err = s.backend.graphClient.SendMailWithMultipleRecipients(ctx, s.to, ccAddresses, subject, body, isHTML)
-	} else {
-		if len(s.to) == 0 {
-			return fmt.Errorf("受信者が指定されていません")
+	if s.backend.recent != nil {
+		bodyType := "text"
+		if content.isHTML {
+			bodyType = "html"
 		}
-		// 単一受信者の場合（後方互換性）
-		err = s.backend.graphClient.SendMail(ctx, s.to[0], subject, body, isHTML)
+		s.backend.recent.Add(MessageRecord{
+			ReceivedAt: time.Now(),
+			From:       s.from,
+			To:         append([]string(nil), s.to...),
+			Cc:         ccAddresses,
+			Subject:    subject,
+			BodyType:   bodyType,
+			Size:       int(raw.Size()),
+		})
+	}
+
+	// 送信元メールボックスを決定
+	sender := s.client()
+	var mailbox string
+	if override := strings.TrimSpace(msg.Header.Get("X-M3Bridge-Mailbox")); override != "" {
+		if s.mailboxOverrideAllowed(override) {
+			s.logger.Debug("送信元メールボックスを上書き", "mailbox", override)
+			mailbox = override
+		} else {
+			s.logger.Warn("許可されていないメールボックス指定、デフォルトで送信します", "mailbox", override)
+		}
+	}
+
+	if len(s.to) == 0 {
+		metrics.MessagesFailed.Inc("no_recipients")
+		return fmt.Errorf("受信者が指定されていません")
+	}
+
+	from := s.sendAsAddress()
+
+	// フッターを追加してから、テキストの代替本文を添付する
+	if s.backend.footer != nil {
+		sender := cmp.Or(from, mailbox, s.from)
+		if err := s.backend.footer.apply(content, newFooterData(sender, subject)); err != nil {
+			s.logger.Warn("フッターを追加できません", "error", err)
+		}
+	}
+	if attachment, ok := textAlternative(s.backend.textAlternative, content); ok {
+		content.attachments = append(content.attachments, attachment)
+	}
+
+	// sendMailはGraphが割り当てたMessage-IDを返さないため、クライアントが指定していなければ生成して設定する
+	// 250の応答で返し、クライアントがSMTPの送信と配信されたメールを対応付けられるようにする
+	messageID := parseMessageID(msg.Header.Get("Message-ID"))
+	if messageID == "" {
+		messageID = newMessageID(cmp.Or(from, mailbox, s.from))
+	}
+
+	// Microsoft Graphで送信（停止時に接続が閉じられた場合は中断する）
+	ctx := s.ctx
+	message := &graph.Message{
+		From:        from,
+		To:          toAddresses,
+		Cc:          ccAddresses,
+		Bcc:         bccAddresses,
+		Subject:     subject,
+		Body:        content.body,
+		IsHTML:      content.isHTML,
+		Attachments: content.attachments,
+
+		ReplyTo:                  replyTo,
+		DisplayNames:             displayNames(msg.Header, "From", "To", "Cc", "Bcc", "Reply-To"),
+		Importance:               parseImportance(msg.Header),
+		ReadReceiptRequested:     hasHeader(msg.Header, "Disposition-Notification-To"),
+		DeliveryReceiptRequested: hasHeader(msg.Header, "Return-Receipt-To"),
+		SentAt:                   parseDate(msg.Header),
+		InternetMessageID:        messageID,
+		Headers:                  passthroughHeaders(msg.Header, s.backend.passthroughHeaders),
+		SaveToSentItems:          parseSaveToSent(msg.Header),
 	}
 
+	// ドライランの場合は送信せず、送信する内容をログに記録して受け付ける
+	if s.backend.dryRun || isDryRun(msg.Header) {
+		s.logger.Info("ドライランのため送信しません",
+			"message_id", messageID,
+			"from", from,
+			"mailbox", mailbox,
+			"to", message.To,
+			"cc", message.Cc,
+			"bcc", message.Bcc,
+			"subject", subject,
+			"is_html", message.IsHTML,
+			"body_bytes", len(message.Body),
+			"attachments", len(message.Attachments))
+		return acceptedResponse(messageID)
+	}
+
+	err = sender.SendMessageFrom(ctx, mailbox, message)
+
 	if err != nil {
 		s.logger.Error("メール送信失敗", "error", err)
-		return fmt.Errorf("メール送信失敗: %w", err)
+		smtpErr := sendError(err, from)
+		class := errorClass(err)
+		if from != "" && graph.IsSendAsDenied(err) {
+			// 権限の問題はリレーに転送しても解決しないため、そのままクライアントに返す
+			s.logger.Error("送信元として送信する権限がありません", "from", from)
+			metrics.MessagesFailed.Inc(class)
+			return smtpErr
+		}
+
+		// Graphが一時的に利用できない場合は上流SMTPリレーへフォールバック（Graphのクライアントは再試行済み）
+		// 宛先の誤りなど再送しても解決しないエラーは、リレーに転送せずクライアントに返す
+		if s.backend.relay != nil && IsTemporary(err) {
+			s.logger.Warn("Graph送信失敗、SMTPリレーへフォールバックします", "error", err)
+			// Bccヘッダーはアドレスが漏れないよう、リレーへ転送する前に削除する
+			relayErr := s.backend.relay.Send(s.from, s.to, raw.withoutHeader("Bcc"))
+			if relayErr == nil {
+				metrics.MessagesRelayed.Inc()
+				return acceptedResponse(messageID)
+			}
+			s.logger.Error("SMTPリレーへの転送失敗", "error", relayErr)
+		}
+
+		// 一時的なエラーの場合は再送キューに保存して受け付ける
+		// XOAUTH2のpassthroughではクライアントのトークンを保存できないため、キューには入れない
+		if s.backend.queue != nil && s.tokenSender == nil && IsTemporary(err) {
+			_, queueErr := s.backend.queue.Enqueue(mailbox, message, err)
+			if queueErr == nil {
+				metrics.MessagesQueued.Inc()
+				return acceptedResponse(messageID)
+			}
+			s.logger.Error("再送キューへの保存失敗", "error", queueErr)
+		}
+
+		s.logger.Debug("SMTPのエラーとして返します", "code", smtpErr.Code, "message", smtpErr.Message)
+		metrics.MessagesFailed.Inc(class)
+		return smtpErr
 	}
 
-	s.logger.Info("メール送信成功", "subject", subject, "to_count", len(s.to), "cc_count", len(ccAddresses))
-	return nil
+	s.logger.Info("メール送信成功", "subject", subject, "message_id", messageID, "to_count", len(toAddresses), "cc_count", len(ccAddresses), "bcc_count", len(bccAddresses))
+	metrics.MessagesSent.Inc()
+	return acceptedResponse(messageID)
+}
+
+// splitRecipients 封筒の受信者をTo/Cc/BCCに振り分ける
+// 配信先は封筒（RCPT TO）の受信者のみとし、重複は1つにまとめる。
+// ヘッダーのToにある受信者はTo、Ccにある受信者はCc（両方にある場合はTo）、どちらにもない受信者はBCCとする。
+// To/Ccヘッダーがどちらもない場合は全員をToとする
+func splitRecipients(envelope, headerTo, headerCc []string) (to, cc, bcc []string) {
+	var seen []string
+	for _, rcpt := range envelope {
+		if containsAddress(seen, rcpt) {
+			continue
+		}
+		seen = append(seen, rcpt)
+
+		switch {
+		case len(headerTo) == 0 && len(headerCc) == 0:
+			to = append(to, rcpt)
+		case containsAddress(headerTo, rcpt):
+			to = append(to, rcpt)
+		case containsAddress(headerCc, rcpt):
+			cc = append(cc, rcpt)
+		default:
+			bcc = append(bcc, rcpt)
+		}
+	}
+	return to, cc, bcc
+}
+
+// sendAsAddress 送信元（From）に設定するアドレスを決定
+// 封筒のMAIL FROMが許可されたメールボックスであればそれを、なければ設定のsend_asを使用する
+func (s *Session) sendAsAddress() string {
+	if s.from != "" && s.mailboxOverrideAllowed(s.from) {
+		return s.from
+	}
+	return s.backend.sendAs
+}
+
+// mailboxOverrideAllowed X-M3Bridge-Mailboxヘッダーによる上書きを許可するか判定
+// 認証済みまたはループバックからのセッションで、かつ許可リストに含まれる場合のみ許可する
+func (s *Session) mailboxOverrideAllowed(mailbox string) bool {
+	if !s.authenticated && !s.loopback {
+		return false
+	}
+	return s.backend.mailboxAllowed(mailbox)
 }
 
 // decodeHeader MIMEエンコードされたヘッダーをデコード
 func decodeHeader(header string) string {
-	dec := new(mime.WordDecoder)
+	dec := &mime.WordDecoder{CharsetReader: charsetReader}
 	decoded, err := dec.DecodeHeader(header)
 	if err != nil {
 		return header
@@ -170,141 +576,151 @@ func decodeHeader(header string) string {
 	return decoded
 }
 
-// extractBody メール本文を抽出
-func extractBody(msg *mail.Message) (string, bool, error) {
+// extractBody メール本文と添付ファイルを抽出
+// 本文が見つからない場合もエラーとともに抽出できた添付ファイルを返す
+func extractBody(msg *mail.Message) (*messageContent, error) {
 	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
 	if err != nil {
 		// Content-Typeがない場合、本文全体を読み取る
 		bodyBytes, err := io.ReadAll(msg.Body)
 		if err != nil {
-			return "", false, err
+			return &messageContent{}, err
 		}
-		return string(bodyBytes), false, nil
+		return &messageContent{body: string(bodyBytes)}, nil
 	}
 
 	// マルチパートの場合
 	if strings.HasPrefix(mediaType, "multipart/") {
-		return extractMultipartBody(msg.Body, params["boundary"])
+		return extractMultipartBody(msg.Body, params["boundary"], mediaType == "multipart/related")
 	}
 
 	// シングルパートの場合
 	bodyBytes, err := io.ReadAll(msg.Body)
 	if err != nil {
-		return "", false, err
+		return &messageContent{}, err
 	}
 
-	// Content-Transfer-Encodingを処理
-	encoding := msg.Header.Get("Content-Transfer-Encoding")
-	bodyText := string(bodyBytes)
-
-	if strings.EqualFold(encoding, "base64") {
-		decoded, err := base64.StdEncoding.DecodeString(bodyText)
-		if err == nil {
-			bodyText = string(decoded)
-		}
-	} else if strings.EqualFold(encoding, "quoted-printable") {
-		bodyText = decodeQuotedPrintable(bodyText)
+	// Content-Transfer-Encodingを処理し、charsetに従ってUTF-8に変換
+	decoded := decodeTransferEncoding(msg.Header.Get("Content-Transfer-Encoding"), bodyBytes)
+	if isCalendar(mediaType) {
+		// 招待のみのメッセージは本文を空にして .ics を添付する
+		return &messageContent{attachments: []graph.Attachment{newCalendarAttachment(nil, params, decoded)}}, nil
 	}
+	bodyText := decodeCharset(params["charset"], decoded)
 
 	isHTML := strings.HasPrefix(mediaType, "text/html")
-	return bodyText, isHTML, nil
+	return &messageContent{body: bodyText, isHTML: isHTML}, nil
 }
 
 // extractMultipartBody マルチパート本文を抽出
-func extractMultipartBody(body io.Reader, boundary string) (string, bool, error) {
-	mr := multipart.NewReader(body, boundary)
+// 本文にならないパートは添付ファイルとして収集し、ネストされたマルチパートも再帰的に処理する
+// related はメッセージ全体がmultipart/relatedか
+func extractMultipartBody(body io.Reader, boundary string, related bool) (*messageContent, error) {
+	content := &messageContent{}
+	var parts bodyParts
+	if err := walkMultipart(body, boundary, related, 0, content, &parts); err != nil {
+		return content, err
+	}
+
+	// HTMLが優先、なければテキスト
+	if parts.html != "" {
+		content.body, content.isHTML, content.text = parts.html, true, parts.text
+		return content, nil
+	}
+	if parts.text != "" {
+		content.body = parts.text
+		return content, nil
+	}
+	if parts.calendar {
+		return content, nil
+	}
+
+	return content, fmt.Errorf("本文が見つかりません")
+}
 
-	var textPart, htmlPart string
+// bodyParts マルチパートから見つかった本文の候補
+type bodyParts struct {
+	text string
+	html string
+	// calendar iCalendarのパートがあったか（招待のみのメッセージは本文が空でもよい）
+	calendar bool
+}
+
+// walkMultipart マルチパートの各パートを処理する
+// related はmultipart/relatedの中のパートか（Content-IDのあるパートをインライン添付にする）
+func walkMultipart(body io.Reader, boundary string, related bool, depth int, content *messageContent, parts *bodyParts) error {
+	mr := multipart.NewReader(body, boundary)
 
 	for {
 		part, err := mr.NextPart()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			return "", false, err
+			return err
 		}
 
 		contentType := part.Header.Get("Content-Type")
-		mediaType, _, _ := mime.ParseMediaType(contentType)
+		mediaType, params, _ := mime.ParseMediaType(contentType)
 
-		partBytes, err := io.ReadAll(part)
-		if err != nil {
+		// ネストされたマルチパート（multipart/alternativeやmultipart/relatedなど）
+		if strings.HasPrefix(mediaType, "multipart/") {
+			if depth >= maxMultipartDepth || params["boundary"] == "" {
+				continue
+			}
+			if err := walkMultipart(part, params["boundary"], mediaType == "multipart/related", depth+1, content, parts); err != nil {
+				return err
+			}
 			continue
 		}
 
-		// Content-Transfer-Encodingを処理
 		encoding := part.Header.Get("Content-Transfer-Encoding")
-		partText := string(partBytes)
 
-		if strings.EqualFold(encoding, "base64") {
-			decoded, err := base64.StdEncoding.DecodeString(partText)
-			if err == nil {
-				partText = string(decoded)
+		// 添付ファイルと添付として転送されたメッセージは、エンコードされた内容を保持せずにデコードする
+		// 会議の招待は本文と同じく読み込んでからデコードする（添付ファイルとして送られた場合も method を残す）
+		if isCalendar(mediaType) {
+			partBytes, err := io.ReadAll(part)
+			if err != nil {
+				continue
+			}
+			content.attachments = append(content.attachments, newCalendarAttachment(part, params, decodeTransferEncoding(encoding, partBytes)))
+			parts.calendar = true
+			continue
+		}
+		if isForwardedMessage(mediaType) || isAttachmentPart(part, mediaType) {
+			decoded, err := io.ReadAll(transferDecoder(encoding, part))
+			if err != nil {
+				continue
+			}
+			if isForwardedMessage(mediaType) {
+				content.attachments = append(content.attachments, newForwardedAttachment(part, decoded))
+			} else {
+				content.attachments = append(content.attachments, newAttachment(part, mediaType, decoded, related))
 			}
-		} else if strings.EqualFold(encoding, "quoted-printable") {
-			partText = decodeQuotedPrintable(partText)
+			continue
 		}
 
-		// パートタイプに応じて保存
-		if strings.HasPrefix(mediaType, "text/plain") {
-			textPart = partText
-		} else if strings.HasPrefix(mediaType, "text/html") {
-			htmlPart = partText
-		} else if strings.HasPrefix(mediaType, "multipart/") {
-			// ネストされたマルチパート（再帰的に処理可能だが、ここでは簡略化）
+		partBytes, err := io.ReadAll(part)
+		if err != nil {
 			continue
 		}
-	}
+		decoded := decodeTransferEncoding(encoding, partBytes)
 
-	// HTMLが優先、なければテキスト
-	if htmlPart != "" {
-		return htmlPart, true, nil
-	}
-	if textPart != "" {
-		return textPart, false, nil
+		// パートタイプに応じて保存（最初に見つかったものを本文にする）
+		if strings.HasPrefix(mediaType, "text/plain") && parts.text == "" {
+			parts.text = decodeCharset(params["charset"], decoded)
+		} else if strings.HasPrefix(mediaType, "text/html") && parts.html == "" {
+			parts.html = decodeCharset(params["charset"], decoded)
+		}
 	}
-
-	return "", false, fmt.Errorf("本文が見つかりません")
 }
 
-// decodeQuotedPrintable Quoted-Printableデコード（簡易版）
+// decodeQuotedPrintable Quoted-Printableデコード（RFC 2045）
+// デコードに失敗した場合は元の文字列を返す
 func decodeQuotedPrintable(s string) string {
-	var buf bytes.Buffer
-	reader := bufio.NewReader(strings.NewReader(s))
-
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil && err != io.EOF {
-			break
-		}
-
-		// 行末の=を削除（ソフト改行）
-		line = strings.TrimRight(line, "\r\n")
-		if strings.HasSuffix(line, "=") {
-			line = strings.TrimSuffix(line, "=")
-		} else {
-			line += "\n"
-		}
-
-		// =XX形式をデコード
-		i := 0
-		for i < len(line) {
-			if line[i] == '=' && i+2 < len(line) {
-				var b byte
-				fmt.Sscanf(line[i+1:i+3], "%02X", &b)
-				buf.WriteByte(b)
-				i += 3
-			} else {
-				buf.WriteByte(line[i])
-				i++
-			}
-		}
-
-		if err == io.EOF {
-			break
-		}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(s)))
+	if err != nil {
+		return s
 	}
-
-	return buf.String()
+	return string(decoded)
 }
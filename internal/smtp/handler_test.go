@@ -0,0 +1,503 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/charmbracelet/log"
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+// fakeSender 送信したメッセージを記録するMailSender
+type fakeSender struct {
+	// err 送信時に返すエラー
+	err error
+	// user WithTokenで返すトークンのユーザー（nilの場合はトークンを無効とする）
+	user *graph.UserInfo
+
+	mailboxes []string
+	messages  []*graph.Message
+	// token WithTokenで作成した送信者（受け取ったトークンを確認するため）
+	token string
+}
+
+func (f *fakeSender) SendMessageFrom(ctx context.Context, mailbox string, msg *graph.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.mailboxes = append(f.mailboxes, mailbox)
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+func (f *fakeSender) WithToken(ctx context.Context, token string) (MailSender, *graph.UserInfo, error) {
+	if f.user == nil {
+		return nil, nil, errors.New("invalid token")
+	}
+	return &fakeSender{token: token}, f.user, nil
+}
+
+// newTestSession fakeなどの送信者で送信するセッションを作成（NewServerと同じ既定値を使う）
+func newTestSession(config Config, sender MailSender) *Session {
+	server := NewServer(config, sender, log.New(io.Discard))
+	ctx, cancel := context.WithCancel(server.backend.ctx)
+	return &Session{
+		backend: server.backend,
+		ctx:     ctx,
+		cancel:  cancel,
+		release: func() {},
+		logger:  server.backend.logger,
+	}
+}
+
+// startTestServer 127.0.0.1の空いているポートで待ち受けるサーバを起動し、アドレスを返す
+func startTestServer(t *testing.T, config Config, sender MailSender) string {
+	t.Helper()
+	config.Listeners = []Listener{{Addr: "127.0.0.1:0"}}
+	server := NewServer(config, sender, log.New(io.Discard))
+	ln, err := server.listeners[0].listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.listeners[0].server.Serve(ln)
+	t.Cleanup(func() { server.Stop() })
+	return ln.Addr().String()
+}
+
+// readTestMessage 改行をCRLFにしたメッセージを解析
+func readTestMessage(t *testing.T, raw string) *mail.Message {
+	t.Helper()
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(strings.ReplaceAll(raw, "\n", "\r\n"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// sendTestMessage MAIL FROM、RCPT TO、DATAを順に実行し、最初のエラー（DATAの場合は応答）を返す
+func sendTestMessage(s *Session, from string, to []string, data string) error {
+	if err := s.Mail(from, nil); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := s.Rcpt(rcpt, nil); err != nil {
+			return err
+		}
+	}
+	return s.Data(strings.NewReader(strings.ReplaceAll(data, "\n", "\r\n")))
+}
+
+// printResponse DATAの応答をSMTPの応答コードとメッセージで表示
+func printResponse(err error) {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		fmt.Println(smtpErr.Code, smtpErr.Message)
+		return
+	}
+	fmt.Println(err)
+}
+
+func ExampleSession_Data() {
+	sender := &fakeSender{}
+	s := newTestSession(Config{AuthDisabled: true}, sender)
+
+	err := sendTestMessage(s, "app@example.com", []string{"alice@example.com", "bob@example.com", "carol@example.com"}, `From: App <app@example.com>
+To: Alice <alice@example.com>
+Cc: bob@example.com
+Subject: =?UTF-8?B?44OG44K544OI?=
+Message-ID: <test-1@example.com>
+Content-Type: text/plain; charset=utf-8
+
+Hello
+`)
+	printResponse(err)
+
+	msg := sender.messages[0]
+	fmt.Println("to:", msg.To, "cc:", msg.Cc, "bcc:", msg.Bcc)
+	fmt.Println("subject:", msg.Subject)
+	fmt.Printf("body: %q html: %v\n", msg.Body, msg.IsHTML)
+	fmt.Println("message-id:", msg.InternetMessageID)
+	// Output:
+	// 250 OK: queued as <test-1@example.com>
+	// to: [alice@example.com] cc: [bob@example.com] bcc: [carol@example.com]
+	// subject: テスト
+	// body: "Hello\r\n" html: false
+	// message-id: <test-1@example.com>
+}
+
+func ExampleSession_Data_graphError() {
+	// ステータスコードのないエラー（接続エラーなど）は再送できるよう4xxを返す
+	sender := &fakeSender{err: errors.New("connection refused")}
+	s := newTestSession(Config{AuthDisabled: true}, sender)
+
+	err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, `Subject: test
+
+Hello
+`)
+	printResponse(err)
+	// Output:
+	// 451 Could not reach Microsoft Graph, try again later
+}
+
+func ExampleSession_Data_xoauth2() {
+	// XOAUTH2のパススルーでは、トークンで作成した送信者でメッセージを送信する
+	sender := &fakeSender{user: &graph.UserInfo{UserPrincipalName: "alice@example.com"}}
+	s := newTestSession(Config{XOAuth2: XOAuth2Passthrough}, sender)
+
+	if err := s.checkXOAuth2("alice@example.com", "token-1"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	err := sendTestMessage(s, "alice@example.com", []string{"bob@example.com"}, `Subject: test
+Message-ID: <test-2@example.com>
+
+Hello
+`)
+	printResponse(err)
+
+	tokenSender := s.tokenSender.(*fakeSender)
+	fmt.Println("token:", tokenSender.token, "sent:", len(tokenSender.messages), "backend sent:", len(sender.messages))
+	// Output:
+	// 250 OK: queued as <test-2@example.com>
+	// token: token-1 sent: 1 backend sent: 0
+}
+
+func ExampleSession_Data_xoauth2InvalidToken() {
+	sender := &fakeSender{}
+	s := newTestSession(Config{XOAuth2: XOAuth2Passthrough}, sender)
+
+	fmt.Println(s.checkXOAuth2("alice@example.com", "expired"))
+	printResponse(sendTestMessage(s, "alice@example.com", []string{"bob@example.com"}, "Subject: test\n\nHello\n"))
+	// Output:
+	// invalid token
+	// 530 Authentication required
+}
+
+func ExampleSession_Data_markdown() {
+	// Markdownの本文はHTMLに変換し、原文をテキストの代替本文として添付する
+	sender := &fakeSender{}
+	s := newTestSession(Config{AuthDisabled: true, TextAlternative: TextAlternativeAttach}, sender)
+
+	err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, `From: app@example.com
+To: alice@example.com
+Subject: report
+Message-ID: <markdown-1@example.com>
+X-Content-Format: markdown
+Content-Type: text/plain; charset=utf-8
+
+# Report
+
+- **done** <script>
+`)
+	printResponse(err)
+
+	msg := sender.messages[0]
+	fmt.Printf("body: %q html: %v\n", msg.Body, msg.IsHTML)
+	for _, attachment := range msg.Attachments {
+		fmt.Printf("%s %s: %q\n", attachment.Name, attachment.ContentType, attachment.Content)
+	}
+	// Output:
+	// 250 OK: queued as <markdown-1@example.com>
+	// body: "<h1>Report</h1>\n<ul>\n<li><strong>done</strong> &lt;script&gt;</li>\n</ul>\n" html: true
+	// body.txt text/plain; charset=utf-8: "# Report\r\n\r\n- **done** <script>\r\n"
+}
+
+func TestMaxConnections(t *testing.T) {
+	addr := startTestServer(t, Config{AuthDisabled: true, MaxConnections: 1}, &fakeSender{})
+
+	hello := func() (*smtp.Client, error) {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Hello("client.example.com"); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+
+	first, err := hello()
+	if err != nil {
+		t.Fatalf("1つ目の接続: %v", err)
+	}
+	var smtpErr *smtp.SMTPError
+	if _, err := hello(); !errors.As(err, &smtpErr) || smtpErr.Code != 421 {
+		t.Fatalf("上限を超える接続: %v, want 421", err)
+	}
+
+	// 接続を閉じると枠が返り、次の接続を受け付ける
+	if err := first.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := hello()
+		if err == nil {
+			c.Quit()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("接続を閉じた後の接続: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	sender := &fakeSender{}
+	addr := startTestServer(t, Config{Username: "user", Password: "pass"}, sender)
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Hello("client.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 認証前のMAIL FROMは530で拒否する
+	var smtpErr *smtp.SMTPError
+	if err := c.Mail("app@example.com", nil); !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+		t.Fatalf("認証前のMAIL FROM: %v, want 530", err)
+	}
+
+	// 認証後は受け付ける
+	if err := c.Auth(sasl.NewPlainClient("", "user", "pass")); err != nil {
+		t.Fatalf("Auth() error = %v", err)
+	}
+	if err := c.SendMail("app@example.com", []string{"alice@example.com"}, strings.NewReader("Subject: test\r\n\r\nHello\r\n")); err != nil {
+		t.Fatalf("認証後の送信: %v", err)
+	}
+	if len(sender.messages) != 1 {
+		t.Errorf("sent %d messages, want 1", len(sender.messages))
+	}
+}
+
+func TestSplitRecipients(t *testing.T) {
+	tests := []struct {
+		name                    string
+		envelope                []string
+		headerTo, headerCc      []string
+		wantTo, wantCc, wantBcc []string
+	}{
+		{name: "ヘッダーと一致", envelope: []string{"a@example.com", "b@example.com"}, headerTo: []string{"a@example.com"}, headerCc: []string{"b@example.com"},
+			wantTo: []string{"a@example.com"}, wantCc: []string{"b@example.com"}},
+		{name: "ヘッダーにない受信者はBCC", envelope: []string{"a@example.com", "hidden@example.com"}, headerTo: []string{"a@example.com"},
+			wantTo: []string{"a@example.com"}, wantBcc: []string{"hidden@example.com"}},
+		// 配信先は封筒の受信者のみ（ヘッダーだけにある受信者には送らない）
+		{name: "封筒にないヘッダーの受信者", envelope: []string{"a@example.com"}, headerTo: []string{"a@example.com", "header-only@example.com"}, headerCc: []string{"cc-only@example.com"},
+			wantTo: []string{"a@example.com"}},
+		{name: "To/Ccヘッダーがない", envelope: []string{"a@example.com", "b@example.com"},
+			wantTo: []string{"a@example.com", "b@example.com"}},
+		{name: "ToとCcの両方にある受信者はTo", envelope: []string{"a@example.com"}, headerTo: []string{"a@example.com"}, headerCc: []string{"a@example.com"},
+			wantTo: []string{"a@example.com"}},
+		{name: "大文字小文字の違い", envelope: []string{"Alice@Example.com"}, headerCc: []string{"alice@example.com"},
+			wantCc: []string{"Alice@Example.com"}},
+		{name: "封筒の重複", envelope: []string{"a@example.com", "A@example.com", "b@example.com"}, headerTo: []string{"a@example.com"},
+			wantTo: []string{"a@example.com"}, wantBcc: []string{"b@example.com"}},
+		{name: "ヘッダーの受信者が誰も封筒にない", envelope: []string{"x@example.com"}, headerTo: []string{"a@example.com"},
+			wantBcc: []string{"x@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to, cc, bcc := splitRecipients(tt.envelope, tt.headerTo, tt.headerCc)
+			if !slices.Equal(to, tt.wantTo) || !slices.Equal(cc, tt.wantCc) || !slices.Equal(bcc, tt.wantBcc) {
+				t.Errorf("splitRecipients() = to %q cc %q bcc %q, want to %q cc %q bcc %q", to, cc, bcc, tt.wantTo, tt.wantCc, tt.wantBcc)
+			}
+		})
+	}
+}
+
+func TestExtractBodyNested(t *testing.T) {
+	tests := []struct {
+		name            string
+		message         string
+		wantBody        string
+		wantHTML        bool
+		wantText        string
+		wantAttachments []string
+	}{
+		{
+			name: "mixedの中のalternative",
+			message: `Content-Type: multipart/mixed; boundary="mixed"
+
+--mixed
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=utf-8
+
+Hello
+--alt
+Content-Type: text/html; charset=utf-8
+
+<p>Hello</p>
+--alt--
+--mixed
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+
+a,b
+--mixed--
+`,
+			wantBody:        "<p>Hello</p>",
+			wantHTML:        true,
+			wantText:        "Hello",
+			wantAttachments: []string{"data.csv"},
+		},
+		{
+			name: "alternativeの中のrelated",
+			message: `Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain
+
+Logo
+--alt
+Content-Type: multipart/related; boundary="related"
+
+--related
+Content-Type: text/html
+
+<img src="cid:logo">
+--related
+Content-Type: image/gif
+Content-ID: <logo>
+Content-Transfer-Encoding: base64
+
+R0lGODlh
+--related--
+--alt--
+`,
+			wantBody:        `<img src="cid:logo">`,
+			wantHTML:        true,
+			wantText:        "Logo",
+			wantAttachments: []string{"attachment.gif"},
+		},
+		{
+			name: "mixedの中のテキストのみのalternative",
+			message: `Content-Type: multipart/mixed; boundary="mixed"
+
+--mixed
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=iso-2022-jp
+Content-Transfer-Encoding: 7bit
+
+` + "\x1b$B$3$s$K$A$O\x1b(B" + `
+--alt--
+--mixed--
+`,
+			wantBody: "こんにちは",
+		},
+		{
+			name: "HTMLが後のパートにある場合もHTMLを優先",
+			message: `Content-Type: multipart/mixed; boundary="mixed"
+
+--mixed
+Content-Type: text/plain
+
+First
+--mixed
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/html
+
+<p>Second</p>
+--alt--
+--mixed--
+`,
+			wantBody: "<p>Second</p>",
+			wantHTML: true,
+			wantText: "First",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := extractBody(readTestMessage(t, tt.message))
+			if err != nil {
+				t.Fatalf("extractBody() error = %v", err)
+			}
+			if content.body != tt.wantBody || content.isHTML != tt.wantHTML || content.text != tt.wantText {
+				t.Errorf("extractBody() = %q (html %v, text %q), want %q (html %v, text %q)",
+					content.body, content.isHTML, content.text, tt.wantBody, tt.wantHTML, tt.wantText)
+			}
+			var names []string
+			for _, attachment := range content.attachments {
+				names = append(names, attachment.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantAttachments, ",") {
+				t.Errorf("extractBody() attachments = %q, want %q", names, tt.wantAttachments)
+			}
+		})
+	}
+}
+
+func TestExtractBodyNestingLimit(t *testing.T) {
+	// 上限を超えてネストしたマルチパートは読み飛ばし、本文が見つからないエラーにする
+	message := "Content-Type: multipart/mixed; boundary=\"b0\"\n\n"
+	for i := 1; i <= maxMultipartDepth+1; i++ {
+		message += fmt.Sprintf("--b%d\nContent-Type: multipart/mixed; boundary=\"b%d\"\n\n", i-1, i)
+	}
+	message += fmt.Sprintf("--b%d\nContent-Type: text/plain\n\ntoo deep\n", maxMultipartDepth+1)
+	for i := maxMultipartDepth + 1; i >= 0; i-- {
+		message += fmt.Sprintf("--b%d--\n", i)
+	}
+
+	if _, err := extractBody(readTestMessage(t, message)); err == nil {
+		t.Error("extractBody() error = nil, want error")
+	}
+}
+
+func BenchmarkData(b *testing.B) {
+	// 5MBの添付ファイルを含むメッセージ（76桁で折り返したBase64）
+	attachment := make([]byte, 5*1024*1024)
+	for i := range attachment {
+		attachment[i] = byte(i * 7)
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment)
+	var message strings.Builder
+	message.WriteString("From: app@example.com\nTo: alice@example.com\nSubject: report\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\n\n" +
+		"--b\nContent-Type: text/plain\n\nsee attachment\n" +
+		"--b\nContent-Type: application/pdf\nContent-Disposition: attachment; filename=\"report.pdf\"\nContent-Transfer-Encoding: base64\n\n")
+	for len(encoded) > 76 {
+		message.WriteString(encoded[:76] + "\n")
+		encoded = encoded[76:]
+	}
+	message.WriteString(encoded + "\n--b--\n")
+	data := strings.ReplaceAll(message.String(), "\n", "\r\n")
+
+	sender := &fakeSender{}
+	s := newTestSession(Config{AuthDisabled: true}, sender)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if err := s.Mail("app@example.com", nil); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.Rcpt("alice@example.com", nil); err != nil {
+			b.Fatal(err)
+		}
+		var smtpErr *smtp.SMTPError
+		if err := s.Data(strings.NewReader(data)); !errors.As(err, &smtpErr) || smtpErr.Code != 250 {
+			b.Fatal(err)
+		}
+		s.Reset()
+		sender.messages = nil
+	}
+}
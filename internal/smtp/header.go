@@ -0,0 +1,336 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+)
+
+// parseAddresses アドレスヘッダーからメールアドレスの一覧を取得
+// 一覧全体を解析できない場合はカンマで区切って個別に解析し、解析できたアドレスのみ返す
+func parseAddresses(header string) []string {
+	list := parseAddressList(header)
+	addresses := make([]string, 0, len(list))
+	for _, addr := range list {
+		addresses = append(addresses, addr.Address)
+	}
+	return addresses
+}
+
+// addressParser 表示名のエンコードワード（=?ISO-2022-JP?B?...?= など）をデコードするアドレスパーサー
+// 標準のパーサーはUTF-8/ISO-8859-1/US-ASCII以外の文字セットをデコードできない
+var addressParser = &mail.AddressParser{WordDecoder: &mime.WordDecoder{CharsetReader: charsetReader}}
+
+// parseAddressList アドレスヘッダーを解析し、有効なアドレスのみ返す
+func parseAddressList(header string) []*mail.Address {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+
+	list, err := addressParser.ParseList(header)
+	if err != nil {
+		list = nil
+		for _, entry := range strings.Split(header, ",") {
+			if addr, err := addressParser.Parse(strings.TrimSpace(entry)); err == nil {
+				list = append(list, addr)
+			}
+		}
+	}
+
+	valid := list[:0]
+	for _, addr := range list {
+		if validAddress(addr.Address) {
+			valid = append(valid, addr)
+		}
+	}
+	return valid
+}
+
+// displayNames 指定したアドレスヘッダーから表示名を集める（キーは小文字のアドレス）
+// 同じアドレスが複数のヘッダーにある場合は最初の表示名を使う
+func displayNames(header mail.Header, fields ...string) map[string]string {
+	names := make(map[string]string)
+	for _, field := range fields {
+		for _, addr := range parseAddressList(header.Get(field)) {
+			key := strings.ToLower(addr.Address)
+			if _, ok := names[key]; ok || addr.Name == "" {
+				continue
+			}
+			names[key] = sanitizeHeaderValue(addr.Name)
+		}
+	}
+	return names
+}
+
+// validAddress メールアドレスとして送信に使えるか判定
+// 改行などの制御文字を含むものはヘッダーインジェクションを防ぐため拒否する
+func validAddress(address string) bool {
+	if address == "" || strings.ContainsFunc(address, isControl) {
+		return false
+	}
+	_, err := mail.ParseAddress(address)
+	return err == nil
+}
+
+// sanitizeHeaderValue ヘッダーの値から改行と制御文字を取り除く
+// 改行は空白に置き換え、連続する空白は1つにまとめる
+func sanitizeHeaderValue(value string) string {
+	if !strings.ContainsFunc(value, isControl) {
+		return value
+	}
+	cleaned := strings.Map(func(r rune) rune {
+		if isControl(r) {
+			return ' '
+		}
+		return r
+	}, value)
+	return strings.Join(strings.Fields(cleaned), " ")
+}
+
+// isControl 制御文字か判定（タブは除く）
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || r == 0x7f
+}
+
+// parseImportance Importance、X-Priority、X-MSMail-Priorityヘッダーの順に重要度を決定
+// 最初に認識できたヘッダーの値を使い、どれもないか認識できない場合は標準とする
+func parseImportance(header mail.Header) graph.Importance {
+	if importance, ok := importanceFromName(header.Get("Importance")); ok {
+		return importance
+	}
+	if importance, ok := importanceFromPriority(header.Get("X-Priority")); ok {
+		return importance
+	}
+	if importance, ok := importanceFromName(header.Get("X-MSMail-Priority")); ok {
+		return importance
+	}
+	return graph.ImportanceNormal
+}
+
+// importanceFromName High/Normal/Low（大文字小文字は区別しない）から重要度を取得
+func importanceFromName(value string) (graph.Importance, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "high":
+		return graph.ImportanceHigh, true
+	case "normal":
+		return graph.ImportanceNormal, true
+	case "low":
+		return graph.ImportanceLow, true
+	}
+	return "", false
+}
+
+// importanceFromPriority X-Priorityの数値（1 (Highest) 〜 5 (Lowest)）から重要度を取得
+// 1と2は高、3は標準、4と5は低とし、数値の後のコメントは無視する
+func importanceFromPriority(value string) (graph.Importance, bool) {
+	value = strings.TrimSpace(value)
+	digits := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	if digits < 0 {
+		digits = len(value)
+	}
+	switch value[:digits] {
+	case "1", "2":
+		return graph.ImportanceHigh, true
+	case "3":
+		return graph.ImportanceNormal, true
+	case "4", "5":
+		return graph.ImportanceLow, true
+	}
+	return "", false
+}
+
+// parseMessageID Message-IDヘッダーの値を検証して返す
+// <local@domain> の形式でない場合は空を返す
+func parseMessageID(value string) string {
+	id := strings.TrimSpace(value)
+	if len(id) < 5 || id[0] != '<' || id[len(id)-1] != '>' {
+		return ""
+	}
+	inner := id[1 : len(id)-1]
+	if strings.ContainsAny(inner, " \t\r\n<>") {
+		return ""
+	}
+	local, domain, ok := strings.Cut(inner, "@")
+	if !ok || local == "" || domain == "" || strings.Contains(domain, "@") {
+		return ""
+	}
+	return id
+}
+
+// newMessageID 送信元のドメインでMessage-IDを生成（送信元がない場合は m3bridge.local）
+func newMessageID(sender string) string {
+	domain := "m3bridge.local"
+	if at := strings.LastIndex(sender, "@"); at >= 0 && at < len(sender)-1 {
+		domain = strings.ToLower(sender[at+1:])
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixMilli(), hex.EncodeToString(b), domain)
+}
+
+// defaultPassthroughHeaders 設定がない場合にGraphに引き継ぐヘッダー
+var defaultPassthroughHeaders = []string{"X-*"}
+
+// reservedHeaders 引き継がないヘッダー
+// Graphのメッセージのプロパティとして設定するものと、ブリッジへの指示に使うもの
+var reservedHeaders = map[string]bool{
+	"From": true, "Sender": true, "To": true, "Cc": true, "Bcc": true, "Reply-To": true,
+	"Subject": true, "Date": true, "Message-Id": true, "In-Reply-To": true, "References": true,
+	"Mime-Version": true, "Content-Type": true, "Content-Transfer-Encoding": true, "Content-Disposition": true,
+	"Importance": true, "X-Priority": true, "X-Msmail-Priority": true, "Received": true, "Return-Path": true,
+	"Disposition-Notification-To": true, "Return-Receipt-To": true,
+	"X-M3bridge-Mailbox": true, "X-Save-To-Sent": true, "X-Content-Format": true,
+	"X-Dry-Run": true,
+}
+
+// isMarkdown X-Content-Format ヘッダーで本文がMarkdownと指定されているか判定
+func isMarkdown(header mail.Header) bool {
+	return strings.EqualFold(strings.TrimSpace(header.Get("X-Content-Format")), "markdown")
+}
+
+// ValidatePassthroughHeaders 引き継ぐヘッダーの許可リストを検証
+// GraphのsendMailはX-で始まるヘッダーしか受け付けないため、それ以外の名前やパターンはエラーにする
+func ValidatePassthroughHeaders(patterns []string) error {
+	for _, pattern := range patterns {
+		if !isCustomHeader(pattern) {
+			return fmt.Errorf("Graphに引き継げるのは X- で始まるヘッダーのみです: %q", pattern)
+		}
+	}
+	return nil
+}
+
+// isCustomHeader X-で始まるカスタムヘッダーか判定（大文字小文字は区別しない）
+func isCustomHeader(name string) bool {
+	return len(name) > 2 && strings.EqualFold(name[:2], "X-")
+}
+
+// passthroughHeaders 許可リストに一致するヘッダーをGraphのヘッダーとして取得
+// 許可リストの末尾が*の場合は前方一致とし、大文字小文字は区別しない。
+// GraphはX-で始まるヘッダーしか受け付けないため、許可リストに一致してもそれ以外のヘッダーは引き継がない
+func passthroughHeaders(header mail.Header, allowed []string) []graph.Header {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if isCustomHeader(name) && !reservedHeaders[name] && headerAllowed(name, allowed) {
+			names = append(names, name)
+		}
+	}
+	// 送信ごとに順序が変わらないよう並べる
+	sort.Strings(names)
+
+	var headers []graph.Header
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, graph.Header{Name: name, Value: sanitizeHeaderValue(strings.TrimSpace(value))})
+		}
+	}
+	return headers
+}
+
+// headerAllowed ヘッダー名が許可リストに一致するか判定
+func headerAllowed(name string, allowed []string) bool {
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHeader ヘッダーが空でない値で指定されているか判定
+// 開封確認（Disposition-Notification-To）や配信確認（Return-Receipt-To）の要求に使う
+func hasHeader(header mail.Header, name string) bool {
+	return strings.TrimSpace(header.Get(name)) != ""
+}
+
+// parseDate Dateヘッダーから作成日時を取得
+// ヘッダーがないか解析できない場合はゼロ値を返し、sentDateTimeを設定せずにGraphに送信時刻を決めさせる
+// Graphは送信時にsentDateTimeを送信時刻で上書きすることがあるため、Dateの日時が保たれるとは限らない
+func parseDate(header mail.Header) time.Time {
+	date, err := header.Date()
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// parseSaveToSent X-Save-To-Sentヘッダーから送信済みアイテムへの保存の指定を取得
+// ヘッダーがないか認識できない値の場合はnil（設定に従う）を返す
+func parseSaveToSent(header mail.Header) *bool {
+	var save bool
+	switch strings.ToLower(strings.TrimSpace(header.Get("X-Save-To-Sent"))) {
+	case "true", "yes", "1":
+		save = true
+	case "false", "no", "0":
+		save = false
+	default:
+		return nil
+	}
+	return &save
+}
+
+// isDryRun X-Dry-Run ヘッダーで送信しないよう指定されているか判定
+func isDryRun(header mail.Header) bool {
+	switch strings.ToLower(strings.TrimSpace(header.Get("X-Dry-Run"))) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// containsAddress アドレスの一覧に含まれるか判定（大文字小文字を区別しない）
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeHeader 生メッセージのヘッダー部から指定したヘッダーを削除（折り返し行を含む）
+func removeHeader(raw []byte, name string) []byte {
+	// ヘッダーと本文の境界を探す
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	sep := 4
+	if end < 0 {
+		end = bytes.Index(raw, []byte("\n\n"))
+		sep = 2
+	}
+	if end < 0 {
+		return raw
+	}
+
+	prefix := strings.ToLower(name) + ":"
+	var out bytes.Buffer
+	skipping := false
+	for _, line := range bytes.SplitAfter(raw[:end+sep/2], []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		// 空白で始まる行は直前のヘッダーの続き
+		if line[0] == ' ' || line[0] == '\t' {
+			if !skipping {
+				out.Write(line)
+			}
+			continue
+		}
+		skipping = strings.HasPrefix(strings.ToLower(string(line)), prefix)
+		if !skipping {
+			out.Write(line)
+		}
+	}
+	out.Write(raw[end+sep/2:])
+	return out.Bytes()
+}
@@ -0,0 +1,314 @@
+package smtp
+
+import (
+	"encoding/base64"
+	"errors"
+	"maps"
+	"mime"
+	"net/mail"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/emersion/go-smtp"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestParseImportance(t *testing.T) {
+	tests := []struct {
+		name   string
+		header mail.Header
+		want   graph.Importance
+	}{
+		{name: "ヘッダーなし", header: mail.Header{}, want: graph.ImportanceNormal},
+		{name: "Importance", header: mail.Header{"Importance": {"High"}}, want: graph.ImportanceHigh},
+		{name: "Importanceの小文字", header: mail.Header{"Importance": {" low "}}, want: graph.ImportanceLow},
+		{name: "X-Priority 1", header: mail.Header{"X-Priority": {"1 (Highest)"}}, want: graph.ImportanceHigh},
+		{name: "X-Priority 2", header: mail.Header{"X-Priority": {"2"}}, want: graph.ImportanceHigh},
+		{name: "X-Priority 3", header: mail.Header{"X-Priority": {"3 (Normal)"}}, want: graph.ImportanceNormal},
+		{name: "X-Priority 4", header: mail.Header{"X-Priority": {"4"}}, want: graph.ImportanceLow},
+		{name: "X-Priority 5", header: mail.Header{"X-Priority": {"5 (Lowest)"}}, want: graph.ImportanceLow},
+		{name: "X-MSMail-Priority", header: mail.Header{"X-Msmail-Priority": {"High"}}, want: graph.ImportanceHigh},
+		{name: "Importanceを優先", header: mail.Header{"Importance": {"low"}, "X-Priority": {"1"}}, want: graph.ImportanceLow},
+		{name: "X-PriorityをX-MSMail-Priorityより優先", header: mail.Header{"X-Priority": {"5"}, "X-Msmail-Priority": {"High"}}, want: graph.ImportanceLow},
+		{name: "認識できないImportanceは次のヘッダー", header: mail.Header{"Importance": {"urgent"}, "X-Priority": {"1"}}, want: graph.ImportanceHigh},
+		{name: "範囲外のX-Priority", header: mail.Header{"X-Priority": {"9"}}, want: graph.ImportanceNormal},
+		{name: "数値でないX-Priority", header: mail.Header{"X-Priority": {"high"}}, want: graph.ImportanceNormal},
+		{name: "2桁のX-Priority", header: mail.Header{"X-Priority": {"12"}}, want: graph.ImportanceNormal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseImportance(tt.header); got != tt.want {
+				t.Errorf("parseImportance(%v) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMessageID(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "<abc@example.com>", want: "<abc@example.com>"},
+		{value: "  <1.2.3@mail.example.com>\r\n", want: "<1.2.3@mail.example.com>"},
+		{value: "abc@example.com"},
+		{value: "<abc>"},
+		{value: "<@example.com>"},
+		{value: "<abc@>"},
+		{value: "<a@b@example.com>"},
+		{value: "<a b@example.com>"},
+		{value: "<a@example.com> <b@example.com>"},
+		{value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseMessageID(tt.value); got != tt.want {
+				t.Errorf("parseMessageID(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassthroughHeaders(t *testing.T) {
+	header := mail.Header{
+		"X-Campaign-Id":      {"spring-2026"},
+		"X-Mailer":           {"app 1.0"},
+		"X-Tag":              {"a", "b"},
+		"List-Unsubscribe":   {"<mailto:unsubscribe@example.com>"},
+		"List-Id":            {"News <news.example.com>"},
+		"In-Reply-To":        {"<parent@example.com>"},
+		"X-M3bridge-Mailbox": {"shared@example.com"},
+		"X-Priority":         {"1"},
+		"Subject":            {"test"},
+	}
+	tests := []struct {
+		name    string
+		allowed []string
+		want    []string
+	}{
+		{name: "既定", allowed: defaultPassthroughHeaders, want: []string{"X-Campaign-Id: spring-2026", "X-Mailer: app 1.0", "X-Tag: a", "X-Tag: b"}},
+		{name: "前方一致", allowed: []string{"x-campaign-*"}, want: []string{"X-Campaign-Id: spring-2026"}},
+		{name: "名前", allowed: []string{"X-Mailer"}, want: []string{"X-Mailer: app 1.0"}},
+		// GraphはX-で始まらないヘッダーを受け付けないため、許可リストに一致しても引き継がない
+		{name: "標準のヘッダー", allowed: []string{"List-Unsubscribe", "List-*"}},
+		{name: "なし", allowed: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, h := range passthroughHeaders(header, tt.allowed) {
+				got = append(got, h.Name+": "+h.Value)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("passthroughHeaders(%q) = %q, want %q", tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePassthroughHeaders(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		wantErr  bool
+	}{
+		{patterns: nil},
+		{patterns: []string{"X-*", "x-mailer"}},
+		{patterns: []string{"List-Unsubscribe"}, wantErr: true},
+		{patterns: []string{"X-*", "*"}, wantErr: true},
+		{patterns: []string{"X-"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.patterns, ","), func(t *testing.T) {
+			if err := ValidatePassthroughHeaders(tt.patterns); (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePassthroughHeaders(%q) error = %v, wantErr %v", tt.patterns, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDataSentAt(t *testing.T) {
+	tests := []struct {
+		name string
+		date string
+		want time.Time
+	}{
+		{name: "Date", date: "Date: Fri, 02 Jan 2026 15:04:05 +0900\n", want: time.Date(2026, 1, 2, 6, 4, 5, 0, time.UTC)},
+		// Dateがない場合は、Graphに送信時刻を決めさせる
+		{name: "Dateがない"},
+		{name: "解析できないDate", date: "Date: yesterday\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{}
+			s := newTestSession(Config{AuthDisabled: true}, sender)
+			err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, tt.date+"Subject: test\n\nHello\n")
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != 250 {
+				t.Fatalf("Data() = %v, want 250", err)
+			}
+			if len(sender.messages) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(sender.messages))
+			}
+			if got := sender.messages[0].SentAt; !got.Equal(tt.want) {
+				t.Errorf("SentAt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// injection 改行でヘッダーを追加しようとする値
+const injection = "victim\r\nBcc: attacker@evil"
+
+func TestValidAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address: "alice@example.com", want: true},
+		{address: "Alice <alice@example.com>", want: true},
+		{address: ""},
+		{address: "alice"},
+		{address: "alice@example.com\r\nBcc: attacker@evil"},
+		{address: "alice@example.com\nBcc: attacker@evil"},
+		{address: "\"" + injection + "\" <alice@example.com>"},
+		{address: "alice@example.com\x00"},
+		{address: "alice@example.com\x7f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := validAddress(tt.address); got != tt.want {
+				t.Errorf("validAddress(%q) = %v, want %v", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeHeaderValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "plain", want: "plain"},
+		{value: "tab\tkept", want: "tab\tkept"},
+		{value: injection, want: "victim Bcc: attacker@evil"},
+		{value: "a\nb\rc", want: "a b c"},
+		{value: "  a\r\n\r\n  b  \x00", want: "a b"},
+		{value: "del\x7fete", want: "del ete"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := sanitizeHeaderValue(tt.value); got != tt.want {
+				t.Errorf("sanitizeHeaderValue(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassthroughHeadersInjection(t *testing.T) {
+	header := mail.Header{"X-Campaign-Id": {injection}}
+	got := passthroughHeaders(header, defaultPassthroughHeaders)
+	if len(got) != 1 || got[0].Value != "victim Bcc: attacker@evil" {
+		t.Errorf("passthroughHeaders() = %q, want one header without line breaks", got)
+	}
+}
+
+func TestDataHeaderInjection(t *testing.T) {
+	// 表示名と件名はエンコードされた単語で改行を含められる。X-ヘッダーは折り返しで改行を含む
+	encoded := mime.BEncoding.Encode("UTF-8", injection)
+	sender := &fakeSender{}
+	s := newTestSession(Config{AuthDisabled: true}, sender)
+	err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, "From: "+encoded+" <app@example.com>\n"+
+		"To: "+encoded+" <alice@example.com>\n"+
+		"Reply-To: "+encoded+" <reply@example.com>\n"+
+		"Subject: "+encoded+"\n"+
+		"X-Campaign-Id: victim\n Bcc: attacker@evil\n"+
+		"\nHello\n")
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 250 {
+		t.Fatalf("Data() = %v, want 250", err)
+	}
+	msg := sender.messages[0]
+
+	if !slices.Equal(msg.To, []string{"alice@example.com"}) || len(msg.Cc) != 0 || len(msg.Bcc) != 0 {
+		t.Errorf("recipients = to %q cc %q bcc %q, want only alice@example.com", msg.To, msg.Cc, msg.Bcc)
+	}
+	values := []string{msg.Subject}
+	for address, name := range msg.DisplayNames {
+		values = append(values, address, name)
+	}
+	for _, h := range msg.Headers {
+		values = append(values, h.Value)
+	}
+	for _, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			t.Errorf("value %q contains a line break", value)
+		}
+	}
+	if got := msg.DisplayNames["alice@example.com"]; got != "victim Bcc: attacker@evil" {
+		t.Errorf("DisplayNames[alice@example.com] = %q, want %q", got, "victim Bcc: attacker@evil")
+	}
+	if msg.Subject != "victim Bcc: attacker@evil" {
+		t.Errorf("Subject = %q, want %q", msg.Subject, "victim Bcc: attacker@evil")
+	}
+}
+
+func TestDisplayNames(t *testing.T) {
+	encoded, err := japanese.ISO2022JP.NewEncoder().String("山田 太郎")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso2022jp := "=?ISO-2022-JP?B?" + base64.StdEncoding.EncodeToString([]byte(encoded)) + "?="
+
+	tests := []struct {
+		name   string
+		header mail.Header
+		want   map[string]string
+	}{
+		{name: "ISO-2022-JPの表示名", header: mail.Header{"From": {iso2022jp + " <a@b.example>"}}, want: map[string]string{"a@b.example": "山田 太郎"}},
+		{name: "UTF-8の表示名", header: mail.Header{"To": {"=?UTF-8?B?44OG44K544OI?= <Test@Example.com>"}}, want: map[string]string{"test@example.com": "テスト"}},
+		{name: "引用符で囲んだ表示名", header: mail.Header{"Cc": {`"Doe, John" <john@example.com>, jane@example.com`}}, want: map[string]string{"john@example.com": "Doe, John"}},
+		{name: "最初のヘッダーの表示名を使う", header: mail.Header{"From": {"First <a@example.com>"}, "To": {"Second <a@example.com>"}}, want: map[string]string{"a@example.com": "First"}},
+		{name: "解析できないアドレスを含む", header: mail.Header{"To": {iso2022jp + " <a@b.example>, <broken"}}, want: map[string]string{"a@b.example": "山田 太郎"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := displayNames(tt.header, "From", "To", "Cc")
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("displayNames() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDataReceiptRequests(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      string
+		wantRead     bool
+		wantDelivery bool
+	}{
+		{name: "なし"},
+		{name: "開封確認", headers: "Disposition-Notification-To: app@example.com\n", wantRead: true},
+		{name: "配信確認", headers: "Return-Receipt-To: app@example.com\n", wantDelivery: true},
+		{name: "両方", headers: "Disposition-Notification-To: <app@example.com>\nReturn-Receipt-To: app@example.com\n", wantRead: true, wantDelivery: true},
+		{name: "大文字小文字の違い", headers: "disposition-notification-to: app@example.com\n", wantRead: true},
+		{name: "空の値", headers: "Disposition-Notification-To: \n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{}
+			s := newTestSession(Config{AuthDisabled: true}, sender)
+			if err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, tt.headers+"Subject: test\n\nHello\n"); !isAccepted(err) {
+				t.Fatalf("Data() = %v, want 250", err)
+			}
+			msg := sender.messages[0]
+			if msg.ReadReceiptRequested != tt.wantRead || msg.DeliveryReceiptRequested != tt.wantDelivery {
+				t.Errorf("ReadReceiptRequested = %v, DeliveryReceiptRequested = %v, want %v, %v",
+					msg.ReadReceiptRequested, msg.DeliveryReceiptRequested, tt.wantRead, tt.wantDelivery)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/emersion/go-smtp"
+)
+
+// Listener SMTPサーバが待ち受けるアドレス
+type Listener struct {
+	// Addr TCPの場合は host:port、Unixドメインソケットの場合はソケットのパス
+	Addr string
+	// TLS 暗黙的TLS（SMTPS）で待ち受ける（Config.TLSが必要）
+	TLS bool
+	// Unix Unixドメインソケットで待ち受ける
+	Unix bool
+}
+
+// String ログや接続情報に表示する待ち受けアドレス
+func (l Listener) String() string {
+	switch {
+	case l.Unix:
+		return "unix:" + l.Addr
+	case l.TLS:
+		return l.Addr + ":tls"
+	}
+	return l.Addr
+}
+
+// ParseListener 待ち受けアドレス（host:port または host:port:tls）を解析
+// 末尾の :tls は暗黙的TLS（SMTPS）で待ち受けることを表す。IPv6のアドレスは [::1]:2525 のように角括弧で囲む
+func ParseListener(value string) (Listener, error) {
+	value = strings.TrimSpace(value)
+	addr, implicitTLS := strings.CutSuffix(value, ":tls")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return Listener{}, fmt.Errorf("待ち受けアドレスは host:port または host:port:tls で指定してください: %q", value)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return Listener{}, fmt.Errorf("ポート番号は1〜65535で指定してください: %q", value)
+	}
+	return Listener{Addr: net.JoinHostPort(host, port), TLS: implicitTLS}, nil
+}
+
+// listener 待ち受けアドレスとそのアドレスで接続を受け付けるgo-smtpのサーバ
+type listener struct {
+	Listener
+	server *smtp.Server
+	// allowedNetworks 接続を許可するネットワーク（空の場合は全て許可、Unixドメインソケットには適用しない）
+	allowedNetworks []netip.Prefix
+	logger          *log.Logger
+
+	// shared, ln Server.Listenで待ち受けを開始したソケットと、このサーバが接続を受け付けるリスナー
+	shared *sharedListener
+	ln     net.Listener
+}
+
+// listen アドレスで待ち受けを開始
+func (l *listener) listen() (net.Listener, error) {
+	shared, err := l.bind()
+	if err != nil {
+		return nil, err
+	}
+	return l.wrap(shared.acquire()), nil
+}
+
+// bind アドレスでソケットを作成
+func (l *listener) bind() (*sharedListener, error) {
+	if l.Unix {
+		ln, err := listenUnix(l.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return newSharedListener(ln), nil
+	}
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s で待ち受けできません: %w", l.Addr, err)
+	}
+	return newSharedListener(ln), nil
+}
+
+// wrap 接続元の制限と暗黙的TLSをリスナーに適用
+func (l *listener) wrap(ln net.Listener) net.Listener {
+	if l.Unix {
+		return ln
+	}
+	if len(l.allowedNetworks) > 0 {
+		ln = &allowedListener{Listener: ln, networks: l.allowedNetworks, reply: !l.TLS, logger: l.logger}
+	}
+	if l.TLS {
+		ln = tls.NewListener(ln, l.server.TLSConfig)
+	}
+	return ln
+}
+
+// sharedListener 設定の再読み込みで古いサーバから新しいサーバへ引き継ぐソケット
+// 受け付けた接続はその時点で開いているいずれかのサーバに渡す。
+// 全てのサーバがリスナーを閉じた時点でソケットを閉じる
+type sharedListener struct {
+	ln    net.Listener
+	conns chan net.Conn
+	// closed ソケットを閉じたかAcceptが失敗した場合に閉じる
+	closed chan struct{}
+	err    error
+
+	mu   sync.Mutex
+	refs int
+}
+
+// newSharedListener ソケットで接続の受け付けを開始
+func newSharedListener(ln net.Listener) *sharedListener {
+	s := &sharedListener{ln: ln, conns: make(chan net.Conn), closed: make(chan struct{})}
+	go s.accept()
+	return s
+}
+
+// accept ソケットで接続を受け付け、開いているサーバに渡す
+func (s *sharedListener) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			s.err = err
+			close(s.closed)
+			return
+		}
+		select {
+		case s.conns <- conn:
+		case <-s.closed:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// acquire サーバが接続を受け付けるリスナーを作成
+func (s *sharedListener) acquire() net.Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs++
+	return &sharedListenerRef{shared: s, done: make(chan struct{})}
+}
+
+// release リスナーが閉じられた。最後のリスナーの場合はソケットを閉じる
+func (s *sharedListener) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	return s.ln.Close()
+}
+
+// Close サーバに渡す前のソケットを閉じる
+func (s *sharedListener) Close() error {
+	return s.ln.Close()
+}
+
+// sharedListenerRef 1つのサーバが使うsharedListenerのリスナー
+// 閉じてもソケットは他のサーバが使っている間は開いたままにする
+type sharedListenerRef struct {
+	shared *sharedListener
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Accept 次の接続を待つ
+func (r *sharedListenerRef) Accept() (net.Conn, error) {
+	select {
+	case conn := <-r.shared.conns:
+		return conn, nil
+	case <-r.done:
+		return nil, net.ErrClosed
+	case <-r.shared.closed:
+		return nil, r.shared.err
+	}
+}
+
+// Close このサーバでの接続の受け付けを止める
+func (r *sharedListenerRef) Close() error {
+	err := net.ErrClosed
+	r.once.Do(func() {
+		close(r.done)
+		err = r.shared.release()
+	})
+	return err
+}
+
+// Addr 待ち受けているアドレス
+func (r *sharedListenerRef) Addr() net.Addr {
+	return r.shared.ln.Addr()
+}
@@ -0,0 +1,44 @@
+package smtp
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-sasl"
+)
+
+// loginServer AUTH LOGINのサーバ側の実装
+// go-saslにはLOGINのサーバ実装がないため、ユーザー名とパスワードを順に問い合わせる
+type loginServer struct {
+	authenticate func(username, password string) error
+	username     string
+	state        int
+}
+
+const (
+	loginStateUsername = iota
+	loginStatePassword
+	loginStateDone
+)
+
+// newLoginServer AUTH LOGINのサーバを作成
+func newLoginServer(authenticate func(username, password string) error) sasl.Server {
+	return &loginServer{authenticate: authenticate}
+}
+
+// Next クライアントの応答を処理して次のチャレンジを返す
+// 初期応答（AUTH LOGIN <ユーザー名>）がある場合はユーザー名として扱う
+func (s *loginServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch s.state {
+	case loginStateUsername:
+		if response == nil {
+			return []byte("Username:"), false, nil
+		}
+		s.username = string(response)
+		s.state = loginStatePassword
+		return []byte("Password:"), false, nil
+	case loginStatePassword:
+		s.state = loginStateDone
+		return nil, true, s.authenticate(s.username, string(response))
+	}
+	return nil, true, fmt.Errorf("unexpected client response")
+}
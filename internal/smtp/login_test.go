@@ -0,0 +1,55 @@
+package smtp
+
+import (
+	"encoding/base64"
+	"net/textproto"
+	"testing"
+)
+
+func TestAuthLogin(t *testing.T) {
+	addr := startTestServer(t, Config{Username: "user", Password: "pass"}, &fakeSender{})
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	steps := []struct {
+		name     string
+		line     string
+		wantCode int
+		wantMsg  string
+	}{
+		{name: "EHLO", line: "EHLO client.example.com", wantCode: 250},
+
+		// ユーザー名とパスワードをBase64のチャレンジで順に問い合わせる
+		{name: "ユーザー名の問い合わせ", line: "AUTH LOGIN", wantCode: 334, wantMsg: encode("Username:")},
+		{name: "パスワードの問い合わせ", line: encode("user"), wantCode: 334, wantMsg: encode("Password:")},
+		{name: "誤ったパスワード", line: encode("wrong"), wantCode: 535},
+
+		// * でキャンセルする
+		{name: "キャンセル前の問い合わせ", line: "AUTH LOGIN", wantCode: 334, wantMsg: encode("Username:")},
+		{name: "キャンセル", line: "*", wantCode: 501},
+
+		// 初期応答のユーザー名を受け付ける
+		{name: "初期応答", line: "AUTH LOGIN " + encode("user"), wantCode: 334, wantMsg: encode("Password:")},
+		{name: "成功", line: encode("pass"), wantCode: 235},
+		{name: "認証後のMAIL FROM", line: "MAIL FROM:<app@example.com>", wantCode: 250},
+	}
+	for _, step := range steps {
+		if err := conn.PrintfLine("%s", step.line); err != nil {
+			t.Fatal(err)
+		}
+		code, msg, err := conn.ReadResponse(step.wantCode)
+		if err != nil {
+			t.Fatalf("%s: %q = %d %q, want %d (%v)", step.name, step.line, code, msg, step.wantCode, err)
+		}
+		if step.wantMsg != "" && msg != step.wantMsg {
+			t.Errorf("%s: challenge = %q, want %q", step.name, msg, step.wantMsg)
+		}
+	}
+}
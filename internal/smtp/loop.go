@@ -0,0 +1,38 @@
+package smtp
+
+import (
+	"cmp"
+	"net/mail"
+	"strings"
+)
+
+// defaultMaxReceived 1通のメッセージのReceivedヘッダーの最大数のデフォルト値
+// 通常の経路より十分大きく、一般的なMTAのホップ数の上限（Postfixは50）より小さくする
+const defaultMaxReceived = 30
+
+// defaultRejectAutoSubmitted 設定がない場合に拒否するAuto-Submittedの値
+// 自動応答（auto-replied）と自動送信（auto-generated）のメールは、ブリッジから送ると応答の連鎖でループになりうるため拒否する
+var defaultRejectAutoSubmitted = []string{"auto-replied", "auto-generated"}
+
+// detectLoop メールループの可能性があるメッセージか判定
+// Receivedヘッダーが上限を超える場合と、Auto-Submittedヘッダーが拒否する値の場合はループとみなし、理由を返す
+func (b *Backend) detectLoop(header mail.Header) (reason string, loop bool) {
+	if len(header["Received"]) > b.maxReceived {
+		return "max_received_headers", true
+	}
+	if value := autoSubmitted(header); value != "" && value != "no" {
+		for _, rejected := range b.rejectAutoSubmitted {
+			if strings.EqualFold(strings.TrimSpace(rejected), value) {
+				return "reject_auto_submitted", true
+			}
+		}
+	}
+	return "", false
+}
+
+// autoSubmitted Auto-Submittedヘッダーの値（RFC 3834）を小文字で取得（パラメーターは除く）
+// Graph経由で送られたメールはAuto-Submittedを設定できないため、X-Auto-Submitted も確認する
+func autoSubmitted(header mail.Header) string {
+	value, _, _ := strings.Cut(cmp.Or(header.Get("Auto-Submitted"), header.Get("X-Auto-Submitted")), ";")
+	return strings.ToLower(strings.TrimSpace(value))
+}
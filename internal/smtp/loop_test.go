@@ -0,0 +1,60 @@
+package smtp
+
+import (
+	"bufio"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// receivedHeaders n個のReceivedヘッダー
+func receivedHeaders(n int) string {
+	return strings.Repeat("Received: from relay.example.com by mx.example.com\n", n)
+}
+
+func TestDetectLoop(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		header     string
+		wantReason string
+	}{
+		{name: "通常のメッセージ", header: receivedHeaders(3)},
+		{name: "Receivedが上限ちょうど", header: receivedHeaders(defaultMaxReceived)},
+		{name: "Receivedが上限を超える", header: receivedHeaders(defaultMaxReceived + 1), wantReason: "max_received_headers"},
+		{name: "設定した上限を超える", config: Config{MaxReceived: 5}, header: receivedHeaders(6), wantReason: "max_received_headers"},
+		{name: "自動応答", header: "Auto-Submitted: auto-replied\n", wantReason: "reject_auto_submitted"},
+		{name: "自動送信", header: "Auto-Submitted: auto-generated\n", wantReason: "reject_auto_submitted"},
+		{name: "パラメーターと大文字", header: "Auto-Submitted: Auto-Replied; owner-email=\"a@example.com\"\n", wantReason: "reject_auto_submitted"},
+		{name: "Graph経由の自動送信", header: "X-Auto-Submitted: auto-generated\n", wantReason: "reject_auto_submitted"},
+		{name: "自動送信ではない", header: "Auto-Submitted: no\n"},
+		{name: "拒否しない値", config: Config{RejectAutoSubmitted: []string{"auto-replied"}}, header: "Auto-Submitted: auto-generated\n"},
+		{name: "拒否しない設定", config: Config{RejectAutoSubmitted: []string{}}, header: "Auto-Submitted: auto-replied\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(tt.header + "Subject: test\n\nbody\n")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend := newTestSession(tt.config, &fakeSender{}).backend
+			reason, loop := backend.detectLoop(msg.Header)
+			if reason != tt.wantReason || loop != (tt.wantReason != "") {
+				t.Errorf("detectLoop() = %q, %v, want %q", reason, loop, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDataRejectsLoop(t *testing.T) {
+	sender := &fakeSender{}
+	s := newTestSession(Config{AuthDisabled: true}, sender)
+
+	err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, receivedHeaders(defaultMaxReceived+1)+"Subject: test\n\nbody\n")
+	if err != errMailLoop {
+		t.Fatalf("Data() = %v, want %v", err, errMailLoop)
+	}
+	if len(sender.messages) != 0 {
+		t.Errorf("ループのメッセージが送信されました: %d", len(sender.messages))
+	}
+}
@@ -0,0 +1,93 @@
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// rejectWriteTimeout 許可されていない接続元に554の応答を書き込む時間の上限
+const rejectWriteTimeout = 5 * time.Second
+
+// rejectResponse 許可されていない接続元に挨拶の代わりに返す応答
+const rejectResponse = "554 5.7.1 Connection not allowed from your address\r\n"
+
+// ParseNetworks IPアドレスまたはCIDRの一覧を解析（IPアドレスは単一のアドレスのネットワークとして扱う）
+func ParseNetworks(values []string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			networks = append(networks, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("IPアドレスまたはCIDRではありません: %q", value)
+		}
+		networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return networks, nil
+}
+
+// remoteIP 接続元のIPアドレス（TCP以外の場合は無効なアドレス）
+func remoteIP(addr net.Addr) netip.Addr {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return netip.Addr{}
+	}
+	ip, _ := netip.AddrFromSlice(tcpAddr.IP)
+	return ip.Unmap()
+}
+
+// allowedListener 許可リストにない接続元からの接続を、SMTPの挨拶を送る前に拒否するリスナー
+// セッションを作成するEHLO/HELOよりも前に切断するため、許可されていない接続元はSMTPのコマンドを一切実行できない
+type allowedListener struct {
+	net.Listener
+	networks []netip.Prefix
+	// reply 切断する前に554の応答を書き込む（暗黙的TLSのアドレスではTLSのハンドシェイク前のため書き込まない）
+	reply  bool
+	logger *log.Logger
+}
+
+// Accept 許可リストにある接続元からの次の接続を返す
+func (l *allowedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if networkAllowed(l.networks, remoteIP(conn.RemoteAddr())) {
+			return conn, nil
+		}
+		l.logger.Warn("許可されていないアドレスからの接続を拒否しました", "remote", conn.RemoteAddr().String())
+		// 応答を書き込めない接続元で他の接続の受け付けが止まらないよう、別のゴルーチンで切断する
+		go l.reject(conn)
+	}
+}
+
+// reject 554の応答を書き込んで接続を閉じる
+func (l *allowedListener) reject(conn net.Conn) {
+	defer conn.Close()
+	if l.reply {
+		conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		conn.Write([]byte(rejectResponse))
+	}
+}
+
+// networkAllowed 接続元が許可リストに含まれるか判定（許可リストが空の場合は全て許可）
+func networkAllowed(networks []netip.Prefix, ip netip.Addr) bool {
+	if len(networks) == 0 {
+		return true
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package smtp
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "空", values: nil, want: nil},
+		{name: "IPv4のアドレス", values: []string{"127.0.0.1"}, want: []string{"127.0.0.1/32"}},
+		{name: "IPv6のアドレス", values: []string{" ::1 "}, want: []string{"::1/128"}},
+		{name: "IPv4射影アドレス", values: []string{"::ffff:10.0.0.1"}, want: []string{"10.0.0.1/32"}},
+		{name: "CIDRはマスクする", values: []string{"10.1.2.3/8", "fd00::1/64"}, want: []string{"10.0.0.0/8", "fd00::/64"}},
+		{name: "ホスト名", values: []string{"localhost"}, wantErr: true},
+		{name: "不正なCIDR", values: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := ParseNetworks(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNetworks(%q) error = %v, wantErr %v", tt.values, err, tt.wantErr)
+			}
+			var got []string
+			for _, network := range networks {
+				got = append(got, network.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseNetworks(%q) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkAllowed(t *testing.T) {
+	networks, err := ParseNetworks([]string{"127.0.0.1", "10.0.0.0/8", "fd00::/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		networks []netip.Prefix
+		addr     net.Addr
+		want     bool
+	}{
+		{name: "許可リストが空", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}, want: true},
+		{name: "アドレス", networks: networks, addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, want: true},
+		{name: "CIDR", networks: networks, addr: &net.TCPAddr{IP: net.ParseIP("10.20.30.40")}, want: true},
+		{name: "IPv4射影アドレス", networks: networks, addr: &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1")}, want: true},
+		{name: "IPv6", networks: networks, addr: &net.TCPAddr{IP: net.ParseIP("fd00::1234")}, want: true},
+		{name: "一覧にないアドレス", networks: networks, addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}},
+		{name: "TCP以外", networks: networks, addr: &net.UnixAddr{Name: "/tmp/smtp.sock"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := networkAllowed(tt.networks, remoteIP(tt.addr)); got != tt.want {
+				t.Errorf("networkAllowed(%v) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedNetworksGreeting(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []string
+		want     string
+	}{
+		{name: "許可", networks: []string{"127.0.0.0/8"}, want: "220 "},
+		{name: "拒否", networks: []string{"10.0.0.0/8"}, want: "554 5.7.1 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := ParseNetworks(tt.networks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := startTestServer(t, Config{AuthDisabled: true, AllowedNetworks: networks}, &fakeSender{})
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			// 許可されていない接続元には、挨拶（220）を送らずに554を返して切断する
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(line, tt.want) {
+				t.Errorf("最初の応答 = %q, want %q", line, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package smtp
+
+import (
+	"strings"
+)
+
+// RecipientPolicy 受信者の許可リストと拒否リスト
+// ドメインのパターンは "example.com"（完全一致）または "*.example.com"（サブドメイン）で、大文字小文字は区別しない
+type RecipientPolicy struct {
+	// AllowedDomains 送信を許可する受信者のドメイン（空の場合は全て許可）
+	AllowedDomains []string
+	// Denied 送信を拒否する受信者のアドレスまたはドメインのパターン（許可リストより優先）
+	Denied []string
+}
+
+// check 受信者に送信できるか判定し、拒否する場合はその理由を返す
+func (p RecipientPolicy) check(address string) (reason string, ok bool) {
+	at := strings.LastIndex(address, "@")
+	domain := address[at+1:]
+
+	for _, denied := range p.Denied {
+		if strings.Contains(denied, "@") {
+			if strings.EqualFold(denied, address) {
+				return "denied_recipients", false
+			}
+		} else if domainMatches(denied, domain) {
+			return "denied_recipients", false
+		}
+	}
+
+	if len(p.AllowedDomains) == 0 {
+		return "", true
+	}
+	for _, allowed := range p.AllowedDomains {
+		if domainMatches(allowed, domain) {
+			return "", true
+		}
+	}
+	return "allowed_recipient_domains", false
+}
+
+// domainMatches ドメインがパターンに一致するか判定
+// "*.example.com" は a.example.com や a.b.example.com に一致し、example.com には一致しない
+func domainMatches(pattern, domain string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return len(domain) > len(suffix)+1 &&
+			strings.EqualFold(domain[len(domain)-len(suffix):], suffix) &&
+			domain[len(domain)-len(suffix)-1] == '.'
+	}
+	return strings.EqualFold(pattern, domain)
+}
@@ -0,0 +1,73 @@
+package smtp
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MessageRecord 受信したメッセージのメタデータ（本文は含まない）
+type MessageRecord struct {
+	ReceivedAt time.Time `json:"received_at"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Cc         []string  `json:"cc,omitempty"`
+	Subject    string    `json:"subject"`
+	BodyType   string    `json:"body_type"`
+	Size       int       `json:"size"`
+}
+
+// RecentMessages 直近に受信したメッセージを保持するリングバッファ
+type RecentMessages struct {
+	mu      sync.Mutex
+	records []MessageRecord
+	next    int
+	full    bool
+}
+
+// NewRecentMessages 指定したサイズのリングバッファを作成
+func NewRecentMessages(size int) *RecentMessages {
+	if size <= 0 {
+		size = 1
+	}
+	return &RecentMessages{
+		records: make([]MessageRecord, size),
+	}
+}
+
+// Add レコードを追加（古いものから上書き）
+func (rm *RecentMessages) Add(record MessageRecord) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.records[rm.next] = record
+	rm.next = (rm.next + 1) % len(rm.records)
+	if rm.next == 0 {
+		rm.full = true
+	}
+}
+
+// List 新しい順にレコードを返す
+func (rm *RecentMessages) List() []MessageRecord {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	count := rm.next
+	if rm.full {
+		count = len(rm.records)
+	}
+
+	result := make([]MessageRecord, 0, count)
+	for i := 1; i <= count; i++ {
+		idx := (rm.next - i + len(rm.records)) % len(rm.records)
+		result = append(result, rm.records[idx])
+	}
+	return result
+}
+
+// ServeHTTP 直近のメッセージをJSONで返す
+func (rm *RecentMessages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rm.List())
+}
@@ -0,0 +1,98 @@
+package smtp
+
+import (
+	"cmp"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	netsmtp "net/smtp"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+)
+
+// defaultRelayPort ポートが指定されていない場合のリレーのポート
+const defaultRelayPort = 25
+
+// RelayConfig 上流SMTPリレーの設定
+type RelayConfig struct {
+	Host string
+	// Port リレーのポート（0の場合は25）
+	Port     int
+	Username string
+	Password string
+}
+
+// Relay Graphが利用できない場合のフォールバック用SMTPリレー
+type Relay struct {
+	config RelayConfig
+	logger *log.Logger
+}
+
+// NewRelay 新しいリレーを作成
+func NewRelay(config RelayConfig, logger *log.Logger) *Relay {
+	return &Relay{
+		config: config,
+		logger: logger,
+	}
+}
+
+// Send 受信した生メッセージをそのまま上流SMTPリレーへ転送
+// メッセージは読み込みながら送信し、全体をメモリに保持しない
+func (r *Relay) Send(from string, to []string, msg io.Reader) error {
+	addr := net.JoinHostPort(r.config.Host, strconv.Itoa(cmp.Or(r.config.Port, defaultRelayPort)))
+	r.logger.Debug("リレー送信開始", "addr", addr, "from", from, "to_count", len(to))
+
+	if err := r.send(addr, from, to, msg); err != nil {
+		r.logger.Error("リレー送信失敗", "addr", addr, "error", err)
+		return fmt.Errorf("リレー送信失敗: %w", err)
+	}
+
+	r.logger.Info("リレー送信成功", "addr", addr, "to_count", len(to))
+	return nil
+}
+
+// send net/smtpのSendMailと同じ手順（STARTTLSに対応していれば使用し、設定があれば認証する）で送信する
+func (r *Relay) send(addr, from string, to []string, msg io.Reader) error {
+	c, err := netsmtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: r.config.Host}); err != nil {
+			return err
+		}
+	}
+	if r.config.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("リレーが認証に対応していません")
+		}
+		if err := c.Auth(netsmtp.PlainAuth("", r.config.Username, r.config.Password, r.config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,186 @@
+package smtp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+// relayBackend 受け取ったメッセージを記録するテスト用のSMTPリレー
+type relayBackend struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (b *relayBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &relaySession{backend: b}, nil
+}
+
+func (b *relayBackend) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.messages)
+}
+
+type relaySession struct {
+	backend *relayBackend
+}
+
+func (s *relaySession) Mail(from string, opts *smtp.MailOptions) error { return nil }
+func (s *relaySession) Rcpt(to string, opts *smtp.RcptOptions) error   { return nil }
+func (s *relaySession) Reset()                                         {}
+func (s *relaySession) Logout() error                                  { return nil }
+
+func (s *relaySession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+	s.backend.messages = append(s.backend.messages, string(data))
+	return nil
+}
+
+// startRelay テスト用のSMTPリレーを起動
+func startRelay(t *testing.T) (RelayConfig, *relayBackend) {
+	t.Helper()
+	backend := &relayBackend{}
+	server := smtp.NewServer(backend)
+	server.Domain = "relay.example.com"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return relayConfigFor(t, ln.Addr().String()), backend
+}
+
+// relayConfigFor host:portのアドレスのリレーの設定
+func relayConfigFor(t *testing.T, addr string) RelayConfig {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RelayConfig{Host: host, Port: n}
+}
+
+// graphError Graphのエラーレスポンス（エラーコードとステータスコード）
+func graphError(code string, status int) error {
+	mainErr := odataerrors.NewMainError()
+	mainErr.SetCode(&code)
+	err := odataerrors.NewODataError()
+	err.SetErrorEscaped(mainErr)
+	err.SetStatusCode(status)
+	return err
+}
+
+func TestDataRelayFallback(t *testing.T) {
+	// 接続を受け付けないアドレス（閉じたリスナーのアドレス）
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name        string
+		err         error
+		relayDown   bool
+		wantCode    int
+		wantRelayed int
+	}{
+		{name: "一時的なエラーはリレーに転送", err: errors.New("connection refused"), wantCode: 250, wantRelayed: 1},
+		{name: "スロットリングはリレーに転送", err: graphError("ApplicationThrottled", 429), wantCode: 250, wantRelayed: 1},
+		{name: "宛先の誤りは転送しない", err: graphError("ErrorInvalidRecipients", 400), wantCode: 550},
+		{name: "権限の不足は転送しない", err: graphError("ErrorAccessDenied", 403), wantCode: 550},
+		{name: "リレーにも送信できない", err: errors.New("connection refused"), relayDown: true, wantCode: 451},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay, backend := startRelay(t)
+			if tt.relayDown {
+				relay = relayConfigFor(t, closedAddr)
+			}
+			s := newTestSession(Config{AuthDisabled: true, RelayFallback: true, Relay: relay}, &fakeSender{err: tt.err})
+
+			err := sendTestMessage(s, "app@example.com", []string{"alice@example.com"}, "Subject: test\nMessage-ID: <relay-1@example.com>\n\nHello\n")
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+				t.Fatalf("Data() = %v, want %d", err, tt.wantCode)
+			}
+			if tt.wantCode == 250 && smtpErr.Message != "OK: queued as <relay-1@example.com>" {
+				t.Errorf("Data() message = %q, want Message-ID", smtpErr.Message)
+			}
+			if got := backend.count(); got != tt.wantRelayed {
+				t.Errorf("relayed = %d, want %d", got, tt.wantRelayed)
+			}
+		})
+	}
+}
+
+func TestDataBccHeader(t *testing.T) {
+	const message = "From: app@example.com\nTo: alice@example.com\nBcc: Secret <secret@example.com>,\n bob@example.com\n" +
+		"Subject: test\n\nHello\nBcc: in the body is kept\n"
+	to := []string{"alice@example.com", "secret@example.com", "bob@example.com"}
+
+	t.Run("Graph", func(t *testing.T) {
+		sender := &fakeSender{}
+		s := newTestSession(Config{AuthDisabled: true}, sender)
+		if err := sendTestMessage(s, "app@example.com", to, message); !isAccepted(err) {
+			t.Fatalf("Data() = %v, want 250", err)
+		}
+		msg := sender.messages[0]
+		if !slices.Equal(msg.To, []string{"alice@example.com"}) || len(msg.Cc) != 0 {
+			t.Errorf("to = %q cc = %q, want only alice@example.com", msg.To, msg.Cc)
+		}
+		if !slices.Equal(msg.Bcc, []string{"secret@example.com", "bob@example.com"}) {
+			t.Errorf("bcc = %q, want [secret@example.com bob@example.com]", msg.Bcc)
+		}
+		for _, h := range msg.Headers {
+			if strings.EqualFold(h.Name, "Bcc") {
+				t.Errorf("Headers contains %s: %s", h.Name, h.Value)
+			}
+		}
+	})
+
+	t.Run("リレー", func(t *testing.T) {
+		relay, backend := startRelay(t)
+		s := newTestSession(Config{AuthDisabled: true, RelayFallback: true, Relay: relay}, &fakeSender{err: errors.New("connection refused")})
+		if err := sendTestMessage(s, "app@example.com", to, message); !isAccepted(err) {
+			t.Fatalf("Data() = %v, want 250", err)
+		}
+		if backend.count() != 1 {
+			t.Fatalf("relayed = %d, want 1", backend.count())
+		}
+		relayed := backend.messages[0]
+		header, body, _ := strings.Cut(relayed, "\r\n\r\n")
+		if strings.Contains(strings.ToLower(header), "bcc:") || strings.Contains(header, "bob@example.com") {
+			t.Errorf("relayed header contains Bcc: %q", header)
+		}
+		if !strings.Contains(header, "To: alice@example.com") || body != "Hello\r\nBcc: in the body is kept\r\n" {
+			t.Errorf("relayed message = %q, want the message without the Bcc header", relayed)
+		}
+	})
+}
+
+// isAccepted DATAの応答が250か判定
+func isAccepted(err error) bool {
+	var smtpErr *smtp.SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Code == 250
+}
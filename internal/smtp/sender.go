@@ -0,0 +1,40 @@
+package smtp
+
+import (
+	"context"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+)
+
+// MailSender メッセージを送信するクライアント（通常は GraphSender）
+// テストで送信したメッセージを記録する実装に差し替えたり、SMTPバックエンドを他のプログラムに組み込んだりする場合に使う
+type MailSender interface {
+	// SendMessageFrom メッセージを指定したメールボックスから送信（空の場合はデフォルトの送信元）
+	SendMessageFrom(ctx context.Context, mailbox string, msg *graph.Message) error
+	// WithToken XOAUTH2のパススルーで提示されたアクセストークンを確認し、そのトークンで送信するクライアントとトークンのユーザーを返す
+	// トークンが無効な場合や、パススルーに対応しない場合はエラーを返す
+	WithToken(ctx context.Context, token string) (MailSender, *graph.UserInfo, error)
+}
+
+// GraphSender Microsoft GraphのクライアントをMailSenderとして使う
+type GraphSender struct {
+	*graph.Client
+}
+
+// NewGraphSender Graphクライアントで送信するMailSenderを作成
+func NewGraphSender(client *graph.Client) *GraphSender {
+	return &GraphSender{Client: client}
+}
+
+// WithToken アクセストークンでGraphクライアントを作り直し、ユーザー情報を取得できれば有効とみなす
+func (g *GraphSender) WithToken(ctx context.Context, token string) (MailSender, *graph.UserInfo, error) {
+	client, err := g.Client.WithAccessToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := client.GetUserInfo(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewGraphSender(client), user, nil
+}
@@ -1,61 +1,334 @@
 package smtp
 
 import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/netip"
+	"strings"
 	"time"
 
-	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/canaria-computer/m3bridge/internal/queue"
 	"github.com/charmbracelet/log"
 	"github.com/emersion/go-smtp"
 )
 
+// defaultMaxLineLength 1行の最大長のデフォルト値
+// 長いReferencesヘッダーなどを折り返さずに送るクライアントがあるため、go-smtpの既定値（2000）より大きくする
+const defaultMaxLineLength = 8192
+
+const (
+	// defaultTimeout 読み書きのタイムアウトのデフォルト値
+	// 低速な回線で大きな添付ファイルを受け取れるよう、go-smtpの例より長くする
+	defaultTimeout = 60 * time.Second
+	// defaultMaxRecipients 1通のメッセージの最大受信者数のデフォルト値
+	defaultMaxRecipients = 50
+)
+
 // Server SMTPサーバ
 type Server struct {
-	smtpServer  *smtp.Server
-	graphClient *graph.Client
-	logger      *log.Logger
+	listeners []*listener
+	backend   *Backend
+	sender    MailSender
+	logger    *log.Logger
 }
 
 // Config サーバ設定
 type Config struct {
-	Host     string
-	Port     int
+	// Listeners 待ち受けるアドレス（TCP、暗黙的TLS、Unixドメインソケット）
+	Listeners []Listener
+
 	Username string
 	Password string
+	// AuthDisabled SMTP AUTHなしでの送信を許可する
+	AuthDisabled bool
+	// XOAuth2 XOAUTH2で提示されたトークンの扱い（空の場合はXOAUTH2を提供しない）
+	XOAuth2 XOAuth2Mode
+
+	// MaxLineLength 1行の最大長（0の場合はデフォルト）
+	MaxLineLength int
+	// MaxMessageBytes 1通のメッセージの最大サイズ（0の場合は10MB、超えた場合は552を返す）
+	MaxMessageBytes int64
+	// MaxBufferedBytes 全セッションで同時に保持するメッセージの合計サイズ（0の場合は無制限、超えた場合は452を返す）
+	MaxBufferedBytes int64
+	// MaxRecipients 1通のメッセージの最大受信者数（0の場合は50）
+	MaxRecipients int
+	// AllowedNetworks 接続を許可するネットワーク（空の場合は全て許可、それ以外は挨拶の前に554を返して切断する）
+	AllowedNetworks []netip.Prefix
+	// MaxConnections 同時に処理するセッションの最大数（0の場合は無制限、超えた場合は421を返す）
+	MaxConnections int
+	// MaxReceived 1通のメッセージのReceivedヘッダーの最大数（0の場合は30、超えた場合はループとみなして554を返す）
+	MaxReceived int
+	// RejectAutoSubmitted ループとみなして554を返すAuto-Submittedの値（nilの場合は auto-replied と auto-generated）
+	RejectAutoSubmitted []string
+
+	// ReadTimeout, WriteTimeout クライアントとの読み書きのタイムアウト（0の場合は60秒）
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// TLS STARTTLSと暗黙的TLSに使う設定（nilの場合はSTARTTLSを提供しない）
+	TLS *tls.Config
+	// RequireTLS STARTTLSの前のAUTHを拒否する（Unixドメインソケットには適用しない）
+	RequireTLS bool
+
+	// Recipients 受信者の許可リストと拒否リスト（RCPT TOで550を返す）
+	Recipients RecipientPolicy
+
+	// AllowedMailboxes X-M3Bridge-Mailboxヘッダーで指定可能なメールボックス
+	// 封筒のMAIL FROMがこの一覧に含まれる場合は、そのアドレスを送信元にする
+	AllowedMailboxes []string
+
+	// SendAs 送信元（From）に設定するアドレス（空の場合はサインインユーザー）
+	SendAs string
+
+	// PassthroughHeaders Graphに引き継ぐヘッダー名（末尾の*は前方一致、nilの場合は X-*）
+	PassthroughHeaders []string
+
+	// TextAlternative HTMLの本文に添付するテキストの代替本文の扱い（空の場合は添付しない）
+	TextAlternative TextAlternativeMode
+	// Footer 本文の末尾に追加するフッター（nilの場合は追加しない）
+	Footer *Footer
+
+	// Recent 受信メッセージのメタデータを記録するバッファ（nilの場合は記録しない）
+	Recent *RecentMessages
+
+	// RelayFallback Graph送信失敗時に上流SMTPリレーへ転送する
+	RelayFallback bool
+	Relay         RelayConfig
+
+	// DryRun メッセージを解析してログに記録するが、Graphで送信しない（250を返す）
+	DryRun bool
+
+	// Queue 一時的なエラーで送信できなかったメッセージを保存する再送キュー（nilの場合はクライアントにエラーを返す）
+	Queue *queue.Queue
 }
 
-// NewServer 新しいSMTPサーバを作成
-func NewServer(config Config, graphClient *graph.Client, logger *log.Logger) *Server {
-	backend := NewBackend(graphClient, config.Username, config.Password, logger)
+// NewServer 新しいSMTPサーバを作成（senderは通常 GraphSender）
+func NewServer(config Config, sender MailSender, logger *log.Logger) *Server {
+	backend := NewBackend(sender, config.Username, config.Password, logger)
+	backend.allowedMailboxes = config.AllowedMailboxes
+	backend.sendAs = config.SendAs
+	backend.authDisabled = config.AuthDisabled
+	backend.xoauth2 = config.XOAuth2
+	backend.passthroughHeaders = config.PassthroughHeaders
+	if backend.passthroughHeaders == nil {
+		backend.passthroughHeaders = defaultPassthroughHeaders
+	}
+	backend.textAlternative = config.TextAlternative
+	backend.footer = config.Footer
+	backend.recipients = config.Recipients
+	backend.dryRun = config.DryRun
+	backend.recent = config.Recent
+	backend.buffers.limit = config.MaxBufferedBytes
+	if config.MaxConnections > 0 {
+		backend.sessions = make(chan struct{}, config.MaxConnections)
+	}
+	backend.maxReceived = cmp.Or(config.MaxReceived, defaultMaxReceived)
+	backend.rejectAutoSubmitted = config.RejectAutoSubmitted
+	if backend.rejectAutoSubmitted == nil {
+		backend.rejectAutoSubmitted = defaultRejectAutoSubmitted
+	}
+	if config.RelayFallback {
+		backend.relay = NewRelay(config.Relay, logger)
+	}
+	backend.queue = config.Queue
+
+	var listeners []*listener
+	for _, l := range config.Listeners {
+		if l.TLS && config.TLS == nil {
+			logger.Warn("証明書が設定されていないため、暗黙的TLSのアドレスでは待ち受けません", "addr", l.Addr)
+			continue
+		}
+		server := newSMTPServer(backend, l.Addr, config)
+		server.TLSConfig = config.TLS
+		// Unixドメインソケットは同じホストのプロセスからのみ接続できるため、STARTTLSの前のAUTHも許可する
+		// 暗黙的TLSのアドレスでは接続時点で暗号化されている
+		if !l.Unix && !l.TLS {
+			server.AllowInsecureAuth = !config.RequireTLS
+		}
+		listeners = append(listeners, &listener{Listener: l, server: server, allowedNetworks: config.AllowedNetworks, logger: logger})
+	}
 
+	addrs := make([]string, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.String()
+	}
+	logger.Info("SMTPサーバ作成完了",
+		"listeners", strings.Join(addrs, ", "),
+		"auth_required", !config.AuthDisabled,
+		"xoauth2", config.XOAuth2,
+		"starttls", config.TLS != nil,
+		"require_tls", config.RequireTLS,
+		"max_message_bytes", cmp.Or(config.MaxMessageBytes, defaultMaxMessageBytes),
+		"max_recipients", cmp.Or(config.MaxRecipients, defaultMaxRecipients),
+		"max_connections", config.MaxConnections,
+		"allowed_networks", len(config.AllowedNetworks),
+		"read_timeout", cmp.Or(config.ReadTimeout, defaultTimeout),
+		"write_timeout", cmp.Or(config.WriteTimeout, defaultTimeout),
+		"relay_fallback", config.RelayFallback,
+		"dry_run", config.DryRun,
+		"queue", config.Queue != nil)
+
+	return &Server{
+		listeners: listeners,
+		backend:   backend,
+		sender:    sender,
+		logger:    logger,
+	}
+}
+
+// newSMTPServer 共通の設定でgo-smtpのサーバを作成
+func newSMTPServer(backend *Backend, addr string, config Config) *smtp.Server {
 	s := smtp.NewServer(backend)
-	s.Addr = fmt.Sprintf("%s:%d", config.Host, config.Port)
+	s.Addr = addr
 	s.Domain = "localhost"
-	s.ReadTimeout = 10 * time.Second
-	s.WriteTimeout = 10 * time.Second
-	s.MaxMessageBytes = 10 * 1024 * 1024 // 10MB
-	s.MaxRecipients = 50
+	s.ReadTimeout = defaultTimeout
+	if config.ReadTimeout > 0 {
+		s.ReadTimeout = config.ReadTimeout
+	}
+	s.WriteTimeout = defaultTimeout
+	if config.WriteTimeout > 0 {
+		s.WriteTimeout = config.WriteTimeout
+	}
+	s.MaxMessageBytes = defaultMaxMessageBytes
+	if config.MaxMessageBytes > 0 {
+		s.MaxMessageBytes = config.MaxMessageBytes
+	}
+	s.MaxRecipients = defaultMaxRecipients
+	if config.MaxRecipients > 0 {
+		s.MaxRecipients = config.MaxRecipients
+	}
+	s.MaxLineLength = defaultMaxLineLength
+	if config.MaxLineLength > 0 {
+		s.MaxLineLength = config.MaxLineLength
+	}
 	s.AllowInsecureAuth = true
+	return s
+}
 
-	logger.Info("SMTPサーバ作成完了",
-		"addr", s.Addr,
-		"auth_enabled", config.Username != "" && config.Password != "")
+// Listen 全てのアドレスで待ち受けを開始する（接続の受け付けはStartで開始）
+// previousが同じアドレスで待ち受けている場合は、そのソケットを引き継ぐ。
+// 待ち受けを開始できないアドレスがある場合は、開始済みのアドレスを閉じてエラーを返す（previousには影響しない）
+func (s *Server) Listen(previous *Server) error {
+	if len(s.listeners) == 0 {
+		return fmt.Errorf("待ち受けるアドレスがありません")
+	}
+	shared := make([]*sharedListener, len(s.listeners))
+	var bound []*sharedListener
+	for i, l := range s.listeners {
+		if shared[i] = previous.sharedListener(l.Listener); shared[i] != nil {
+			continue
+		}
+		ln, err := l.bind()
+		if err != nil {
+			for _, opened := range bound {
+				opened.Close()
+			}
+			return err
+		}
+		bound = append(bound, ln)
+		shared[i] = ln
+	}
+	for i, l := range s.listeners {
+		l.shared = shared[i]
+		l.ln = l.wrap(shared[i].acquire())
+	}
+	return nil
+}
 
-	return &Server{
-		smtpServer:  s,
-		graphClient: graphClient,
-		logger:      logger,
+// sharedListener 同じアドレスで待ち受けているソケット（ない場合はnil）
+func (s *Server) sharedListener(target Listener) *sharedListener {
+	if s == nil {
+		return nil
+	}
+	for _, l := range s.listeners {
+		if l.shared != nil && l.Addr == target.Addr && l.Unix == target.Unix {
+			return l.shared
+		}
 	}
+	return nil
 }
 
-// Start サーバを起動
+// Start 全てのアドレスで接続の受け付けを開始し、最初に発生したエラーを返す
+// Listenを呼んでいない場合はここで待ち受けを開始する
 func (s *Server) Start() error {
-	s.logger.Info("SMTPサーバ起動", "addr", s.smtpServer.Addr)
-	return s.smtpServer.ListenAndServe()
+	if len(s.listeners) == 0 || s.listeners[0].ln == nil {
+		if err := s.Listen(nil); err != nil {
+			return err
+		}
+	}
+
+	errChan := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		s.logger.Info("SMTPサーバ起動", "addr", l.String())
+		go func() {
+			errChan <- l.server.Serve(l.ln)
+		}()
+	}
+	// 1つのアドレスでエラーが発生した場合は、他のアドレスも停止する
+	err := <-errChan
+	if err != nil {
+		s.Stop()
+	}
+	return err
+}
+
+// servers 全てのアドレスのgo-smtpのサーバ
+func (s *Server) servers() []*smtp.Server {
+	servers := make([]*smtp.Server, len(s.listeners))
+	for i, l := range s.listeners {
+		servers[i] = l.server
+	}
+	return servers
 }
 
 // Stop サーバを停止
+// 処理中の接続も直ちに切断する。送信中のメッセージを失わないよう、通常はShutdownを使う
 func (s *Server) Stop() error {
 	s.logger.Info("SMTPサーバ停止")
-	return s.smtpServer.Close()
+	s.backend.shuttingDown.Store(true)
+	var err error
+	for _, server := range s.servers() {
+		if closeErr := server.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Shutdown 新しい接続の受け付けを止め、処理中のセッションの終了を待ってサーバを停止
+// 停止中の新しいMAIL/RCPTには421を返し、送信中のDATAは完了させる。
+// ctxが終了した場合は残りの接続を切断し、ctxのエラーを返す
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("SMTPサーバ停止中（処理中のセッションの終了を待機）")
+	s.backend.shuttingDown.Store(true)
+	defer s.backend.cancel()
+
+	servers := s.servers()
+	errChan := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			errChan <- server.Shutdown(ctx)
+		}()
+	}
+
+	var err error
+	for range servers {
+		if shutdownErr := <-errChan; shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		s.logger.Warn("停止の待機時間を過ぎたため、処理中の送信を中断して残りの接続を切断します")
+		s.backend.cancel()
+		for _, server := range servers {
+			server.Close()
+		}
+		return err
+	}
+	s.logger.Info("SMTPサーバ停止")
+	return err
 }
@@ -0,0 +1,253 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	netsmtp "net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+	"github.com/charmbracelet/log"
+	"github.com/emersion/go-smtp"
+)
+
+func TestServerListen(t *testing.T) {
+	// 空いているアドレスを確保してから、古いサーバを起動する
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+
+	send := func() error {
+		return netsmtp.SendMail(addr, nil, "app@example.com", []string{"alice@example.com"},
+			[]byte("From: app@example.com\r\nTo: alice@example.com\r\nSubject: test\r\n\r\nHello\r\n"))
+	}
+	newServer := func(sender MailSender, listeners ...Listener) *Server {
+		return NewServer(Config{AuthDisabled: true, Listeners: listeners}, sender, log.New(io.Discard))
+	}
+
+	oldSender := &fakeSender{}
+	old := newServer(oldSender, Listener{Addr: addr})
+	if err := old.Listen(nil); err != nil {
+		t.Fatal(err)
+	}
+	go old.Start()
+	t.Cleanup(func() { old.Stop() })
+	if err := send(); err != nil {
+		t.Fatalf("古いサーバへの送信エラー: %v", err)
+	}
+
+	// 新しいアドレスで待ち受けできない場合は、古いサーバがそのまま接続を受け付ける
+	used, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer used.Close()
+	failed := newServer(&fakeSender{}, Listener{Addr: addr}, Listener{Addr: used.Addr().String()})
+	if err := failed.Listen(old); err == nil {
+		t.Fatal("Listen() on used address error = nil")
+	}
+	if err := send(); err != nil {
+		t.Fatalf("待ち受けの失敗後の古いサーバへの送信エラー: %v", err)
+	}
+	if len(oldSender.messages) != 2 {
+		t.Fatalf("古いサーバの送信数 = %d, want 2", len(oldSender.messages))
+	}
+
+	// 同じアドレスのソケットを引き継ぎ、古いサーバを停止しても新しいサーバが接続を受け付ける
+	nextSender := &fakeSender{}
+	next := newServer(nextSender, Listener{Addr: addr})
+	if err := next.Listen(old); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go next.Start()
+	t.Cleanup(func() { next.Stop() })
+	if err := old.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := send(); err != nil {
+		t.Fatalf("新しいサーバへの送信エラー: %v", err)
+	}
+	if len(oldSender.messages) != 2 || len(nextSender.messages) != 1 {
+		t.Errorf("送信数 = 古いサーバ %d, 新しいサーバ %d, want 2, 1", len(oldSender.messages), len(nextSender.messages))
+	}
+}
+
+// blockingSender releaseが閉じられるまで送信を完了しないMailSender
+type blockingSender struct {
+	fakeSender
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSender) SendMessageFrom(ctx context.Context, mailbox string, msg *graph.Message) error {
+	close(b.started)
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return b.fakeSender.SendMessageFrom(ctx, mailbox, msg)
+}
+
+func TestServerShutdown(t *testing.T) {
+	sender := &blockingSender{started: make(chan struct{}), release: make(chan struct{})}
+	server := NewServer(Config{AuthDisabled: true, Listeners: []Listener{{Addr: "127.0.0.1:0"}}}, sender, log.New(io.Discard))
+	if err := server.Listen(nil); err != nil {
+		t.Fatal(err)
+	}
+	addr := server.listeners[0].ln.Addr().String()
+	go server.Start()
+	t.Cleanup(func() { server.Stop() })
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- netsmtp.SendMail(addr, nil, "app@example.com", []string{"alice@example.com"},
+			[]byte("Subject: test\r\n\r\nHello\r\n"))
+	}()
+	select {
+	case <-sender.started:
+	case err := <-sent:
+		t.Fatalf("送信が送信処理の前に終了しました: %v", err)
+	}
+
+	// 送信中に停止を開始する
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Shutdown(context.Background())
+	}()
+
+	// 停止中は新しい接続を受け付けない
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("停止中に新しい接続を受け付けました")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("送信の完了前にShutdown()が終了しました: %v", err)
+	default:
+	}
+
+	// 送信中のメッセージは最後まで送信してから停止する
+	close(sender.release)
+	if err := <-sent; err != nil {
+		t.Fatalf("送信中のメッセージの送信エラー: %v", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if len(sender.messages) != 1 {
+		t.Errorf("sent %d messages, want 1", len(sender.messages))
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	const limit = 1024
+	addr := startTestServer(t, Config{AuthDisabled: true, MaxMessageBytes: limit}, &fakeSender{})
+	dial := func(t *testing.T) *smtp.Client {
+		t.Helper()
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if err := c.Hello("client.example.com"); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	t.Run("SIZE", func(t *testing.T) {
+		tests := []struct {
+			size     int64
+			wantCode int
+		}{
+			{size: limit, wantCode: 250},
+			{size: limit + 1, wantCode: 552},
+		}
+		for _, tt := range tests {
+			c := dial(t)
+			err := c.Mail("app@example.com", &smtp.MailOptions{Size: tt.size})
+			code := 250
+			var smtpErr *smtp.SMTPError
+			if errors.As(err, &smtpErr) {
+				code = smtpErr.Code
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("MAIL FROM SIZE=%d = %v, want %d", tt.size, err, tt.wantCode)
+			}
+		}
+	})
+
+	t.Run("DATA", func(t *testing.T) {
+		c := dial(t)
+		if err := c.Mail("app@example.com", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Rcpt("alice@example.com", nil); err != nil {
+			t.Fatal(err)
+		}
+		w, err := c.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "Subject: test\r\n\r\n"+strings.Repeat("x", limit)+"\r\n")
+		var smtpErr *smtp.SMTPError
+		if err := w.Close(); !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+			t.Errorf("上限を超えるDATA = %v, want 552", err)
+		}
+	})
+}
+
+func TestMaxLineLength(t *testing.T) {
+	// 折り返さずに送られた長いReferencesヘッダー（約2000文字）
+	var refs []string
+	for i := 0; len(strings.Join(refs, " ")) < 2000; i++ {
+		refs = append(refs, fmt.Sprintf("<message-%04d@mail.example.com>", i))
+	}
+	references := strings.Join(refs, " ")
+	message := "From: app@example.com\r\nTo: alice@example.com\r\nSubject: Re: test\r\n" +
+		"In-Reply-To: " + refs[len(refs)-1] + "\r\nReferences: " + references + "\r\n\r\nHello\r\n"
+
+	tests := []struct {
+		name          string
+		maxLineLength int
+		wantErr       bool
+	}{
+		{name: "既定の上限"},
+		{name: "上限より長い行", maxLineLength: 1000, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{}
+			addr := startTestServer(t, Config{AuthDisabled: true, MaxLineLength: tt.maxLineLength}, sender)
+			err := netsmtp.SendMail(addr, nil, "app@example.com", []string{"alice@example.com"}, []byte(message))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendMail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(sender.messages) != 1 || sender.messages[0].Subject != "Re: test" {
+				t.Errorf("sent = %d messages, want the message with the long References header", len(sender.messages))
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// socketPerm Unixドメインソケットのパーミッション（所有者のみ接続可能）
+const socketPerm = 0o600
+
+// listenUnix Unixドメインソケットで待ち受けるリスナーを作成
+// 前回の異常終了で残ったソケットファイルは削除するが、使用中のソケットやソケット以外のファイルは削除しない
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("ソケット以外のファイルが存在します: %s", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("ソケットは別のプロセスが使用中です: %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("古いソケットの削除エラー: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("ソケットの確認エラー: %w", err)
+	}
+
+	l, err := listenSocket(path)
+	if err != nil {
+		return nil, fmt.Errorf("ソケットの作成エラー: %w", err)
+	}
+	return l, nil
+}
+
+// isUnixSocket Unixドメインソケットからの接続か判定
+func isUnixSocket(addr net.Addr) bool {
+	_, ok := addr.(*net.UnixAddr)
+	return ok
+}
+
+// remoteName ログに記録する接続元（Unixドメインソケットの相手にはアドレスがないため "unix" とする）
+func remoteName(addr net.Addr) string {
+	if isUnixSocket(addr) {
+		return "unix"
+	}
+	return addr.String()
+}
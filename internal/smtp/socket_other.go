@@ -0,0 +1,23 @@
+//go:build !unix
+
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenSocket ソケットを作成して socketPerm のパーミッションを設定する
+// umaskのない環境では作成後に設定するため、作成から設定までの間は既定のパーミッションになる
+func listenSocket(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketPerm); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("パーミッション設定エラー: %w", err)
+	}
+	return l, nil
+}
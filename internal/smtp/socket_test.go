@@ -0,0 +1,94 @@
+//go:build unix
+
+package smtp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, path string)
+		wantErr bool
+	}{
+		{name: "新規作成"},
+		{
+			name: "前回のソケットが残っている",
+			setup: func(t *testing.T, path string) {
+				l, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 異常終了を再現するため、ソケットファイルを残したまま閉じる
+				l.(*net.UnixListener).SetUnlinkOnClose(false)
+				l.Close()
+			},
+		},
+		{
+			name: "使用中のソケット",
+			setup: func(t *testing.T, path string) {
+				l, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { l.Close() })
+			},
+			wantErr: true,
+		},
+		{
+			name: "ソケット以外のファイル",
+			setup: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "smtp.sock")
+			if tt.setup != nil {
+				tt.setup(t, path)
+			}
+
+			l, err := listenUnix(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenUnix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != socketPerm {
+				t.Errorf("socket mode = %v, want %v", perm, os.FileMode(socketPerm))
+			}
+			if got := l.Addr().String(); got != path {
+				t.Errorf("Addr() = %q, want %q", got, path)
+			}
+			// 作成に使った一時ディレクトリは残さない
+			entries, err := os.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("directory entries = %d, want only the socket", len(entries))
+			}
+
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("socket file remains after Close: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenSocket socketPerm のパーミッションでソケットを作成して待ち受ける
+// 作成後にchmodすると、それまでの間に他のユーザーが接続できるため、所有者のみが入れる一時ディレクトリで
+// 作成とchmodを行ってから目的のパスに移動する（umaskはプロセス全体の設定のため変更しない）
+func listenSocket(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock-")
+	if err != nil {
+		return nil, fmt.Errorf("一時ディレクトリ作成エラー: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// 移動後のパスは socketListener.Close で削除する
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, socketPerm); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("パーミッション設定エラー: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("ソケットの移動エラー: %w", err)
+	}
+	return &socketListener{UnixListener: l, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// socketListener 一時ディレクトリで作成して移動したソケットのリスナー
+type socketListener struct {
+	*net.UnixListener
+	addr *net.UnixAddr
+}
+
+// Addr 移動後のソケットのパスを返す
+func (l *socketListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close 待ち受けを終了してソケットファイルを削除する
+func (l *socketListener) Close() error {
+	err := l.UnixListener.Close()
+	if removeErr := os.Remove(l.addr.Name); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// spoolMemoryLimit メモリに保持する生メッセージの最大サイズ
+// これを超えるメッセージは一時ファイルに書き出し、添付ファイルの大きなメッセージでも全体をメモリに保持しない
+const spoolMemoryLimit = 1024 * 1024
+
+// spool 受信した生メッセージを保持する（小さいものはメモリ、大きいものは一時ファイル）
+// 解析とリレーへの転送では先頭から読み直す
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+// Write メッセージを追記し、メモリの上限を超えた時点で一時ファイルに切り替える
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && int64(s.buf.Len()+len(p)) > spoolMemoryLimit {
+		// 一時ディレクトリはTMPDIRで変更できる
+		f, err := os.CreateTemp("", "m3bridge-*.eml")
+		if err != nil {
+			return 0, fmt.Errorf("一時ファイル作成エラー: %w", err)
+		}
+		s.file = f
+		if _, err := f.Write(s.buf.Bytes()); err != nil {
+			return 0, fmt.Errorf("一時ファイル書き込みエラー: %w", err)
+		}
+		s.buf = bytes.Buffer{}
+	}
+
+	var (
+		n   int
+		err error
+	)
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// Size 保持しているメッセージのサイズ
+func (s *spool) Size() int64 {
+	return s.size
+}
+
+// Reader 保持しているメッセージを先頭から読むReader
+func (s *spool) Reader() io.Reader {
+	if s.file != nil {
+		return io.NewSectionReader(s.file, 0, s.size)
+	}
+	return bytes.NewReader(s.buf.Bytes())
+}
+
+// withoutHeader ヘッダー部から指定したヘッダーを削除したメッセージを読むReader
+// ヘッダー部のみメモリに読み込み、本文は保持している場所から読みながら渡す
+func (s *spool) withoutHeader(name string) io.Reader {
+	br := bufio.NewReader(s.Reader())
+	var header bytes.Buffer
+	for {
+		line, err := br.ReadBytes('\n')
+		header.Write(line)
+		if err != nil {
+			// 本文のないメッセージ
+			return bytes.NewReader(removeHeader(header.Bytes(), name))
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+	}
+	return io.MultiReader(bytes.NewReader(removeHeader(header.Bytes(), name)), br)
+}
+
+// Close 一時ファイルを削除
+func (s *spool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
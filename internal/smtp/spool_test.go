@@ -0,0 +1,66 @@
+package smtp
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	body := strings.Repeat("x", spoolMemoryLimit) + "\r\n"
+	tests := []struct {
+		name     string
+		body     string
+		wantFile bool
+	}{
+		{"小さいメッセージはメモリに保持", "hello\r\n", false},
+		{"上限を超えるメッセージは一時ファイルに書き出す", body, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			message := "From: app@example.com\r\nBcc: secret@example.com\r\nSubject: test\r\n\r\n" + tt.body
+
+			s := &spool{}
+			if _, err := io.Copy(s, strings.NewReader(message)); err != nil {
+				t.Fatal(err)
+			}
+			if (s.file != nil) != tt.wantFile {
+				t.Errorf("一時ファイル = %v, want %v", s.file != nil, tt.wantFile)
+			}
+			if s.Size() != int64(len(message)) {
+				t.Errorf("Size() = %d, want %d", s.Size(), len(message))
+			}
+
+			got, err := io.ReadAll(s.Reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != message {
+				t.Error("Reader() の内容が書き込んだメッセージと一致しません")
+			}
+
+			got, err = io.ReadAll(s.withoutHeader("Bcc"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Replace(message, "Bcc: secret@example.com\r\n", "", 1); string(got) != want {
+				t.Error("withoutHeader() の内容にBccヘッダーが残っているか、本文が一致しません")
+			}
+
+			var name string
+			if s.file != nil {
+				name = s.file.Name()
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if name != "" {
+				if _, err := os.Stat(name); !os.IsNotExist(err) {
+					t.Errorf("Close() 後も一時ファイルが残っています: %v", err)
+				}
+			}
+		})
+	}
+}
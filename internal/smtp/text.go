@@ -0,0 +1,85 @@
+package smtp
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/canaria-computer/m3bridge/internal/graph"
+)
+
+// textAlternativeName テキストの代替本文の添付ファイル名
+const textAlternativeName = "body.txt"
+
+// TextAlternativeMode HTMLの本文に対するテキストの代替本文の扱い
+// Graphのメッセージには本文を1種類しか設定できないため、代替本文はテキストファイルとして添付する
+type TextAlternativeMode string
+
+const (
+	// TextAlternativeNone 代替本文を送信しない
+	TextAlternativeNone TextAlternativeMode = ""
+	// TextAlternativeAttach クライアントが送ったテキストのパート（またはMarkdownの原文）を添付する
+	TextAlternativeAttach TextAlternativeMode = "attach"
+	// TextAlternativeGenerate attach に加え、テキストのパートがない場合はHTMLからタグを取り除いて生成する
+	TextAlternativeGenerate TextAlternativeMode = "generate"
+)
+
+// ParseTextAlternativeMode 設定値から代替本文のモードを取得
+func ParseTextAlternativeMode(value string) (TextAlternativeMode, error) {
+	switch mode := TextAlternativeMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case TextAlternativeNone, TextAlternativeAttach, TextAlternativeGenerate:
+		return mode, nil
+	}
+	return TextAlternativeNone, fmt.Errorf("text_alternative は attach または generate を指定してください: %s", value)
+}
+
+// textAlternative HTMLの本文に添付するテキストの代替本文（添付しない場合はfalse）
+func textAlternative(mode TextAlternativeMode, content *messageContent) (graph.Attachment, bool) {
+	if mode == TextAlternativeNone || !content.isHTML {
+		return graph.Attachment{}, false
+	}
+	text := content.text
+	if text == "" && mode == TextAlternativeGenerate {
+		text = htmlToText(content.body)
+	}
+	if strings.TrimSpace(text) == "" {
+		return graph.Attachment{}, false
+	}
+	return graph.Attachment{
+		Name:        textAlternativeName,
+		ContentType: "text/plain; charset=utf-8",
+		Content:     []byte(text),
+	}, true
+}
+
+var (
+	// invisibleElementPattern 内容を表示しない要素
+	invisibleElementPattern = regexp.MustCompile(`(?is)<(script|style|head|title)\b.*?</(script|style|head|title)\s*>`)
+	// commentPattern HTMLのコメント
+	commentPattern = regexp.MustCompile(`(?s)<!--.*?-->`)
+	// lineBreakPattern 改行にするタグ
+	lineBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</?(p|div|h[1-6]|li|tr|table|blockquote|pre|ul|ol|hr)\b[^>]*>`)
+	// tagPattern その他のタグ
+	tagPattern = regexp.MustCompile(`(?s)<[^>]*>`)
+	// blankLinesPattern 3行以上続く空行
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText HTMLからタグを取り除いてテキストにする
+// ブロック要素と改行タグは改行に置き換え、文字参照はデコードする
+func htmlToText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = invisibleElementPattern.ReplaceAllString(s, "")
+	s = commentPattern.ReplaceAllString(s, "")
+	s = lineBreakPattern.ReplaceAllString(s, "\n")
+	s = tagPattern.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	s = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(s) + "\n"
+}
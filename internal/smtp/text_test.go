@@ -0,0 +1,85 @@
+package smtp
+
+import "testing"
+
+func TestParseTextAlternativeMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    TextAlternativeMode
+		wantErr bool
+	}{
+		{value: "", want: TextAlternativeNone},
+		{value: "attach", want: TextAlternativeAttach},
+		{value: " Generate ", want: TextAlternativeGenerate},
+		{value: "always", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTextAlternativeMode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTextAlternativeMode(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTextAlternativeMode(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{name: "段落と改行", html: "<p>Hello</p><p>World<br>again</p>", want: "Hello\n\nWorld\nagain\n"},
+		{name: "表示しない要素", html: "<html><head><title>T</title><style>p{}</style></head><body>Text<script>alert(1)</script></body></html>", want: "Text\n"},
+		{name: "コメント", html: "A<!-- <p>hidden</p> -->B", want: "AB\n"},
+		{name: "文字参照", html: "&lt;tag&gt; &amp; &quot;q&quot; &#x3042;", want: "<tag> & \"q\" あ\n"},
+		{name: "インライン要素", html: "<b>bold</b> and <a href=\"https://example.com\">link</a>", want: "bold and link\n"},
+		{name: "連続する空行", html: "<div>A</div>\r\n\r\n\r\n<div>B</div>", want: "A\n\nB\n"},
+		{name: "リスト", html: "<ul><li>one</li><li>two</li></ul>", want: "one\n\ntwo\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htmlToText(tt.html); got != tt.want {
+				t.Errorf("htmlToText(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextAlternative(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    TextAlternativeMode
+		content messageContent
+		want    string
+		wantOK  bool
+	}{
+		{name: "無効", mode: TextAlternativeNone, content: messageContent{body: "<p>HTML</p>", isHTML: true, text: "text"}},
+		{name: "テキストの本文", mode: TextAlternativeGenerate, content: messageContent{body: "text"}},
+		{name: "テキストのパートを添付", mode: TextAlternativeAttach, content: messageContent{body: "<p>HTML</p>", isHTML: true, text: "text"}, want: "text", wantOK: true},
+		{name: "テキストのパートがない", mode: TextAlternativeAttach, content: messageContent{body: "<p>HTML</p>", isHTML: true}},
+		{name: "HTMLから生成", mode: TextAlternativeGenerate, content: messageContent{body: "<p>HTML</p>", isHTML: true}, want: "HTML\n", wantOK: true},
+		{name: "テキストのパートを優先", mode: TextAlternativeGenerate, content: messageContent{body: "<p>HTML</p>", isHTML: true, text: "text"}, want: "text", wantOK: true},
+		{name: "生成したテキストが空", mode: TextAlternativeGenerate, content: messageContent{body: "<img src=\"cid:logo\">", isHTML: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachment, ok := textAlternative(tt.mode, &tt.content)
+			if ok != tt.wantOK {
+				t.Fatalf("textAlternative() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if attachment.Name != textAlternativeName || attachment.ContentType != "text/plain; charset=utf-8" {
+				t.Errorf("textAlternative() = %q (%s), want %q", attachment.Name, attachment.ContentType, textAlternativeName)
+			}
+			if got := string(attachment.Content); got != tt.want {
+				t.Errorf("textAlternative() content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package smtp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// selfSignedValidity 自己署名証明書の有効期間
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// LoadTLSConfig 証明書と秘密鍵のPEMファイルからSTARTTLS用の設定を作成
+// パスは環境変数を展開する
+func LoadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(os.ExpandEnv(certFile), os.ExpandEnv(keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("TLS証明書読み込みエラー: %w", err)
+	}
+	return newTLSConfig(cert), nil
+}
+
+// SelfSignedTLSConfig localhost向けの自己署名証明書を生成してSTARTTLS用の設定を作成
+// 証明書は起動ごとに生成し、ファイルには保存しない
+func SelfSignedTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("秘密鍵生成エラー: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("シリアル番号生成エラー: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"m3bridge"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("自己署名証明書生成エラー: %w", err)
+	}
+
+	return newTLSConfig(tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}), nil
+}
+
+// newTLSConfig 証明書からTLS設定を作成
+func newTLSConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
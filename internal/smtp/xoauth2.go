@@ -0,0 +1,129 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+)
+
+// xoauth2Mechanism XOAUTH2の認証メカニズム名
+const xoauth2Mechanism = "XOAUTH2"
+
+// xoauth2VerifyTimeout パススルーモードでトークンを確認する際のタイムアウト
+const xoauth2VerifyTimeout = 30 * time.Second
+
+// XOAuth2Mode XOAUTH2で提示されたトークンの扱い
+type XOAuth2Mode string
+
+const (
+	// XOAuth2Disabled XOAUTH2を提供しない
+	XOAuth2Disabled XOAuth2Mode = ""
+	// XOAuth2Static トークンを設定のパスワードと照合する（ユーザー名も一致する必要がある）
+	XOAuth2Static XOAuth2Mode = "static"
+	// XOAuth2Passthrough トークンをそのセッションのGraphのアクセストークンとして使う
+	XOAuth2Passthrough XOAuth2Mode = "passthrough"
+)
+
+// ParseXOAuth2Mode 設定値からXOAUTH2のモードを取得
+func ParseXOAuth2Mode(value string) (XOAuth2Mode, error) {
+	switch mode := XOAuth2Mode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case XOAuth2Disabled, XOAuth2Static, XOAuth2Passthrough:
+		return mode, nil
+	}
+	return XOAuth2Disabled, fmt.Errorf("xoauth2 は static または passthrough を指定してください: %s", value)
+}
+
+// xoauth2FailureChallenge 認証失敗時にクライアントへ返すエラー（XOAUTH2の仕様ではJSONを返し、空の応答を待つ）
+const xoauth2FailureChallenge = `{"status":"401","schemes":"bearer","scope":"https://graph.microsoft.com/.default"}`
+
+// xoauth2Server AUTH XOAUTH2のサーバ側の実装
+// クライアントの応答は "user=<ユーザー名>\x01auth=Bearer <トークン>\x01\x01" の形式
+type xoauth2Server struct {
+	authenticate func(username, token string) error
+	err          error // 失敗した場合のエラー（クライアントの空の応答の後に返す）
+}
+
+// newXOAuth2Server AUTH XOAUTH2のサーバを作成
+func newXOAuth2Server(authenticate func(username, token string) error) sasl.Server {
+	return &xoauth2Server{authenticate: authenticate}
+}
+
+// Next クライアントの応答を処理する
+func (s *xoauth2Server) Next(response []byte) (challenge []byte, done bool, err error) {
+	if s.err != nil {
+		return nil, true, s.err
+	}
+	if response == nil {
+		return []byte{}, false, nil
+	}
+
+	username, token, err := parseXOAuth2Response(string(response))
+	if err != nil {
+		return nil, true, err
+	}
+	if err := s.authenticate(username, token); err != nil {
+		s.err = err
+		return []byte(xoauth2FailureChallenge), false, nil
+	}
+	return nil, true, nil
+}
+
+// parseXOAuth2Response XOAUTH2の応答からユーザー名とトークンを取得
+func parseXOAuth2Response(response string) (username, token string, err error) {
+	for _, field := range strings.Split(response, "\x01") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "user":
+			username = value
+		case "auth":
+			scheme, credential, ok := strings.Cut(value, " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") {
+				return "", "", fmt.Errorf("invalid XOAUTH2 auth field")
+			}
+			token = strings.TrimSpace(credential)
+		}
+	}
+	if token == "" {
+		return "", "", fmt.Errorf("missing XOAUTH2 bearer token")
+	}
+	return username, token, nil
+}
+
+// checkXOAuth2 XOAUTH2で提示されたトークンを確認
+func (s *Session) checkXOAuth2(username, token string) error {
+	if s.backend.xoauth2 != XOAuth2Passthrough {
+		return s.checkCredentials(xoauth2Mechanism, username, token)
+	}
+
+	// トークンを確認できれば、そのトークンのクライアントをこのセッションの送信に使う
+	ctx, cancel := context.WithTimeout(s.ctx, xoauth2VerifyTimeout)
+	defer cancel()
+	sender, user, err := s.backend.sender.WithToken(ctx, token)
+	if err != nil {
+		s.logger.Warn("認証失敗", "username", username, "mechanism", xoauth2Mechanism, "error", err)
+		return fmt.Errorf("invalid token")
+	}
+	if username != "" && !strings.EqualFold(username, user.Address()) && !strings.EqualFold(username, user.UserPrincipalName) {
+		s.logger.Warn("認証失敗（トークンのユーザーが一致しません）", "username", username, "token_user", user.UserPrincipalName, "mechanism", xoauth2Mechanism)
+		return fmt.Errorf("token does not belong to user")
+	}
+
+	s.logger.Debug("認証成功", "username", user.UserPrincipalName, "mechanism", xoauth2Mechanism)
+	s.authenticated = true
+	s.tokenSender = sender
+	return nil
+}
+
+// client 送信に使うクライアント（XOAUTH2のパススルーで認証した場合はそのユーザーのクライアント）
+func (s *Session) client() MailSender {
+	if s.tokenSender != nil {
+		return s.tokenSender
+	}
+	return s.backend.sender
+}